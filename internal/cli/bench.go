@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/sirupsen/logrus"
+	"github.com/skorokithakis/dox/pkg/dox"
+)
+
+// overheadBudget is how much time dox may add to a run of a command.
+const overheadBudget = 3 * time.Second
+
+// phaseResolve is the phase of a benchmarked run that loads the command's configuration.
+const phaseResolve = "resolve"
+
+// newBenchCommand creates the bench command.
+func newBenchCommand(client *dox.Client) *cobra.Command {
+	var runs int
+
+	cmd := &cobra.Command{
+		Use:   "bench [flags] <command> [arguments...]",
+		Short: "Measure the overhead of running a command",
+		Long: `Run a command repeatedly and report how long each phase of its runs takes. The
+command's stdin is empty and its output is discarded, so pick arguments that make it
+exit quickly, like --version.
+
+The first run may pull or build the image or start a warm container, so it is reported
+separately and the statistics cover the runs after it. The last phase of a run includes
+the command itself, and everything before it counts as dox's overhead.`,
+		Example: `  dox bench python --version
+  dox bench --runs 20 terraform version`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if runs < 1 {
+				return &UsageError{Err: fmt.Errorf("invalid --runs %d: must be at least 1", runs)}
+			}
+			return benchCommand(cmd, client, args, runs)
+		},
+	}
+
+	cmd.Flags().IntVarP(&runs, "runs", "n", 5, "Number of runs to measure after the first one")
+	cmd.Flags().SetInterspersed(false)
+
+	return cmd
+}
+
+// benchCommand runs a command one more time than requested and prints a table of
+// its phases.
+func benchCommand(cmd *cobra.Command, client *dox.Client, args []string, runs int) error {
+	var names []string
+	timings := make(map[string][]time.Duration)
+	record := func(name string, duration time.Duration) {
+		if _, ok := timings[name]; !ok {
+			names = append(names, name)
+		}
+		timings[name] = append(timings[name], duration)
+	}
+
+	for i := 0; i <= runs; i++ {
+		started := time.Now()
+		command, err := client.Resolve(args[0])
+		if err != nil {
+			return err
+		}
+		record(phaseResolve, time.Since(started))
+
+		result, err := command.Run(context.Background(), dox.RunOptions{Args: args[1:], Stdin: strings.NewReader("")})
+		if err != nil {
+			return err
+		}
+		if result.ExitCode != 0 && i == 0 {
+			logrus.Warnf("The command exited with code %d, so its runs may not be representative.", result.ExitCode)
+		}
+		for _, phase := range result.Phases {
+			record(phase.Name, phase.Duration)
+		}
+		record("total", time.Since(started))
+	}
+
+	// The last phase of a run includes the command, so it isn't overhead.
+	overhead := make([]time.Duration, runs+1)
+	for _, name := range names[:len(names)-2] {
+		for i, duration := range timings[name] {
+			overhead[i] += duration
+		}
+	}
+	names = append(names, "overhead")
+	timings["overhead"] = overhead
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PHASE\tFIRST\tMEDIAN\tMIN\tMAX")
+	for _, name := range names {
+		first, rest := timings[name][0], sortedDurations(timings[name][1:])
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, formatDuration(first), formatDuration(rest[len(rest)/2]), formatDuration(rest[0]), formatDuration(rest[len(rest)-1]))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	median := sortedDurations(overhead[1:])[runs/2]
+	status := "within"
+	if median > overheadBudget {
+		status = "over"
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "\nMedian overhead of %s is %s the budget of %s.\n", formatDuration(median), status, overheadBudget)
+	return nil
+}
+
+// sortedDurations returns a sorted copy of durations.
+func sortedDurations(durations []time.Duration) []time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
+
+// formatDuration rounds a duration to a precision that is readable in a table.
+func formatDuration(d time.Duration) string {
+	return d.Round(10 * time.Microsecond).String()
+}
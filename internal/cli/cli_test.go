@@ -0,0 +1,701 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/skorokithakis/dox/internal/config"
+	"github.com/skorokithakis/dox/internal/runtime"
+	"github.com/skorokithakis/dox/internal/runtime/runtimetest"
+	"github.com/skorokithakis/dox/internal/versioning"
+)
+
+// cliTest runs the CLI against a temporary configuration directory and a fake runtime.
+type cliTest struct {
+	t          *testing.T
+	configHome string
+	// executable is the path of the dox binary.
+	executable string
+	runtime    *runtimetest.Runtime
+	// hosts records the Docker host of every runtime that was created.
+	hosts  []string
+	stdin  string
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+}
+
+// newCLITest creates a CLI test with no commands configured.
+func newCLITest(t *testing.T) *cliTest {
+	t.Helper()
+	c := &cliTest{t: t, configHome: t.TempDir(), runtime: runtimetest.New()}
+	t.Setenv("XDG_CONFIG_HOME", c.configHome)
+	if err := os.MkdirAll(filepath.Join(c.configHome, "dox", "commands"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// addCommand writes a command configuration.
+func (c *cliTest) addCommand(name, yaml string) {
+	c.t.Helper()
+	path := filepath.Join(c.configHome, "dox", "commands", name+".yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// run runs dox with the given arguments and returns its exit code.
+func (c *cliTest) run(args ...string) int {
+	c.stdout.Reset()
+	c.stderr.Reset()
+	deps := &Dependencies{
+		Loader: config.NewLoader(),
+		NewRuntime: func(globalConfig *config.GlobalConfig, dockerHost string) (runtime.Runtime, error) {
+			c.hosts = append(c.hosts, dockerHost)
+			return c.runtime, nil
+		},
+		Versions: versioning.NewVersionStore(),
+		Executable: func() (string, error) {
+			return c.executable, nil
+		},
+		Stdin:  strings.NewReader(c.stdin),
+		Stdout: &c.stdout,
+		Stderr: &c.stderr,
+	}
+	return Run(deps, args)
+}
+
+func TestRunCommand(t *testing.T) {
+	c := newCLITest(t)
+	c.addCommand("python", "image: python:3.11\ncommand: python\n")
+	c.runtime.Stdout = "Python 3.11.0\n"
+	c.runtime.Stderr = "warning\n"
+	c.stdin = "print(1)\n"
+
+	if code := c.run("run", "python", "script.py"); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	if c.stdout.String() != "Python 3.11.0\n" {
+		t.Errorf("stdout = %q, want the command's output", c.stdout.String())
+	}
+	if c.stderr.String() != "warning\n" {
+		t.Errorf("stderr = %q, want the command's error output", c.stderr.String())
+	}
+
+	execution, ok := c.runtime.LastExecution()
+	if !ok {
+		t.Fatal("command was not executed")
+	}
+	if execution.Command != "python" || !reflect.DeepEqual(execution.Args, []string{"script.py"}) {
+		t.Errorf("executed %s %v, want python [script.py]", execution.Command, execution.Args)
+	}
+	if execution.Config.Image != "python:3.11" {
+		t.Errorf("Image = %s, want python:3.11", execution.Config.Image)
+	}
+	if execution.Stdin != "print(1)\n" {
+		t.Errorf("stdin = %q, want it forwarded", execution.Stdin)
+	}
+}
+
+func TestRunCommandExitCode(t *testing.T) {
+	c := newCLITest(t)
+	c.addCommand("tool", "image: alpine\n")
+	c.runtime.ExitCode = 3
+
+	if code := c.run("run", "tool"); code != 3 {
+		t.Errorf("exit code = %d, want the command's exit code 3", code)
+	}
+	if c.stderr.Len() != 0 {
+		t.Errorf("stderr = %q, want no error message for a non-zero exit", c.stderr.String())
+	}
+}
+
+func TestRunCommandOutOfMemory(t *testing.T) {
+	c := newCLITest(t)
+	c.addCommand("tool", "image: alpine\nresources:\n  memory: 512m\n")
+	c.runtime.ExitCode = 137
+	c.runtime.OOMKilled = true
+
+	if code := c.run("run", "tool"); code != 137 {
+		t.Errorf("exit code = %d, want the command's exit code 137", code)
+	}
+	if !strings.Contains(c.stderr.String(), "'tool' ran out of memory") {
+		t.Errorf("stderr = %q, want an out of memory message", c.stderr.String())
+	}
+
+	if code := c.run("--dox-errors=json", "run", "tool"); code != 137 {
+		t.Errorf("exit code = %d, want 137", code)
+	}
+	var report errorReport
+	if err := json.Unmarshal(c.stderr.Bytes(), &report); err != nil {
+		t.Fatalf("stderr = %q, want a JSON object: %v", c.stderr.String(), err)
+	}
+	if report.Kind != kindOutOfMemory || report.ExitCode != 137 || report.Command != "tool" {
+		t.Errorf("report = %+v, want an out_of_memory report for tool", report)
+	}
+}
+
+func TestRunCommandTimeout(t *testing.T) {
+	c := newCLITest(t)
+	c.addCommand("tool", "image: alpine\ntimeout: 10m\n")
+	c.runtime.ExitCode = 143
+	c.runtime.TimedOut = true
+
+	if code := c.run("run", "--timeout", "30s", "tool"); code != ExitTimeout {
+		t.Errorf("exit code = %d, want %d", code, ExitTimeout)
+	}
+	if !strings.Contains(c.stderr.String(), "'tool' timed out after 30s") {
+		t.Errorf("stderr = %q, want a timeout message", c.stderr.String())
+	}
+	if execution, _ := c.runtime.LastExecution(); execution.Config.Timeout != "30s" {
+		t.Errorf("Timeout = %q, want the flag to override the configuration", execution.Config.Timeout)
+	}
+
+	if code := c.run("--dox-errors=json", "tool"); code != ExitTimeout {
+		t.Errorf("exit code = %d, want %d", code, ExitTimeout)
+	}
+	var report errorReport
+	if err := json.Unmarshal(c.stderr.Bytes(), &report); err != nil {
+		t.Fatalf("stderr = %q, want a JSON object: %v", c.stderr.String(), err)
+	}
+	if report.Kind != kindTimeout || report.Command != "tool" || !strings.Contains(report.Message, "after 10m") {
+		t.Errorf("report = %+v, want a timeout report for tool", report)
+	}
+
+	if code := c.run("--timeout", "soon", "tool"); code != ExitUsage {
+		t.Errorf("exit code = %d, want %d for an invalid timeout", code, ExitUsage)
+	}
+}
+
+func TestRunCommandErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(c *cliTest)
+		args    []string
+		code    int
+		message string
+	}{
+		{
+			name:    "unknown command",
+			args:    []string{"run", "missing"},
+			code:    ExitCommandNotFound,
+			message: "command 'missing' doesn't exist",
+		},
+		{
+			name:    "missing command name",
+			args:    []string{"run"},
+			code:    ExitUsage,
+			message: "requires at least 1 arg",
+		},
+		{
+			name:    "unknown flag",
+			args:    []string{"list", "--bogus"},
+			code:    ExitUsage,
+			message: "unknown flag: --bogus",
+		},
+		{
+			name:    "invalid error format",
+			args:    []string{"list", "--dox-errors=xml"},
+			code:    ExitUsage,
+			message: "invalid --dox-errors format 'xml'",
+		},
+		{
+			name: "invalid config",
+			setup: func(c *cliTest) {
+				c.addCommand("tool", "network: host\n")
+			},
+			args:    []string{"run", "tool"},
+			code:    ExitConfig,
+			message: "configuration missing required field",
+		},
+		{
+			name: "runtime unavailable",
+			setup: func(c *cliTest) {
+				c.addCommand("tool", "image: alpine\n")
+				c.runtime.UnavailableError = errors.New("docker is not running")
+			},
+			args:    []string{"run", "tool"},
+			code:    ExitRuntime,
+			message: "docker is not running",
+		},
+		{
+			name: "execution failure",
+			setup: func(c *cliTest) {
+				c.addCommand("tool", "image: alpine\n")
+				c.runtime.ExecuteError = errors.New("unexpected failure")
+			},
+			args:    []string{"run", "tool"},
+			code:    ExitRuntime,
+			message: "command execution failed: unexpected failure",
+		},
+		{
+			name: "image failure",
+			setup: func(c *cliTest) {
+				c.addCommand("tool", "image: alpine\n")
+				c.runtime.ExecuteError = &runtime.ImageError{Image: "alpine", Err: errors.New("failed to pull image")}
+			},
+			args:    []string{"run", "tool"},
+			code:    ExitImage,
+			message: "command execution failed: failed to pull image",
+		},
+		{
+			name: "container failure",
+			setup: func(c *cliTest) {
+				c.addCommand("tool", "image: alpine\n")
+				c.runtime.ExecuteError = &runtime.ContainerError{Err: errors.New("failed to create container")}
+			},
+			args:    []string{"run", "tool"},
+			code:    ExitRuntime,
+			message: "command execution failed: failed to create container",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCLITest(t)
+			if tt.setup != nil {
+				tt.setup(c)
+			}
+			if code := c.run(tt.args...); code != tt.code {
+				t.Errorf("exit code = %d, want %d", code, tt.code)
+			}
+			if !strings.Contains(c.stderr.String(), tt.message) {
+				t.Errorf("stderr = %q, want it to contain %q", c.stderr.String(), tt.message)
+			}
+		})
+	}
+}
+
+func TestRunCommandRebuildsChangedInlineDockerfile(t *testing.T) {
+	c := newCLITest(t)
+	c.addCommand("tool", "build:\n  dockerfile_inline: FROM alpine\n")
+
+	c.run("run", "tool")
+	if execution, _ := c.runtime.LastExecution(); !execution.Upgrade {
+		t.Error("first run should build the image")
+	}
+
+	c.run("run", "tool")
+	if execution, _ := c.runtime.LastExecution(); execution.Upgrade {
+		t.Error("unchanged configuration should reuse the image")
+	}
+
+	c.addCommand("tool", "build:\n  dockerfile_inline: FROM alpine:3.19\n")
+	c.run("run", "tool")
+	if execution, _ := c.runtime.LastExecution(); !execution.Upgrade {
+		t.Error("changed configuration should rebuild the image")
+	}
+
+	// A failed run doesn't record the new version, so the next run rebuilds again.
+	c.addCommand("tool", "build:\n  dockerfile_inline: FROM alpine:3.20\n")
+	c.runtime.ExitCode = 1
+	c.run("run", "tool")
+	c.runtime.ExitCode = 0
+	c.run("run", "tool")
+	if execution, _ := c.runtime.LastExecution(); !execution.Upgrade {
+		t.Error("configuration should be rebuilt until a run succeeds")
+	}
+}
+
+func TestRunCommandUsesDockerHost(t *testing.T) {
+	c := newCLITest(t)
+	c.addCommand("tool", "image: alpine\ndocker_host: ssh://builder\n")
+
+	c.run("run", "tool")
+	if !reflect.DeepEqual(c.hosts, []string{"ssh://builder"}) {
+		t.Errorf("runtime hosts = %v, want [ssh://builder]", c.hosts)
+	}
+}
+
+func TestListCommand(t *testing.T) {
+	c := newCLITest(t)
+	if code := c.run("list"); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+	if !strings.Contains(c.stdout.String(), "No commands configured.") {
+		t.Errorf("stdout = %q, want the empty message", c.stdout.String())
+	}
+
+	c.addCommand("python", "image: python:3.11\n")
+	c.addCommand("node", "image: node:20\n")
+	if code := c.run("list"); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+	expected := "Available commands:\n  node\n  python\n"
+	if c.stdout.String() != expected {
+		t.Errorf("stdout = %q, want %q", c.stdout.String(), expected)
+	}
+}
+
+func TestUpgradeCommand(t *testing.T) {
+	t.Run("pulls the image", func(t *testing.T) {
+		c := newCLITest(t)
+		c.addCommand("python", "image: python:3.11\n")
+		if code := c.run("upgrade", "python"); code != 0 {
+			t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+		}
+		if !reflect.DeepEqual(c.runtime.Pulls, []string{"python:3.11"}) {
+			t.Errorf("pulls = %v, want [python:3.11]", c.runtime.Pulls)
+		}
+	})
+
+	t.Run("skips SHA-pinned images", func(t *testing.T) {
+		c := newCLITest(t)
+		c.addCommand("python", "image: python@sha256:abc123\n")
+		if code := c.run("upgrade", "python"); code != 0 {
+			t.Fatalf("exit code = %d, want 0", code)
+		}
+		if len(c.runtime.Pulls) != 0 {
+			t.Errorf("pulls = %v, want none", c.runtime.Pulls)
+		}
+		if !strings.Contains(c.stdout.String(), "SHA-pinned") {
+			t.Errorf("stdout = %q, want a SHA-pinned notice", c.stdout.String())
+		}
+	})
+
+	t.Run("removes inline images", func(t *testing.T) {
+		c := newCLITest(t)
+		c.addCommand("tool", "build:\n  dockerfile_inline: FROM alpine\n")
+		c.runtime.AddImage("dox-tool:latest")
+		if code := c.run("upgrade", "tool"); code != 0 {
+			t.Fatalf("exit code = %d, want 0", code)
+		}
+		if !reflect.DeepEqual(c.runtime.Removed, []string{"dox-tool:latest"}) {
+			t.Errorf("removed = %v, want [dox-tool:latest]", c.runtime.Removed)
+		}
+	})
+
+	t.Run("missing inline image is not an error", func(t *testing.T) {
+		c := newCLITest(t)
+		c.addCommand("tool", "build:\n  dockerfile_inline: FROM alpine\n")
+		if code := c.run("upgrade", "tool"); code != 0 {
+			t.Fatalf("exit code = %d, want 0", code)
+		}
+		if strings.Contains(c.stdout.String(), "Warning") {
+			t.Errorf("stdout = %q, want no warning", c.stdout.String())
+		}
+	})
+
+	t.Run("pull failure", func(t *testing.T) {
+		c := newCLITest(t)
+		c.addCommand("python", "image: python:3.11\n")
+		c.runtime.PullError = errors.New("manifest unknown")
+		if code := c.run("upgrade", "python"); code != ExitImage {
+			t.Errorf("exit code = %d, want %d", code, ExitImage)
+		}
+		if !strings.Contains(c.stderr.String(), "failed to pull image: manifest unknown") {
+			t.Errorf("stderr = %q, want the pull error", c.stderr.String())
+		}
+	})
+}
+
+func TestUpgradeAllCommand(t *testing.T) {
+	c := newCLITest(t)
+	if code := c.run("upgrade-all"); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+	if !strings.Contains(c.stdout.String(), "No commands to upgrade.") {
+		t.Errorf("stdout = %q, want the empty message", c.stdout.String())
+	}
+
+	c.addCommand("python", "image: python:3.11\n")
+	c.addCommand("pinned", "image: alpine@sha256:abc123\n")
+	c.addCommand("tool", "build:\n  dockerfile_inline: FROM alpine\n")
+	c.addCommand("remote", "image: redis:7\ndocker_host: tcp://builder:2376\n")
+	c.addCommand("broken", "network: host\n")
+	c.runtime.AddImage("dox-tool:latest")
+
+	if code := c.run("upgrade-all"); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	if !reflect.DeepEqual(c.runtime.Pulls, []string{"python:3.11", "redis:7"}) {
+		t.Errorf("pulls = %v, want [python:3.11 redis:7]", c.runtime.Pulls)
+	}
+	if !reflect.DeepEqual(c.runtime.Removed, []string{"dox-tool:latest"}) {
+		t.Errorf("removed = %v, want [dox-tool:latest]", c.runtime.Removed)
+	}
+	if !reflect.DeepEqual(c.hosts, []string{"", "tcp://builder:2376"}) {
+		t.Errorf("runtime hosts = %v, want the default and the remote host", c.hosts)
+	}
+
+	output := c.stdout.String()
+	for _, expected := range []string{"Failed to load config for 'broken'", "Skipping 'pinned'", "Upgraded 3 command(s)"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("stdout = %q, want it to contain %q", output, expected)
+		}
+	}
+}
+
+func TestCleanCommand(t *testing.T) {
+	c := newCLITest(t)
+	if code := c.run("clean"); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+	if c.runtime.Cleanups != 1 {
+		t.Errorf("cleanups = %d, want 1", c.runtime.Cleanups)
+	}
+	if !strings.Contains(c.stdout.String(), "Cleanup complete.") {
+		t.Errorf("stdout = %q, want the completion message", c.stdout.String())
+	}
+
+	c.runtime.CleanError = errors.New("daemon error")
+	if code := c.run("clean"); code != ExitRuntime {
+		t.Errorf("exit code = %d, want %d", code, ExitRuntime)
+	}
+	if !strings.Contains(c.stderr.String(), "failed to remove containers: daemon error") {
+		t.Errorf("stderr = %q, want the cleanup error", c.stderr.String())
+	}
+}
+
+func TestVersionCommand(t *testing.T) {
+	c := newCLITest(t)
+	if code := c.run("version"); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+	if c.stdout.String() != "dox version "+version+"\n" {
+		t.Errorf("stdout = %q, want the version", c.stdout.String())
+	}
+}
+
+func TestBenchCommand(t *testing.T) {
+	c := newCLITest(t)
+	c.addCommand("tool", "image: alpine\n")
+	c.runtime.Stdout = "tool 1.0\n"
+
+	if code := c.run("bench", "--runs", "3", "tool", "--version"); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	if len(c.runtime.Executions) != 4 {
+		t.Errorf("executions = %d, want a first run and 3 measured ones", len(c.runtime.Executions))
+	}
+	if execution, _ := c.runtime.LastExecution(); !reflect.DeepEqual(execution.Args, []string{"--version"}) {
+		t.Errorf("args = %v, want the arguments after the command", execution.Args)
+	}
+	output := c.stdout.String()
+	for _, expected := range []string{"PHASE", "resolve", "prepare", "run", "total", "overhead", "within the budget of 3s"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("stdout = %q, want it to contain %q", output, expected)
+		}
+	}
+	if strings.Contains(output, "tool 1.0") {
+		t.Errorf("stdout = %q, want the command's output discarded", output)
+	}
+
+	if code := c.run("bench", "--runs", "0", "tool"); code != ExitUsage {
+		t.Errorf("exit code = %d, want %d for an invalid number of runs", code, ExitUsage)
+	}
+}
+
+func TestDirectInvocation(t *testing.T) {
+	c := newCLITest(t)
+	c.addCommand("tool", "image: alpine\n")
+
+	if code := c.run("tool", "build", "--upgrade", "--help", "-v"); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	execution, ok := c.runtime.LastExecution()
+	if !ok {
+		t.Fatal("command was not executed")
+	}
+	if execution.Command != "tool" || !reflect.DeepEqual(execution.Args, []string{"build", "--upgrade", "--help", "-v"}) {
+		t.Errorf("executed %s %v, want tool with the arguments verbatim", execution.Command, execution.Args)
+	}
+	if execution.Upgrade {
+		t.Error("a flag after the command name should not upgrade the image")
+	}
+
+	if code := c.run("--upgrade", "tool", "--version"); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	execution, _ = c.runtime.LastExecution()
+	if !execution.Upgrade || !reflect.DeepEqual(execution.Args, []string{"--version"}) {
+		t.Errorf("executed with upgrade %v and args %v, want an upgrade with [--version]", execution.Upgrade, execution.Args)
+	}
+
+	if code := c.run("--bogus", "tool"); code != ExitUsage {
+		t.Errorf("exit code = %d, want %d for an unknown dox flag", code, ExitUsage)
+	}
+
+	if code := c.run("frobnicate"); code != ExitCommandNotFound {
+		t.Errorf("exit code = %d, want %d", code, ExitCommandNotFound)
+	}
+	if !strings.Contains(c.stderr.String(), "command 'frobnicate' doesn't exist") {
+		t.Errorf("stderr = %q, want a missing command error", c.stderr.String())
+	}
+
+	if code := c.run(); code != 0 {
+		t.Errorf("exit code = %d, want 0", code)
+	}
+	if !strings.Contains(c.stdout.String(), "Usage:") {
+		t.Errorf("stdout = %q, want the help", c.stdout.String())
+	}
+}
+
+func TestRunCommandPassesFlagsThrough(t *testing.T) {
+	c := newCLITest(t)
+	c.addCommand("tool", "image: alpine\n")
+
+	if code := c.run("run", "tool", "--flag", "--upgrade", "--help"); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	execution, _ := c.runtime.LastExecution()
+	if !reflect.DeepEqual(execution.Args, []string{"--flag", "--upgrade", "--help"}) || execution.Upgrade {
+		t.Errorf("executed with upgrade %v and args %v, want the flags passed through", execution.Upgrade, execution.Args)
+	}
+
+	if code := c.run("run", "--flag", "tool"); code != ExitUsage {
+		t.Errorf("exit code = %d, want %d for an unknown flag before the command name", code, ExitUsage)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	c := newCLITest(t)
+	code := c.run("run", "--dox-errors=json", "missing")
+	if code != ExitCommandNotFound {
+		t.Errorf("exit code = %d, want %d", code, ExitCommandNotFound)
+	}
+
+	var report map[string]interface{}
+	if err := json.Unmarshal(c.stderr.Bytes(), &report); err != nil {
+		t.Fatalf("stderr = %q, want a JSON object: %v", c.stderr.String(), err)
+	}
+	expected := map[string]interface{}{
+		"kind":      "command_not_found",
+		"message":   report["message"],
+		"exit_code": float64(ExitCommandNotFound),
+		"command":   "missing",
+		"path":      filepath.Join(c.configHome, "dox", "commands", "missing.yaml"),
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("report = %v, want %v", report, expected)
+	}
+	if !strings.HasPrefix(report["message"].(string), "command 'missing' doesn't exist") {
+		t.Errorf("message = %q, want the error message", report["message"])
+	}
+
+	// The exit code of the command is passed through without a report.
+	c = newCLITest(t)
+	c.addCommand("tool", "image: alpine\n")
+	c.runtime.ExitCode = 3
+	if code := c.run("--dox-errors=json", "run", "tool"); code != 3 {
+		t.Errorf("exit code = %d, want 3", code)
+	}
+	if c.stderr.Len() != 0 {
+		t.Errorf("stderr = %q, want nothing for a non-zero exit", c.stderr.String())
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	tests := []struct {
+		argv     []string
+		expected []string
+	}{
+		{[]string{"/usr/local/bin/dox", "list"}, []string{"list"}},
+		{[]string{"dox.exe", "--upgrade", "tool"}, []string{"--upgrade", "tool"}},
+		{[]string{"/home/user/.local/bin/terraform", "plan", "--help"}, []string{"run", "terraform", "plan", "--help"}},
+		{[]string{"python3.11", "-V"}, []string{"run", "python3.11", "-V"}},
+		{[]string{"node.exe"}, []string{"run", "node"}},
+	}
+
+	for _, tt := range tests {
+		if actual := commandLine(tt.argv); !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("commandLine(%v) = %v, want %v", tt.argv, actual, tt.expected)
+		}
+	}
+}
+
+func TestRunCommandOverrides(t *testing.T) {
+	c := newCLITest(t)
+	c.addCommand("tool", "build:\n  dockerfile_inline: FROM alpine\nvolumes:\n  - /cache:/cache\nenvironment:\n  - TOOL_MODE=fast\nports:\n  - \"3000:3000\"\n")
+	t.Setenv("DOX_TEST_TOKEN", "secret")
+
+	// The first run builds the image and records the configuration's version.
+	if code := c.run("tool"); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+
+	code := c.run("-e", "TOOL_MODE=slow", "-e", "DOX_TEST_TOKEN", "-e", "DOX_TEST_UNSET", "-v", "/data:/data:ro",
+		"-p", "8080:80", "--network", "bridge", "--workdir", "/src", "--entrypoint", "/bin/sh", "--user", "0:0", "tool", "-c", "id")
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+
+	execution, _ := c.runtime.LastExecution()
+	cfg := execution.Config
+	if !reflect.DeepEqual(cfg.Volumes, []string{"/cache:/cache", "/data:/data:ro"}) {
+		t.Errorf("Volumes = %v, want the configured and the extra volume", cfg.Volumes)
+	}
+	if !reflect.DeepEqual(cfg.Ports, []string{"3000:3000", "8080:80"}) {
+		t.Errorf("Ports = %v, want the configured and the extra port", cfg.Ports)
+	}
+	if !reflect.DeepEqual(cfg.Environment, []string{"DOX_TEST_TOKEN=secret", "TOOL_MODE=slow"}) {
+		t.Errorf("Environment = %v, want the overrides", cfg.Environment)
+	}
+	if cfg.Network != "bridge" || cfg.WorkingDir != "/src" || cfg.Entrypoint != "/bin/sh" || cfg.User != "0:0" {
+		t.Errorf("network %q, workdir %q, entrypoint %q and user %q, want the overrides", cfg.Network, cfg.WorkingDir, cfg.Entrypoint, cfg.User)
+	}
+	if !reflect.DeepEqual(execution.Args, []string{"-c", "id"}) {
+		t.Errorf("Args = %v, want [-c id]", execution.Args)
+	}
+	if execution.Upgrade {
+		t.Error("overrides should not count as a configuration change")
+	}
+
+	// Overrides only apply to the run they are given for.
+	if code := c.run("tool"); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	execution, _ = c.runtime.LastExecution()
+	if len(execution.Config.Volumes) != 1 || execution.Config.User != "" || execution.Upgrade {
+		t.Errorf("config = %+v with upgrade %v, want the configuration file's", execution.Config, execution.Upgrade)
+	}
+
+	if code := c.run("-e", "=value", "tool"); code != ExitUsage {
+		t.Errorf("exit code = %d, want %d for an invalid variable", code, ExitUsage)
+	}
+
+	// Commands with an egress allowlist have their own network.
+	c.addCommand("fenced", "image: alpine\negress:\n  allow: [pypi.org]\n")
+	if code := c.run("--network", "host", "fenced"); code != ExitUsage {
+		t.Errorf("exit code = %d, want %d for a network override with egress", code, ExitUsage)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	c := newCLITest(t)
+	c.addCommand("tool", "build:\n  dockerfile_inline: FROM alpine\nenvironment:\n  - API_TOKEN=abc123\n")
+
+	for _, args := range [][]string{{"--dry-run", "tool", "--version"}, {"explain", "tool", "--version"}} {
+		if code := c.run(args...); code != 0 {
+			t.Fatalf("%v: exit code = %d, want 0 (stderr: %s)", args, code, c.stderr.String())
+		}
+		output := c.stdout.String()
+		for _, expected := range []string{"docker build -t dox-tool:latest - <<'EOF'\nFROM alpine\nEOF\n", "docker run --rm -i ", "-e 'API_TOKEN=****' dox-tool:latest --version\n"} {
+			if !strings.Contains(output, expected) {
+				t.Errorf("%v: stdout = %q, want it to contain %q", args, output, expected)
+			}
+		}
+		if strings.Contains(output, "abc123") {
+			t.Errorf("%v: stdout = %q, want the secret masked", args, output)
+		}
+	}
+
+	if len(c.runtime.Executions) != 0 || len(c.runtime.Builds) != 0 || len(c.runtime.Pulls) != 0 {
+		t.Errorf("executions %d, builds %v and pulls %v, want nothing run", len(c.runtime.Executions), c.runtime.Builds, c.runtime.Pulls)
+	}
+
+	// A dry run doesn't record the configuration's version, so the image is still
+	// built when the command is run for real.
+	if code := c.run("tool"); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	if execution, _ := c.runtime.LastExecution(); !execution.Upgrade {
+		t.Error("the first real run should still rebuild the image")
+	}
+}
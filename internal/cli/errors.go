@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/skorokithakis/dox/internal/config"
+	"github.com/skorokithakis/dox/internal/runtime"
+)
+
+// Exit codes reserved for dox's own failures. Like docker run, dox passes the
+// exit code of the containerized command through, so these are chosen to be
+// unlikely to clash with it.
+const (
+	// ExitUsage means dox was invoked incorrectly, e.g. with an unknown flag.
+	ExitUsage = 64
+	// ExitConfig means a configuration file couldn't be read or is invalid.
+	ExitConfig = 78
+	// ExitTimeout means the command ran for longer than its timeout and was
+	// stopped, like with timeout(1).
+	ExitTimeout = 124
+	// ExitRuntime means the container runtime is unavailable, the container
+	// couldn't be created or started, or dox failed in some other way.
+	ExitRuntime = 125
+	// ExitImage means the command's image couldn't be pulled or built.
+	ExitImage = 126
+	// ExitCommandNotFound means the command isn't configured.
+	ExitCommandNotFound = 127
+)
+
+// Error kinds reported with --dox-errors=json.
+const (
+	kindUsage              = "usage"
+	kindConfig             = "config"
+	kindCommandNotFound    = "command_not_found"
+	kindRuntimeUnavailable = "runtime_unavailable"
+	kindImage              = "image"
+	kindContainer          = "container"
+	kindOutOfMemory        = "out_of_memory"
+	kindTimeout            = "timeout"
+	kindSandboxNotFound    = "sandbox_not_found"
+	kindSandboxConflict    = "sandbox_conflict"
+	kindInternal           = "internal"
+)
+
+// Error formats accepted by --dox-errors.
+const (
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+)
+
+// UsageError reports that dox was invoked incorrectly.
+type UsageError struct {
+	Err error
+}
+
+// Error implements the error interface.
+func (e *UsageError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *UsageError) Unwrap() error {
+	return e.Err
+}
+
+// OutOfMemoryError reports that a command was killed for exceeding its memory
+// limit. Dox exits with the command's exit code, like for any other exit.
+type OutOfMemoryError struct {
+	Command string
+	Code    int
+}
+
+// Error implements the error interface.
+func (e *OutOfMemoryError) Error() string {
+	return fmt.Sprintf("'%s' ran out of memory and was killed; raise resources.memory in its configuration to give it more", e.Command)
+}
+
+// TimeoutError reports that a command ran for longer than its timeout and was
+// stopped.
+type TimeoutError struct {
+	Command string
+	Timeout string
+}
+
+// Error implements the error interface.
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("'%s' timed out after %s and was stopped", e.Command, e.Timeout)
+}
+
+// errorReport is the machine-readable form of an error printed with --dox-errors=json.
+type errorReport struct {
+	Kind     string `json:"kind"`
+	Message  string `json:"message"`
+	ExitCode int    `json:"exit_code"`
+	Command  string `json:"command,omitempty"`
+	Image    string `json:"image,omitempty"`
+	Path     string `json:"path,omitempty"`
+}
+
+// classify returns the report for an error of dox itself.
+func classify(err error) errorReport {
+	report := errorReport{Kind: kindInternal, Message: err.Error(), ExitCode: ExitRuntime}
+
+	var (
+		usageErr       *UsageError
+		notFoundErr    *config.NotFoundError
+		invalidErr     *config.InvalidError
+		unavailableErr *runtime.UnavailableError
+		imageErr       *runtime.ImageError
+		containerErr   *runtime.ContainerError
+		oomErr         *OutOfMemoryError
+		timeoutErr     *TimeoutError
+		sandboxErr     *runtime.SandboxNotFoundError
+		conflictErr    *runtime.SandboxConflictError
+	)
+	switch {
+	case errors.As(err, &usageErr):
+		report.Kind, report.ExitCode = kindUsage, ExitUsage
+	case errors.As(err, &notFoundErr):
+		report.Kind, report.ExitCode = kindCommandNotFound, ExitCommandNotFound
+		report.Command, report.Path = notFoundErr.Command, notFoundErr.Path
+	case errors.As(err, &invalidErr):
+		report.Kind, report.ExitCode = kindConfig, ExitConfig
+		report.Path = invalidErr.Path
+	case errors.As(err, &unavailableErr):
+		report.Kind, report.ExitCode = kindRuntimeUnavailable, ExitRuntime
+	case errors.As(err, &imageErr):
+		report.Kind, report.ExitCode = kindImage, ExitImage
+		report.Image = imageErr.Image
+	case errors.As(err, &containerErr):
+		report.Kind, report.ExitCode = kindContainer, ExitRuntime
+	case errors.As(err, &oomErr):
+		report.Kind, report.ExitCode = kindOutOfMemory, oomErr.Code
+		report.Command = oomErr.Command
+	case errors.As(err, &timeoutErr):
+		report.Kind, report.ExitCode = kindTimeout, ExitTimeout
+		report.Command = timeoutErr.Command
+	case errors.As(err, &sandboxErr):
+		report.Kind, report.ExitCode = kindSandboxNotFound, ExitUsage
+	case errors.As(err, &conflictErr):
+		report.Kind, report.ExitCode = kindSandboxConflict, ExitRuntime
+	}
+
+	return report
+}
+
+// exitCode converts the error returned by a command into a process exit code,
+// printing it in the given format unless it only carries an exit status.
+func exitCode(err error, format string, stderr io.Writer) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+
+	report := classify(err)
+	if format == errorFormatJSON {
+		encoded, _ := json.Marshal(report)
+		fmt.Fprintf(stderr, "%s\n", encoded)
+	} else {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+	}
+	return report.ExitCode
+}
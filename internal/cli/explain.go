@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/skorokithakis/dox/pkg/dox"
+)
+
+// newExplainCommand creates the explain command.
+func newExplainCommand(client *dox.Client) *cobra.Command {
+	flags := &runFlags{}
+
+	cmd := &cobra.Command{
+		Use:   "explain [flags] <command> [arguments...]",
+		Short: "Show how a command would be run",
+		Long: `Print the docker or podman commands equivalent to running a command, including any
+image build or pull, without running anything. This is the same as "dox run --dry-run".
+
+Values of environment variables whose names look like secrets are masked, as are
+passwords in URLs. Other values are printed as they are.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.dryRun = true
+			return runCommand(cmd, client, args, flags)
+		},
+	}
+
+	addRunFlags(cmd, flags)
+
+	return cmd
+}
@@ -2,36 +2,33 @@ package cli
 
 import (
 	"fmt"
-	"sort"
 
 	"github.com/spf13/cobra"
-	"github.com/skorokithakis/dox/internal/config"
+	"github.com/skorokithakis/dox/pkg/dox"
 )
 
 // newListCommand creates the list command.
-func newListCommand() *cobra.Command {
+func newListCommand(client *dox.Client) *cobra.Command {
 	return &cobra.Command{
 		Use:   "list",
 		Short: "List available commands",
 		Long:  "List all commands configured in the dox commands directory",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			loader := config.NewLoader()
-			commands, err := loader.ListCommands()
+			commands, err := client.Commands()
 			if err != nil {
-				return fmt.Errorf("failed to list commands: %w", err)
+				return err
 			}
 
 			if len(commands) == 0 {
-				fmt.Println("No commands configured.")
-				fmt.Println("\nTo add a command, create a YAML file in:")
-				fmt.Println("  ${XDG_CONFIG_HOME}/dox/commands/<command>.yaml")
+				fmt.Fprintln(cmd.OutOrStdout(), "No commands configured.")
+				fmt.Fprintln(cmd.OutOrStdout(), "\nTo add a command, create a YAML file in:")
+				fmt.Fprintln(cmd.OutOrStdout(), "  ${XDG_CONFIG_HOME}/dox/commands/<command>.yaml")
 				return nil
 			}
 
-			fmt.Println("Available commands:")
-			sort.Strings(commands)
+			fmt.Fprintln(cmd.OutOrStdout(), "Available commands:")
 			for _, command := range commands {
-				fmt.Printf("  %s\n", command)
+				fmt.Fprintf(cmd.OutOrStdout(), "  %s\n", command)
 			}
 
 			return nil
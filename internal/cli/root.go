@@ -1,42 +1,179 @@
 package cli
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/skorokithakis/dox/internal/config"
+	"github.com/skorokithakis/dox/internal/versioning"
+	"github.com/skorokithakis/dox/pkg/dox"
 )
 
 var (
 	version = "0.1.0"
 )
 
-// Execute runs the CLI.
-func Execute() {
+// Dependencies are the external services and streams the CLI works with.
+type Dependencies struct {
+	Loader     dox.ConfigLoader
+	NewRuntime dox.RuntimeFactory
+	Versions   dox.VersionStore
+	// Executable returns the path of the dox binary, which shims link to.
+	Executable func() (string, error)
+	Stdin      io.Reader
+	Stdout     io.Writer
+	Stderr     io.Writer
+}
+
+// DefaultDependencies returns the dependencies used by the dox binary.
+func DefaultDependencies() *Dependencies {
+	return &Dependencies{
+		Loader:     config.NewLoader(),
+		NewRuntime: dox.NewRuntime,
+		Versions:   versioning.NewVersionStore(),
+		Executable: os.Executable,
+		Stdin:      os.Stdin,
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+	}
+}
+
+// client returns a dox client that uses the dependencies.
+func (d *Dependencies) client() *dox.Client {
+	return dox.New(
+		dox.WithLoader(d.Loader),
+		dox.WithRuntimeFactory(d.NewRuntime),
+		dox.WithVersionStore(d.Versions),
+	)
+}
+
+// ExitError reports that a command finished with a non-zero exit status that
+// should be passed on as is, like the exit code of a containerized command.
+type ExitError struct {
+	Code int
+}
+
+// Error implements the error interface.
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// Execute runs the CLI with the process arguments and returns the exit code.
+// When dox is invoked through a shim, it runs the command the shim is named after.
+func Execute() int {
+	return Run(DefaultDependencies(), commandLine(os.Args))
+}
+
+// commandLine returns the dox arguments for a process's argv. If the binary was
+// invoked under a name other than dox, like a shim named "terraform", the name is
+// the command to run and all arguments are passed to it.
+func commandLine(argv []string) []string {
+	name := filepath.Base(argv[0])
+	if ext := filepath.Ext(name); strings.EqualFold(ext, ".exe") {
+		name = strings.TrimSuffix(name, ext)
+	}
+	if name == "dox" {
+		return argv[1:]
+	}
+	return append([]string{"run", name}, argv[1:]...)
+}
+
+// Run runs the CLI with the given dependencies and arguments and returns the exit code.
+func Run(deps *Dependencies, args []string) int {
+	rootCmd := newRootCommand(deps)
+	rootCmd.SetArgs(args)
+
+	err := rootCmd.Execute()
+	format, _ := rootCmd.PersistentFlags().GetString("dox-errors")
+	return exitCode(err, format, deps.Stderr)
+}
+
+// newRootCommand creates the dox command with all of its subcommands.
+func newRootCommand(deps *Dependencies) *cobra.Command {
+	client := deps.client()
+	flags := &runFlags{}
 
 	rootCmd := &cobra.Command{
-		Use:   "dox",
+		Use:   "dox [flags] <command> [arguments...]",
 		Short: "Execute commands in Docker containers",
 		Long: `Dox is a lightweight wrapper that transparently executes commands within Docker or Podman containers
-while maintaining the user experience of native host commands.`,
+while maintaining the user experience of native host commands.
+
+Any command that isn't one of dox's own is run as a configured command. Dox's flags
+must come before the command name; everything after it is passed to the command.`,
+		Example: `  dox python script.py
+  dox --upgrade terraform plan -out plan.tfplan`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return cmd.Help()
+			}
+			return runCommand(cmd, client, args, flags)
+		},
 		SilenceUsage: true,
+		// Errors are printed by Run, which knows which ones carry an exit status.
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("dox-errors")
+			if format != errorFormatText && format != errorFormatJSON {
+				return &UsageError{Err: fmt.Errorf("invalid --dox-errors format '%s': must be %s or %s", format, errorFormatText, errorFormatJSON)}
+			}
+			return nil
+		},
 	}
+	rootCmd.SetIn(deps.Stdin)
+	rootCmd.SetOut(deps.Stdout)
+	rootCmd.SetErr(deps.Stderr)
+
+	addRunFlags(rootCmd, flags)
+	rootCmd.PersistentFlags().String("dox-errors", errorFormatText, "Print dox's own errors as text or json")
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &UsageError{Err: err}
+	})
 
 	// Disable default completion command.
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
 
 	// Add subcommands.
 	rootCmd.AddCommand(
-		newRunCommand(),
-		newListCommand(),
+		newRunCommand(client),
+		newExplainCommand(client),
+		newBenchCommand(client),
+		newListCommand(client),
 		newVersionCommand(),
-		newUpgradeCommand(),
-		newUpgradeAllCommand(),
-		newCleanCommand(),
+		newUpgradeCommand(client),
+		newUpgradeAllCommand(client),
+		newCleanCommand(client),
+		newSandboxCommand(),
+		newShimsCommand(client, deps.Executable),
 	)
 
+	// Wrong argument counts are usage errors too.
+	wrapArgs(rootCmd)
+
+	return rootCmd
+}
 
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+// wrapArgs makes the argument validators of a command's subcommands return usage errors.
+func wrapArgs(parent *cobra.Command) {
+	for _, cmd := range parent.Commands() {
+		if cmd.Args != nil {
+			cmd.Args = usageArgs(cmd.Args)
+		}
+		wrapArgs(cmd)
 	}
 }
 
+// usageArgs makes an argument validator return its errors as usage errors.
+func usageArgs(validate cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := validate(cmd, args); err != nil {
+			return &UsageError{Err: err}
+		}
+		return nil
+	}
+}
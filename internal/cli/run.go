@@ -4,113 +4,180 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
-	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/skorokithakis/dox/internal/config"
-	"github.com/skorokithakis/dox/internal/runtime"
-	"github.com/skorokithakis/dox/internal/versioning"
+	"github.com/skorokithakis/dox/pkg/dox"
 )
 
+// runFlags are the flags for running a command. Except for upgrade, dryRun and
+// sandbox, they override the command's configuration for a single run.
+type runFlags struct {
+	upgrade    bool
+	dryRun     bool
+	env        []string
+	volumes    []string
+	ports      []string
+	network    string
+	workdir    string
+	entrypoint string
+	user       string
+	timeout    string
+	sandbox    bool
+}
+
 // newRunCommand creates the run command.
-func newRunCommand() *cobra.Command {
-	var upgrade bool
+func newRunCommand(client *dox.Client) *cobra.Command {
+	flags := &runFlags{}
 	
 	cmd := &cobra.Command{
-		Use:   "run [command] [arguments...]",
+		Use:   "run [flags] <command> [arguments...]",
 		Short: "Run a containerized command",
-		Long: `Run a command in a Docker or Podman container.
+		Long: `Run a command in a Docker or Podman container. This is the same as "dox <command>",
+and also works for commands named like one of dox's own.
 
-The command must have a configuration file in ~/.config/dox/commands/<command>.yaml`,
+The command must have a configuration file in ~/.config/dox/commands/<command>.yaml.
+Dox's flags must come before the command name; everything after it is passed to the command.`,
 		Args: cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runCommand(cmd, args, upgrade)
+			return runCommand(cmd, client, args, flags)
 		},
 	}
 	
-	// Add upgrade flag.
-	cmd.Flags().BoolVar(&upgrade, "upgrade", false, "Force pull/rebuild the container image")
-	
-	// Disable flag parsing after the first argument to pass all flags to the containerized command.
-	cmd.TraverseChildren = false
-	cmd.FParseErrWhitelist.UnknownFlags = true
+	addRunFlags(cmd, flags)
 	
 	return cmd
 }
 
-// runCommand handles execution of containerized commands.
-func runCommand(cmd *cobra.Command, args []string, upgrade bool) error {
-	// First argument is the command to run.
-	command := args[0]
-	commandArgs := args[1:]
+// addRunFlags adds the flags for running a command, and stops flag parsing at the
+// command name so that everything after it is passed to the containerized command.
+func addRunFlags(cmd *cobra.Command, flags *runFlags) {
+	cmd.Flags().BoolVar(&flags.upgrade, "upgrade", false, "Force pull/rebuild the container image")
+	cmd.Flags().BoolVar(&flags.dryRun, "dry-run", false, "Print the equivalent docker or podman commands instead of running anything")
+	cmd.Flags().StringArrayVarP(&flags.env, "env", "e", nil, "Set an environment variable (NAME=value), or pass NAME through from the host")
+	cmd.Flags().StringArrayVarP(&flags.volumes, "volume", "v", nil, "Mount an additional volume (source:destination[:options])")
+	cmd.Flags().StringArrayVarP(&flags.ports, "publish", "p", nil, "Publish an additional port ([host_ip:]host_port:container_port)")
+	cmd.Flags().StringVar(&flags.network, "network", "", "Override the network mode")
+	cmd.Flags().StringVar(&flags.workdir, "workdir", "", "Override the working directory in the container")
+	cmd.Flags().StringVar(&flags.entrypoint, "entrypoint", "", "Override the image's entrypoint")
+	cmd.Flags().StringVar(&flags.user, "user", "", "Override the user the command runs as (host, root, image, a name or uid[:gid])")
+	cmd.Flags().StringVar(&flags.timeout, "timeout", "", "Stop the command if it runs for longer than this (e.g. 30s or 10m)")
+	cmd.Flags().BoolVar(&flags.sandbox, "sandbox", false, "Run the command on a copy of the workspace, whose changes are applied with \"dox sandbox apply\"")
+	cmd.Flags().SetInterspersed(false)
+}
 
-	// Load configuration.
-	loader := config.NewLoader()
-	
-	globalConfig, err := loader.LoadGlobalConfig()
-	if err != nil {
-		return fmt.Errorf("failed to load global config: %w", err)
+// apply overlays the flags on a resolved command's configuration and returns the
+// environment variables they set. The configuration file is left alone, so
+// overrides don't count as configuration changes.
+func (f *runFlags) apply(command *dox.Command) (map[string]string, error) {
+	overlay := *command.Config
+
+	if len(f.volumes) > 0 {
+		overlay.Volumes = append([]string(nil), overlay.Volumes...)
+		for _, volume := range f.volumes {
+			overlay.Volumes = append(overlay.Volumes, config.ExpandVolume(volume))
+		}
+	}
+	if len(f.ports) > 0 {
+		overlay.Ports = append(append([]string(nil), overlay.Ports...), f.ports...)
+	}
+	if f.network != "" {
+		if overlay.Egress != nil {
+			return nil, &UsageError{Err: fmt.Errorf("--network can't be used with %s's egress allowlist, which uses its own network", command.Name)}
+		}
+		overlay.Network = f.network
+	}
+	if f.workdir != "" {
+		overlay.WorkingDir = f.workdir
+	}
+	if f.entrypoint != "" {
+		overlay.Entrypoint = f.entrypoint
+	}
+	if f.user != "" {
+		overlay.User = f.user
+	}
+	if f.timeout != "" {
+		if timeout, err := time.ParseDuration(f.timeout); err != nil || timeout <= 0 {
+			return nil, &UsageError{Err: fmt.Errorf("invalid --timeout '%s': must be a duration like 30s or 10m", f.timeout)}
+		}
+		overlay.Timeout = f.timeout
+	}
+	command.Config = &overlay
+
+	env := make(map[string]string)
+	for _, entry := range f.env {
+		name, value, hasValue := strings.Cut(entry, "=")
+		if name == "" {
+			return nil, &UsageError{Err: fmt.Errorf("invalid environment variable '%s': must be NAME=value or NAME", entry)}
+		}
+		// Like docker run, names without a value are passed through when set on the host.
+		if !hasValue {
+			var ok bool
+			if value, ok = os.LookupEnv(name); !ok {
+				continue
+			}
+		}
+		env[name] = value
 	}
+	return env, nil
+}
 
-	commandConfig, err := loader.LoadCommandConfig(command)
+// runCommand handles execution of containerized commands.
+// A non-zero exit code of the command is returned as an *ExitError, unless the
+// command ran out of memory or timed out, which are returned as an
+// *OutOfMemoryError and a *TimeoutError.
+func runCommand(cmd *cobra.Command, client *dox.Client, args []string, flags *runFlags) error {
+	// First argument is the command to run.
+	command, err := client.Resolve(args[0])
 	if err != nil {
 		return err
 	}
 
-	// Check if the command YAML has changed.
-	versionStore := versioning.NewVersionStore()
-	commandChanged, err := versionStore.HasCommandChanged(command)
+	env, err := flags.apply(command)
 	if err != nil {
-		logrus.Warnf("Failed to check command version: %v", err)
-		// Continue without version checking on error.
-		commandChanged = false
+		return err
 	}
-	
-	// Force rebuild if the YAML file has changed.
-	if commandChanged && (commandConfig.Build != nil && commandConfig.Build.DockerfileInline != "") {
-		logrus.Infof("Command configuration has changed, rebuilding container...")
-		upgrade = true
+
+	opts := dox.RunOptions{
+		Args:    args[1:],
+		Stdin:   cmd.InOrStdin(),
+		Stdout:  cmd.OutOrStdout(),
+		Stderr:  cmd.ErrOrStderr(),
+		Env:     env,
+		Upgrade: flags.upgrade,
+		Sandbox: flags.sandbox,
 	}
 
-	// Create runtime based on configuration.
-	var rt runtime.Runtime
-	switch globalConfig.Runtime {
-	case "podman":
-		podmanRuntime, err := runtime.NewPodmanRuntime()
+	if flags.dryRun {
+		plan, err := command.Explain(context.Background(), opts)
 		if err != nil {
 			return err
 		}
-		rt = podmanRuntime
-	default:
-		dockerRuntime, err := runtime.NewDockerRuntime()
-		if err != nil {
-			return err
-		}
-		rt = dockerRuntime
-	}
-
-	// Check if runtime is available.
-	ctx := context.Background()
-	if err := rt.IsAvailable(ctx); err != nil {
-		return err
+		fmt.Fprint(cmd.OutOrStdout(), plan)
+		return nil
 	}
 
 	// Execute the command in container.
-	exitCode, err := rt.ExecuteCommand(ctx, commandConfig, command, commandArgs, upgrade, os.Stdin, os.Stdout, os.Stderr)
+	result, err := command.Run(context.Background(), opts)
+	if result.Sandbox != nil {
+		if reportErr := reportSandbox(cmd.ErrOrStderr(), result.Sandbox); reportErr != nil && err == nil {
+			err = reportErr
+		}
+	}
 	if err != nil {
-		logrus.Errorf("Command execution failed: %v", err)
-		os.Exit(1)
+		return err
 	}
 
-	// Update the command version after successful execution.
-	// Only update if the command ran successfully and we detected a change or this is the first run.
-	if exitCode == 0 && (commandChanged || upgrade) {
-		if err := versionStore.UpdateCommandVersion(command); err != nil {
-			logrus.Warnf("Failed to update command version: %v", err)
-			// Not a fatal error, continue.
-		}
+	if result.TimedOut {
+		return &TimeoutError{Command: command.Name, Timeout: command.Config.Timeout}
+	}
+	if result.OOMKilled {
+		return &OutOfMemoryError{Command: command.Name, Code: result.ExitCode}
+	}
+	if result.ExitCode != 0 {
+		return &ExitError{Code: result.ExitCode}
 	}
-
-	os.Exit(exitCode)
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/skorokithakis/dox/pkg/dox"
+)
+
+// newSandboxCommand creates the sandbox command and its subcommands.
+func newSandboxCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sandbox",
+		Short: "Review, apply or discard sandboxed changes",
+		Long: `Manage the sandboxes of runs with --sandbox, which work on a copy of the workspace.
+Their changes only reach the workspace once they are applied.
+
+Subcommands take a sandbox ID, and default to the newest sandbox of the current workspace.`,
+	}
+
+	var force bool
+	apply := &cobra.Command{
+		Use:   "apply [id]",
+		Short: "Apply a sandbox's changes to the workspace and remove it",
+		Long: `Apply a sandbox's changes to the workspace it was copied from, and remove the sandbox.
+
+If the workspace has changed since the sandbox was created in any of the same files,
+nothing is applied unless --force is given. Other changes to the workspace are kept.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sandbox, err := findSandbox(args)
+			if err != nil {
+				return err
+			}
+			changes, err := sandbox.Apply(force)
+			var conflictErr *dox.SandboxConflictError
+			if errors.As(err, &conflictErr) {
+				return fmt.Errorf("%w; apply it with --force to overwrite them", err)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Applied %s to %s.\n", changeCount(len(changes)), sandbox.Source)
+			return nil
+		},
+	}
+	apply.Flags().BoolVar(&force, "force", false, "Overwrite changes made to the workspace since the sandbox was created")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List sandboxes",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				sandboxes, err := dox.Sandboxes()
+				if err != nil {
+					return err
+				}
+				if len(sandboxes) == 0 {
+					fmt.Fprintln(cmd.OutOrStdout(), "No sandboxes.")
+					return nil
+				}
+				for _, sandbox := range sandboxes {
+					fmt.Fprintf(cmd.OutOrStdout(), "%s  %-12s %s\n", sandbox.ID, sandbox.Command, sandbox.Source)
+				}
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "diff [id]",
+			Short: "Show the changes a sandbox made",
+			Long: `Show the changes a sandbox made, as unified diffs against the workspace for text files.
+Deleted files, directories, symlinks and binary files are listed.`,
+			Args:  cobra.MaximumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				sandbox, err := findSandbox(args)
+				if err != nil {
+					return err
+				}
+				changes, err := sandbox.Changes()
+				if err != nil {
+					return err
+				}
+				// Text files are shown as diffs after the list of everything
+				// else.
+				var listed []dox.SandboxChange
+				var diffs bytes.Buffer
+				for _, change := range changes {
+					shown, err := sandbox.Diff(&diffs, change)
+					if err != nil {
+						return err
+					}
+					if !shown {
+						listed = append(listed, change)
+					}
+				}
+
+				out := cmd.OutOrStdout()
+				fmt.Fprintf(out, "Sandbox %s of %s, copied to %s:\n", sandbox.ID, sandbox.Source, sandbox.Workspace)
+				if len(changes) == 0 {
+					fmt.Fprintln(out, "  No changes.")
+				}
+				printChanges(out, listed)
+				_, err = diffs.WriteTo(out)
+				return err
+			},
+		},
+		apply,
+		&cobra.Command{
+			Use:   "discard [id]",
+			Short: "Remove a sandbox without applying its changes",
+			Args:  cobra.MaximumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				sandbox, err := findSandbox(args)
+				if err != nil {
+					return err
+				}
+				if err := sandbox.Discard(); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Discarded sandbox %s.\n", sandbox.ID)
+				return nil
+			},
+		},
+	)
+	return cmd
+}
+
+// findSandbox returns the sandbox with the ID in args, or the newest sandbox of
+// the current workspace.
+func findSandbox(args []string) (*dox.Sandbox, error) {
+	if len(args) == 1 {
+		return dox.OpenSandbox(args[0])
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	}
+	return dox.LatestSandbox(cwd)
+}
+
+// reportSandbox summarizes the changes made in a sandbox after a run. Sandboxes
+// without changes have nothing to review, so they are discarded.
+func reportSandbox(out io.Writer, sandbox *dox.Sandbox) error {
+	changes, err := sandbox.Changes()
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(out, "No changes were made in the sandbox.")
+		return sandbox.Discard()
+	}
+
+	fmt.Fprintf(out, "Sandbox %s has %s:\n", sandbox.ID, changeCount(len(changes)))
+	printChanges(out, changes)
+	fmt.Fprintln(out, `Review them with "dox sandbox diff", then run "dox sandbox apply" or "dox sandbox discard".`)
+	return nil
+}
+
+// printChanges lists the changes made in a sandbox.
+func printChanges(out io.Writer, changes []dox.SandboxChange) {
+	for _, change := range changes {
+		fmt.Fprintf(out, "  %-9s %s\n", change.Kind, change.Path)
+	}
+}
+
+// changeCount returns the number of changes in words.
+func changeCount(n int) string {
+	if n == 1 {
+		return "1 change"
+	}
+	return fmt.Sprintf("%d changes", n)
+}
@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+// chdirWorkspace changes into a new workspace holding a single file, with
+// sandboxes kept in a temporary cache directory.
+func chdirWorkspace(t *testing.T) string {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.py"), []byte("print('hi')"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	return dir
+}
+
+func TestRunCommandSandbox(t *testing.T) {
+	dir := chdirWorkspace(t)
+	c := newCLITest(t)
+	c.addCommand("tool", "image: alpine\nworkspace: copy\nkeep_alive: 0s\n")
+	c.runtime.OnExecute = func(cfg *config.CommandConfig) {
+		os.WriteFile(filepath.Join(cfg.Sandbox, "main.py"), []byte("print('changed')"), 0644)
+		os.WriteFile(filepath.Join(cfg.Sandbox, "new.txt"), []byte("created"), 0644)
+	}
+
+	if code := c.run("run", "--sandbox", "tool"); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	execution, _ := c.runtime.LastExecution()
+	if execution.Config.Sandbox == "" || execution.Config.Workspace != config.WorkspaceCwd {
+		t.Errorf("ran with sandbox %q and workspace %q, want the sandbox mounted", execution.Config.Sandbox, execution.Config.Workspace)
+	}
+	for _, expected := range []string{"has 2 changes:\n", "  created   new.txt\n", "  modified  main.py\n", "dox sandbox apply"} {
+		if !strings.Contains(c.stderr.String(), expected) {
+			t.Errorf("stderr = %q, want it to contain %q", c.stderr.String(), expected)
+		}
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "main.py")); string(content) != "print('hi')" {
+		t.Errorf("main.py = %q, want the workspace untouched", content)
+	}
+
+	if code := c.run("sandbox", "diff"); code != 0 {
+		t.Fatalf("diff: exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	diff := "--- a/main.py\n+++ b/main.py\n@@ -1 +1 @@\n-print('hi')\n\\ No newline at end of file\n+print('changed')\n\\ No newline at end of file\n"
+	for _, expected := range []string{diff, "--- /dev/null\n+++ b/new.txt\n@@ -0,0 +1 @@\n+created\n"} {
+		if !strings.Contains(c.stdout.String(), expected) {
+			t.Errorf("diff: stdout = %q, want it to contain %q", c.stdout.String(), expected)
+		}
+	}
+	if strings.Contains(c.stdout.String(), "  modified  main.py\n") {
+		t.Errorf("diff: stdout = %q, want text files diffed rather than listed", c.stdout.String())
+	}
+
+	if code := c.run("sandbox", "apply"); code != 0 {
+		t.Fatalf("apply: exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "main.py")); string(content) != "print('changed')" {
+		t.Errorf("main.py = %q, want the sandbox's changes applied", content)
+	}
+	if code := c.run("sandbox", "apply"); code != ExitUsage {
+		t.Errorf("apply: exit code = %d, want %d once the sandbox is gone", code, ExitUsage)
+	}
+}
+
+func TestRunCommandSandboxWithoutChanges(t *testing.T) {
+	chdirWorkspace(t)
+	c := newCLITest(t)
+	c.addCommand("tool", "image: alpine\n")
+
+	if code := c.run("run", "--sandbox", "tool"); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	if !strings.Contains(c.stderr.String(), "No changes were made in the sandbox.") {
+		t.Errorf("stderr = %q, want no changes reported", c.stderr.String())
+	}
+	if code := c.run("sandbox", "list"); code != 0 || c.stdout.String() != "No sandboxes.\n" {
+		t.Errorf("list: exit code %d and stdout %q, want the sandbox discarded", code, c.stdout.String())
+	}
+}
+
+func TestSandboxApplyConflict(t *testing.T) {
+	dir := chdirWorkspace(t)
+	c := newCLITest(t)
+	c.addCommand("tool", "image: alpine\n")
+	c.runtime.OnExecute = func(cfg *config.CommandConfig) {
+		os.WriteFile(filepath.Join(cfg.Sandbox, "main.py"), []byte("print('sandbox')"), 0644)
+	}
+
+	if code := c.run("run", "--sandbox", "tool"); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	os.WriteFile(filepath.Join(dir, "main.py"), []byte("print('workspace')"), 0644)
+
+	if code := c.run("sandbox", "apply"); code != ExitRuntime {
+		t.Errorf("apply: exit code = %d, want %d for a conflict", code, ExitRuntime)
+	}
+	if !strings.Contains(c.stderr.String(), "main.py; apply it with --force") {
+		t.Errorf("apply: stderr = %q, want the conflict and how to override it", c.stderr.String())
+	}
+
+	if code := c.run("sandbox", "discard"); code != 0 {
+		t.Fatalf("discard: exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "main.py")); string(content) != "print('workspace')" {
+		t.Errorf("main.py = %q, want the workspace's version kept", content)
+	}
+}
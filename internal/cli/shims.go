@@ -0,0 +1,196 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/skorokithakis/dox/pkg/dox"
+)
+
+// shimOptions are the flags shared by the shims subcommands.
+type shimOptions struct {
+	dir   string
+	force bool
+}
+
+// newShimsCommand creates the shims command and its subcommands.
+func newShimsCommand(client *dox.Client, executable func() (string, error)) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "shims",
+		Short: "Manage command shims",
+		Long: `Manage shims, which are links named after each configured command that point to dox.
+Running a shim runs its command, so "terraform plan" behaves like "dox run terraform plan".`,
+	}
+
+	cmd.AddCommand(
+		newShimsSubcommand(client, executable, "install", "Create shims for all commands", false),
+		newShimsSubcommand(client, executable, "sync", "Create missing shims and remove those of deleted commands", true),
+	)
+	return cmd
+}
+
+// newShimsSubcommand creates a shims subcommand. Stale shims are only removed when prune is set.
+func newShimsSubcommand(client *dox.Client, executable func() (string, error), use, short string, prune bool) *cobra.Command {
+	opts := &shimOptions{}
+
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		Long: short + `.
+
+Shims aren't created where they would replace or shadow a program that isn't a shim,
+unless --force is given.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.dir == "" {
+				home, err := os.UserHomeDir()
+				if err != nil {
+					return fmt.Errorf("failed to find the home directory: %w", err)
+				}
+				opts.dir = filepath.Join(home, ".local", "bin")
+			}
+
+			doxPath, err := executable()
+			if err != nil {
+				return fmt.Errorf("failed to find the dox binary: %w", err)
+			}
+
+			commands, err := client.Commands()
+			if err != nil {
+				return err
+			}
+
+			return syncShims(cmd.OutOrStdout(), opts, doxPath, commands, prune)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.dir, "dir", "", "Directory to create the shims in (default ~/.local/bin)")
+	cmd.Flags().BoolVar(&opts.force, "force", false, "Create shims even if they replace or shadow other programs")
+	return cmd
+}
+
+// syncShims creates a shim in the directory for each command, and removes the
+// shims of commands that no longer exist if prune is set.
+func syncShims(out io.Writer, opts *shimOptions, doxPath string, commands []string, prune bool) error {
+	if err := os.MkdirAll(opts.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create shim directory: %w", err)
+	}
+
+	// Shims of a dox.exe are executables too.
+	ext := filepath.Ext(doxPath)
+	if !strings.EqualFold(ext, ".exe") {
+		ext = ""
+	}
+
+	created, skipped := 0, 0
+	configured := make(map[string]bool)
+	for _, command := range commands {
+		name := command + ext
+		configured[name] = true
+		path := filepath.Join(opts.dir, name)
+
+		if isShim(path, doxPath) {
+			if linksTo(path, doxPath) {
+				continue
+			}
+			// The shim points to a dox that was moved.
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to replace %s: %w", path, err)
+			}
+		} else if _, err := os.Lstat(path); err == nil {
+			if !opts.force {
+				fmt.Fprintf(out, "Skipping '%s': %s already exists. Use --force to replace it.\n", command, path)
+				skipped++
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to replace %s: %w", path, err)
+			}
+		} else if shadowed := findProgram(name, opts.dir, doxPath); shadowed != "" && !opts.force {
+			fmt.Fprintf(out, "Skipping '%s': it would shadow %s. Use --force to create it anyway.\n", command, shadowed)
+			skipped++
+			continue
+		}
+
+		if err := os.Symlink(doxPath, path); err != nil {
+			return fmt.Errorf("failed to create shim for '%s': %w", command, err)
+		}
+		fmt.Fprintf(out, "Created shim %s\n", path)
+		created++
+	}
+
+	removed := 0
+	if prune {
+		entries, err := os.ReadDir(opts.dir)
+		if err != nil {
+			return fmt.Errorf("failed to read shim directory: %w", err)
+		}
+		for _, entry := range entries {
+			path := filepath.Join(opts.dir, entry.Name())
+			if configured[entry.Name()] || !isShim(path, doxPath) {
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove shim %s: %w", path, err)
+			}
+			fmt.Fprintf(out, "Removed shim %s\n", path)
+			removed++
+		}
+	}
+
+	fmt.Fprintf(out, "Created %d, removed %d and skipped %d shim(s) in %s.\n", created, removed, skipped, opts.dir)
+	return nil
+}
+
+// isShim reports whether path is a link to dox. Links to a binary named dox count
+// too, so shims still belong to dox after it is moved.
+func isShim(path, doxPath string) bool {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return false
+	}
+
+	if name := filepath.Base(target); strings.TrimSuffix(name, filepath.Ext(name)) == "dox" {
+		return true
+	}
+	return linksTo(path, doxPath)
+}
+
+// linksTo reports whether path resolves to the dox binary.
+func linksTo(path, doxPath string) bool {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	resolvedDox, err := filepath.EvalSymlinks(doxPath)
+	if err != nil {
+		return false
+	}
+	return resolved == resolvedDox
+}
+
+// findProgram returns the path of a program with the given name in a PATH
+// directory other than dir, ignoring dox's own shims, or "" if there is none.
+func findProgram(name, dir, doxPath string) string {
+	for _, pathDir := range filepath.SplitList(os.Getenv("PATH")) {
+		if pathDir == "" || filepath.Clean(pathDir) == filepath.Clean(dir) {
+			continue
+		}
+
+		path := filepath.Join(pathDir, name)
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() || isShim(path, doxPath) {
+			continue
+		}
+		// Windows has no executable bit, and the name already has the .exe extension there.
+		if goruntime.GOOS == "windows" || info.Mode()&0111 != 0 {
+			return path
+		}
+	}
+	return ""
+}
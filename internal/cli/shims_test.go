@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeExecutable creates an executable file.
+func writeExecutable(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertShim checks whether path is a shim linking to dox.
+func assertShim(t *testing.T, c *cliTest, path string, expected bool) {
+	t.Helper()
+	if actual := linksTo(path, c.executable); actual != expected {
+		t.Errorf("%s is a shim = %v, want %v", filepath.Base(path), actual, expected)
+	}
+}
+
+func TestShimsInstall(t *testing.T) {
+	c := newCLITest(t)
+	c.executable = filepath.Join(t.TempDir(), "dox")
+	writeExecutable(t, c.executable)
+
+	binDir := t.TempDir()
+	writeExecutable(t, filepath.Join(binDir, "node"))
+	t.Setenv("PATH", binDir)
+
+	shimDir := filepath.Join(t.TempDir(), "shims")
+	c.addCommand("python", "image: python:3.11\n")
+	c.addCommand("node", "image: node:20\n")
+	c.addCommand("tool", "image: alpine\n")
+	if err := os.MkdirAll(shimDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeExecutable(t, filepath.Join(shimDir, "tool"))
+
+	if code := c.run("shims", "install", "--dir", shimDir); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	assertShim(t, c, filepath.Join(shimDir, "python"), true)
+	assertShim(t, c, filepath.Join(shimDir, "node"), false)
+	assertShim(t, c, filepath.Join(shimDir, "tool"), false)
+	output := c.stdout.String()
+	for _, expected := range []string{"it would shadow " + filepath.Join(binDir, "node"), "already exists", "Created 1, removed 0 and skipped 2"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("stdout = %q, want it to contain %q", output, expected)
+		}
+	}
+
+	if code := c.run("shims", "install", "--dir", shimDir, "--force"); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	assertShim(t, c, filepath.Join(shimDir, "node"), true)
+	assertShim(t, c, filepath.Join(shimDir, "tool"), true)
+	if !strings.Contains(c.stdout.String(), "Created 2, removed 0 and skipped 0") {
+		t.Errorf("stdout = %q, want the forced shims created", c.stdout.String())
+	}
+}
+
+func TestShimsSync(t *testing.T) {
+	c := newCLITest(t)
+	c.executable = filepath.Join(t.TempDir(), "dox")
+	writeExecutable(t, c.executable)
+	t.Setenv("PATH", "")
+
+	shimDir := t.TempDir()
+	c.addCommand("python", "image: python:3.11\n")
+	c.addCommand("node", "image: node:20\n")
+	if code := c.run("shims", "install", "--dir", shimDir); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+
+	// Links to other programs aren't shims.
+	other := filepath.Join(t.TempDir(), "sh")
+	writeExecutable(t, other)
+	if err := os.Symlink(other, filepath.Join(shimDir, "sh")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(filepath.Join(c.configHome, "dox", "commands", "python.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	c.addCommand("go", "image: golang:1.21\n")
+
+	// Install never removes shims.
+	if code := c.run("shims", "install", "--dir", shimDir); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	assertShim(t, c, filepath.Join(shimDir, "python"), true)
+
+	if code := c.run("shims", "sync", "--dir", shimDir); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	if _, err := os.Lstat(filepath.Join(shimDir, "python")); !os.IsNotExist(err) {
+		t.Errorf("the shim of a deleted command should be removed, got %v", err)
+	}
+	assertShim(t, c, filepath.Join(shimDir, "go"), true)
+	assertShim(t, c, filepath.Join(shimDir, "node"), true)
+	if _, err := os.Lstat(filepath.Join(shimDir, "sh")); err != nil {
+		t.Errorf("links to other programs should be kept: %v", err)
+	}
+	if !strings.Contains(c.stdout.String(), "Created 0, removed 1 and skipped 0") {
+		t.Errorf("stdout = %q, want the stale shim removed", c.stdout.String())
+	}
+}
+
+func TestShimsRelinkMovedDox(t *testing.T) {
+	c := newCLITest(t)
+	c.executable = filepath.Join(t.TempDir(), "dox")
+	writeExecutable(t, c.executable)
+	t.Setenv("PATH", "")
+
+	shimDir := t.TempDir()
+	c.addCommand("python", "image: python:3.11\n")
+	if err := os.Symlink("/old/location/dox", filepath.Join(shimDir, "python")); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := c.run("shims", "sync", "--dir", shimDir); code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, c.stderr.String())
+	}
+	assertShim(t, c, filepath.Join(shimDir, "python"), true)
+}
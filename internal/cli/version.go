@@ -13,7 +13,7 @@ func newVersionCommand() *cobra.Command {
 		Short: "Show dox version",
 		Long:  "Display the version of dox",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Printf("dox version %s\n", version)
+			fmt.Fprintf(cmd.OutOrStdout(), "dox version %s\n", version)
 			return nil
 		},
 	}
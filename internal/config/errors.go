@@ -0,0 +1,30 @@
+package config
+
+import "fmt"
+
+// NotFoundError is returned when a command has no configuration file.
+type NotFoundError struct {
+	Command string
+	Path    string
+}
+
+// Error implements the error interface.
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("command '%s' doesn't exist. Create %s", e.Command, e.Path)
+}
+
+// InvalidError is returned when a configuration file can't be read or is invalid.
+type InvalidError struct {
+	Path string
+	Err  error
+}
+
+// Error implements the error interface.
+func (e *InvalidError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *InvalidError) Unwrap() error {
+	return e.Err
+}
@@ -4,8 +4,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/docker/go-units"
 	"github.com/spf13/viper"
 )
 
@@ -21,6 +25,12 @@ func NewLoader() *Loader {
 		home, _ := os.UserHomeDir()
 		configHome = filepath.Join(home, ".config")
 	}
+	return NewLoaderWithConfigHome(configHome)
+}
+
+// NewLoaderWithConfigHome creates a configuration loader that reads from
+// configHome/dox instead of the XDG config directory.
+func NewLoaderWithConfigHome(configHome string) *Loader {
 	return &Loader{
 		configHome: configHome,
 	}
@@ -43,7 +53,7 @@ func (l *Loader) LoadGlobalConfig() (*GlobalConfig, error) {
 	}
 
 	if err := globalViper.Unmarshal(config); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal global config: %w", err)
+		return nil, &InvalidError{Path: globalViper.ConfigFileUsed(), Err: fmt.Errorf("failed to unmarshal global config: %w", err)}
 	}
 
 	return config, nil
@@ -56,20 +66,20 @@ func (l *Loader) LoadCommandConfig(command string) (*CommandConfig, error) {
 	commandViper.SetConfigFile(configPath)
 
 	if err := commandViper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			return nil, fmt.Errorf("command '%s' doesn't exist. Create %s", command, configPath)
+		// Viper reports a missing file as a path error when the file is set explicitly.
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok || os.IsNotExist(err) {
+			return nil, &NotFoundError{Command: command, Path: configPath}
 		}
-		return nil, fmt.Errorf("failed to read command config: %w", err)
+		return nil, &InvalidError{Path: configPath, Err: fmt.Errorf("failed to read command config: %w", err)}
 	}
 
 	config := &CommandConfig{}
 	if err := commandViper.Unmarshal(config); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal command config: %w", err)
+		return nil, &InvalidError{Path: configPath, Err: fmt.Errorf("failed to unmarshal command config: %w", err)}
 	}
 
-	// Validate required fields.
-	if config.Image == "" && (config.Build == nil || config.Build.DockerfileInline == "") {
-		return nil, fmt.Errorf("configuration missing required field: image or build.dockerfile_inline")
+	if err := validateCommandConfig(config); err != nil {
+		return nil, &InvalidError{Path: configPath, Err: err}
 	}
 
 	// Expand environment variables in volume paths.
@@ -77,9 +87,264 @@ func (l *Loader) LoadCommandConfig(command string) (*CommandConfig, error) {
 		config.Volumes[i] = l.expandVolumePath(volume)
 	}
 
+	// Expand environment variables in the Docker host.
+	config.DockerHost = os.ExpandEnv(config.DockerHost)
+
+	// Expand environment variables in the seccomp profile's path.
+	if config.Security != nil {
+		config.Security.Seccomp = os.ExpandEnv(config.Security.Seccomp)
+	}
+
 	return config, nil
 }
 
+// validateCommandConfig checks a command configuration for missing or invalid fields.
+func validateCommandConfig(config *CommandConfig) error {
+	// Validate required fields.
+	if config.Image == "" && (config.Build == nil || config.Build.DockerfileInline == "") {
+		return fmt.Errorf("configuration missing required field: image or build.dockerfile_inline")
+	}
+
+	// Validate the workspace mode.
+	switch config.Workspace {
+	case "", WorkspaceCwd, WorkspaceCopy, WorkspaceMirror, WorkspaceGitRoot:
+	default:
+		return fmt.Errorf("invalid workspace mode '%s': must be %s, %s, %s or %s", config.Workspace, WorkspaceCwd, WorkspaceCopy, WorkspaceMirror, WorkspaceGitRoot)
+	}
+
+	// Validate the path argument mode.
+	switch config.PathArgs {
+	case "", PathArgsOff, PathArgsAuto:
+	default:
+		return fmt.Errorf("invalid path_args mode '%s': must be %s or %s", config.PathArgs, PathArgsOff, PathArgsAuto)
+	}
+
+	// Validate the keep-alive duration. Warm containers share their filesystem
+	// between runs, so they can't have a copied workspace.
+	if config.KeepAlive != "" {
+		duration, err := time.ParseDuration(config.KeepAlive)
+		if err != nil || duration < 0 {
+			return fmt.Errorf("invalid keep_alive '%s': must be a duration like 10m", config.KeepAlive)
+		}
+		if duration > 0 && config.Workspace == WorkspaceCopy {
+			return fmt.Errorf("keep_alive can't be used with workspace mode '%s'", WorkspaceCopy)
+		}
+		// The egress proxy, forwarded sockets and display cookies only exist
+		// while dox runs, so they can't serve warm containers.
+		if duration > 0 && config.Egress != nil {
+			return fmt.Errorf("keep_alive can't be used with egress")
+		}
+		if duration > 0 && len(config.Forward) > 0 {
+			return fmt.Errorf("keep_alive can't be used with forward")
+		}
+		if duration > 0 && (config.GUI != "" || config.Audio) {
+			return fmt.Errorf("keep_alive can't be used with gui or audio")
+		}
+	}
+
+	if config.Resources != nil {
+		if err := validateResources(config.Resources); err != nil {
+			return err
+		}
+	}
+
+	if config.Security != nil {
+		if err := validateSecurity(config.Security); err != nil {
+			return err
+		}
+	}
+
+	// Validate the GUI mode.
+	switch config.GUI {
+	case "", GUIX11, GUIWayland, GUIAuto:
+	default:
+		return fmt.Errorf("invalid gui mode '%s': must be %s, %s or %s", config.GUI, GUIX11, GUIWayland, GUIAuto)
+	}
+
+	// Validate the forwarded host services.
+	for _, forward := range config.Forward {
+		switch forward {
+		case ForwardSSHAgent, ForwardGPGAgent, ForwardGitCredentials:
+		default:
+			return fmt.Errorf("invalid forward '%s': must be %s, %s or %s", forward, ForwardSSHAgent, ForwardGPGAgent, ForwardGitCredentials)
+		}
+	}
+
+	if config.Egress != nil {
+		if err := validateEgress(config); err != nil {
+			return err
+		}
+		// Containers started through the socket wouldn't be on the egress network.
+		if config.EngineSocket {
+			return fmt.Errorf("engine_socket can't be used with egress, since the containers it starts could bypass the allowlist")
+		}
+	}
+
+	for _, device := range config.Devices {
+		if err := validateDevice(device); err != nil {
+			return err
+		}
+	}
+
+	// Validate how the command is stopped.
+	durations := map[string]string{"timeout": config.Timeout, "stop_grace_period": config.StopGracePeriod}
+	for name, value := range durations {
+		if value == "" {
+			continue
+		}
+		if duration, err := time.ParseDuration(value); err != nil || duration <= 0 {
+			return fmt.Errorf("invalid %s '%s': must be a duration like 30s or 10m", name, value)
+		}
+	}
+	if config.StopSignal != "" {
+		if _, ok := SignalName(config.StopSignal); !ok {
+			return fmt.Errorf("invalid stop_signal '%s': must be a signal like SIGTERM or SIGINT", config.StopSignal)
+		}
+	}
+
+	return nil
+}
+
+// validateResources checks that resource limits can be passed to the runtime.
+func validateResources(resources *ResourcesConfig) error {
+	if resources.CPUs != "" {
+		if cpus, err := strconv.ParseFloat(resources.CPUs, 64); err != nil || cpus <= 0 {
+			return fmt.Errorf("invalid resources.cpus '%s': must be a positive number like 1.5", resources.CPUs)
+		}
+	}
+
+	sizes := map[string]string{"memory": resources.Memory, "shm_size": resources.ShmSize}
+	for name, size := range sizes {
+		if size == "" {
+			continue
+		}
+		if bytes, err := units.RAMInBytes(size); err != nil || bytes <= 0 {
+			return fmt.Errorf("invalid resources.%s '%s': must be a size like 512m or 4g", name, size)
+		}
+	}
+
+	// Like docker run, the swap limit includes memory, so it needs a memory limit.
+	if resources.MemorySwap != "" {
+		if resources.Memory == "" {
+			return fmt.Errorf("resources.memory_swap requires resources.memory")
+		}
+		if resources.MemorySwap != "-1" {
+			swap, err := units.RAMInBytes(resources.MemorySwap)
+			if err != nil || swap <= 0 {
+				return fmt.Errorf("invalid resources.memory_swap '%s': must be a size like 6g, or -1 for unlimited swap", resources.MemorySwap)
+			}
+			if memory, _ := units.RAMInBytes(resources.Memory); swap < memory {
+				return fmt.Errorf("resources.memory_swap '%s' must be at least resources.memory '%s'", resources.MemorySwap, resources.Memory)
+			}
+		}
+	}
+
+	if resources.PidsLimit < -1 {
+		return fmt.Errorf("invalid resources.pids_limit %d: must be positive, or -1 for unlimited", resources.PidsLimit)
+	}
+
+	for name, value := range resources.Ulimits {
+		if _, err := units.ParseUlimit(name + "=" + value); err != nil {
+			return fmt.Errorf("invalid resources.ulimits.%s '%s': %w", name, value, err)
+		}
+	}
+
+	return nil
+}
+
+// capabilityName matches the names of Linux capabilities, with or without the
+// CAP_ prefix, and ALL.
+var capabilityName = regexp.MustCompile(`^(?i)(CAP_)?[A-Z_]+$`)
+
+// validateSecurity checks that security settings can be passed to the runtime.
+func validateSecurity(security *SecurityConfig) error {
+	switch security.Preset {
+	case "", SecurityPresetStrict:
+	default:
+		return fmt.Errorf("invalid security.preset '%s': must be %s", security.Preset, SecurityPresetStrict)
+	}
+
+	for _, tmpfs := range security.Tmpfs {
+		if path, _, _ := strings.Cut(tmpfs, ":"); !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("invalid security.tmpfs '%s': must be an absolute path in the container, optionally followed by :options", tmpfs)
+		}
+	}
+
+	capabilities := map[string][]string{"cap_drop": security.CapDrop, "cap_add": security.CapAdd}
+	for name, values := range capabilities {
+		for _, capability := range values {
+			if !capabilityName.MatchString(capability) {
+				return fmt.Errorf("invalid security.%s '%s': must be a capability like NET_ADMIN, or ALL", name, capability)
+			}
+		}
+	}
+
+	// Profiles are read on the host, so the path must not depend on where dox runs.
+	if seccomp := security.Seccomp; seccomp != "" && seccomp != SecurityUnconfined && !filepath.IsAbs(os.ExpandEnv(seccomp)) {
+		return fmt.Errorf("invalid security.seccomp '%s': must be an absolute path to a profile, or %s", seccomp, SecurityUnconfined)
+	}
+	if strings.ContainsAny(security.AppArmor, " \t=") {
+		return fmt.Errorf("invalid security.apparmor '%s': must be the name of a loaded profile, or %s", security.AppArmor, SecurityUnconfined)
+	}
+
+	return nil
+}
+
+// egressHost matches the host names of egress patterns, with an optional *.
+// wildcard for subdomains.
+var egressHost = regexp.MustCompile(`^(?i)(\*\.)?[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*\.?$`)
+
+// validateEgress checks that the egress allowlist can be enforced by the proxy.
+func validateEgress(config *CommandConfig) error {
+	// The container is put on dox's internal network, which replaces any other.
+	if config.Network != "" {
+		return fmt.Errorf("network can't be used with egress, which uses its own network")
+	}
+	if len(config.Egress.Allow) == 0 {
+		return fmt.Errorf("egress.allow must list at least one host; use network: none to block all network access")
+	}
+
+	for _, pattern := range config.Egress.Allow {
+		// Anything after the colon that isn't a number, like the rest of a URL,
+		// means the pattern isn't a host name.
+		host, port, hasPort := strings.Cut(pattern, ":")
+		number, err := strconv.Atoi(port)
+		if !egressHost.MatchString(host) || hasPort && err != nil {
+			return fmt.Errorf("invalid egress.allow '%s': must be a host name like pypi.org or *.npmjs.org, optionally followed by :port", pattern)
+		}
+		if hasPort && (number < 1 || number > 65535) {
+			return fmt.Errorf("invalid egress.allow '%s': the port must be between 1 and 65535", pattern)
+		}
+	}
+
+	return nil
+}
+
+// devicePermissions matches the cgroup permissions of a device: read, write
+// and mknod.
+var devicePermissions = regexp.MustCompile(`^[rwm]{1,3}$`)
+
+// validateDevice checks a device in docker's host[:container][:permissions]
+// format.
+func validateDevice(device string) error {
+	parts := strings.Split(device, ":")
+	if len(parts) == 2 && devicePermissions.MatchString(parts[1]) {
+		parts = []string{parts[0], parts[0], parts[1]}
+	}
+	if len(parts) > 3 {
+		return fmt.Errorf("invalid device '%s': must be host[:container][:permissions]", device)
+	}
+	for _, path := range parts[:min(len(parts), 2)] {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("invalid device '%s': paths must be absolute, like /dev/fuse", device)
+		}
+	}
+	if len(parts) == 3 && !devicePermissions.MatchString(parts[2]) {
+		return fmt.Errorf("invalid device '%s': permissions must be a combination of r, w and m", device)
+	}
+	return nil
+}
+
 // ListCommands returns a list of available commands.
 func (l *Loader) ListCommands() ([]string, error) {
 	commandsDir := filepath.Join(l.configHome, "dox", "commands")
@@ -88,7 +353,7 @@ func (l *Loader) ListCommands() ([]string, error) {
 		if os.IsNotExist(err) {
 			return []string{}, nil
 		}
-		return nil, fmt.Errorf("failed to read commands directory: %w", err)
+		return nil, &InvalidError{Path: commandsDir, Err: fmt.Errorf("failed to read commands directory: %w", err)}
 	}
 
 	var commands []string
@@ -104,6 +369,12 @@ func (l *Loader) ListCommands() ([]string, error) {
 
 // expandVolumePath expands environment variables and special paths in volume mount strings.
 func (l *Loader) expandVolumePath(volume string) string {
+	return ExpandVolume(volume)
+}
+
+// ExpandVolume expands environment variables in the source of a volume mount
+// string, and makes sources relative to the current directory absolute.
+func ExpandVolume(volume string) string {
 	parts := strings.SplitN(volume, ":", 3)
 	if len(parts) < 2 {
 		return volume
@@ -120,6 +391,13 @@ func (l *Loader) expandVolumePath(volume string) string {
 		source = os.ExpandEnv(source)
 	}
 
+	// Docker would take other relative sources for named volumes.
+	if strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../") {
+		if absolute, err := filepath.Abs(source); err == nil {
+			source = absolute
+		}
+	}
+
 	// Reconstruct the volume string.
 	parts[0] = source
 	return strings.Join(parts, ":")
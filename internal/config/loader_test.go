@@ -1,8 +1,10 @@
 package config
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -33,6 +35,19 @@ func TestExpandVolumePath(t *testing.T) {
 			input:    "${HOME}/.config:/config:ro",
 			expected: os.Getenv("HOME") + "/.config:/config:ro",
 		},
+		{
+			name:  "relative path",
+			input: "./data:/data",
+			expected: func() string {
+				cwd, _ := os.Getwd()
+				return filepath.Join(cwd, "data") + ":/data"
+			}(),
+		},
+		{
+			name:     "named volume",
+			input:    "cache:/cache",
+			expected: "cache:/cache",
+		},
 	}
 	
 	for _, tt := range tests {
@@ -133,10 +148,8 @@ func TestLoadCommandConfigMissing(t *testing.T) {
 		t.Fatal("LoadCommandConfig() should have returned an error for missing command")
 	}
 	
-	// The error could be either "command doesn't exist" or "failed to read command config"
-	// depending on how viper detects the missing file.
-	if !strings.Contains(err.Error(), "nonexistent") {
-		t.Errorf("error message should mention the nonexistent command, got %q", err.Error())
+	if !strings.Contains(err.Error(), "command 'nonexistent' doesn't exist") {
+		t.Errorf("error message should say the command doesn't exist, got %q", err.Error())
 	}
 }
 
@@ -187,3 +200,378 @@ func TestListCommands(t *testing.T) {
 	}
 }
 
+
+func TestLoadCommandConfigWorkspace(t *testing.T) {
+	tmpDir := t.TempDir()
+	commandsDir := filepath.Join(tmpDir, "dox", "commands")
+	os.MkdirAll(commandsDir, 0755)
+
+	configContent := `image: python:3.11-slim
+workspace: copy
+workspace_ignore:
+  - .git
+  - "*.pyc"
+docker_host: ssh://builder@remote`
+	os.WriteFile(filepath.Join(commandsDir, "python.yaml"), []byte(configContent), 0644)
+	os.WriteFile(filepath.Join(commandsDir, "broken.yaml"), []byte("image: test\nworkspace: teleport"), 0644)
+
+	oldConfig := os.Getenv("XDG_CONFIG_HOME")
+	os.Setenv("XDG_CONFIG_HOME", tmpDir)
+	defer os.Setenv("XDG_CONFIG_HOME", oldConfig)
+
+	loader := NewLoader()
+	config, err := loader.LoadCommandConfig("python")
+	if err != nil {
+		t.Fatalf("LoadCommandConfig() error = %v", err)
+	}
+
+	if config.Workspace != WorkspaceCopy {
+		t.Errorf("config.Workspace = %s, want %s", config.Workspace, WorkspaceCopy)
+	}
+	if len(config.WorkspaceIgnore) != 2 {
+		t.Errorf("len(config.WorkspaceIgnore) = %d, want 2", len(config.WorkspaceIgnore))
+	}
+	if config.DockerHost != "ssh://builder@remote" {
+		t.Errorf("config.DockerHost = %s, want ssh://builder@remote", config.DockerHost)
+	}
+
+	if _, err := loader.LoadCommandConfig("broken"); err == nil {
+		t.Error("LoadCommandConfig() should reject an unknown workspace mode")
+	}
+
+	for _, mode := range []string{WorkspaceMirror, WorkspaceGitRoot} {
+		os.WriteFile(filepath.Join(commandsDir, mode+".yaml"), []byte("image: test\nworkspace: "+mode), 0644)
+		if config, err := loader.LoadCommandConfig(mode); err != nil || config.Workspace != mode {
+			t.Errorf("LoadCommandConfig(%s) = %v, want workspace %s", mode, err, mode)
+		}
+	}
+}
+
+func TestLoadCommandConfigErrorTypes(t *testing.T) {
+	tmpDir := t.TempDir()
+	commandsDir := filepath.Join(tmpDir, "dox", "commands")
+	os.MkdirAll(commandsDir, 0755)
+	os.WriteFile(filepath.Join(commandsDir, "noimage.yaml"), []byte("network: host"), 0644)
+	os.WriteFile(filepath.Join(commandsDir, "malformed.yaml"), []byte("image: [unclosed"), 0644)
+
+	loader := NewLoaderWithConfigHome(tmpDir)
+
+	_, err := loader.LoadCommandConfig("missing")
+	var notFound *NotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("LoadCommandConfig(missing) error = %v, want a NotFoundError", err)
+	}
+	if notFound.Command != "missing" || notFound.Path != filepath.Join(commandsDir, "missing.yaml") {
+		t.Errorf("NotFoundError = %+v, want the command and its config path", notFound)
+	}
+
+	for _, command := range []string{"noimage", "malformed"} {
+		_, err := loader.LoadCommandConfig(command)
+		var invalid *InvalidError
+		if !errors.As(err, &invalid) {
+			t.Errorf("LoadCommandConfig(%s) error = %v, want an InvalidError", command, err)
+			continue
+		}
+		if invalid.Path != filepath.Join(commandsDir, command+".yaml") {
+			t.Errorf("InvalidError.Path = %s, want the config path", invalid.Path)
+		}
+	}
+}
+
+// writeCommandConfigs writes each command's configuration into a new config
+// home, and returns a loader that reads them.
+func writeCommandConfigs(t *testing.T, configs map[string]string) *Loader {
+	t.Helper()
+	configHome := t.TempDir()
+	commandsDir := filepath.Join(configHome, "dox", "commands")
+	if err := os.MkdirAll(commandsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for command, content := range configs {
+		if err := os.WriteFile(filepath.Join(commandsDir, command+".yaml"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewLoaderWithConfigHome(configHome)
+}
+
+// assertInvalid checks that loading a command fails with an InvalidError for
+// its configuration, whose message contains expected.
+func assertInvalid(t *testing.T, loader *Loader, command, expected string) {
+	t.Helper()
+	_, err := loader.LoadCommandConfig(command)
+	var invalid *InvalidError
+	if !errors.As(err, &invalid) {
+		t.Errorf("LoadCommandConfig(%s) error = %v, want an InvalidError", command, err)
+		return
+	}
+	if filepath.Base(invalid.Path) != command+".yaml" || !strings.Contains(invalid.Err.Error(), expected) {
+		t.Errorf("LoadCommandConfig(%s) error = %v, want %q in %s.yaml", command, err, expected, command)
+	}
+}
+
+func TestLoadCommandConfigPathArgs(t *testing.T) {
+	loader := writeCommandConfigs(t, map[string]string{
+		"python": "image: python:3.11-slim\npath_args: auto\npath_args_allow:\n  - --config\npath_args_deny:\n  - -m\npath_args_write: true",
+		"broken": "image: test\npath_args: always",
+	})
+	config, err := loader.LoadCommandConfig("python")
+	if err != nil {
+		t.Fatalf("LoadCommandConfig() error = %v", err)
+	}
+	if config.PathArgs != PathArgsAuto || !config.PathArgsWrite {
+		t.Errorf("path_args = %s with write %v, want auto with write", config.PathArgs, config.PathArgsWrite)
+	}
+	if len(config.PathArgsAllow) != 1 || config.PathArgsAllow[0] != "--config" || len(config.PathArgsDeny) != 1 || config.PathArgsDeny[0] != "-m" {
+		t.Errorf("allow = %v and deny = %v, want [--config] and [-m]", config.PathArgsAllow, config.PathArgsDeny)
+	}
+
+	assertInvalid(t, loader, "broken", "invalid path_args mode 'always'")
+}
+
+func TestLoadCommandConfigKeepAlive(t *testing.T) {
+	loader := writeCommandConfigs(t, map[string]string{
+		"warm":     "image: test\nkeep_alive: 10m",
+		"invalid":  "image: test\nkeep_alive: forever",
+		"negative": "image: test\nkeep_alive: -1m",
+		"copied":   "image: test\nkeep_alive: 10m\nworkspace: copy",
+	})
+	config, err := loader.LoadCommandConfig("warm")
+	if err != nil {
+		t.Fatalf("LoadCommandConfig() error = %v", err)
+	}
+	if config.KeepAlive != "10m" {
+		t.Errorf("config.KeepAlive = %s, want 10m", config.KeepAlive)
+	}
+
+	for command, expected := range map[string]string{
+		"invalid":  "invalid keep_alive 'forever'",
+		"negative": "invalid keep_alive '-1m'",
+		"copied":   "keep_alive can't be used with workspace mode 'copy'",
+	} {
+		assertInvalid(t, loader, command, expected)
+	}
+}
+
+func TestLoadCommandConfigResources(t *testing.T) {
+	loader := writeCommandConfigs(t, map[string]string{
+		"limited":      "image: test\nresources:\n  cpus: 1.5\n  memory: 4g\n  memory_swap: -1\n  pids_limit: 512\n  ulimits:\n    nofile: 1024:2048\n  shm_size: 1g",
+		"cpus":         "image: test\nresources:\n  cpus: none",
+		"memory":       "image: test\nresources:\n  memory: lots",
+		"swap":         "image: test\nresources:\n  memory_swap: 1g",
+		"smaller_swap": "image: test\nresources:\n  memory: 2g\n  memory_swap: 1g",
+		"pids":         "image: test\nresources:\n  pids_limit: -2",
+		"ulimit":       "image: test\nresources:\n  ulimits:\n    nofile: many",
+		"shm":          "image: test\nresources:\n  shm_size: 0",
+	})
+	config, err := loader.LoadCommandConfig("limited")
+	if err != nil {
+		t.Fatalf("LoadCommandConfig() error = %v", err)
+	}
+	resources := config.Resources
+	if resources == nil || resources.CPUs != "1.5" || resources.Memory != "4g" || resources.MemorySwap != "-1" || resources.PidsLimit != 512 || resources.Ulimits["nofile"] != "1024:2048" || resources.ShmSize != "1g" {
+		t.Errorf("config.Resources = %+v, want the configured limits", resources)
+	}
+
+	for command, expected := range map[string]string{
+		"cpus":         "invalid resources.cpus 'none'",
+		"memory":       "invalid resources.memory 'lots'",
+		"swap":         "resources.memory_swap requires resources.memory",
+		"smaller_swap": "resources.memory_swap '1g' must be at least resources.memory '2g'",
+		"pids":         "invalid resources.pids_limit -2",
+		"ulimit":       "invalid resources.ulimits.nofile 'many'",
+		"shm":          "invalid resources.shm_size '0'",
+	} {
+		assertInvalid(t, loader, command, expected)
+	}
+}
+
+func TestLoadCommandConfigStop(t *testing.T) {
+	loader := writeCommandConfigs(t, map[string]string{
+		"stopped": "image: test\ntimeout: 30m\nstop_signal: SIGINT\nstop_grace_period: 30s",
+		"timeout": "image: test\ntimeout: forever",
+		"grace":   "image: test\nstop_grace_period: 0s",
+		"signal":  "image: test\nstop_signal: SIGNOPE",
+		"short":   "image: test\nstop_signal: quit",
+	})
+	config, err := loader.LoadCommandConfig("stopped")
+	if err != nil {
+		t.Fatalf("LoadCommandConfig() error = %v", err)
+	}
+	if config.Timeout != "30m" || config.StopSignal != "SIGINT" || config.StopGracePeriod != "30s" {
+		t.Errorf("timeout = %q, stop_signal = %q and stop_grace_period = %q, want the configured ones", config.Timeout, config.StopSignal, config.StopGracePeriod)
+	}
+	if _, err := loader.LoadCommandConfig("short"); err != nil {
+		t.Errorf("LoadCommandConfig(short) error = %v, want signal names without SIG accepted", err)
+	}
+
+	for command, expected := range map[string]string{
+		"timeout": "invalid timeout 'forever'",
+		"grace":   "invalid stop_grace_period '0s'",
+		"signal":  "invalid stop_signal 'SIGNOPE'",
+	} {
+		assertInvalid(t, loader, command, expected)
+	}
+}
+
+func TestLoadCommandConfigSecurity(t *testing.T) {
+	t.Setenv("DOX_TEST_PROFILES", "/etc/profiles")
+	loader := writeCommandConfigs(t, map[string]string{
+		"hardened": "image: test\nsecurity:\n  preset: strict\n  read_only_rootfs: false\n  tmpfs:\n    - /tmp:size=64m\n  cap_drop: [NET_RAW]\n  cap_add: [cap_chown]\n  no_new_privileges: true\n  seccomp: ${DOX_TEST_PROFILES}/seccomp.json\n  apparmor: dox",
+		"preset":   "image: test\nsecurity:\n  preset: paranoid",
+		"tmpfs":    "image: test\nsecurity:\n  tmpfs: [tmp]",
+		"cap":      "image: test\nsecurity:\n  cap_add: [NET-ADMIN]",
+		"seccomp":  "image: test\nsecurity:\n  seccomp: seccomp.json",
+		"apparmor": "image: test\nsecurity:\n  apparmor: my profile",
+	})
+	config, err := loader.LoadCommandConfig("hardened")
+	if err != nil {
+		t.Fatalf("LoadCommandConfig() error = %v", err)
+	}
+	security := config.Security
+	if security == nil || security.Preset != SecurityPresetStrict || security.ReadOnlyRootfs == nil || *security.ReadOnlyRootfs || security.NoNewPrivileges == nil || !*security.NoNewPrivileges {
+		t.Fatalf("config.Security = %+v, want the preset with the booleans set", security)
+	}
+	if !reflect.DeepEqual(security.Tmpfs, []string{"/tmp:size=64m"}) || !reflect.DeepEqual(security.CapDrop, []string{"NET_RAW"}) || !reflect.DeepEqual(security.CapAdd, []string{"cap_chown"}) {
+		t.Errorf("tmpfs = %v, cap_drop = %v and cap_add = %v, want the configured ones", security.Tmpfs, security.CapDrop, security.CapAdd)
+	}
+	if security.Seccomp != "/etc/profiles/seccomp.json" || security.AppArmor != "dox" {
+		t.Errorf("seccomp = %q and apparmor = %q, want the expanded path and the profile name", security.Seccomp, security.AppArmor)
+	}
+
+	for command, expected := range map[string]string{
+		"preset":   "invalid security.preset 'paranoid'",
+		"tmpfs":    "invalid security.tmpfs 'tmp'",
+		"cap":      "invalid security.cap_add 'NET-ADMIN'",
+		"seccomp":  "invalid security.seccomp 'seccomp.json'",
+		"apparmor": "invalid security.apparmor 'my profile'",
+	} {
+		assertInvalid(t, loader, command, expected)
+	}
+}
+
+func TestLoadCommandConfigEgress(t *testing.T) {
+	loader := writeCommandConfigs(t, map[string]string{
+		"allowed":   "image: test\negress:\n  allow: [pypi.org, \"*.npmjs.org\", \"registry.example.com:8443\"]",
+		"empty":     "image: test\negress:\n  allow: []",
+		"wildcard":  "image: test\negress:\n  allow: [\"pypi.*\"]",
+		"url":       "image: test\negress:\n  allow: [\"https://pypi.org\"]",
+		"port":      "image: test\negress:\n  allow: [\"pypi.org:99999\"]",
+		"network":   "image: test\nnetwork: host\negress:\n  allow: [pypi.org]",
+		"keepalive": "image: test\nkeep_alive: 10m\negress:\n  allow: [pypi.org]",
+	})
+	config, err := loader.LoadCommandConfig("allowed")
+	if err != nil {
+		t.Fatalf("LoadCommandConfig() error = %v", err)
+	}
+	if expected := []string{"pypi.org", "*.npmjs.org", "registry.example.com:8443"}; config.Egress == nil || !reflect.DeepEqual(config.Egress.Allow, expected) {
+		t.Errorf("config.Egress = %+v, want %v allowed", config.Egress, expected)
+	}
+
+	for command, expected := range map[string]string{
+		"empty":     "egress.allow must list at least one host",
+		"wildcard":  "invalid egress.allow 'pypi.*': must be a host name",
+		"url":       "invalid egress.allow 'https://pypi.org': must be a host name",
+		"port":      "invalid egress.allow 'pypi.org:99999': the port must be between 1 and 65535",
+		"network":   "network can't be used with egress",
+		"keepalive": "keep_alive can't be used with egress",
+	} {
+		assertInvalid(t, loader, command, expected)
+	}
+}
+
+func TestLoadCommandConfigForward(t *testing.T) {
+	loader := writeCommandConfigs(t, map[string]string{
+		"git":       "image: test\nforward: [ssh-agent, gpg-agent, git-credentials]",
+		"unknown":   "image: test\nforward: [docker-socket]",
+		"keepalive": "image: test\nkeep_alive: 10m\nforward: [ssh-agent]",
+	})
+	config, err := loader.LoadCommandConfig("git")
+	if err != nil {
+		t.Fatalf("LoadCommandConfig() error = %v", err)
+	}
+	if expected := []string{ForwardSSHAgent, ForwardGPGAgent, ForwardGitCredentials}; !reflect.DeepEqual(config.Forward, expected) {
+		t.Errorf("config.Forward = %v, want %v", config.Forward, expected)
+	}
+
+	assertInvalid(t, loader, "unknown", "invalid forward 'docker-socket'")
+	assertInvalid(t, loader, "keepalive", "keep_alive can't be used with forward")
+}
+
+func TestLoadCommandConfigGUI(t *testing.T) {
+	loader := writeCommandConfigs(t, map[string]string{
+		"gimp":      "image: test\ngui: auto\naudio: true",
+		"invalid":   "image: test\ngui: quartz",
+		"keepalive": "image: test\nkeep_alive: 10m\ngui: x11",
+	})
+	config, err := loader.LoadCommandConfig("gimp")
+	if err != nil {
+		t.Fatalf("LoadCommandConfig() error = %v", err)
+	}
+	if config.GUI != GUIAuto || !config.Audio {
+		t.Errorf("gui = %q and audio = %v, want auto with audio", config.GUI, config.Audio)
+	}
+
+	assertInvalid(t, loader, "invalid", "invalid gui mode 'quartz'")
+	assertInvalid(t, loader, "keepalive", "keep_alive can't be used with gui or audio")
+}
+
+func TestLoadCommandConfigDevices(t *testing.T) {
+	loader := writeCommandConfigs(t, map[string]string{
+		"sshfs":       "image: test\ndevices: [/dev/fuse, /dev/ttyUSB0:/dev/ttyS0:rw, /dev/snd:r]\nengine_socket: true",
+		"relative":    "image: test\ndevices: [fuse]",
+		"permissions": "image: test\ndevices: [/dev/fuse:/dev/fuse:rx]",
+		"extra":       "image: test\ndevices: [/dev/fuse:/dev/fuse:rw:x]",
+		"egress":      "image: test\nengine_socket: true\negress:\n  allow: [pypi.org]",
+	})
+	config, err := loader.LoadCommandConfig("sshfs")
+	if err != nil {
+		t.Fatalf("LoadCommandConfig() error = %v", err)
+	}
+	if len(config.Devices) != 3 || !config.EngineSocket {
+		t.Errorf("devices = %v and engine_socket = %v, want three devices with the engine socket", config.Devices, config.EngineSocket)
+	}
+
+	for command, expected := range map[string]string{
+		"relative":    "invalid device 'fuse': paths must be absolute",
+		"permissions": "invalid device '/dev/fuse:/dev/fuse:rx': permissions must be a combination of r, w and m",
+		"extra":       "invalid device '/dev/fuse:/dev/fuse:rw:x': must be host[:container][:permissions]",
+		"egress":      "engine_socket can't be used with egress",
+	} {
+		assertInvalid(t, loader, command, expected)
+	}
+}
+
+// BenchmarkLoadCommandConfig tracks how long loading a command's configuration
+// adds to every run.
+func BenchmarkLoadCommandConfig(b *testing.B) {
+	tmpDir := b.TempDir()
+	commandsDir := filepath.Join(tmpDir, "dox", "commands")
+	os.MkdirAll(commandsDir, 0755)
+	content := "image: python:3.11\nvolumes:\n  - .:/workspace\nenvironment:\n  - HOME\nkeep_alive: 10m\n"
+	os.WriteFile(filepath.Join(commandsDir, "python.yaml"), []byte(content), 0644)
+
+	loader := NewLoaderWithConfigHome(tmpDir)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := loader.LoadCommandConfig("python"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkLoadGlobalConfig tracks how long loading the global configuration
+// adds to every run.
+func BenchmarkLoadGlobalConfig(b *testing.B) {
+	tmpDir := b.TempDir()
+	os.MkdirAll(filepath.Join(tmpDir, "dox"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "dox", "config.yaml"), []byte("runtime: podman\n"), 0644)
+
+	loader := NewLoaderWithConfigHome(tmpDir)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := loader.LoadGlobalConfig(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package config
+
+import "strings"
+
+// signalNames are the signals a container can be asked to stop with. Commands
+// run on Linux, so these are Linux's signals, whatever the host.
+var signalNames = map[string]bool{
+	"SIGHUP": true, "SIGINT": true, "SIGQUIT": true, "SIGILL": true, "SIGTRAP": true,
+	"SIGABRT": true, "SIGBUS": true, "SIGFPE": true, "SIGKILL": true, "SIGUSR1": true,
+	"SIGSEGV": true, "SIGUSR2": true, "SIGPIPE": true, "SIGALRM": true, "SIGTERM": true,
+	"SIGSTKFLT": true, "SIGCHLD": true, "SIGCONT": true, "SIGSTOP": true, "SIGTSTP": true,
+	"SIGTTIN": true, "SIGTTOU": true, "SIGURG": true, "SIGXCPU": true, "SIGXFSZ": true,
+	"SIGVTALRM": true, "SIGPROF": true, "SIGWINCH": true, "SIGIO": true, "SIGPWR": true,
+	"SIGSYS": true,
+}
+
+// SignalName returns the canonical name of a signal, like SIGTERM for term or
+// TERM, and whether it is a known signal.
+func SignalName(signal string) (string, bool) {
+	name := strings.ToUpper(signal)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	return name, signalNames[name]
+}
@@ -1,5 +1,51 @@
 package config
 
+// Workspace modes control how the current directory is made available to the container.
+const (
+	WorkspaceCwd     = "cwd"      // Bind mount the current directory at /workspace (default)
+	WorkspaceCopy    = "copy"     // Copy the current directory in before start and sync changes back after exit
+	WorkspaceMirror  = "mirror"   // Bind mount the current directory at its host path and work there
+	WorkspaceGitRoot = "git-root" // Bind mount the git repository root at /workspace and work in the current subdirectory
+)
+
+// WorkingDirImage is the workdir setting that keeps the image's WORKDIR.
+const WorkingDirImage = "image"
+
+// User modes control who the command runs as. Any other value is a user name or uid[:gid].
+const (
+	UserHost  = "host"  // The host user's uid and gid (default)
+	UserRoot  = "root"  // The container's root user
+	UserImage = "image" // The image's USER
+)
+
+// Path argument modes control whether arguments that are host paths are made available in the container.
+const (
+	PathArgsOff  = "off"  // Pass arguments through unchanged (default)
+	PathArgsAuto = "auto" // Mount arguments that are existing host paths and rewrite them to container paths
+)
+
+// Security presets are hardened combinations of security settings.
+const (
+	SecurityPresetStrict = "strict" // Read-only root filesystem with in-memory scratch directories, no capabilities and no privilege gains
+)
+
+// Forwards are the host services whose sockets can be forwarded into the container.
+const (
+	ForwardSSHAgent       = "ssh-agent"       // The SSH agent in SSH_AUTH_SOCK
+	ForwardGPGAgent       = "gpg-agent"       // The GnuPG agent's restricted extra socket, with the public keyring
+	ForwardGitCredentials = "git-credentials" // Credentials from the host's git credential helpers
+)
+
+// GUI modes control which of the host's displays GUI applications can use.
+const (
+	GUIX11     = "x11"     // The X11 display in DISPLAY
+	GUIWayland = "wayland" // The Wayland compositor in WAYLAND_DISPLAY
+	GUIAuto    = "auto"    // Whichever of them the host has
+)
+
+// SecurityUnconfined is the seccomp or AppArmor setting that disables the profile.
+const SecurityUnconfined = "unconfined"
+
 // GlobalConfig represents the global dox configuration.
 type GlobalConfig struct {
 	Runtime string `mapstructure:"runtime" yaml:"runtime"` // docker or podman
@@ -7,13 +53,73 @@ type GlobalConfig struct {
 
 // CommandConfig represents configuration for a specific command.
 type CommandConfig struct {
-	Image       string            `mapstructure:"image" yaml:"image"`             // Container image to use
-	Build       *BuildConfig      `mapstructure:"build" yaml:"build"`             // Optional build configuration
-	Volumes     []string          `mapstructure:"volumes" yaml:"volumes"`         // Volume mounts
-	Environment []string          `mapstructure:"environment" yaml:"environment"` // Environment variables to pass through
-	Command     string            `mapstructure:"command" yaml:"command"`         // Optional command override
-	Network     string            `mapstructure:"network" yaml:"network"`         // Network mode (host, bridge, none, or custom network name)
-	Ports       []string          `mapstructure:"ports" yaml:"ports"`             // Port mappings (format: "host:container")
+	Image           string           `mapstructure:"image" yaml:"image"`                         // Container image to use
+	Build           *BuildConfig     `mapstructure:"build" yaml:"build"`                         // Optional build configuration
+	Volumes         []string         `mapstructure:"volumes" yaml:"volumes"`                     // Volume mounts
+	Environment     []string         `mapstructure:"environment" yaml:"environment"`             // Environment variables to pass through
+	Command         string           `mapstructure:"command" yaml:"command"`                     // Optional command override
+	Entrypoint      string           `mapstructure:"entrypoint" yaml:"entrypoint"`               // Optional image entrypoint override
+	WorkingDir      string           `mapstructure:"workdir" yaml:"workdir"`                     // Optional working directory override, or "image" for the image's WORKDIR
+	User            string           `mapstructure:"user" yaml:"user"`                           // User mode (host, root or image), or a user name or uid[:gid]
+	UserNS          string           `mapstructure:"userns" yaml:"userns"`                       // User namespace mode (e.g. host, or keep-id for podman)
+	GroupAdd        []string         `mapstructure:"group_add" yaml:"group_add"`                 // Supplementary groups, as host group names or GIDs
+	Network         string           `mapstructure:"network" yaml:"network"`                     // Network mode (host, bridge, none, or custom network name)
+	Ports           []string         `mapstructure:"ports" yaml:"ports"`                         // Port mappings (format: "host:container")
+	Workspace       string           `mapstructure:"workspace" yaml:"workspace"`                 // Workspace mode (cwd, copy, mirror or git-root)
+	WorkspaceIgnore []string         `mapstructure:"workspace_ignore" yaml:"workspace_ignore"`   // Patterns excluded from workspace copies
+	DockerHost      string           `mapstructure:"docker_host" yaml:"docker_host"`             // Docker daemon address for this command
+	PathArgs        string           `mapstructure:"path_args" yaml:"path_args"`                 // Path argument mode (off or auto)
+	PathArgsAllow   []string         `mapstructure:"path_args_allow" yaml:"path_args_allow"`     // Flags whose --flag=value values are translated
+	PathArgsDeny    []string         `mapstructure:"path_args_deny" yaml:"path_args_deny"`       // Flags whose values are never translated
+	PathArgsWrite   bool             `mapstructure:"path_args_write" yaml:"path_args_write"`     // Mount paths read-write instead of read-only
+	HostIdentity    bool             `mapstructure:"host_identity" yaml:"host_identity"`         // Emulate the host user with passwd and group entries and a home directory
+	HostTimezone    bool             `mapstructure:"host_timezone" yaml:"host_timezone"`         // Use the host's timezone
+	HostLocale      bool             `mapstructure:"host_locale" yaml:"host_locale"`             // Pass the host's locale variables through
+	KeepAlive       string           `mapstructure:"keep_alive" yaml:"keep_alive"`               // How long a warm container is kept after its last run (e.g. 10m), off if empty
+	Resources       *ResourcesConfig `mapstructure:"resources" yaml:"resources"`                 // Optional resource limits
+	Security        *SecurityConfig  `mapstructure:"security" yaml:"security"`                   // Optional security hardening
+	Timeout         string           `mapstructure:"timeout" yaml:"timeout"`                     // How long the command may run (e.g. 30m), unlimited if empty
+	StopSignal      string           `mapstructure:"stop_signal" yaml:"stop_signal"`             // Signal that asks the command to stop (e.g. SIGINT), SIGTERM if empty
+	StopGracePeriod string           `mapstructure:"stop_grace_period" yaml:"stop_grace_period"` // How long the command gets to stop before it is killed (e.g. 30s)
+	Egress          *EgressConfig    `mapstructure:"egress" yaml:"egress"`                       // Optional allowlist of hosts the container may connect to
+	Forward         []string         `mapstructure:"forward" yaml:"forward"`                     // Host services forwarded into the container (ssh-agent, gpg-agent or git-credentials)
+	GUI             string           `mapstructure:"gui" yaml:"gui"`                             // Display GUI applications use (x11, wayland or auto), none if empty
+	Audio           bool             `mapstructure:"audio" yaml:"audio"`                         // Play and record audio through the host's PulseAudio or PipeWire
+	Devices         []string         `mapstructure:"devices" yaml:"devices"`                     // Host devices, as host[:container][:permissions] (e.g. /dev/fuse)
+	EngineSocket    bool             `mapstructure:"engine_socket" yaml:"engine_socket"`         // Mount the container runtime's socket, which is root-equivalent
+
+	// Sandbox is the host copy of the workspace that sandboxed runs mount instead
+	// of the workspace. It is set by dox rather than configured.
+	Sandbox string `mapstructure:"-" yaml:"-"`
+}
+
+// ResourcesConfig represents the resource limits of a command's container.
+type ResourcesConfig struct {
+	CPUs       string            `mapstructure:"cpus" yaml:"cpus"`               // Number of CPUs (e.g. 1.5)
+	Memory     string            `mapstructure:"memory" yaml:"memory"`           // Memory limit (e.g. 4g)
+	MemorySwap string            `mapstructure:"memory_swap" yaml:"memory_swap"` // Memory plus swap limit (e.g. 6g), or -1 for unlimited swap
+	PidsLimit  int64             `mapstructure:"pids_limit" yaml:"pids_limit"`   // Maximum number of processes, or -1 for unlimited
+	Ulimits    map[string]string `mapstructure:"ulimits" yaml:"ulimits"`         // Limits by name, as soft[:hard] (e.g. nofile: 1024:2048)
+	ShmSize    string            `mapstructure:"shm_size" yaml:"shm_size"`       // Size of /dev/shm (e.g. 1g)
+}
+
+// SecurityConfig represents the security hardening of a command's container.
+// Settings are added to the preset's, and booleans that are set override it.
+type SecurityConfig struct {
+	Preset          string   `mapstructure:"preset" yaml:"preset"`                       // Hardened combination to start from (strict), none if empty
+	ReadOnlyRootfs  *bool    `mapstructure:"read_only_rootfs" yaml:"read_only_rootfs"`   // Mount the container's root filesystem read-only
+	Tmpfs           []string `mapstructure:"tmpfs" yaml:"tmpfs"`                         // Writable in-memory mounts, as path[:options]
+	CapDrop         []string `mapstructure:"cap_drop" yaml:"cap_drop"`                   // Capabilities to drop (e.g. ALL)
+	CapAdd          []string `mapstructure:"cap_add" yaml:"cap_add"`                     // Capabilities to add back (e.g. NET_BIND_SERVICE)
+	NoNewPrivileges *bool    `mapstructure:"no_new_privileges" yaml:"no_new_privileges"` // Keep processes from gaining privileges, e.g. with setuid binaries
+	Seccomp         string   `mapstructure:"seccomp" yaml:"seccomp"`                     // Path to a seccomp profile, or unconfined
+	AppArmor        string   `mapstructure:"apparmor" yaml:"apparmor"`                   // Name of a loaded AppArmor profile, or unconfined
+}
+
+// EgressConfig represents the hosts a command's container may connect to. The
+// container has no other network access, and reaches them through dox's proxy.
+type EgressConfig struct {
+	Allow []string `mapstructure:"allow" yaml:"allow"` // Host names, where *.example.com matches subdomains, optionally followed by :port
 }
 
 // BuildConfig represents inline Dockerfile build configuration.
@@ -25,4 +131,4 @@ type BuildConfig struct {
 type Config struct {
 	Global  GlobalConfig
 	Command CommandConfig
-}
\ No newline at end of file
+}
@@ -0,0 +1,306 @@
+// Package egress implements the proxy that limits which hosts a command's
+// container may connect to.
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultPorts are the ports of hosts that are allowed without a port.
+var defaultPorts = map[string]bool{"80": true, "443": true}
+
+// hopHeaders are the headers that only apply to a single connection, and so
+// aren't forwarded.
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// errInternal is returned when an allowed host resolves to an internal address
+// that isn't allowed itself.
+var errInternal = errors.New("internal addresses are only reachable if they are allowed")
+
+// Proxy is an HTTP proxy that only lets requests and CONNECT tunnels through to
+// allowed hosts, and logs those it denies.
+type Proxy struct {
+	// Dial connects to the addresses of allowed hosts. It defaults to a
+	// net.Dialer, and can be replaced to reach stand-in servers.
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+	// Resolve looks up the addresses of a host. It defaults to the system's
+	// resolver, and can be replaced along with Dial.
+	Resolve func(ctx context.Context, host string) ([]net.IP, error)
+
+	allow     []string
+	server    *http.Server
+	transport *http.Transport
+
+	mu      sync.Mutex
+	tunnels map[net.Conn]bool
+	closed  bool
+}
+
+// NewProxy creates a proxy that allows connections to the hosts matching the
+// patterns. Patterns are host names, where *.example.com matches subdomains of
+// example.com, optionally followed by a port. Without a port, ports 80 and 443
+// are allowed. Private, loopback and link-local addresses are only reachable
+// if the address itself is allowed, so allowed names can't be pointed at them.
+func NewProxy(allow []string) *Proxy {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	p := &Proxy{
+		Dial: dialer.DialContext,
+		Resolve: func(ctx context.Context, host string) ([]net.IP, error) {
+			return net.DefaultResolver.LookupIP(ctx, "ip", host)
+		},
+		allow:   allow,
+		tunnels: make(map[net.Conn]bool),
+	}
+	p.transport = &http.Transport{
+		DialContext: p.dial,
+		// Requests to other hosts must not reach the proxy's own proxy.
+		Proxy: nil,
+	}
+	p.server = &http.Server{Handler: p, ReadHeaderTimeout: 30 * time.Second}
+	return p
+}
+
+// Serve accepts proxy connections on the listener until the proxy is closed.
+func (p *Proxy) Serve(listener net.Listener) error {
+	err := p.server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Close stops the proxy and closes its open connections, including tunnels.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	for conn := range p.tunnels {
+		conn.Close()
+	}
+	p.mu.Unlock()
+
+	p.transport.CloseIdleConnections()
+	return p.server.Close()
+}
+
+// Allowed reports whether connections to a host and port are allowed.
+func (p *Proxy) Allowed(host, port string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, pattern := range p.allow {
+		patternHost, patternPort := SplitPattern(pattern)
+		if patternPort == "" && !defaultPorts[port] || patternPort != "" && patternPort != port {
+			continue
+		}
+		if suffix, ok := strings.CutPrefix(patternHost, "*"); ok {
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+		} else if host == patternHost {
+			return true
+		}
+	}
+	return false
+}
+
+// dial connects to an allowed host. The proxy resolves the host itself and
+// connects to the address it checked, so the name can't resolve differently in
+// between.
+func (p *Proxy) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := p.Resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fmt.Errorf("no addresses found for %s", host)
+	for _, ip := range ips {
+		if internal(ip) && !p.Allowed(ip.String(), port) {
+			err = fmt.Errorf("%s resolves to %s: %w", host, ip, errInternal)
+			continue
+		}
+		var conn net.Conn
+		if conn, err = p.Dial(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// internal reports whether an address belongs to a private network, to the
+// machine itself or to its link, such as cloud metadata services.
+func internal(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// SplitPattern splits an allowlist pattern into its lowercase host and its
+// port, which is empty if there isn't one.
+func SplitPattern(pattern string) (host, port string) {
+	pattern = strings.ToLower(pattern)
+	if h, p, err := net.SplitHostPort(pattern); err == nil {
+		return strings.TrimSuffix(h, "."), p
+	}
+	return strings.TrimSuffix(strings.Trim(pattern, "[]"), "."), ""
+}
+
+// ServeHTTP handles a proxy request, which is either a CONNECT tunnel or a
+// plain HTTP request for an absolute URL.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+	if !r.URL.IsAbs() || r.URL.Scheme != "http" {
+		http.Error(w, "dox: this is an egress proxy, which only handles proxy requests", http.StatusBadRequest)
+		return
+	}
+
+	host, port := r.URL.Hostname(), r.URL.Port()
+	if port == "" {
+		port = "80"
+	}
+	if !p.Allowed(host, port) {
+		p.deny(w, net.JoinHostPort(host, port))
+		return
+	}
+
+	outgoing := r.Clone(r.Context())
+	outgoing.RequestURI = ""
+	for _, header := range hopHeaders {
+		outgoing.Header.Del(header)
+	}
+	response, err := p.transport.RoundTrip(outgoing)
+	if errors.Is(err, errInternal) {
+		p.denyInternal(w, net.JoinHostPort(host, port), err)
+		return
+	}
+	if err != nil {
+		logrus.Debugf("Egress to %s failed: %v", r.URL.Host, err)
+		http.Error(w, fmt.Sprintf("dox: failed to reach %s", r.URL.Host), http.StatusBadGateway)
+		return
+	}
+	defer response.Body.Close()
+
+	for _, header := range hopHeaders {
+		response.Header.Del(header)
+	}
+	for name, values := range response.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(response.StatusCode)
+	_, _ = io.Copy(w, response.Body)
+}
+
+// tunnel handles a CONNECT request by relaying the connection to the host,
+// which is how HTTPS goes through the proxy.
+func (p *Proxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("dox: invalid CONNECT address %s", r.Host), http.StatusBadRequest)
+		return
+	}
+	if !p.Allowed(host, port) {
+		p.deny(w, r.Host)
+		return
+	}
+
+	upstream, err := p.dial(r.Context(), "tcp", r.Host)
+	if errors.Is(err, errInternal) {
+		p.denyInternal(w, r.Host, err)
+		return
+	}
+	if err != nil {
+		logrus.Debugf("Egress to %s failed: %v", r.Host, err)
+		http.Error(w, fmt.Sprintf("dox: failed to reach %s", r.Host), http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "dox: tunnels aren't supported", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	if !p.track(client, upstream) {
+		return
+	}
+	defer p.untrack(client, upstream)
+
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+	// The client may have sent the start of the tunnel along with the request.
+	if n := buffered.Reader.Buffered(); n > 0 {
+		data, _ := buffered.Reader.Peek(n)
+		if _, err := upstream.Write(data); err != nil {
+			return
+		}
+	}
+
+	done := make(chan struct{}, 2)
+	relay := func(dst, src net.Conn) {
+		_, _ = io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go relay(upstream, client)
+	go relay(client, upstream)
+	<-done
+}
+
+// track records the connections of a tunnel, so they are closed along with the
+// proxy. It closes them and returns false if the proxy is already closed.
+func (p *Proxy) track(conns ...net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		return false
+	}
+	for _, conn := range conns {
+		p.tunnels[conn] = true
+	}
+	return true
+}
+
+// untrack closes the connections of a finished tunnel.
+func (p *Proxy) untrack(conns ...net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+		delete(p.tunnels, conn)
+	}
+}
+
+// deny refuses a connection to an address that isn't allowed, and logs it.
+func (p *Proxy) deny(w http.ResponseWriter, address string) {
+	logrus.Warnf("Denied egress to %s, which isn't in the egress allowlist", address)
+	http.Error(w, fmt.Sprintf("dox: egress to %s is not allowed", address), http.StatusForbidden)
+}
+
+// denyInternal refuses a connection to an allowed host that resolved to an
+// internal address, and logs it.
+func (p *Proxy) denyInternal(w http.ResponseWriter, address string, err error) {
+	logrus.Warnf("Denied egress to %s: %v", address, err)
+	http.Error(w, fmt.Sprintf("dox: egress to %s is not allowed", address), http.StatusForbidden)
+}
@@ -0,0 +1,246 @@
+package egress
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// fakeAddresses are the addresses of hosts for fakeResolve.
+var fakeAddresses = map[string]string{
+	"metadata.npmjs.org": "169.254.169.254",
+	"intranet.npmjs.org": "10.0.0.5",
+	"localhost":          "127.0.0.1",
+}
+
+// fakeResolve resolves the hosts in fakeAddresses, addresses to themselves
+// and other hosts to a public address.
+func fakeResolve(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	if address, ok := fakeAddresses[host]; ok {
+		return []net.IP{net.ParseIP(address)}, nil
+	}
+	return []net.IP{net.ParseIP("203.0.113.1")}, nil
+}
+
+// startProxy starts a proxy that reaches the upstream server whatever host it
+// is asked for, and returns a client that goes through it.
+func startProxy(t *testing.T, upstream *httptest.Server, allow ...string) *http.Client {
+	t.Helper()
+	proxy := NewProxy(allow)
+	upstreamAddress := upstream.Listener.Addr().String()
+	proxy.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, upstreamAddress)
+	}
+	proxy.Resolve = fakeResolve
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go proxy.Serve(listener)
+	t.Cleanup(func() { proxy.Close() })
+
+	proxyURL := &url.URL{Scheme: "http", Host: listener.Addr().String()}
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+}
+
+// fetch gets a URL and returns the response's status code and body.
+func fetch(t *testing.T, client *http.Client, target string) (int, string) {
+	t.Helper()
+	response, err := client.Get(target)
+	if err != nil {
+		// Go reports refused CONNECT tunnels as errors holding the status.
+		if strings.Contains(err.Error(), "Forbidden") {
+			return http.StatusForbidden, ""
+		}
+		t.Fatalf("GET %s error = %v", target, err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	return response.StatusCode, string(body)
+}
+
+func TestProxyAllowed(t *testing.T) {
+	proxy := NewProxy([]string{"pypi.org", "*.npmjs.org", "Example.com:8080"})
+	tests := []struct {
+		host    string
+		port    string
+		allowed bool
+	}{
+		{"pypi.org", "443", true},
+		{"pypi.org", "80", true},
+		{"PyPI.org.", "443", true},
+		{"pypi.org", "22", false},
+		{"files.pypi.org", "443", false},
+		{"registry.npmjs.org", "443", true},
+		{"a.b.npmjs.org", "443", true},
+		{"npmjs.org", "443", false},
+		{"evilnpmjs.org", "443", false},
+		{"example.com", "8080", true},
+		{"example.com", "443", false},
+		{"github.com", "443", false},
+	}
+	for _, test := range tests {
+		if allowed := proxy.Allowed(test.host, test.port); allowed != test.allowed {
+			t.Errorf("Allowed(%s, %s) = %t, want %t", test.host, test.port, allowed, test.allowed)
+		}
+	}
+}
+
+func TestSplitPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		port    string
+	}{
+		{"pypi.org", "pypi.org", ""},
+		{"*.NPMJS.org", "*.npmjs.org", ""},
+		{"example.com:8080", "example.com", "8080"},
+		{"[::1]:443", "::1", "443"},
+		{"[::1]", "::1", ""},
+	}
+	for _, test := range tests {
+		if host, port := SplitPattern(test.pattern); host != test.host || port != test.port {
+			t.Errorf("SplitPattern(%s) = %s, %s, want %s, %s", test.pattern, host, port, test.host, test.port)
+		}
+	}
+}
+
+func TestProxyHTTP(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Connection") != "" {
+			t.Error("hop-by-hop headers should not be forwarded")
+		}
+		io.WriteString(w, "hello from "+r.Host)
+	}))
+	defer upstream.Close()
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	client := startProxy(t, upstream, "pypi.org")
+
+	if status, body := fetch(t, client, "http://pypi.org/simple/"); status != http.StatusOK || body != "hello from pypi.org" {
+		t.Errorf("allowed request = %d %q, want it forwarded", status, body)
+	}
+	if status, body := fetch(t, client, "http://github.com/"); status != http.StatusForbidden || !strings.Contains(body, "egress to github.com:80 is not allowed") {
+		t.Errorf("denied request = %d %q, want 403", status, body)
+	}
+
+	entry := hook.LastEntry()
+	if entry == nil || entry.Level != logrus.WarnLevel || !strings.Contains(entry.Message, "github.com:80") {
+		t.Errorf("last log entry = %v, want the denied connection logged", entry)
+	}
+}
+
+func TestProxyConnect(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secure hello")
+	}))
+	defer upstream.Close()
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	client := startProxy(t, upstream, "*.npmjs.org")
+
+	if status, body := fetch(t, client, "https://registry.npmjs.org/left-pad"); status != http.StatusOK || body != "secure hello" {
+		t.Errorf("allowed tunnel = %d %q, want it relayed", status, body)
+	}
+	if status, _ := fetch(t, client, "https://npmjs.org.evil.com/"); status != http.StatusForbidden {
+		t.Errorf("denied tunnel = %d, want 403", status)
+	}
+	if entry := hook.LastEntry(); entry == nil || !strings.Contains(entry.Message, "npmjs.org.evil.com:443") {
+		t.Errorf("last log entry = %v, want the denied tunnel logged", entry)
+	}
+}
+
+func TestProxyInternalAddresses(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello from "+r.Host)
+	}))
+	defer upstream.Close()
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	client := startProxy(t, upstream, "*.npmjs.org", "localhost:8080", "10.0.0.5", "10.0.0.6")
+
+	// Allowed names that resolve to internal addresses are refused, unless
+	// the address is allowed too.
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"http://registry.npmjs.org/", http.StatusOK},
+		{"http://metadata.npmjs.org/", http.StatusForbidden},
+		{"http://localhost:8080/", http.StatusForbidden},
+		{"https://localhost:8080/", http.StatusForbidden},
+		{"http://intranet.npmjs.org/", http.StatusOK},
+		{"http://10.0.0.6/", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if status, _ := fetch(t, client, tt.url); status != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.url, status, tt.status)
+		}
+	}
+
+	var logged bool
+	for _, entry := range hook.AllEntries() {
+		logged = logged || strings.Contains(entry.Message, "metadata.npmjs.org:80") && strings.Contains(entry.Message, "169.254.169.254")
+	}
+	if !logged {
+		t.Error("the denied internal address should be logged")
+	}
+}
+
+func TestProxyRejectsDirectRequests(t *testing.T) {
+	proxy := NewProxy([]string{"pypi.org"})
+	recorder := httptest.NewRecorder()
+	proxy.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("direct request = %d, want 400", recorder.Code)
+	}
+}
+
+func TestProxyCloseEndsTunnels(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	proxy := NewProxy([]string{"pypi.org"})
+	proxy.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, upstream.Listener.Addr().String())
+	}
+	proxy.Resolve = fakeResolve
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go proxy.Serve(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "CONNECT pypi.org:443 HTTP/1.1\r\nHost: pypi.org:443\r\n\r\n")
+	established := make([]byte, len("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	if _, err := io.ReadFull(conn, established); err != nil || !strings.Contains(string(established), "200") {
+		t.Fatalf("CONNECT response = %q, %v, want the tunnel established", established, err)
+	}
+
+	proxy.Close()
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("reading a closed tunnel error = %v, want EOF", err)
+	}
+}
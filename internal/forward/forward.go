@@ -0,0 +1,231 @@
+// Package forward makes host services like agents and credential helpers
+// available to containers through unix sockets.
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// socketMode lets any user connect to the sockets, since the container's user
+// may not be the host user. The sockets are only reachable by the host user and
+// through the container's mount.
+const socketMode = 0666
+
+// credentialTimeout is how long a credential helper may take to answer.
+const credentialTimeout = time.Minute
+
+// FillFunc looks up the credential matching a request in the format of git's
+// credential protocol, and returns the completed credential in the same format.
+type FillFunc func(ctx context.Context, request string) (string, error)
+
+// Server serves the sockets that forward host services, until it is closed.
+type Server struct {
+	mu        sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]bool
+	closed    bool
+}
+
+// NewServer creates a server without sockets.
+func NewServer() *Server {
+	return &Server{conns: make(map[net.Conn]bool)}
+}
+
+// Relay creates a socket at path that relays each connection to the socket at
+// target, like the host's SSH agent.
+func (s *Server) Relay(path, target string) error {
+	listener, err := s.listen(path)
+	if err != nil {
+		return err
+	}
+	go s.serve(listener, func(conn net.Conn) {
+		upstream, err := net.Dial("unix", target)
+		if err != nil {
+			logrus.Warnf("Failed to connect to %s: %v", target, err)
+			return
+		}
+		if !s.track(upstream) {
+			return
+		}
+		defer s.untrack(upstream)
+
+		done := make(chan struct{}, 2)
+		relay := func(dst, src net.Conn) {
+			_, _ = io.Copy(dst, src)
+			done <- struct{}{}
+		}
+		go relay(upstream, conn)
+		go relay(conn, upstream)
+		<-done
+	})
+	return nil
+}
+
+// Credentials creates a socket at path that answers git's credential-cache
+// client with credentials from fill, so git in the container can use the
+// host's credential helpers with "credential.helper=cache --socket=<path>".
+// Only lookups are answered, so the container can't store or erase the host's
+// credentials.
+func (s *Server) Credentials(path string, fill FillFunc) error {
+	listener, err := s.listen(path)
+	if err != nil {
+		return err
+	}
+	go s.serve(listener, func(conn net.Conn) {
+		action, request, err := readCredentialRequest(conn)
+		if err != nil {
+			logrus.Debugf("Failed to read credential request: %v", err)
+			return
+		}
+		if action != "get" {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), credentialTimeout)
+		defer cancel()
+		credential, err := fill(ctx, request)
+		if err != nil {
+			logrus.Debugf("No credential for %s: %v", credentialHost(request), err)
+			return
+		}
+		_, _ = io.WriteString(conn, credential)
+	})
+	return nil
+}
+
+// Close removes the sockets and closes their connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// listen creates a socket at path that anyone who can reach it may connect to.
+func (s *Server) listen(path string) (net.Listener, error) {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket %s: %w", path, err)
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set permissions of socket %s: %w", path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		listener.Close()
+		return nil, fmt.Errorf("failed to create socket %s: server closed", path)
+	}
+	s.listeners = append(s.listeners, listener)
+	return listener, nil
+}
+
+// serve handles each connection to a socket until the server is closed.
+func (s *Server) serve(listener net.Listener, handle func(conn net.Conn)) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		if !s.track(conn) {
+			return
+		}
+		go func() {
+			defer s.untrack(conn)
+			handle(conn)
+		}()
+	}
+}
+
+// track records a connection, so it is closed along with the server. It closes
+// the connection and returns false if the server is already closed.
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		conn.Close()
+		return false
+	}
+	s.conns[conn] = true
+	return true
+}
+
+// untrack closes a finished connection.
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conn.Close()
+	delete(s.conns, conn)
+}
+
+// readCredentialRequest reads a request of git's credential-cache client, which
+// is its action and timeout followed by the credential, and returns the action
+// and the credential.
+func readCredentialRequest(conn net.Conn) (action, request string, err error) {
+	_ = conn.SetReadDeadline(time.Now().Add(credentialTimeout))
+	var credential strings.Builder
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		key, value, _ := strings.Cut(line, "=")
+		switch key {
+		case "action":
+			action = value
+		case "timeout":
+		default:
+			credential.WriteString(line + "\n")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	return action, credential.String(), nil
+}
+
+// credentialHost returns the host of a credential request, for logging.
+func credentialHost(request string) string {
+	for _, line := range strings.Split(request, "\n") {
+		if host, ok := strings.CutPrefix(line, "host="); ok {
+			return host
+		}
+	}
+	return "the request"
+}
+
+// GitFill looks up credentials with the host's git credential helpers. Git
+// must not prompt on the terminal the command runs on, so credentials that
+// aren't stored aren't found.
+func GitFill(ctx context.Context, request string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "credential", "fill")
+	cmd.Stdin = strings.NewReader(request + "\n")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GCM_INTERACTIVE=never", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(output), nil
+}
@@ -0,0 +1,132 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// socketDir returns a directory for sockets, whose paths are limited in length.
+func socketDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "dox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// startAgent starts a stand-in agent that answers each connection with its
+// request in upper case.
+func startAgent(t *testing.T, path string) {
+	t.Helper()
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buffer := make([]byte, 64)
+				n, _ := conn.Read(buffer)
+				conn.Write([]byte(strings.ToUpper(string(buffer[:n]))))
+			}()
+		}
+	}()
+}
+
+func TestServerRelay(t *testing.T) {
+	dir := socketDir(t)
+	startAgent(t, filepath.Join(dir, "agent.sock"))
+	server := NewServer()
+	defer server.Close()
+
+	relay := filepath.Join(dir, "relay.sock")
+	if err := server.Relay(relay, filepath.Join(dir, "agent.sock")); err != nil {
+		t.Fatalf("Relay() error = %v", err)
+	}
+	if info, err := os.Stat(relay); err != nil || info.Mode().Perm() != socketMode {
+		t.Errorf("relay socket mode = %v, %v, want %v so any container user can connect", info.Mode(), err, os.FileMode(socketMode))
+	}
+
+	conn, err := net.Dial("unix", relay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("list keys"))
+	response := make([]byte, 9)
+	if _, err := io.ReadFull(conn, response); err != nil || string(response) != "LIST KEYS" {
+		t.Errorf("response = %q, %v, want the agent's answer", response, err)
+	}
+
+	server.Close()
+	if _, err := net.Dial("unix", relay); err == nil {
+		t.Error("the relay should stop accepting connections once closed")
+	}
+}
+
+func TestServerCredentials(t *testing.T) {
+	dir := socketDir(t)
+	server := NewServer()
+	defer server.Close()
+
+	var mu sync.Mutex
+	var requests []string
+	fill := func(ctx context.Context, request string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, request)
+		if strings.Contains(request, "host=github.com\n") {
+			return request + "username=octocat\npassword=hunter2\n", nil
+		}
+		return "", errors.New("not found")
+	}
+	path := filepath.Join(dir, "git-credentials.sock")
+	if err := server.Credentials(path, fill); err != nil {
+		t.Fatalf("Credentials() error = %v", err)
+	}
+
+	// ask sends a request the way git credential-cache does, and returns the answer.
+	ask := func(request string) string {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		io.WriteString(conn, request)
+		conn.(*net.UnixConn).CloseWrite()
+		response, _ := io.ReadAll(conn)
+		return string(response)
+	}
+
+	expected := "protocol=https\nhost=github.com\nusername=octocat\npassword=hunter2\n"
+	if response := ask("action=get\ntimeout=900\nprotocol=https\nhost=github.com\n"); response != expected {
+		t.Errorf("get = %q, want %q", response, expected)
+	}
+	if response := ask("action=get\ntimeout=900\nprotocol=https\nhost=gitlab.com\n"); response != "" {
+		t.Errorf("get of an unknown host = %q, want no credential", response)
+	}
+	// The container can't change the host's credentials.
+	if response := ask("action=store\ntimeout=900\nprotocol=https\nhost=github.com\nusername=evil\npassword=x\n"); response != "" {
+		t.Errorf("store = %q, want it ignored", response)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 2 || requests[0] != "protocol=https\nhost=github.com\n" {
+		t.Errorf("fill requests = %q, want the two lookups without the action and timeout", requests)
+	}
+}
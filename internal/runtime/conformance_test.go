@@ -0,0 +1,1325 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+// backendHarness drives a fake container backend for the conformance suite.
+type backendHarness interface {
+	Runtime() Runtime
+	AddImage(name string)
+	Script(exitCode int, stdout, stderr string, echoStdin bool)
+	FailPulls(message string)
+	// KillWithSignal makes the next container die from a signal.
+	KillWithSignal(sig syscall.Signal)
+	// LastRun returns the options the last container was started with, normalized
+	// to ContainerOptions so both backends can be compared.
+	LastRun() (ContainerOptions, bool)
+	BuildCount() int
+	PullCount() int
+	// SetImageDefaults sets the entrypoint and default command of all images.
+	SetImageDefaults(entrypoint, cmd []string)
+	// SetImageFiles sets the files in /etc of all images, by path.
+	SetImageFiles(files map[string]string)
+	// WarmContainers returns the warm containers that exist, normalized like LastRun.
+	WarmContainers() []fakePodmanWarm
+	// Execs returns the commands executed in warm containers, normalized like LastRun.
+	Execs() []ContainerOptions
+	// RunOutOfMemory makes containers with a memory limit get killed for running out of it.
+	RunOutOfMemory()
+	// RunUntilStopped makes containers run until they are sent a signal, and
+	// then die from it.
+	RunUntilStopped()
+	// RemoveIdleCommand makes images lack the command warm containers idle
+	// with, so they fail to start.
+	RemoveIdleCommand()
+	// Fetch makes containers fetch URLs through the proxy in their environment,
+	// and print a line with each URL and its response's status.
+	Fetch(urls ...string)
+	// Networks returns the networks that were created, with whether they are internal.
+	Networks() map[string]bool
+	// EngineSocket returns the path of the runtime's API socket on the host.
+	EngineSocket() string
+}
+
+// dockerHarness runs the conformance suite against the fake Docker daemon.
+type dockerHarness struct {
+	fake *fakeDocker
+}
+
+func (h *dockerHarness) Runtime() Runtime {
+	return h.fake.runtime()
+}
+
+func (h *dockerHarness) AddImage(name string) {
+	h.fake.AddImage(name)
+}
+
+func (h *dockerHarness) Script(exitCode int, stdout, stderr string, echoStdin bool) {
+	h.fake.ExitCode, h.fake.Stdout, h.fake.Stderr, h.fake.EchoStdin = exitCode, stdout, stderr, echoStdin
+}
+
+func (h *dockerHarness) FailPulls(message string) {
+	h.fake.PullError = message
+}
+
+func (h *dockerHarness) KillWithSignal(sig syscall.Signal) {
+	// The daemon reports containers killed by a signal with 128 plus its number.
+	h.fake.ExitCode = 128 + int(sig)
+}
+
+func (h *dockerHarness) LastRun() (ContainerOptions, bool) {
+	containers := h.fake.Containers()
+	if len(containers) == 0 {
+		return ContainerOptions{}, false
+	}
+	create := containers[len(containers)-1].Create
+
+	var ports []string
+	for port, bindings := range create.HostConfig.PortBindings {
+		for _, binding := range bindings {
+			ports = append(ports, fmt.Sprintf("%s:%s", binding.HostPort, port.Port()))
+		}
+	}
+	sort.Strings(ports)
+
+	var ulimits []string
+	for _, ulimit := range create.HostConfig.Ulimits {
+		ulimits = append(ulimits, ulimit.String())
+	}
+	var pidsLimit int64
+	if create.HostConfig.PidsLimit != nil {
+		pidsLimit = *create.HostConfig.PidsLimit
+	}
+	// Anonymous volumes are only a path, like podman's.
+	volumes := create.HostConfig.Binds
+	for path := range create.Volumes {
+		volumes = append(volumes, path)
+	}
+	var tmpfs []string
+	for path, options := range create.HostConfig.Tmpfs {
+		if options != "" {
+			path += ":" + options
+		}
+		tmpfs = append(tmpfs, path)
+	}
+	sort.Strings(tmpfs)
+	var devices []string
+	for _, device := range create.HostConfig.Devices {
+		devices = append(devices, device.PathOnHost+":"+device.PathInContainer+":"+device.CgroupPermissions)
+	}
+	var stopGracePeriod time.Duration
+	if create.StopTimeout != nil {
+		stopGracePeriod = time.Duration(*create.StopTimeout) * time.Second
+	}
+
+	return ContainerOptions{
+		Image:           create.Image,
+		Command:         create.Cmd,
+		Entrypoint:      strings.Join(create.Entrypoint, " "),
+		Env:             create.Env,
+		Volumes:         volumes,
+		WorkingDir:      create.WorkingDir,
+		User:            create.User,
+		UserNS:          string(create.HostConfig.UsernsMode),
+		GroupAdd:        create.HostConfig.GroupAdd,
+		Interactive:     create.OpenStdin,
+		TTY:             create.Tty,
+		Remove:          create.HostConfig.AutoRemove,
+		Network:         string(create.HostConfig.NetworkMode),
+		Ports:           ports,
+		CPUs:            float64(create.HostConfig.NanoCPUs) / 1e9,
+		Memory:          create.HostConfig.Memory,
+		MemorySwap:      create.HostConfig.MemorySwap,
+		PidsLimit:       pidsLimit,
+		Ulimits:         ulimits,
+		ShmSize:         create.HostConfig.ShmSize,
+		StopSignal:      create.StopSignal,
+		StopGracePeriod: stopGracePeriod,
+		ReadOnly:        create.HostConfig.ReadonlyRootfs,
+		Tmpfs:           tmpfs,
+		CapDrop:         create.HostConfig.CapDrop,
+		CapAdd:          create.HostConfig.CapAdd,
+		SecurityOpt:     create.HostConfig.SecurityOpt,
+		Devices:         devices,
+	}, true
+}
+
+func (h *dockerHarness) RunOutOfMemory() {
+	h.fake.OOMKill = true
+}
+
+func (h *dockerHarness) RunUntilStopped() {
+	h.fake.RunUntilKilled = true
+}
+
+func (h *dockerHarness) RemoveIdleCommand() {
+	h.fake.NoIdleCommand = true
+}
+
+func (h *dockerHarness) Fetch(urls ...string) {
+	h.fake.Fetch = urls
+}
+
+func (h *dockerHarness) Networks() map[string]bool {
+	networks := make(map[string]bool)
+	for name, resource := range h.fake.Networks() {
+		networks[name] = resource.Internal
+	}
+	return networks
+}
+
+func (h *dockerHarness) EngineSocket() string {
+	return strings.TrimPrefix(h.fake.Host, "unix://")
+}
+
+func (h *dockerHarness) SetImageDefaults(entrypoint, cmd []string) {
+	h.fake.ImageEntrypoint, h.fake.ImageCmd = entrypoint, cmd
+}
+
+func (h *dockerHarness) SetImageFiles(files map[string]string) {
+	h.fake.ImageFiles = files
+}
+
+func (h *dockerHarness) WarmContainers() []fakePodmanWarm {
+	var warm []fakePodmanWarm
+	for _, c := range h.fake.Containers() {
+		if !c.isWarm() || h.fake.lookup(c.ID) == nil {
+			continue
+		}
+		warm = append(warm, fakePodmanWarm{
+			ID:     c.ID,
+			Name:   c.Create.Name,
+			Labels: c.Create.Labels,
+			Init:   c.Create.HostConfig.Init != nil && *c.Create.HostConfig.Init,
+			Options: ContainerOptions{
+				Image:      c.Create.Image,
+				Entrypoint: strings.Join(c.Create.Entrypoint, " "),
+				Command:    c.Create.Cmd,
+				Volumes:    c.Create.HostConfig.Binds,
+			},
+		})
+	}
+	return warm
+}
+
+func (h *dockerHarness) Execs() []ContainerOptions {
+	var execs []ContainerOptions
+	for _, e := range h.fake.Execs() {
+		execs = append(execs, ContainerOptions{
+			Command:     e.Config.Cmd,
+			Env:         e.Config.Env,
+			WorkingDir:  e.Config.WorkingDir,
+			User:        e.Config.User,
+			Interactive: e.Config.AttachStdin,
+			TTY:         e.Config.Tty,
+		})
+	}
+	return execs
+}
+
+func (h *dockerHarness) BuildCount() int {
+	return len(h.fake.Builds())
+}
+
+func (h *dockerHarness) PullCount() int {
+	return len(h.fake.Pulls())
+}
+
+// podmanHarness runs the conformance suite against the fake podman binary.
+type podmanHarness struct {
+	fake *fakePodman
+}
+
+func (h *podmanHarness) Runtime() Runtime {
+	return h.fake.runtime()
+}
+
+func (h *podmanHarness) AddImage(name string) {
+	h.fake.Update(func(state *fakePodmanState) {
+		state.Images = append(state.Images, normalizeImage(name))
+	})
+}
+
+func (h *podmanHarness) Script(exitCode int, stdout, stderr string, echoStdin bool) {
+	h.fake.Update(func(state *fakePodmanState) {
+		state.ExitCode, state.Stdout, state.Stderr, state.EchoStdin = exitCode, stdout, stderr, echoStdin
+	})
+}
+
+func (h *podmanHarness) FailPulls(message string) {
+	h.fake.Update(func(state *fakePodmanState) {
+		state.PullError = message
+	})
+}
+
+func (h *podmanHarness) KillWithSignal(sig syscall.Signal) {
+	h.fake.Update(func(state *fakePodmanState) {
+		state.Signal = int(sig)
+	})
+}
+
+func (h *podmanHarness) LastRun() (ContainerOptions, bool) {
+	runs := h.fake.State().Runs
+	if len(runs) == 0 {
+		return ContainerOptions{}, false
+	}
+	opts := runs[len(runs)-1].Options
+	sort.Strings(opts.Ports)
+	sort.Strings(opts.Tmpfs)
+	return opts, true
+}
+
+func (h *podmanHarness) RunOutOfMemory() {
+	h.fake.Update(func(state *fakePodmanState) {
+		state.OOMKill = true
+	})
+}
+
+func (h *podmanHarness) RunUntilStopped() {
+	h.fake.Update(func(state *fakePodmanState) {
+		state.Hold = true
+	})
+}
+
+func (h *podmanHarness) RemoveIdleCommand() {
+	h.fake.Update(func(state *fakePodmanState) {
+		state.NoIdleCommand = true
+	})
+}
+
+func (h *podmanHarness) Fetch(urls ...string) {
+	h.fake.Update(func(state *fakePodmanState) {
+		state.Fetch = urls
+	})
+}
+
+func (h *podmanHarness) Networks() map[string]bool {
+	return h.fake.State().Networks
+}
+
+func (h *podmanHarness) EngineSocket() string {
+	socket := filepath.Join(h.fake.dir, "podman.sock")
+	if _, err := os.Stat(socket); err != nil {
+		os.WriteFile(socket, nil, 0600)
+		h.fake.Update(func(state *fakePodmanState) {
+			state.EngineSocket = socket
+		})
+	}
+	return socket
+}
+
+func (h *podmanHarness) SetImageDefaults(entrypoint, cmd []string) {
+	h.fake.Update(func(state *fakePodmanState) {
+		state.ImageEntrypoint, state.ImageCmd = entrypoint, cmd
+	})
+}
+
+func (h *podmanHarness) SetImageFiles(files map[string]string) {
+	h.fake.Update(func(state *fakePodmanState) {
+		state.ImageFiles = files
+	})
+}
+
+func (h *podmanHarness) WarmContainers() []fakePodmanWarm {
+	warm := h.fake.State().Warm
+	for i := range warm {
+		// Only compare the settings both backends record.
+		warm[i].Options = ContainerOptions{Image: warm[i].Options.Image, Entrypoint: warm[i].Options.Entrypoint, Command: warm[i].Options.Command, Volumes: warm[i].Options.Volumes}
+	}
+	return warm
+}
+
+func (h *podmanHarness) Execs() []ContainerOptions {
+	var execs []ContainerOptions
+	for _, e := range h.fake.State().Execs {
+		e.Options.Image = ""
+		execs = append(execs, e.Options)
+	}
+	return execs
+}
+
+func (h *podmanHarness) BuildCount() int {
+	return len(h.fake.State().Builds)
+}
+
+func (h *podmanHarness) PullCount() int {
+	return len(h.fake.State().Pulls)
+}
+
+// conformanceBackends lists the backends that must behave identically.
+var conformanceBackends = []struct {
+	name  string
+	setup func(t testing.TB) backendHarness
+}{
+	{"docker", func(t testing.TB) backendHarness { return &dockerHarness{fake: newFakeDocker(t)} }},
+	{"podman", func(t testing.TB) backendHarness { return &podmanHarness{fake: newFakePodman(t)} }},
+}
+
+// conformanceRun is the outcome of a single ExecuteCommand call.
+type conformanceRun struct {
+	exitCode    int
+	containerID string
+	oomKilled   bool
+	timedOut    bool
+	err         error
+	stdout      string
+	stderr      string
+}
+
+// fakeIdles reports whether a container of the fakes keeps running until it is
+// removed, which it does if its process is the idle command. The process is the
+// container's entrypoint and command, with the image's defaults for the ones
+// it doesn't set, like the runtimes do. docker-entrypoint.sh runs its arguments.
+func fakeIdles(entrypoint, cmd, imageEntrypoint, imageCmd []string) bool {
+	process := append(append([]string{}, entrypoint...), cmd...)
+	if len(entrypoint) == 0 {
+		if len(cmd) == 0 {
+			cmd = imageCmd
+		}
+		process = append(append([]string{}, imageEntrypoint...), cmd...)
+	}
+	if len(process) > 0 && process[0] == "docker-entrypoint.sh" {
+		process = process[1:]
+	}
+	return reflect.DeepEqual(process, idleCommand)
+}
+
+// fakeFetch fetches URLs through the proxy in a container's HTTP_PROXY, like a
+// command in the container would, and returns a line with each URL and its
+// response's status, or "error".
+func fakeFetch(env []string, urls []string) string {
+	transport := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	for _, entry := range env {
+		if address, ok := strings.CutPrefix(entry, "HTTP_PROXY="); ok {
+			if proxyURL, err := url.Parse(address); err == nil {
+				transport.Proxy = http.ProxyURL(proxyURL)
+			}
+		}
+	}
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+	defer transport.CloseIdleConnections()
+
+	var out strings.Builder
+	for _, target := range urls {
+		status := "error"
+		response, err := client.Get(target)
+		if err == nil {
+			response.Body.Close()
+			status = strconv.Itoa(response.StatusCode)
+		} else if strings.Contains(err.Error(), http.StatusText(http.StatusForbidden)) {
+			// Refused CONNECT tunnels are reported as errors.
+			status = strconv.Itoa(http.StatusForbidden)
+		}
+		fmt.Fprintf(&out, "%s %s\n", target, status)
+	}
+	return out.String()
+}
+
+// execute runs a command through the backend with in-memory streams.
+func execute(h backendHarness, cfg *config.CommandConfig, command string, args []string, upgrade bool, stdin string) conformanceRun {
+	var stdout, stderr bytes.Buffer
+	result, err := h.Runtime().ExecuteCommand(context.Background(), cfg, command, args, upgrade, strings.NewReader(stdin), &stdout, &stderr)
+	return conformanceRun{exitCode: result.ExitCode, containerID: result.ContainerID, oomKilled: result.OOMKilled, timedOut: result.TimedOut, err: err, stdout: stdout.String(), stderr: stderr.String()}
+}
+
+func TestRuntimeConformance(t *testing.T) {
+	cwd, _ := os.Getwd()
+	user := fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, h backendHarness)
+	}{
+		{
+			name: "exit code is propagated",
+			run: func(t *testing.T, h backendHarness) {
+				h.AddImage("alpine")
+				h.Script(42, "", "", false)
+				result := execute(h, &config.CommandConfig{Image: "alpine"}, "alpine", []string{"false"}, false, "")
+				if result.err != nil {
+					t.Fatalf("ExecuteCommand() error = %v", result.err)
+				}
+				if result.exitCode != 42 {
+					t.Errorf("exitCode = %d, want 42", result.exitCode)
+				}
+			},
+		},
+		{
+			name: "death by signal is reported as 128 plus the signal number",
+			run: func(t *testing.T, h backendHarness) {
+				h.AddImage("alpine")
+				h.KillWithSignal(syscall.SIGTERM)
+				result := execute(h, &config.CommandConfig{Image: "alpine"}, "alpine", []string{"sleep", "60"}, false, "")
+				if result.err != nil {
+					t.Fatalf("ExecuteCommand() error = %v", result.err)
+				}
+				if result.exitCode != 143 {
+					t.Errorf("exitCode = %d, want 143", result.exitCode)
+				}
+			},
+		},
+		{
+			name: "container ID is reported",
+			run: func(t *testing.T, h backendHarness) {
+				h.AddImage("alpine")
+				result := execute(h, &config.CommandConfig{Image: "alpine"}, "alpine", nil, false, "")
+				if result.containerID == "" {
+					t.Error("ContainerID should be set once a container was created")
+				}
+			},
+		},
+		{
+			name: "environment overrides are set verbatim",
+			run: func(t *testing.T, h backendHarness) {
+				t.Setenv("DOX_CONFORMANCE_OVERRIDE", "host")
+				h.AddImage("alpine")
+				cfg := &config.CommandConfig{Image: "alpine", Environment: []string{"DOX_CONFORMANCE_OVERRIDE=explicit", "EMPTY="}}
+				execute(h, cfg, "alpine", nil, false, "")
+				opts, _ := h.LastRun()
+				expected := []string{"DOX_CONFORMANCE_OVERRIDE=explicit", "EMPTY="}
+				if !reflect.DeepEqual(opts.Env, expected) {
+					t.Errorf("Env = %v, want %v", opts.Env, expected)
+				}
+			},
+		},
+		{
+			name: "stdout and stderr stay separate when piped",
+			run: func(t *testing.T, h backendHarness) {
+				h.AddImage("alpine")
+				h.Script(0, "result\n", "progress\n", false)
+				result := execute(h, &config.CommandConfig{Image: "alpine"}, "alpine", nil, false, "")
+				if result.stdout != "result\n" {
+					t.Errorf("stdout = %q, want %q", result.stdout, "result\n")
+				}
+				if result.stderr != "progress\n" {
+					t.Errorf("stderr = %q, want %q", result.stderr, "progress\n")
+				}
+			},
+		},
+		{
+			name: "piped stdin is forwarded without a TTY",
+			run: func(t *testing.T, h backendHarness) {
+				h.AddImage("alpine")
+				h.Script(0, "", "", true)
+				result := execute(h, &config.CommandConfig{Image: "alpine"}, "alpine", []string{"cat"}, false, "line one\nline two\n")
+				if result.stdout != "line one\nline two\n" {
+					t.Errorf("stdout = %q, want the piped input", result.stdout)
+				}
+				opts, _ := h.LastRun()
+				if opts.TTY {
+					t.Error("TTY must not be allocated when stdin and stdout are not terminals")
+				}
+				if !opts.Interactive {
+					t.Error("stdin must be kept open for piped input")
+				}
+			},
+		},
+		{
+			name: "TTY is allocated for terminals",
+			run: func(t *testing.T, h backendHarness) {
+				_, tty := openPTY(t)
+				h.AddImage("alpine")
+				_, err := h.Runtime().ExecuteCommand(context.Background(), &config.CommandConfig{Image: "alpine"}, "alpine", nil, false, tty, tty, tty)
+				if err != nil {
+					t.Fatalf("ExecuteCommand() error = %v", err)
+				}
+				if opts, _ := h.LastRun(); !opts.TTY {
+					t.Error("TTY should be allocated when stdin and stdout are terminals")
+				}
+			},
+		},
+		{
+			name: "container settings",
+			run: func(t *testing.T, h backendHarness) {
+				t.Setenv("DOX_CONFORMANCE_SET", "value")
+				t.Setenv("DOX_CONFORMANCE_UNSET", "")
+				h.AddImage("python:3.11")
+				cfg := &config.CommandConfig{
+					Image:       "python:3.11",
+					Command:     "python",
+					Volumes:     []string{"/data:/data:ro"},
+					Environment: []string{"DOX_CONFORMANCE_SET", "DOX_CONFORMANCE_UNSET"},
+					Network:     "bridge",
+					Ports:       []string{"8080:80"},
+				}
+				if result := execute(h, cfg, "python", []string{"-V"}, false, ""); result.err != nil {
+					t.Fatalf("ExecuteCommand() error = %v", result.err)
+				}
+
+				opts, ok := h.LastRun()
+				if !ok {
+					t.Fatal("no container was run")
+				}
+				expected := ContainerOptions{
+					Image:       "python:3.11",
+					Command:     []string{"python", "-V"},
+					Env:         []string{"DOX_CONFORMANCE_SET=value"},
+					Volumes:     []string{cwd + ":/workspace", "/data:/data:ro"},
+					WorkingDir:  "/workspace",
+					User:        user,
+					Interactive: true,
+					Remove:      true,
+					Network:     "bridge",
+					Ports:       []string{"8080:80"},
+				}
+				if !reflect.DeepEqual(opts, expected) {
+					t.Errorf("options = %+v, want %+v", opts, expected)
+				}
+			},
+		},
+		{
+			name: "entrypoint, working directory and user overrides",
+			run: func(t *testing.T, h backendHarness) {
+				h.AddImage("dox-tool:latest")
+				cfg := &config.CommandConfig{
+					Build:      &config.BuildConfig{DockerfileInline: "FROM alpine\nWORKDIR /src\n"},
+					Entrypoint: "/bin/sh",
+					WorkingDir: "/tmp",
+					User:       "0:0",
+				}
+				if result := execute(h, cfg, "tool", []string{"-c", "id"}, false, ""); result.err != nil {
+					t.Fatalf("ExecuteCommand() error = %v", result.err)
+				}
+
+				opts, _ := h.LastRun()
+				if opts.Entrypoint != "/bin/sh" || opts.WorkingDir != "/tmp" || opts.User != "0:0" {
+					t.Errorf("entrypoint %q, working directory %q and user %q, want /bin/sh, /tmp and 0:0", opts.Entrypoint, opts.WorkingDir, opts.User)
+				}
+				if !reflect.DeepEqual(opts.Command, []string{"-c", "id"}) {
+					t.Errorf("Command = %v, want [-c id]", opts.Command)
+				}
+			},
+		},
+		{
+			name: "host path arguments are mounted",
+			run: func(t *testing.T, h backendHarness) {
+				file := filepath.Join(t.TempDir(), "data.json")
+				if err := os.WriteFile(file, []byte("{}"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				h.AddImage("jq")
+				cfg := &config.CommandConfig{Image: "jq", PathArgs: config.PathArgsAuto}
+				if result := execute(h, cfg, "jq", []string{".", file}, false, ""); result.err != nil {
+					t.Fatalf("ExecuteCommand() error = %v", result.err)
+				}
+
+				opts, _ := h.LastRun()
+				if !reflect.DeepEqual(opts.Command, []string{".", "/host" + file}) {
+					t.Errorf("Command = %v, want the path translated", opts.Command)
+				}
+				expected := []string{cwd + ":/workspace", file + ":/host" + file + ":ro"}
+				if !reflect.DeepEqual(opts.Volumes, expected) {
+					t.Errorf("Volumes = %v, want %v", opts.Volumes, expected)
+				}
+			},
+		},
+		{
+			name: "workspace modes and the inline Dockerfile's WORKDIR",
+			run: func(t *testing.T, h backendHarness) {
+				repo := t.TempDir()
+				dir := filepath.Join(repo, "services", "api")
+				if err := os.MkdirAll(filepath.Join(repo, ".git"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chdir(dir); err != nil {
+					t.Fatal(err)
+				}
+				defer os.Chdir(cwd)
+
+				h.AddImage("dox-tool:latest")
+				build := &config.BuildConfig{DockerfileInline: "FROM alpine\nWORKDIR /app\n"}
+				tests := []struct {
+					workspace, workdir string
+					volume, workingDir string
+				}{
+					{config.WorkspaceCwd, "", dir + ":/workspace", ""},
+					{config.WorkspaceMirror, "", dir + ":" + dir, dir},
+					{config.WorkspaceGitRoot, "", repo + ":/workspace", "/workspace/services/api"},
+					{config.WorkspaceGitRoot, config.WorkingDirImage, repo + ":/workspace", ""},
+					{config.WorkspaceMirror, "/tmp", dir + ":" + dir, "/tmp"},
+				}
+				for _, tt := range tests {
+					cfg := &config.CommandConfig{Build: build, Workspace: tt.workspace, WorkingDir: tt.workdir}
+					if result := execute(h, cfg, "tool", nil, false, ""); result.err != nil {
+						t.Fatalf("ExecuteCommand() error = %v", result.err)
+					}
+					opts, _ := h.LastRun()
+					if len(opts.Volumes) != 1 || opts.Volumes[0] != tt.volume || opts.WorkingDir != tt.workingDir {
+						t.Errorf("%s with workdir %q: volumes %v and working directory %q, want [%s] and %q",
+							tt.workspace, tt.workdir, opts.Volumes, opts.WorkingDir, tt.volume, tt.workingDir)
+					}
+				}
+			},
+		},
+		{
+			name: "user modes, user namespaces and supplementary groups",
+			run: func(t *testing.T, h backendHarness) {
+				defer stubHostUser(t, 1000, 1000)()
+				h.AddImage("alpine")
+
+				// The workspace is bind mounted, so files keep their host owner and
+				// the host user is the one that can change them.
+				tests := []struct {
+					user, expected string
+				}{
+					{"", "1000:1000"},
+					{config.UserHost, "1000:1000"},
+					{config.UserRoot, "0:0"},
+					{config.UserImage, ""},
+					{"node", "node"},
+					{"1001:1001", "1001:1001"},
+				}
+				for _, tt := range tests {
+					cfg := &config.CommandConfig{Image: "alpine", User: tt.user, UserNS: "host", GroupAdd: []string{"4321", "keep-groups"}}
+					if result := execute(h, cfg, "tool", nil, false, ""); result.err != nil {
+						t.Fatalf("ExecuteCommand() error = %v", result.err)
+					}
+					opts, _ := h.LastRun()
+					if opts.User != tt.expected {
+						t.Errorf("user %q runs as %q, want %q", tt.user, opts.User, tt.expected)
+					}
+					if opts.UserNS != "host" || !reflect.DeepEqual(opts.GroupAdd, []string{"4321", "keep-groups"}) {
+						t.Errorf("userns = %q and groups = %v, want host and [4321 keep-groups]", opts.UserNS, opts.GroupAdd)
+					}
+				}
+			},
+		},
+		{
+			name: "keep_alive executes runs in a warm container",
+			run: func(t *testing.T, h backendHarness) {
+				defer stubHostUser(t, 1000, 1000)()
+				t.Setenv("XDG_CACHE_HOME", t.TempDir())
+				h.AddImage("node:20")
+				h.SetImageDefaults([]string{"docker-entrypoint.sh"}, []string{"node"})
+				h.Script(3, "out", "err", false)
+
+				cfg := &config.CommandConfig{Image: "node:20", KeepAlive: "10m", Environment: []string{"A=1"}}
+				result := execute(h, cfg, "node", []string{"-v"}, false, "")
+				if result.err != nil {
+					t.Fatalf("ExecuteCommand() error = %v", result.err)
+				}
+				if result.exitCode != 3 || result.stdout != "out" || result.stderr != "err" {
+					t.Errorf("result = %d, %q and %q, want the execution's exit code and output", result.exitCode, result.stdout, result.stderr)
+				}
+
+				warm := h.WarmContainers()
+				if len(warm) != 1 {
+					t.Fatalf("warm containers = %+v, want one", warm)
+				}
+				if !warm[0].Init || warm[0].Options.Entrypoint != "tail" || !reflect.DeepEqual(warm[0].Options.Command, []string{"-f", "/dev/null"}) || warm[0].Labels[labelKeepAlive] != "10m" || warm[0].Labels[labelCommand] != "node" {
+					t.Errorf("warm container = %+v, want an idle labeled container with an init process", warm[0])
+				}
+				if !reflect.DeepEqual(warm[0].Options.Volumes, []string{cwd + ":/workspace"}) {
+					t.Errorf("volumes = %v, want the workspace", warm[0].Options.Volumes)
+				}
+
+				// Per-run settings go to each execution, and the image's defaults apply.
+				execs := h.Execs()
+				expected := ContainerOptions{Command: []string{"docker-entrypoint.sh", "-v"}, Env: []string{"A=1"}, WorkingDir: "/workspace", User: "1000:1000", Interactive: true}
+				if len(execs) != 1 || !reflect.DeepEqual(execs[0], expected) {
+					t.Errorf("execs = %+v, want [%+v]", execs, expected)
+				}
+
+				// The warm container is reused, whatever the command and environment.
+				cfg.Environment = []string{"A=2"}
+				execute(h, cfg, "node", nil, false, "")
+				cfg.Entrypoint = "sh"
+				execute(h, cfg, "node", []string{"-c", "true"}, false, "")
+				if reused := h.WarmContainers(); len(reused) != 1 || reused[0].ID != warm[0].ID {
+					t.Errorf("warm containers = %+v, want the first one reused", reused)
+				}
+				execs = h.Execs()
+				if len(execs) != 3 || !reflect.DeepEqual(execs[1].Command, []string{"docker-entrypoint.sh", "node"}) || !reflect.DeepEqual(execs[2].Command, []string{"sh", "-c", "true"}) {
+					t.Errorf("execs = %+v, want the image's default command and then the entrypoint override", execs)
+				}
+
+				// Changing the container's settings or upgrading replaces it.
+				cfg.Volumes = []string{"/cache:/cache"}
+				execute(h, cfg, "node", nil, false, "")
+				replaced := h.WarmContainers()
+				if len(replaced) != 1 || replaced[0].ID == warm[0].ID || len(replaced[0].Options.Volumes) != 2 {
+					t.Errorf("warm containers = %+v, want one with the new volumes", replaced)
+				}
+				execute(h, cfg, "node", nil, true, "")
+				if upgraded := h.WarmContainers(); len(upgraded) != 1 || upgraded[0].ID == replaced[0].ID {
+					t.Errorf("warm containers = %+v, want a new one after upgrading", upgraded)
+				}
+			},
+		},
+		{
+			name: "keep_alive idles in place of an image entrypoint that is the tool itself",
+			run: func(t *testing.T, h backendHarness) {
+				t.Setenv("XDG_CACHE_HOME", t.TempDir())
+				h.AddImage("hashicorp/terraform")
+				h.SetImageDefaults([]string{"/bin/terraform"}, nil)
+				h.Script(0, "Terraform v1.7.0", "", false)
+
+				cfg := &config.CommandConfig{Image: "hashicorp/terraform", KeepAlive: "10m"}
+				for i := 0; i < 2; i++ {
+					result := execute(h, cfg, "terraform", []string{"version"}, false, "")
+					if result.err != nil || result.exitCode != 0 || result.stdout != "Terraform v1.7.0" {
+						t.Fatalf("run %d = %d, %q and %v, want terraform's output from the warm container", i, result.exitCode, result.stdout, result.err)
+					}
+				}
+
+				// The executions still use the image's entrypoint.
+				execs := h.Execs()
+				if len(execs) != 2 || !reflect.DeepEqual(execs[1].Command, []string{"/bin/terraform", "version"}) {
+					t.Errorf("execs = %+v, want terraform version twice", execs)
+				}
+				if warm := h.WarmContainers(); len(warm) != 1 {
+					t.Errorf("warm containers = %+v, want one reused", warm)
+				}
+			},
+		},
+		{
+			name: "keep_alive runs in a container of its own when the warm container can't be used",
+			run: func(t *testing.T, h backendHarness) {
+				t.Setenv("XDG_CACHE_HOME", t.TempDir())
+				h.AddImage("alpine")
+				h.Script(0, "out", "", false)
+				cfg := &config.CommandConfig{Image: "alpine", KeepAlive: "10m", PathArgs: config.PathArgsAuto}
+
+				// Path arguments that need mounts leave the warm container alone.
+				execute(h, cfg, "tool", nil, false, "")
+				warm := h.WarmContainers()
+				if len(warm) != 1 {
+					t.Fatalf("warm containers = %+v, want one", warm)
+				}
+				input := filepath.Join(t.TempDir(), "input.json")
+				if err := os.WriteFile(input, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+				result := execute(h, cfg, "tool", []string{input}, false, "")
+				if result.err != nil || result.stdout != "out" {
+					t.Fatalf("ExecuteCommand() = %q, %v, want the command's output", result.stdout, result.err)
+				}
+				if opts, ok := h.LastRun(); !ok || !contains(opts.Volumes, input+":/host"+input+":ro") {
+					t.Errorf("last run = %+v, want the path argument mounted in a container of its own", opts)
+				}
+				execute(h, cfg, "tool", nil, false, "")
+				if reused := h.WarmContainers(); len(reused) != 1 || reused[0].ID != warm[0].ID || len(h.Execs()) != 2 {
+					t.Errorf("warm containers = %+v with %d executions, want the first one reused", reused, len(h.Execs()))
+				}
+
+				// Images the warm container can't idle in run the command directly.
+				hook := logtest.NewGlobal()
+				defer hook.Reset()
+				h.RemoveIdleCommand()
+				cfg.Image, cfg.PathArgs = "busybox-less", ""
+				h.AddImage("busybox-less")
+				result = execute(h, cfg, "other", []string{"-v"}, false, "")
+				if result.err != nil || result.stdout != "out" {
+					t.Fatalf("ExecuteCommand() = %q, %v, want the command's output", result.stdout, result.err)
+				}
+				if opts, _ := h.LastRun(); opts.Image != "busybox-less" || !reflect.DeepEqual(opts.Command, []string{"-v"}) {
+					t.Errorf("last run = %+v, want the command run in a container of its own", opts)
+				}
+				if warm := h.WarmContainers(); len(warm) != 1 {
+					t.Errorf("warm containers = %+v, want the one that failed to start removed", warm)
+				}
+				var warned bool
+				for _, entry := range hook.AllEntries() {
+					warned = warned || entry.Level == logrus.WarnLevel && strings.Contains(entry.Message, idleCommand[0])
+				}
+				if !warned {
+					t.Error("running without a warm container should come with a warning that names the idle command")
+				}
+			},
+		},
+		{
+			name: "idle warm containers are reaped",
+			run: func(t *testing.T, h backendHarness) {
+				t.Setenv("XDG_CACHE_HOME", t.TempDir())
+				h.AddImage("alpine")
+
+				execute(h, &config.CommandConfig{Image: "alpine", KeepAlive: "1ms"}, "brief", nil, false, "")
+				if warm := h.WarmContainers(); len(warm) != 1 {
+					t.Fatalf("warm containers = %+v, want the one in use kept", warm)
+				}
+
+				time.Sleep(10 * time.Millisecond)
+				execute(h, &config.CommandConfig{Image: "alpine", KeepAlive: "1h"}, "long", nil, false, "")
+				warm := h.WarmContainers()
+				if len(warm) != 1 || warm[0].Labels[labelCommand] != "long" {
+					t.Errorf("warm containers = %+v, want only the one in use", warm)
+				}
+
+				if err := h.Runtime().RemoveUnusedContainers(context.Background()); err != nil {
+					t.Fatalf("RemoveUnusedContainers() error = %v", err)
+				}
+				if warm := h.WarmContainers(); len(warm) != 1 {
+					t.Errorf("warm containers = %+v, want the one that isn't idle kept", warm)
+				}
+			},
+		},
+		{
+			name: "host identity, timezone and locale",
+			run: func(t *testing.T, h backendHarness) {
+				defer stubHostUser(t, 1000, 1000)()
+				cacheHome := t.TempDir()
+				t.Setenv("XDG_CACHE_HOME", cacheHome)
+				t.Setenv("TZ", "Europe/Athens")
+				t.Setenv("LANG", "el_GR.UTF-8")
+
+				h.AddImage("node")
+				// The image's own users are kept, except the one in the host
+				// user's place.
+				h.SetImageFiles(map[string]string{
+					passwdPath: "root:x:0:0:root:/root:/bin/ash\nnode:x:1000:1000::/home/node:/bin/sh\npostgres:x:70:70::/var/lib/postgresql:/bin/sh\n",
+					groupPath:  "root:x:0:root\nnode:x:1000:\npostgres:x:70:\n",
+				})
+				cfg := &config.CommandConfig{Image: "node", HostIdentity: true, HostTimezone: true, HostLocale: true}
+				if result := execute(h, cfg, "tool", nil, false, ""); result.err != nil {
+					t.Fatalf("ExecuteCommand() error = %v", result.err)
+				}
+				opts, _ := h.LastRun()
+				identity := identityFor(cfg, "tool", "node")
+				for _, expected := range []string{"HOME=" + identity.home, "USER=" + identity.name, "TZ=Europe/Athens", "LANG=el_GR.UTF-8"} {
+					if !contains(opts.Env, expected) {
+						t.Errorf("env = %v, want it to contain %s", opts.Env, expected)
+					}
+				}
+				for _, volume := range identity.volumes() {
+					if !contains(opts.Volumes, volume) {
+						t.Errorf("volumes = %v, want it to contain %s", opts.Volumes, volume)
+					}
+				}
+
+				passwd, group := identity.files()
+				content, err := os.ReadFile(passwd)
+				expected := "root:x:0:0:root:/root:/bin/ash\npostgres:x:70:70::/var/lib/postgresql:/bin/sh\n" + identity.name + ":x:1000:1000:"
+				if err != nil || !strings.HasPrefix(string(content), expected) {
+					t.Errorf("passwd = %q (%v), want the image's users and the host user", content, err)
+				}
+				content, err = os.ReadFile(group)
+				expected = "root:x:0:root\npostgres:x:70:\n" + identity.group + ":x:1000:" + identity.name + "\n"
+				if err != nil || string(content) != expected {
+					t.Errorf("group = %q (%v), want %q", content, err, expected)
+				}
+				if info, err := os.Stat(filepath.Join(cacheHome, "dox", "identity", "home", "tool")); err != nil || !info.IsDir() {
+					t.Errorf("the home directory should be created: %v", err)
+				}
+			},
+		},
+		{
+			name: "ports are dropped on the host network",
+			run: func(t *testing.T, h backendHarness) {
+				h.AddImage("nginx")
+				cfg := &config.CommandConfig{Image: "nginx", Network: "host", Ports: []string{"8080:80"}}
+				execute(h, cfg, "nginx", nil, false, "")
+				if opts, _ := h.LastRun(); len(opts.Ports) != 0 {
+					t.Errorf("Ports = %v, want none on the host network", opts.Ports)
+				}
+			},
+		},
+		{
+			name: "inline image is built once and rebuilt on upgrade",
+			run: func(t *testing.T, h backendHarness) {
+				cfg := func() *config.CommandConfig {
+					return &config.CommandConfig{Build: &config.BuildConfig{DockerfileInline: "FROM alpine\n"}}
+				}
+				execute(h, cfg(), "tool", nil, false, "")
+				execute(h, cfg(), "tool", nil, false, "")
+				if count := h.BuildCount(); count != 1 {
+					t.Errorf("builds = %d after two runs, want 1", count)
+				}
+
+				opts, _ := h.LastRun()
+				if opts.Image != "dox-tool:latest" {
+					t.Errorf("Image = %s, want dox-tool:latest", opts.Image)
+				}
+				if opts.WorkingDir != "" {
+					t.Errorf("WorkingDir = %s, want the Dockerfile's WORKDIR", opts.WorkingDir)
+				}
+
+				execute(h, cfg(), "tool", nil, true, "")
+				if count := h.BuildCount(); count != 2 {
+					t.Errorf("builds = %d after upgrade, want 2", count)
+				}
+			},
+		},
+		{
+			name: "missing image is pulled once",
+			run: func(t *testing.T, h backendHarness) {
+				result := execute(h, &config.CommandConfig{Image: "redis:7"}, "redis", nil, false, "")
+				if result.err != nil {
+					t.Fatalf("ExecuteCommand() error = %v", result.err)
+				}
+				if count := h.PullCount(); count != 1 {
+					t.Errorf("pulls = %d, want 1", count)
+				}
+				execute(h, &config.CommandConfig{Image: "redis:7"}, "redis", nil, false, "")
+				if count := h.PullCount(); count != 1 {
+					t.Errorf("pulls = %d after second run, want the image to be cached", count)
+				}
+			},
+		},
+		{
+			name: "pull failure is an error rather than an exit code",
+			run: func(t *testing.T, h backendHarness) {
+				h.FailPulls("manifest unknown")
+				result := execute(h, &config.CommandConfig{Image: "redis:nonexistent"}, "redis", nil, false, "")
+				if result.err == nil {
+					t.Fatal("ExecuteCommand() should fail when the image can't be pulled")
+				}
+				if result.exitCode != 1 {
+					t.Errorf("exitCode = %d, want 1", result.exitCode)
+				}
+				if _, ok := h.LastRun(); ok {
+					t.Error("no container should run without an image")
+				}
+			},
+		},
+		{
+			name: "explaining touches no images or containers",
+			run: func(t *testing.T, h backendHarness) {
+				explainer, ok := h.Runtime().(Explainer)
+				if !ok {
+					t.Fatalf("%T doesn't implement Explainer", h.Runtime())
+				}
+				h.AddImage("dox-tool:latest")
+				inline := &config.CommandConfig{Build: &config.BuildConfig{DockerfileInline: "FROM alpine\n"}}
+				plan, err := explainer.Explain(context.Background(), inline, "tool", []string{"-v"}, true, strings.NewReader(""), &bytes.Buffer{})
+				if err != nil {
+					t.Fatalf("Explain() error = %v", err)
+				}
+				if plan.RemoveImage != "dox-tool:latest" || plan.BuildTag != "dox-tool:latest" {
+					t.Errorf("plan removes %q and builds %q, want the inline image rebuilt", plan.RemoveImage, plan.BuildTag)
+				}
+
+				plan, err = explainer.Explain(context.Background(), &config.CommandConfig{Image: "redis:7"}, "redis", nil, false, strings.NewReader(""), &bytes.Buffer{})
+				if err != nil {
+					t.Fatalf("Explain() error = %v", err)
+				}
+				if plan.Pull != "redis:7" || plan.Options.Image != "redis:7" {
+					t.Errorf("plan pulls %q and runs %q, want the missing image pulled", plan.Pull, plan.Options.Image)
+				}
+
+				if h.BuildCount() != 0 || h.PullCount() != 0 {
+					t.Errorf("builds = %d and pulls = %d, want none", h.BuildCount(), h.PullCount())
+				}
+				if _, ok := h.LastRun(); ok {
+					t.Error("no container should be created")
+				}
+			},
+		},
+		{
+			name: "resource limits and running out of memory",
+			run: func(t *testing.T, h backendHarness) {
+				h.AddImage("alpine")
+				resources := &config.ResourcesConfig{
+					CPUs:       "1.5",
+					Memory:     "512m",
+					MemorySwap: "1g",
+					PidsLimit:  100,
+					Ulimits:    map[string]string{"nproc": "64", "nofile": "1024:2048"},
+					ShmSize:    "1g",
+				}
+				result := execute(h, &config.CommandConfig{Image: "alpine", Resources: resources}, "alpine", nil, false, "")
+				if result.err != nil || result.exitCode != 0 {
+					t.Fatalf("ExecuteCommand() = %d, %v, want success", result.exitCode, result.err)
+				}
+				opts, _ := h.LastRun()
+				if opts.CPUs != 1.5 || opts.Memory != 512<<20 || opts.MemorySwap != 1<<30 || opts.PidsLimit != 100 || opts.ShmSize != 1<<30 {
+					t.Errorf("limits = %v CPUs, %d memory, %d swap, %d pids and %d shm, want the configured ones", opts.CPUs, opts.Memory, opts.MemorySwap, opts.PidsLimit, opts.ShmSize)
+				}
+				if !reflect.DeepEqual(opts.Ulimits, []string{"nofile=1024:2048", "nproc=64:64"}) {
+					t.Errorf("ulimits = %v, want them sorted by name", opts.Ulimits)
+				}
+
+				h.RunOutOfMemory()
+				limited := execute(h, &config.CommandConfig{Image: "alpine", Resources: &config.ResourcesConfig{Memory: "64m"}}, "alpine", nil, false, "")
+				if limited.err != nil || limited.exitCode != oomExitCode || !limited.oomKilled {
+					t.Errorf("ExecuteCommand() = %d, %v with OOMKilled %v, want exit code %d reported as out of memory", limited.exitCode, limited.err, limited.oomKilled, oomExitCode)
+				}
+				// Without a memory limit, the container is removed on exit as usual.
+				unlimited := execute(h, &config.CommandConfig{Image: "alpine"}, "alpine", nil, false, "")
+				if opts, _ := h.LastRun(); unlimited.exitCode != 0 || !opts.Remove {
+					t.Errorf("exit code = %d and remove = %v, want an unlimited container unaffected", unlimited.exitCode, opts.Remove)
+				}
+			},
+		},
+		{
+			name: "timeouts stop the container with the stop signal",
+			run: func(t *testing.T, h backendHarness) {
+				h.AddImage("alpine")
+				h.RunUntilStopped()
+				cfg := &config.CommandConfig{Image: "alpine", Timeout: "250ms", StopSignal: "int", StopGracePeriod: "1500ms"}
+				result := execute(h, cfg, "alpine", nil, false, "")
+				if result.err != nil || !result.timedOut {
+					t.Fatalf("ExecuteCommand() = %v with TimedOut %v, want a timed out run", result.err, result.timedOut)
+				}
+				if result.exitCode != 130 {
+					t.Errorf("exit code = %d, want 130 from the stop signal", result.exitCode)
+				}
+				// The grace period is rounded up to whole seconds for the runtime.
+				if opts, _ := h.LastRun(); opts.StopSignal != "SIGINT" || opts.StopGracePeriod != 2*time.Second {
+					t.Errorf("stop signal = %q and grace period = %s, want SIGINT and 2s", opts.StopSignal, opts.StopGracePeriod)
+				}
+			},
+		},
+		{
+			name: "security hardening and the strict preset",
+			run: func(t *testing.T, h backendHarness) {
+				h.AddImage("alpine")
+				writable := false
+				security := &config.SecurityConfig{
+					Preset:   config.SecurityPresetStrict,
+					Tmpfs:    []string{"/tmp:size=64m", "/cache"},
+					CapAdd:   []string{"net_bind_service"},
+					Seccomp:  config.SecurityUnconfined,
+					AppArmor: "dox-default",
+				}
+				result := execute(h, &config.CommandConfig{Image: "alpine", Security: security}, "alpine", nil, false, "")
+				if result.err != nil || result.exitCode != 0 {
+					t.Fatalf("ExecuteCommand() = %d, %v, want success", result.exitCode, result.err)
+				}
+				opts, _ := h.LastRun()
+				if !opts.ReadOnly || !reflect.DeepEqual(opts.Tmpfs, []string{"/cache", "/run", "/tmp:size=64m", "/var/tmp"}) {
+					t.Errorf("read-only = %v with tmpfs %v, want a read-only root with the scratch directories", opts.ReadOnly, opts.Tmpfs)
+				}
+				if !reflect.DeepEqual(opts.CapDrop, []string{"ALL"}) || !reflect.DeepEqual(opts.CapAdd, []string{"NET_BIND_SERVICE"}) {
+					t.Errorf("cap_drop = %v and cap_add = %v, want all dropped and one added back", opts.CapDrop, opts.CapAdd)
+				}
+				if expected := []string{"no-new-privileges", "seccomp=unconfined", "apparmor=dox-default"}; !reflect.DeepEqual(opts.SecurityOpt, expected) {
+					t.Errorf("security options = %v, want %v", opts.SecurityOpt, expected)
+				}
+
+				// Settings override the preset.
+				security.ReadOnlyRootfs = &writable
+				execute(h, &config.CommandConfig{Image: "alpine", Security: security}, "alpine", nil, false, "")
+				if opts, _ := h.LastRun(); opts.ReadOnly || !reflect.DeepEqual(opts.Tmpfs, []string{"/cache", "/tmp:size=64m"}) {
+					t.Errorf("read-only = %v with tmpfs %v, want a writable root with the configured tmpfs only", opts.ReadOnly, opts.Tmpfs)
+				}
+
+				// A missing seccomp profile fails before a container is started.
+				before, _ := h.LastRun()
+				missing := &config.SecurityConfig{Seccomp: filepath.Join(t.TempDir(), "missing.json")}
+				failed := execute(h, &config.CommandConfig{Image: "alpine", Security: missing}, "alpine", nil, false, "")
+				var containerErr *ContainerError
+				if !errors.As(failed.err, &containerErr) {
+					t.Errorf("error = %v, want a container error for the missing profile", failed.err)
+				}
+				if after, _ := h.LastRun(); !reflect.DeepEqual(after, before) {
+					t.Error("a container was started despite the missing profile")
+				}
+			},
+		},
+		{
+			name: "copied workspaces with a read-only root filesystem",
+			run: func(t *testing.T, h backendHarness) {
+				dir := t.TempDir()
+				if err := os.WriteFile(filepath.Join(dir, "main.py"), []byte("print('hi')"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chdir(dir); err != nil {
+					t.Fatal(err)
+				}
+				defer os.Chdir(cwd)
+
+				h.AddImage("alpine")
+				cfg := &config.CommandConfig{Image: "alpine", Workspace: config.WorkspaceCopy, Security: &config.SecurityConfig{Preset: config.SecurityPresetStrict}}
+				result := execute(h, cfg, "alpine", nil, false, "")
+				if _, ok := h.Runtime().(*PodmanRuntime); ok {
+					// Podman doesn't copy workspaces at all.
+					if result.err == nil {
+						t.Error("ExecuteCommand() should fail for copied workspaces with podman")
+					}
+					return
+				}
+				if result.err != nil {
+					t.Fatalf("ExecuteCommand() error = %v", result.err)
+				}
+
+				// The workspace is a volume, so it can be copied into and stays writable.
+				opts, _ := h.LastRun()
+				if !opts.ReadOnly || !contains(opts.Volumes, "/workspace") {
+					t.Errorf("read-only = %v with volumes %v, want a read-only root with a /workspace volume", opts.ReadOnly, opts.Volumes)
+				}
+				if content, err := os.ReadFile(filepath.Join(dir, "main.py")); err != nil || string(content) != "print('hi')" {
+					t.Errorf("main.py = %q, %v after syncing back, want it unchanged", content, err)
+				}
+			},
+		},
+		{
+			name: "egress only reaches allowed hosts through dox's proxy",
+			run: func(t *testing.T, h backendHarness) {
+				handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+				allowed, allowedTLS, denied := httptest.NewServer(handler), httptest.NewTLSServer(handler), httptest.NewServer(handler)
+				defer allowed.Close()
+				defer allowedTLS.Close()
+				defer denied.Close()
+				allow := []string{strings.TrimPrefix(allowed.URL, "http://"), strings.TrimPrefix(allowedTLS.URL, "https://")}
+
+				h.AddImage("alpine")
+				h.Fetch(allowed.URL+"/simple/", allowedTLS.URL+"/", denied.URL+"/")
+				cfg := &config.CommandConfig{Image: "alpine", Egress: &config.EgressConfig{Allow: allow}}
+				result := execute(h, cfg, "alpine", nil, false, "")
+				if result.err != nil {
+					t.Fatalf("ExecuteCommand() error = %v", result.err)
+				}
+				expected := fmt.Sprintf("%s/simple/ 200\n%s/ 200\n%s/ 403\n", allowed.URL, allowedTLS.URL, denied.URL)
+				if result.stdout != expected {
+					t.Errorf("stdout = %q, want %q", result.stdout, expected)
+				}
+
+				if internal, ok := h.Networks()[egressNetwork]; !ok || !internal {
+					t.Errorf("networks = %v, want the internal %s network created", h.Networks(), egressNetwork)
+				}
+				opts, _ := h.LastRun()
+				if opts.Network != egressNetwork {
+					t.Errorf("Network = %q, want %q", opts.Network, egressNetwork)
+				}
+				var proxy string
+				for _, entry := range opts.Env {
+					if address, ok := strings.CutPrefix(entry, "HTTPS_PROXY="); ok {
+						proxy = strings.TrimPrefix(address, "http://")
+					}
+				}
+				if proxy == "" {
+					t.Fatalf("Env = %v, want HTTPS_PROXY set", opts.Env)
+				}
+				// The proxy stops along with the command.
+				if conn, err := net.Dial("tcp", proxy); err == nil {
+					conn.Close()
+					t.Errorf("the egress proxy on %s is still running", proxy)
+				}
+
+				// Later runs reuse the network.
+				if result := execute(h, cfg, "alpine", nil, false, ""); result.err != nil {
+					t.Errorf("second run error = %v, want the network reused", result.err)
+				}
+			},
+		},
+		{
+			name: "host agents and git credentials are forwarded through sockets",
+			run: func(t *testing.T, h backendHarness) {
+				agents, err := os.MkdirTemp("", "dox")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(agents)
+				t.Setenv("SSH_AUTH_SOCK", startEchoAgent(t, agents, "ssh.sock"))
+
+				h.AddImage("alpine")
+				cfg := &config.CommandConfig{Image: "alpine", Forward: []string{config.ForwardSSHAgent, config.ForwardGitCredentials}}
+				if result := execute(h, cfg, "alpine", nil, false, ""); result.err != nil {
+					t.Fatalf("ExecuteCommand() error = %v", result.err)
+				}
+
+				opts, _ := h.LastRun()
+				for _, expected := range []string{"SSH_AUTH_SOCK=/run/dox/ssh-agent.sock", "GIT_CONFIG_KEY_0=credential.helper", "GIT_CONFIG_VALUE_0=cache --socket=/run/dox/git-credentials.sock"} {
+					if !contains(opts.Env, expected) {
+						t.Errorf("Env = %v, want %s", opts.Env, expected)
+					}
+				}
+				// The sockets only exist while the command runs.
+				if dir := mountSource(t, opts.Volumes, forwardDir); dir != "" {
+					if _, err := os.Stat(dir); !os.IsNotExist(err) {
+						t.Errorf("the forwarded sockets in %s should be removed, stat error = %v", dir, err)
+					}
+				}
+			},
+		},
+		{
+			name: "GUI applications get the host's display sockets and their own cookie",
+			run: func(t *testing.T, h backendHarness) {
+				dir := fakeDisplays(t)
+				h.AddImage("alpine")
+				cfg := &config.CommandConfig{Image: "alpine", GUI: config.GUIAuto}
+				if result := execute(h, cfg, "alpine", nil, false, ""); result.err != nil {
+					t.Fatalf("ExecuteCommand() error = %v", result.err)
+				}
+
+				opts, _ := h.LastRun()
+				for _, expected := range []string{filepath.Join(x11SocketDir, "X7") + ":/tmp/.X11-unix/X7", filepath.Join(dir, "wayland-1") + ":/run/dox-gui/wayland-0"} {
+					if !contains(opts.Volumes, expected) {
+						t.Errorf("Volumes = %v, want %s", opts.Volumes, expected)
+					}
+				}
+				for _, expected := range []string{"DISPLAY=:7.0", "XAUTHORITY=/run/dox-gui/Xauthority", "WAYLAND_DISPLAY=wayland-0"} {
+					if !contains(opts.Env, expected) {
+						t.Errorf("Env = %v, want %s", opts.Env, expected)
+					}
+				}
+				// The cookie only exists while the command runs.
+				if private := mountSource(t, opts.Volumes, guiDir); private != "" {
+					if _, err := os.Stat(private); !os.IsNotExist(err) {
+						t.Errorf("the cookie in %s should be removed, stat error = %v", private, err)
+					}
+				}
+			},
+		},
+		{
+			name: "devices and the runtime's socket are passed through with a warning",
+			run: func(t *testing.T, h backendHarness) {
+				socket := h.EngineSocket()
+				info, err := os.Stat(socket)
+				if err != nil {
+					t.Fatal(err)
+				}
+				hook := logtest.NewGlobal()
+				defer hook.Reset()
+
+				h.AddImage("alpine")
+				cfg := &config.CommandConfig{Image: "alpine", Devices: []string{"/dev/fuse", "/dev/ttyUSB0:/dev/ttyS0:rw"}, EngineSocket: true}
+				if result := execute(h, cfg, "alpine", nil, false, ""); result.err != nil {
+					t.Fatalf("ExecuteCommand() error = %v", result.err)
+				}
+
+				opts, _ := h.LastRun()
+				if expected := []string{"/dev/fuse:/dev/fuse:rwm", "/dev/ttyUSB0:/dev/ttyS0:rw"}; !reflect.DeepEqual(opts.Devices, expected) {
+					t.Errorf("Devices = %v, want %v", opts.Devices, expected)
+				}
+				if !contains(opts.Volumes, socket+":/var/run/docker.sock") || !contains(opts.Env, "DOCKER_HOST=unix:///var/run/docker.sock") {
+					t.Errorf("Volumes = %v and Env = %v, want the socket mounted at /var/run/docker.sock", opts.Volumes, opts.Env)
+				}
+				if gid, ok := fileGroup(info); ok && !contains(opts.GroupAdd, strconv.Itoa(gid)) {
+					t.Errorf("GroupAdd = %v, want the socket's group %d", opts.GroupAdd, gid)
+				}
+
+				var warned bool
+				for _, entry := range hook.AllEntries() {
+					warned = warned || (entry.Level == logrus.WarnLevel && strings.Contains(entry.Message, "root access"))
+				}
+				if !warned {
+					t.Error("engine access should come with a warning that it is root-equivalent")
+				}
+			},
+		},
+	}
+
+	for _, backend := range conformanceBackends {
+		for _, tt := range tests {
+			t.Run(backend.name+"/"+tt.name, func(t *testing.T) {
+				tt.run(t, backend.setup(t))
+			})
+		}
+	}
+}
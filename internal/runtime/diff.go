@@ -0,0 +1,170 @@
+package runtime
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// diffContext is how many unchanged lines surround the changes in a hunk.
+const diffContext = 3
+
+// diffLine is a line of an edit script: unchanged, deleted or inserted.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// writeUnifiedDiff writes the differences between two texts in the unified
+// format of diff -u, labelling them with from and to.
+func writeUnifiedDiff(w io.Writer, from, to, before, after string) error {
+	script := diffLines(splitLines(before), splitLines(after))
+
+	// oldLines and newLines count the lines of each text before each edit.
+	oldLines := make([]int, len(script)+1)
+	newLines := make([]int, len(script)+1)
+	for i, line := range script {
+		oldLines[i+1], newLines[i+1] = oldLines[i], newLines[i]
+		if line.op != '+' {
+			oldLines[i+1]++
+		}
+		if line.op != '-' {
+			newLines[i+1]++
+		}
+	}
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", from, to)
+	for start := 0; start < len(script); {
+		// Find the next change, and extend its hunk over every change that is
+		// close enough for their context to touch.
+		first := start
+		for first < len(script) && script[first].op == ' ' {
+			first++
+		}
+		if first == len(script) {
+			break
+		}
+		last := first
+		for i := first; i < len(script) && i <= last+2*diffContext+1; i++ {
+			if script[i].op != ' ' {
+				last = i
+			}
+		}
+		begin := max(first-diffContext, start)
+		end := min(last+diffContext+1, len(script))
+
+		fmt.Fprintf(out, "@@ -%s +%s @@\n",
+			hunkRange(oldLines[begin], oldLines[end]-oldLines[begin]),
+			hunkRange(newLines[begin], newLines[end]-newLines[begin]))
+		for _, line := range script[begin:end] {
+			out.WriteByte(line.op)
+			out.WriteString(line.text)
+			if !strings.HasSuffix(line.text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = end
+	}
+	return out.Flush()
+}
+
+// hunkRange formats the lines a hunk covers after skipping, in a hunk header.
+// Empty ranges start at the line before them.
+func hunkRange(skipped, length int) string {
+	switch length {
+	case 0:
+		return fmt.Sprintf("%d,0", skipped)
+	case 1:
+		return fmt.Sprintf("%d", skipped+1)
+	}
+	return fmt.Sprintf("%d,%d", skipped+1, length)
+}
+
+// splitLines splits text into lines that keep their newlines, so a last line
+// without one differs from the same line with one.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the shortest edit script that turns a into b, using Myers'
+// algorithm. It only keeps the diagonals each step reached, so its memory
+// grows with the square of the number of edits rather than the size of the
+// texts.
+func diffLines(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	// v holds the furthest x reached on each diagonal k = x - y, at v[offset+k].
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+	for d := 0; d <= n+m; d++ {
+		// trace[d] holds the diagonals -d-1 to d+1 before step d.
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackDiff(a, b, trace)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrackDiff follows the steps in the trace of diffLines back from the end
+// of both texts to build the edit script.
+func backtrackDiff(a, b []string, trace [][]int) []diffLine {
+	var script []diffLine
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+		k := x - y
+		var previous int
+		if k == -d || k != d && at(k-1) < at(k+1) {
+			previous = k + 1
+		} else {
+			previous = k - 1
+		}
+		previousX := at(previous)
+		previousY := previousX - previous
+		if d == 0 {
+			previousX, previousY = 0, 0
+		}
+		for x > previousX && y > previousY {
+			script = append(script, diffLine{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d == 0 {
+			break
+		}
+		if x == previousX {
+			script = append(script, diffLine{'+', b[y-1]})
+		} else {
+			script = append(script, diffLine{'-', a[x-1]})
+		}
+		x, y = previousX, previousY
+	}
+
+	for i, j := 0, len(script)-1; i < j; i, j = i+1, j-1 {
+		script[i], script[j] = script[j], script[i]
+	}
+	return script
+}
@@ -0,0 +1,78 @@
+package runtime
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWriteUnifiedDiff(t *testing.T) {
+	numbered := func(from, to int, replace map[int]string) string {
+		var lines []string
+		for i := from; i <= to; i++ {
+			line, ok := replace[i]
+			if !ok {
+				line = strconv.Itoa(i)
+			}
+			if line != "" {
+				lines = append(lines, line+"\n")
+			}
+		}
+		return strings.Join(lines, "")
+	}
+
+	tests := []struct {
+		name     string
+		before   string
+		after    string
+		expected string
+	}{
+		{
+			name:     "identical",
+			before:   "a\nb\n",
+			after:    "a\nb\n",
+			expected: "",
+		},
+		{
+			name:     "created",
+			after:    "a\nb\n",
+			expected: "@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:     "emptied",
+			before:   "a\n",
+			expected: "@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name:     "missing newline",
+			before:   "a\nb",
+			after:    "a\nb\n",
+			expected: "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name:     "distant changes are separate hunks",
+			before:   numbered(1, 20, nil),
+			after:    numbered(1, 20, map[int]string{2: "two", 18: ""}),
+			expected: "@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n@@ -15,6 +15,5 @@\n 15\n 16\n 17\n-18\n 19\n 20\n",
+		},
+		{
+			name:     "close changes share a hunk",
+			before:   numbered(1, 9, nil),
+			after:    numbered(1, 9, map[int]string{1: "one", 8: "eight"}),
+			expected: "@@ -1,9 +1,9 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n 9\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			if err := writeUnifiedDiff(&out, "a/file", "b/file", tt.before, tt.after); err != nil {
+				t.Fatalf("writeUnifiedDiff() error = %v", err)
+			}
+			expected := "--- a/file\n+++ b/file\n" + tt.expected
+			if out.String() != expected {
+				t.Errorf("writeUnifiedDiff() =\n%s\nwant\n%s", out.String(), expected)
+			}
+		})
+	}
+}
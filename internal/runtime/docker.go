@@ -5,16 +5,21 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/go-connections/nat"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/sirupsen/logrus"
@@ -29,9 +34,29 @@ type DockerRuntime struct {
 
 // NewDockerRuntime creates a new Docker runtime.
 func NewDockerRuntime() (*DockerRuntime, error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	return NewDockerRuntimeForHost("")
+}
+
+// NewDockerRuntimeForHost creates a new Docker runtime connected to a specific daemon.
+// An empty host falls back to DOCKER_HOST and then to the active Docker context.
+func NewDockerRuntimeForHost(host string) (*DockerRuntime, error) {
+	endpoint, err := resolveDockerEndpoint(host)
+	if err != nil {
+		return nil, &UnavailableError{Err: err}
+	}
+
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if endpoint != nil {
+		endpointOpts, err := endpoint.clientOpts()
+		if err != nil {
+			return nil, &UnavailableError{Err: err}
+		}
+		opts = append(opts, endpointOpts...)
+	}
+
+	cli, err := client.NewClientWithOpts(opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Docker client: %w", err)
+		return nil, &UnavailableError{Err: fmt.Errorf("failed to create Docker client: %w", err)}
 	}
 	
 	return &DockerRuntime{
@@ -43,101 +68,97 @@ func NewDockerRuntime() (*DockerRuntime, error) {
 func (r *DockerRuntime) IsAvailable(ctx context.Context) error {
 	_, err := r.client.Ping(ctx)
 	if err != nil {
-		return fmt.Errorf("Docker daemon not responding. Is Docker running?")
+		return &UnavailableError{Err: fmt.Errorf("Docker daemon not responding. Is Docker running?")}
 	}
 	return nil
 }
 
 // ExecuteCommand runs a command in a Docker container.
-func (r *DockerRuntime) ExecuteCommand(ctx context.Context, cfg *config.CommandConfig, command string, args []string, upgrade bool, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
-	// Build image if inline Dockerfile is provided.
-	if cfg.Build != nil && cfg.Build.DockerfileInline != "" {
-		imageName := fmt.Sprintf("dox-%s:latest", command)
-		
-		// Check if image already exists.
-		_, _, err := r.client.ImageInspectWithRaw(ctx, imageName)
-		imageExists := err == nil
-		
-		if upgrade && imageExists {
-			// Remove the existing image to force rebuild.
-			logrus.Infof("Removing existing image %s for rebuild...", imageName)
-			if err := r.RemoveImage(ctx, imageName); err != nil {
-				logrus.Warnf("Failed to remove existing image: %v", err)
-			}
-			imageExists = false
-		}
-		
-		if !imageExists {
-			// Image doesn't exist or was removed, build it.
-			logrus.Infof("Building image %s from inline Dockerfile...", imageName)
-			if err := r.BuildImage(ctx, cfg.Build.DockerfileInline, imageName); err != nil {
-				return 1, err
-			}
-		}
-		cfg.Image = imageName
+func (r *DockerRuntime) ExecuteCommand(ctx context.Context, cfg *config.CommandConfig, command string, args []string, upgrade bool, stdin io.Reader, stdout, stderr io.Writer) (ExecResult, error) {
+	if err := unsupported("docker", cfg); err != nil {
+		return ExecResult{ExitCode: 1}, err
 	}
-
-	// Prepare command.
-	var cmd []string
-	if cfg.Command != "" {
-		cmd = append([]string{cfg.Command}, args...)
-	} else if len(args) > 0 {
-		// Only pass args if provided, let container use its default ENTRYPOINT/CMD.
-		cmd = args
+	if keepAlive(cfg) > 0 {
+		return r.executeWarm(ctx, cfg, command, args, upgrade, stdin, stdout, stderr)
 	}
 
-	// Get current user UID and GID.
-	uid := os.Getuid()
-	gid := os.Getgid()
-	user := fmt.Sprintf("%d:%d", uid, gid)
-
-	// Prepare environment variables.
-	var env []string
-	for _, envVar := range cfg.Environment {
-		if value := os.Getenv(envVar); value != "" {
-			env = append(env, fmt.Sprintf("%s=%s", envVar, value))
-		}
+	timer := newPhaseTimer()
+	image, err := resolveImage(ctx, r, cfg, command, upgrade)
+	if err != nil {
+		return ExecResult{ExitCode: 1}, err
 	}
+	timer.mark(PhaseImage)
 
-	// Prepare volume mounts - always mount current directory.
-	cwd, _ := os.Getwd()
-	volumes := []string{fmt.Sprintf("%s:/workspace", cwd)}
-	volumes = append(volumes, cfg.Volumes...)
+	opts := newContainerOptions(cfg, command, image, args, isTerminal(stdin, stdout))
+	if err := prepareIdentity(ctx, r, cfg, command, image); err != nil {
+		return ExecResult{ExitCode: 1}, err
+	}
+	if _, err := seccompProfile(opts); err != nil {
+		return ExecResult{ExitCode: 1}, err
+	}
+	if err := mountEngineSocket(ctx, r, &opts); err != nil {
+		return ExecResult{ExitCode: 1}, err
+	}
+	stopEgress, err := startEgress(ctx, r, &opts)
+	if err != nil {
+		return ExecResult{ExitCode: 1}, err
+	}
+	defer stopEgress()
+	stopForward, err := startForward(&opts)
+	if err != nil {
+		return ExecResult{ExitCode: 1}, err
+	}
+	defer stopForward()
+	stopGUI, err := startGUI(&opts)
+	if err != nil {
+		return ExecResult{ExitCode: 1}, err
+	}
+	defer stopGUI()
 
 	// Create container.
 	containerConfig := &container.Config{
-		Image:        cfg.Image,
-		Cmd:          cmd,
-		Env:          env,
-		User:         user,
-		AttachStdin:  true,
+		Image:        opts.Image,
+		Cmd:          opts.Command,
+		Entrypoint:   entrypoint(opts.Entrypoint),
+		Env:          opts.Env,
+		User:         opts.User,
+		WorkingDir:   opts.WorkingDir,
+		AttachStdin:  opts.Interactive,
 		AttachStdout: true,
 		AttachStderr: true,
-		OpenStdin:    true,
-		Tty:          isTerminal(),
+		OpenStdin:    opts.Interactive,
+		Tty:          opts.TTY,
+		StopSignal:   opts.StopSignal,
 	}
-
-	// Only set working directory if no inline Dockerfile is provided.
-	// When using inline Dockerfile, let the WORKDIR instruction in the Dockerfile take precedence.
-	if cfg.Build == nil || cfg.Build.DockerfileInline == "" {
-		containerConfig.WorkingDir = "/workspace"
+	if opts.StopGracePeriod > 0 {
+		stopTimeout := stopTimeout(opts)
+		containerConfig.StopTimeout = &stopTimeout
 	}
 
+	binds, volumes := dockerVolumes(opts.Volumes)
+	containerConfig.Volumes = volumes
 	hostConfig := &container.HostConfig{
-		AutoRemove:  true,
-		Binds:       volumes,
+		AutoRemove: opts.Remove && !keepForInspection(opts),
+		Binds:      binds,
+		UsernsMode: container.UsernsMode(opts.UserNS),
+		GroupAdd:   opts.GroupAdd,
+		Resources:  dockerResources(opts),
+		ShmSize:    opts.ShmSize,
+	}
+	if err := applyDockerSecurity(hostConfig, opts); err != nil {
+		return ExecResult{ExitCode: 1}, err
 	}
 
 	// Set network mode if specified.
-	if cfg.Network != "" {
-		hostConfig.NetworkMode = container.NetworkMode(cfg.Network)
+	if opts.Network != "" {
+		hostConfig.NetworkMode = container.NetworkMode(opts.Network)
 	}
 
 	// Configure port bindings if specified.
-	if len(cfg.Ports) > 0 && cfg.Network != "host" {
-		portBindings, exposedPorts, err := parsePortMappings(cfg.Ports)
+	if len(opts.Ports) > 0 {
+		portBindings, exposedPorts, err := parsePortMappings(opts.Ports)
 		if err != nil {
-			return 1, fmt.Errorf("failed to parse port mappings: %w", err)
+			return ExecResult{ExitCode: 1}, &ContainerError{Err: fmt.Errorf("failed to parse port mappings: %w", err)}
 		}
 		hostConfig.PortBindings = portBindings
 		containerConfig.ExposedPorts = exposedPorts
@@ -145,38 +166,54 @@ func (r *DockerRuntime) ExecuteCommand(ctx context.Context, cfg *config.CommandC
 
 	// Set terminal size if TTY is enabled
 	if containerConfig.Tty {
-		width, height := utils.GetTerminalSize()
+		width, height := utils.GetTerminalSize(stdout.(*os.File))
 		hostConfig.ConsoleSize = [2]uint{uint(height), uint(width)}
 	}
 
 	networkConfig := &network.NetworkingConfig{}
 
-	// Force pull if upgrade flag is set and it's not a locally built image.
-	if upgrade && (cfg.Build == nil || cfg.Build.DockerfileInline == "") {
-		logrus.Infof("Pulling latest version of image %s...", cfg.Image)
-		if pullErr := r.PullImage(ctx, cfg.Image); pullErr != nil {
-			logrus.Warnf("Failed to pull latest image: %v. Using existing image if available.", pullErr)
-		}
-	}
-	
 	resp, err := r.client.ContainerCreate(ctx, containerConfig, hostConfig, networkConfig, nil, "")
 	if err != nil {
-		// Try to pull the image if it doesn't exist locally.
+		// Pull or build the image if it doesn't exist locally.
 		if strings.Contains(err.Error(), "No such image") {
-			logrus.Infof("Pulling image %s...", cfg.Image)
-			if pullErr := r.PullImage(ctx, cfg.Image); pullErr != nil {
-				return 1, fmt.Errorf("failed to pull image: %w", pullErr)
+			if err := provideImage(ctx, r, cfg, opts.Image); err != nil {
+				return ExecResult{ExitCode: 1}, err
 			}
 			// Retry container creation.
 			resp, err = r.client.ContainerCreate(ctx, containerConfig, hostConfig, networkConfig, nil, "")
 			if err != nil {
-				return 1, fmt.Errorf("failed to create container: %w", err)
+				return ExecResult{ExitCode: 1}, &ContainerError{Err: fmt.Errorf("failed to create container: %w", err)}
 			}
 		} else {
-			return 1, fmt.Errorf("failed to create container: %w", err)
+			return ExecResult{ExitCode: 1}, &ContainerError{Err: fmt.Errorf("failed to create container: %w", err)}
 		}
 	}
 
+	timer.mark(PhaseCreate)
+	result := ExecResult{ExitCode: 1, ContainerID: resp.ID}
+	// Containers that aren't removed on exit are removed once dox is done with
+	// them: after checking whether they ran out of memory, or after syncing
+	// their copy of the workspace back.
+	if keepForInspection(opts) || cfg.Workspace == config.WorkspaceCopy {
+		defer r.removeContainer(resp.ID)
+	}
+
+	// Copy the workspace in and make sure it is synced back afterwards.
+	if cfg.Workspace == config.WorkspaceCopy {
+		cwd, _ := os.Getwd()
+		uid, gid := workspaceOwner(opts.User)
+		_, volume := volumes[workspaceDir]
+		snapshot, err := r.copyWorkspaceIn(ctx, resp.ID, cwd, cfg.WorkspaceIgnore, uid, gid, volume)
+		if err != nil {
+			return result, &ContainerError{Err: err}
+		}
+		defer func() {
+			if err := r.copyWorkspaceOut(resp.ID, cwd, snapshot, cfg.WorkspaceIgnore); err != nil {
+				logrus.Errorf("Failed to sync workspace back: %v", err)
+			}
+		}()
+	}
+
 	// Attach to container.
 	attachOptions := types.ContainerAttachOptions{
 		Stream: true,
@@ -187,14 +224,23 @@ func (r *DockerRuntime) ExecuteCommand(ctx context.Context, cfg *config.CommandC
 
 	hijackedResp, err := r.client.ContainerAttach(ctx, resp.ID, attachOptions)
 	if err != nil {
-		return 1, fmt.Errorf("failed to attach to container: %w", err)
+		return result, &ContainerError{Err: fmt.Errorf("failed to attach to container: %w", err)}
 	}
 	defer hijackedResp.Close()
 
+	// Wait for the container before starting it, so a fast exit isn't missed once
+	// auto-remove has deleted the container.
+	waitCondition := container.WaitConditionNextExit
+	if hostConfig.AutoRemove {
+		waitCondition = container.WaitConditionRemoved
+	}
+	statusCh, errCh := r.client.ContainerWait(ctx, resp.ID, waitCondition)
+
 	// Start container.
 	if err := r.client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
-		return 1, fmt.Errorf("failed to start container: %w", err)
+		return result, &ContainerError{Err: fmt.Errorf("failed to start container: %w", err)}
 	}
+	timer.mark(PhaseStart)
 
 	// Setup terminal raw mode for TTY.
 	if containerConfig.Tty {
@@ -202,50 +248,67 @@ func (r *DockerRuntime) ExecuteCommand(ctx context.Context, cfg *config.CommandC
 		defer utils.RestoreTerminal(oldTermState)
 	}
 
-	// Setup signal forwarding.
-	utils.SetupSignalHandler(ctx, r.client, resp.ID)
-	defer utils.CleanupSignalHandler()
-
-	// Handle I/O.
-	errChan := make(chan error, 2)
+	// Setup signal forwarding, and keep the TTY size in sync with the terminal.
+	var terminal *os.File
+	if containerConfig.Tty {
+		terminal = stdout.(*os.File)
+	}
+	forwarder := newSignalForwarder(&dockerSignalTarget{client: r.client, containerID: resp.ID}, terminal, opts)
+	forwarder.Start()
+	defer forwarder.Stop()
 
 	// Copy stdin to container.
 	go func() {
 		defer hijackedResp.CloseWrite()
 		if stdin != nil {
-			_, err := io.Copy(hijackedResp.Conn, stdin)
-			errChan <- err
-		} else {
-			errChan <- nil
+			_, _ = io.Copy(hijackedResp.Conn, stdin)
 		}
 	}()
 
 	// Copy container output to stdout/stderr.
+	outputDone := make(chan struct{})
 	go func() {
+		defer close(outputDone)
 		if containerConfig.Tty {
-			_, err := io.Copy(stdout, hijackedResp.Reader)
-			errChan <- err
+			_, _ = io.Copy(stdout, hijackedResp.Reader)
 		} else {
-			_, err := stdcopy.StdCopy(stdout, stderr, hijackedResp.Reader)
-			errChan <- err
+			_, _ = stdcopy.StdCopy(stdout, stderr, hijackedResp.Reader)
 		}
 	}()
 
 	// Wait for container to exit.
-	statusCh, errCh := r.client.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
-
 	select {
 	case err := <-errCh:
-		if err != nil {
-			return 1, fmt.Errorf("error waiting for container: %w", err)
-		}
+		return result, &ContainerError{Err: fmt.Errorf("error waiting for container: %w", err)}
 	case status := <-statusCh:
-		return int(status.StatusCode), nil
+		// The daemon closes the stream on exit; drain it so no trailing output is lost.
+		<-outputDone
+		result.ExitCode = int(status.StatusCode)
+		result.TimedOut = forwarder.TimedOut()
+		if result.ExitCode == oomExitCode && opts.Memory > 0 {
+			result.OOMKilled = r.oomKilled(resp.ID)
+		}
+		timer.mark(PhaseRun)
+		result.Phases = timer.phases
+		return result, nil
 	case <-ctx.Done():
-		return 1, ctx.Err()
+		// Don't leave the container running when the caller gives up on it. Copied
+		// workspaces are still synced back and removed by the deferred cleanup.
+		if hostConfig.AutoRemove {
+			r.removeContainer(resp.ID)
+		} else if err := r.client.ContainerKill(context.Background(), resp.ID, "KILL"); err != nil {
+			logrus.Debugf("Failed to kill container %s: %v", resp.ID, err)
+		}
+		return result, ctx.Err()
 	}
+}
 
-	return 0, nil
+// Explain works out what ExecuteCommand would do, without doing it.
+func (r *DockerRuntime) Explain(ctx context.Context, cfg *config.CommandConfig, command string, args []string, upgrade bool, stdin io.Reader, stdout io.Writer) (*Plan, error) {
+	exists := func(image string) bool {
+		return r.imageExists(ctx, image)
+	}
+	return NewPlan("docker", exists, cfg, command, args, upgrade, isTerminal(stdin, stdout))
 }
 
 // PullImage pulls a Docker image.
@@ -256,7 +319,7 @@ func (r *DockerRuntime) PullImage(ctx context.Context, imageName string) error {
 	}
 	defer reader.Close()
 
-	// Stream the pull output to stdout while reading it.
+	// Stream the pull output to stderr while reading it, so piped output stays clean.
 	decoder := json.NewDecoder(reader)
 	for {
 		var msg map[string]interface{}
@@ -270,11 +333,11 @@ func (r *DockerRuntime) PullImage(ctx context.Context, imageName string) error {
 		// Display pull progress.
 		if status, ok := msg["status"].(string); ok {
 			if progress, ok := msg["progress"].(string); ok && progress != "" {
-				fmt.Fprintf(os.Stdout, "%s: %s\r", status, progress)
+				fmt.Fprintf(os.Stderr, "%s: %s\r", status, progress)
 			} else if id, ok := msg["id"].(string); ok && id != "" {
-				fmt.Fprintf(os.Stdout, "%s: %s\n", id, status)
+				fmt.Fprintf(os.Stderr, "%s: %s\n", id, status)
 			} else {
-				fmt.Fprintln(os.Stdout, status)
+				fmt.Fprintln(os.Stderr, status)
 			}
 		}
 
@@ -323,7 +386,7 @@ func (r *DockerRuntime) BuildImage(ctx context.Context, dockerfileContent string
 	}
 	defer buildResp.Body.Close()
 
-	// Stream build output to stdout while checking for errors.
+	// Stream build output to stderr while checking for errors, so piped output stays clean.
 	decoder := json.NewDecoder(buildResp.Body)
 	for {
 		var msg map[string]interface{}
@@ -336,13 +399,13 @@ func (r *DockerRuntime) BuildImage(ctx context.Context, dockerfileContent string
 
 		// Display build output stream.
 		if stream, ok := msg["stream"].(string); ok && stream != "" {
-			fmt.Fprint(os.Stdout, stream)
+			fmt.Fprint(os.Stderr, stream)
 		}
 
 		// Display aux messages (like image IDs).
 		if aux, ok := msg["aux"].(map[string]interface{}); ok {
 			if id, ok := aux["ID"].(string); ok {
-				fmt.Fprintf(os.Stdout, "Successfully built %s\n", id)
+				fmt.Fprintf(os.Stderr, "Successfully built %s\n", id)
 			}
 		}
 
@@ -359,6 +422,12 @@ func (r *DockerRuntime) BuildImage(ctx context.Context, dockerfileContent string
 	return nil
 }
 
+// imageExists checks whether an image is available locally.
+func (r *DockerRuntime) imageExists(ctx context.Context, image string) bool {
+	_, _, err := r.client.ImageInspectWithRaw(ctx, image)
+	return err == nil
+}
+
 // ListImages lists Docker images.
 func (r *DockerRuntime) ListImages(ctx context.Context) ([]string, error) {
 	images, err := r.client.ImageList(ctx, types.ImageListOptions{})
@@ -373,8 +442,10 @@ func (r *DockerRuntime) ListImages(ctx context.Context) ([]string, error) {
 	return imageNames, nil
 }
 
-// RemoveUnusedContainers removes stopped containers.
+// RemoveUnusedContainers removes stopped containers and idle warm containers.
 func (r *DockerRuntime) RemoveUnusedContainers(ctx context.Context) error {
+	defer reapIdle(ctx, r, "")
+
 	// List all stopped containers.
 	filterArgs := filters.NewArgs()
 	filterArgs.Add("status", "exited")
@@ -409,13 +480,358 @@ func (r *DockerRuntime) RemoveImage(ctx context.Context, image string) error {
 	return nil
 }
 
-// isTerminal checks if both stdin and stdout are terminals.
-func isTerminal() bool {
-	stdinInfo, _ := os.Stdin.Stat()
-	stdoutInfo, _ := os.Stdout.Stat()
-	// Both stdin and stdout should be terminals for interactive mode.
-	return (stdinInfo.Mode()&os.ModeCharDevice) != 0 &&
-		(stdoutInfo.Mode()&os.ModeCharDevice) != 0
+// copyWorkspaceIn uploads the working directory into the container's workspace.
+// A workspace that is a volume already exists and is copied into directly,
+// because a read-only root filesystem only accepts copies into volumes.
+// Otherwise, the workspace is created by extracting it at the root.
+func (r *DockerRuntime) copyWorkspaceIn(ctx context.Context, containerID, dir string, ignore []string, uid, gid int, volume bool) (workspaceSnapshot, error) {
+	destination, prefix := "/", strings.TrimPrefix(workspaceDir, "/")
+	if volume {
+		destination, prefix = workspaceDir, "."
+	}
+	archive, wait := streamWorkspace(dir, prefix, ignore, uid, gid)
+	copyErr := r.client.CopyToContainer(ctx, containerID, destination, archive, types.CopyToContainerOptions{})
+	snapshot, err := wait()
+	// A failed archive makes the copy fail too, so report why it failed.
+	if err != nil {
+		return nil, err
+	}
+	if copyErr != nil {
+		return nil, fmt.Errorf("failed to copy workspace into container: %w", copyErr)
+	}
+
+	return snapshot, nil
+}
+
+// copyWorkspaceOut syncs the container's workspace back to the working directory.
+func (r *DockerRuntime) copyWorkspaceOut(containerID, dir string, snapshot workspaceSnapshot, ignore []string) error {
+	// Use a fresh context so the sync still happens if the run was interrupted.
+	reader, _, err := r.client.CopyFromContainer(context.Background(), containerID, workspaceDir)
+	if err != nil {
+		return fmt.Errorf("failed to copy workspace from container: %w", err)
+	}
+	defer reader.Close()
+
+	return syncWorkspace(dir, strings.TrimPrefix(workspaceDir, "/"), reader, snapshot, ignore)
+}
+
+// executeWarm runs a command in its warm container, which is started if it
+// isn't running or was started with different settings.
+func (r *DockerRuntime) executeWarm(ctx context.Context, cfg *config.CommandConfig, command string, args []string, upgrade bool, stdin io.Reader, stdout, stderr io.Writer) (ExecResult, error) {
+	result := ExecResult{ExitCode: 1}
+
+	timer := newPhaseTimer()
+	image, err := resolveImage(ctx, r, cfg, command, upgrade)
+	if err != nil {
+		return result, err
+	}
+	imageInfo, _, err := r.client.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		if err := provideImage(ctx, r, cfg, image); err != nil {
+			return result, err
+		}
+		if imageInfo, _, err = r.client.ImageInspectWithRaw(ctx, image); err != nil {
+			return result, &ImageError{Image: image, Err: fmt.Errorf("failed to inspect image: %w", err)}
+		}
+	}
+
+	opts := newContainerOptions(cfg, command, image, args, isTerminal(stdin, stdout))
+	if err := prepareIdentity(ctx, r, cfg, command, image); err != nil {
+		return result, err
+	}
+	if _, err := seccompProfile(opts); err != nil {
+		return result, err
+	}
+	if err := mountEngineSocket(ctx, r, &opts); err != nil {
+		return result, err
+	}
+	var entrypoint, cmd []string
+	if imageInfo.Config != nil {
+		entrypoint, cmd = imageInfo.Config.Entrypoint, imageInfo.Config.Cmd
+	}
+	// Warm containers can't gain mounts, so path arguments that need them are
+	// run in a container of their own.
+	if len(opts.HostPaths) > 0 {
+		return r.ExecuteCommand(ctx, withoutKeepAlive(cfg), command, args, false, stdin, stdout, stderr)
+	}
+	warm := newWarmContainer(cfg, command, opts, imageInfo.ID, entrypoint, cmd)
+
+	timer.mark(PhaseImage)
+
+	containerID, err := r.startWarm(ctx, warm, upgrade)
+	if errors.Is(err, errNotIdling) {
+		logrus.Warnf("Running %s without a warm container: %v", command, err)
+		return r.ExecuteCommand(ctx, withoutKeepAlive(cfg), command, args, false, stdin, stdout, stderr)
+	}
+	if err != nil {
+		return result, err
+	}
+	result.ContainerID = containerID
+	timer.mark(PhaseContainer)
+	touchWarm(warm.name)
+	defer func() {
+		touchWarm(warm.name)
+		reapIdle(context.Background(), r, warm.name)
+	}()
+
+	execConfig := types.ExecConfig{
+		User:         opts.User,
+		Tty:          opts.TTY,
+		AttachStdin:  opts.Interactive,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          opts.Env,
+		WorkingDir:   opts.WorkingDir,
+		Cmd:          warm.execCommand(opts),
+	}
+	startCheck := types.ExecStartCheck{Tty: opts.TTY}
+	if opts.TTY {
+		width, height := utils.GetTerminalSize(stdout.(*os.File))
+		execConfig.ConsoleSize = &[2]uint{uint(height), uint(width)}
+		startCheck.ConsoleSize = execConfig.ConsoleSize
+	}
+	execResp, err := r.client.ContainerExecCreate(ctx, containerID, execConfig)
+	if err != nil {
+		return result, &ContainerError{Err: fmt.Errorf("failed to create exec: %w", err)}
+	}
+	hijackedResp, err := r.client.ContainerExecAttach(ctx, execResp.ID, startCheck)
+	if err != nil {
+		return result, &ContainerError{Err: fmt.Errorf("failed to start exec: %w", err)}
+	}
+	defer hijackedResp.Close()
+
+	if opts.TTY {
+		oldTermState, _ := utils.SetupTerminal()
+		defer utils.RestoreTerminal(oldTermState)
+	}
+
+	// Executions can't be signaled on their own, so signals go to the warm
+	// container, which ends it. Resizes go to the execution's TTY.
+	var terminal *os.File
+	if opts.TTY {
+		terminal = stdout.(*os.File)
+	}
+	target := &dockerExecSignalTarget{dockerSignalTarget: dockerSignalTarget{client: r.client, containerID: containerID}, execID: execResp.ID}
+	forwarder := newSignalForwarder(target, terminal, opts)
+	forwarder.Start()
+	defer forwarder.Stop()
+
+	go func() {
+		defer hijackedResp.CloseWrite()
+		if stdin != nil && opts.Interactive {
+			_, _ = io.Copy(hijackedResp.Conn, stdin)
+		}
+	}()
+
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		if opts.TTY {
+			_, _ = io.Copy(stdout, hijackedResp.Reader)
+		} else {
+			_, _ = stdcopy.StdCopy(stdout, stderr, hijackedResp.Reader)
+		}
+	}()
+
+	select {
+	case <-outputDone:
+	case <-ctx.Done():
+		// The execution can't be stopped by itself, so remove the warm container.
+		r.removeContainer(containerID)
+		return result, ctx.Err()
+	}
+
+	// The stream ends when the process exits, but the daemon may take a moment
+	// to record its exit code.
+	for attempt := 0; attempt < 50; attempt++ {
+		inspect, err := r.client.ContainerExecInspect(context.Background(), execResp.ID)
+		if err != nil {
+			return result, &ContainerError{Err: fmt.Errorf("failed to inspect exec: %w", err)}
+		}
+		if !inspect.Running {
+			result.ExitCode = inspect.ExitCode
+			result.TimedOut = forwarder.TimedOut()
+			if result.ExitCode == oomExitCode && opts.Memory > 0 {
+				result.OOMKilled = r.oomKilled(containerID)
+			}
+			timer.mark(PhaseExec)
+			result.Phases = timer.phases
+			return result, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return result, &ContainerError{Err: fmt.Errorf("exec in container %s did not finish", warm.name)}
+}
+
+// startWarm returns the ID of a running warm container, replacing one that was
+// started with different settings or when upgrading.
+func (r *DockerRuntime) startWarm(ctx context.Context, warm *warmContainer, upgrade bool) (string, error) {
+	existing, err := r.client.ContainerInspect(ctx, warm.name)
+	if err == nil {
+		if !upgrade && existing.State != nil && existing.State.Running && existing.Config != nil && warm.matches(existing.Config.Labels) {
+			return existing.ID, nil
+		}
+		logrus.Infof("Replacing warm container %s...", warm.name)
+		if err := r.client.ContainerRemove(ctx, existing.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
+			return "", &ContainerError{Err: fmt.Errorf("failed to remove warm container: %w", err)}
+		}
+	}
+
+	init := true
+	containerConfig := &container.Config{
+		Image:      warm.options.Image,
+		Entrypoint: entrypoint(warm.options.Entrypoint),
+		Cmd:        warm.options.Command,
+		Labels:     warm.labels,
+	}
+	hostConfig := &container.HostConfig{
+		Binds:      warm.options.Volumes,
+		UsernsMode: container.UsernsMode(warm.options.UserNS),
+		GroupAdd:   warm.options.GroupAdd,
+		Init:       &init,
+		Resources:  dockerResources(warm.options),
+		ShmSize:    warm.options.ShmSize,
+	}
+	if err := applyDockerSecurity(hostConfig, warm.options); err != nil {
+		return "", err
+	}
+	if warm.options.Network != "" {
+		hostConfig.NetworkMode = container.NetworkMode(warm.options.Network)
+	}
+	if len(warm.options.Ports) > 0 {
+		portBindings, exposedPorts, err := parsePortMappings(warm.options.Ports)
+		if err != nil {
+			return "", &ContainerError{Err: fmt.Errorf("failed to parse port mappings: %w", err)}
+		}
+		hostConfig.PortBindings = portBindings
+		containerConfig.ExposedPorts = exposedPorts
+	}
+
+	resp, err := r.client.ContainerCreate(ctx, containerConfig, hostConfig, &network.NetworkingConfig{}, nil, warm.name)
+	if err != nil {
+		// Another run may have started the container in the meantime.
+		if errdefs.IsConflict(err) {
+			if existing, inspectErr := r.client.ContainerInspect(ctx, warm.name); inspectErr == nil && existing.State != nil && existing.State.Running {
+				return existing.ID, nil
+			}
+		}
+		return "", &ContainerError{Err: fmt.Errorf("failed to create warm container: %w", err)}
+	}
+	if err := r.client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		r.removeContainer(resp.ID)
+		return "", fmt.Errorf("%w: %w", errNotIdling, err)
+	}
+	if started, err := r.client.ContainerInspect(ctx, resp.ID); err == nil && (started.State == nil || !started.State.Running) {
+		r.removeContainer(resp.ID)
+		return "", errNotIdling
+	}
+	return resp.ID, nil
+}
+
+// listWarm returns the names of warm containers with their keep-alive durations.
+func (r *DockerRuntime) listWarm(ctx context.Context) (map[string]string, error) {
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("label", labelKeepAlive)
+	containers, err := r.client.ContainerList(ctx, types.ContainerListOptions{Filters: filterArgs, All: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	warm := make(map[string]string)
+	for _, c := range containers {
+		if len(c.Names) > 0 {
+			warm[containerName(c.Names[0])] = c.Labels[labelKeepAlive]
+		}
+	}
+	return warm, nil
+}
+
+// removeWarm removes a warm container.
+func (r *DockerRuntime) removeWarm(ctx context.Context, name string) error {
+	return r.client.ContainerRemove(ctx, name, types.ContainerRemoveOptions{Force: true})
+}
+
+// oomKilled reports whether the kernel killed a container for running out of memory.
+func (r *DockerRuntime) oomKilled(containerID string) bool {
+	inspect, err := r.client.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		logrus.Debugf("Failed to inspect container %s: %v", containerID, err)
+		return false
+	}
+	return inspect.State != nil && inspect.State.OOMKilled
+}
+
+// removeContainer force-removes a container that was not created with auto-remove.
+func (r *DockerRuntime) removeContainer(containerID string) {
+	if err := r.client.ContainerRemove(context.Background(), containerID, types.ContainerRemoveOptions{Force: true, RemoveVolumes: true}); err != nil {
+		logrus.Debugf("Failed to remove container %s: %v", containerID, err)
+	}
+}
+
+// dockerSignalTarget relays signals and terminal resizes to a Docker container.
+type dockerSignalTarget struct {
+	client      *client.Client
+	containerID string
+}
+
+// Signal sends a signal to the container's main process.
+func (t *dockerSignalTarget) Signal(sig os.Signal) error {
+	// The daemon expects signal names like SIGINT or numbers, so send the number.
+	signalNumber, ok := sig.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("unsupported signal %s", sig)
+	}
+	return t.client.ContainerKill(context.Background(), t.containerID, strconv.Itoa(int(signalNumber)))
+}
+
+// Resize changes the size of the container's TTY.
+func (t *dockerSignalTarget) Resize(width, height int) error {
+	return t.client.ContainerResize(context.Background(), t.containerID, types.ResizeOptions{
+		Width:  uint(width),
+		Height: uint(height),
+	})
+}
+
+// Stop sends the stop signal to the container's main process.
+func (t *dockerSignalTarget) Stop(signal string) error {
+	return t.client.ContainerKill(context.Background(), t.containerID, signal)
+}
+
+// Kill kills the container.
+func (t *dockerSignalTarget) Kill() error {
+	return t.client.ContainerKill(context.Background(), t.containerID, "KILL")
+}
+
+// dockerExecSignalTarget relays signals to the warm container an execution runs
+// in, and terminal resizes to the execution.
+type dockerExecSignalTarget struct {
+	dockerSignalTarget
+	execID string
+}
+
+// Resize changes the size of the execution's TTY.
+func (t *dockerExecSignalTarget) Resize(width, height int) error {
+	return t.client.ContainerExecResize(context.Background(), t.execID, types.ResizeOptions{
+		Width:  uint(width),
+		Height: uint(height),
+	})
+}
+
+// dockerVolumes splits volumes into bind mounts and anonymous volumes, which
+// are only the path they are mounted at.
+func dockerVolumes(volumes []string) ([]string, map[string]struct{}) {
+	var binds []string
+	var anonymous map[string]struct{}
+	for _, volume := range volumes {
+		if strings.Contains(volume, ":") {
+			binds = append(binds, volume)
+			continue
+		}
+		if anonymous == nil {
+			anonymous = map[string]struct{}{}
+		}
+		anonymous[volume] = struct{}{}
+	}
+	return binds, anonymous
 }
 
 // parsePortMappings parses port mapping strings and returns Docker port bindings.
@@ -438,4 +854,11 @@ func parsePortMappings(ports []string) (nat.PortMap, nat.PortSet, error) {
 	}
 
 	return portBindings, exposedPorts, nil
-}
\ No newline at end of file
+}
+// entrypoint returns the entrypoint override of a container, or nil to keep the image's.
+func entrypoint(override string) []string {
+	if override == "" {
+		return nil
+	}
+	return []string{override}
+}
@@ -0,0 +1,523 @@
+package runtime
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+func TestDockerExecuteCommand(t *testing.T) {
+	fake := newFakeDocker(t)
+	fake.AddImage("python:3.11-slim")
+	fake.ExitCode = 3
+	fake.Stdout = "hello\n"
+	fake.Stderr = "warning\n"
+
+	t.Setenv("DOX_TEST_TOKEN", "secret")
+	t.Setenv("DOX_TEST_UNSET", "")
+
+	cfg := &config.CommandConfig{
+		Image:       "python:3.11-slim",
+		Volumes:     []string{"/host/cache:/cache:ro"},
+		Environment: []string{"DOX_TEST_TOKEN", "DOX_TEST_UNSET"},
+		Command:     "python",
+		Network:     "bridge",
+		Ports:       []string{"8080:80"},
+	}
+
+	var stdout, stderr bytes.Buffer
+	result, err := fake.runtime().ExecuteCommand(context.Background(), cfg, "python", []string{"-c", "print(1)"}, false, strings.NewReader(""), &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+
+	if result.ExitCode != 3 {
+		t.Errorf("exitCode = %d, want 3", result.ExitCode)
+	}
+	if stdout.String() != "hello\n" {
+		t.Errorf("stdout = %q, want %q", stdout.String(), "hello\n")
+	}
+	if stderr.String() != "warning\n" {
+		t.Errorf("stderr = %q, want %q", stderr.String(), "warning\n")
+	}
+
+	create := fake.LastCreate()
+	cwd, _ := os.Getwd()
+
+	if create.Image != "python:3.11-slim" {
+		t.Errorf("Image = %s, want python:3.11-slim", create.Image)
+	}
+	if want := []string{"python", "-c", "print(1)"}; !reflect.DeepEqual([]string(create.Cmd), want) {
+		t.Errorf("Cmd = %v, want %v", create.Cmd, want)
+	}
+	if want := []string{"DOX_TEST_TOKEN=secret"}; !reflect.DeepEqual(create.Env, want) {
+		t.Errorf("Env = %v, want %v", create.Env, want)
+	}
+	if want := fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()); create.User != want {
+		t.Errorf("User = %s, want %s", create.User, want)
+	}
+	if create.WorkingDir != "/workspace" {
+		t.Errorf("WorkingDir = %s, want /workspace", create.WorkingDir)
+	}
+	if create.Tty {
+		t.Error("Tty should be false when not attached to a terminal")
+	}
+	if !create.OpenStdin || !create.AttachStdin {
+		t.Error("stdin should be open and attached")
+	}
+
+	hostConfig := create.HostConfig
+	if want := []string{cwd + ":/workspace", "/host/cache:/cache:ro"}; !reflect.DeepEqual(hostConfig.Binds, want) {
+		t.Errorf("Binds = %v, want %v", hostConfig.Binds, want)
+	}
+	if !hostConfig.AutoRemove {
+		t.Error("AutoRemove should be set")
+	}
+	if hostConfig.NetworkMode != "bridge" {
+		t.Errorf("NetworkMode = %s, want bridge", hostConfig.NetworkMode)
+	}
+	if bindings := hostConfig.PortBindings["80/tcp"]; len(bindings) != 1 || bindings[0].HostPort != "8080" {
+		t.Errorf("PortBindings[80/tcp] = %v, want host port 8080", bindings)
+	}
+
+	id := fake.Containers()[0].ID
+	expected := []string{
+		"POST /containers/create",
+		"POST /containers/" + id + "/attach",
+		"POST /containers/" + id + "/wait",
+		"POST /containers/" + id + "/start",
+	}
+	if requests := fake.Requests(); !reflect.DeepEqual(requests, expected) {
+		t.Errorf("requests = %v, want %v", requests, expected)
+	}
+
+	wait, _ := fake.Request("POST", "/containers/"+id+"/wait")
+	if condition := wait.Query.Get("condition"); condition != "removed" {
+		t.Errorf("wait condition = %s, want removed", condition)
+	}
+}
+
+func TestDockerExecuteCommandOutOfMemory(t *testing.T) {
+	fake := newFakeDocker(t)
+	fake.AddImage("alpine")
+	fake.OOMKill = true
+
+	cfg := &config.CommandConfig{Image: "alpine", Resources: &config.ResourcesConfig{Memory: "64m"}}
+	result, err := fake.runtime().ExecuteCommand(context.Background(), cfg, "alpine", nil, false, nil, &bytes.Buffer{}, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+	if result.ExitCode != oomExitCode || !result.OOMKilled {
+		t.Errorf("result = %+v, want exit code %d reported as out of memory", result, oomExitCode)
+	}
+
+	// The container is inspected before it is removed, so it isn't removed on exit.
+	id := fake.Containers()[0].ID
+	if fake.LastCreate().HostConfig.AutoRemove {
+		t.Error("AutoRemove shouldn't be set for a container with a memory limit")
+	}
+	requests := fake.Requests()
+	if len(requests) < 2 || requests[len(requests)-2] != "GET /containers/"+id+"/json" || requests[len(requests)-1] != "DELETE /containers/"+id {
+		t.Errorf("requests = %v, want the container inspected and then removed", requests)
+	}
+}
+
+func TestDockerExecuteCommandSeccompProfile(t *testing.T) {
+	fake := newFakeDocker(t)
+	fake.AddImage("alpine")
+
+	profile := filepath.Join(t.TempDir(), "seccomp.json")
+	if err := os.WriteFile(profile, []byte("{\n  \"defaultAction\": \"SCMP_ACT_ERRNO\"\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.CommandConfig{Image: "alpine", Security: &config.SecurityConfig{Seccomp: profile}}
+	if _, err := fake.runtime().ExecuteCommand(context.Background(), cfg, "alpine", nil, false, nil, &bytes.Buffer{}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+
+	// Like the docker CLI, the profile's contents are sent rather than its path.
+	expected := []string{`seccomp={"defaultAction":"SCMP_ACT_ERRNO"}`}
+	if opts := fake.LastCreate().HostConfig.SecurityOpt; !reflect.DeepEqual(opts, expected) {
+		t.Errorf("SecurityOpt = %v, want %v", opts, expected)
+	}
+}
+
+func TestDockerExecuteCommandDefaultCommand(t *testing.T) {
+	fake := newFakeDocker(t)
+	fake.AddImage("busybox")
+
+	cfg := &config.CommandConfig{Image: "busybox"}
+	result, err := fake.runtime().ExecuteCommand(context.Background(), cfg, "busybox", nil, false, nil, &bytes.Buffer{}, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+	if result.ExitCode != 0 {
+		t.Errorf("exitCode = %d, want 0", result.ExitCode)
+	}
+
+	// Without arguments or a command override the image's own CMD must be used.
+	if cmd := fake.LastCreate().Cmd; len(cmd) != 0 {
+		t.Errorf("Cmd = %v, want empty", cmd)
+	}
+}
+
+func TestDockerExecuteCommandForwardsStdin(t *testing.T) {
+	fake := newFakeDocker(t)
+	fake.AddImage("busybox")
+	fake.EchoStdin = true
+
+	cfg := &config.CommandConfig{Image: "busybox"}
+	var stdout bytes.Buffer
+	_, err := fake.runtime().ExecuteCommand(context.Background(), cfg, "cat", []string{"cat"}, false, strings.NewReader("piped input"), &stdout, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+
+	if stdout.String() != "piped input" {
+		t.Errorf("stdout = %q, want %q", stdout.String(), "piped input")
+	}
+}
+
+func TestDockerExecuteCommandPullsMissingImage(t *testing.T) {
+	fake := newFakeDocker(t)
+
+	cfg := &config.CommandConfig{Image: "busybox"}
+	result, err := fake.runtime().ExecuteCommand(context.Background(), cfg, "busybox", []string{"true"}, false, nil, &bytes.Buffer{}, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+	if result.ExitCode != 0 {
+		t.Errorf("exitCode = %d, want 0", result.ExitCode)
+	}
+
+	if pulls := fake.Pulls(); !reflect.DeepEqual(pulls, []string{"busybox:latest"}) {
+		t.Errorf("pulls = %v, want [busybox:latest]", pulls)
+	}
+
+	requests := fake.Requests()
+	if len(requests) < 3 || requests[0] != "POST /containers/create" || requests[1] != "POST /images/create" || requests[2] != "POST /containers/create" {
+		t.Errorf("requests = %v, want create, pull, create", requests)
+	}
+}
+
+func TestDockerExecuteCommandPullFailure(t *testing.T) {
+	fake := newFakeDocker(t)
+	fake.PullError = "manifest unknown"
+
+	cfg := &config.CommandConfig{Image: "busybox:nonexistent"}
+	result, err := fake.runtime().ExecuteCommand(context.Background(), cfg, "busybox", nil, false, nil, &bytes.Buffer{}, &bytes.Buffer{})
+	if err == nil {
+		t.Fatal("ExecuteCommand() should fail when the image can't be pulled")
+	}
+	if !strings.Contains(err.Error(), "manifest unknown") {
+		t.Errorf("error = %v, want it to mention the pull error", err)
+	}
+	if result.ExitCode != 1 {
+		t.Errorf("exitCode = %d, want 1", result.ExitCode)
+	}
+	if len(fake.Containers()) != 0 {
+		t.Error("no container should have been created")
+	}
+}
+
+func TestDockerExecuteCommandUpgradePulls(t *testing.T) {
+	fake := newFakeDocker(t)
+	fake.AddImage("node:20")
+
+	cfg := &config.CommandConfig{Image: "node:20"}
+	if _, err := fake.runtime().ExecuteCommand(context.Background(), cfg, "node", nil, true, nil, &bytes.Buffer{}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+
+	if requests := fake.Requests(); len(requests) == 0 || requests[0] != "POST /images/create" {
+		t.Errorf("requests = %v, want a pull before anything else", requests)
+	}
+}
+
+func TestDockerExecuteCommandInlineBuild(t *testing.T) {
+	fake := newFakeDocker(t)
+	rt := fake.runtime()
+	dockerfile := "FROM alpine\nRUN apk add jq\n"
+
+	run := func(upgrade bool) {
+		t.Helper()
+		cfg := &config.CommandConfig{Build: &config.BuildConfig{DockerfileInline: dockerfile}}
+		if _, err := rt.ExecuteCommand(context.Background(), cfg, "jq", nil, upgrade, nil, &bytes.Buffer{}, &bytes.Buffer{}); err != nil {
+			t.Fatalf("ExecuteCommand() error = %v", err)
+		}
+	}
+
+	// The first run builds the image.
+	run(false)
+	builds := fake.Builds()
+	if len(builds) != 1 {
+		t.Fatalf("len(builds) = %d, want 1", len(builds))
+	}
+	if !reflect.DeepEqual(builds[0].Tags, []string{"dox-jq:latest"}) {
+		t.Errorf("build tags = %v, want [dox-jq:latest]", builds[0].Tags)
+	}
+	if builds[0].Dockerfile != dockerfile {
+		t.Errorf("Dockerfile = %q, want %q", builds[0].Dockerfile, dockerfile)
+	}
+
+	create := fake.LastCreate()
+	if create.Image != "dox-jq:latest" {
+		t.Errorf("Image = %s, want dox-jq:latest", create.Image)
+	}
+	if create.WorkingDir != "" {
+		t.Errorf("WorkingDir = %s, want the Dockerfile's WORKDIR to apply", create.WorkingDir)
+	}
+
+	// The second run reuses it.
+	run(false)
+	if len(fake.Builds()) != 1 {
+		t.Errorf("len(builds) = %d, want the cached image to be reused", len(fake.Builds()))
+	}
+
+	// An upgrade removes and rebuilds it.
+	run(true)
+	if len(fake.Builds()) != 2 {
+		t.Errorf("len(builds) = %d, want a rebuild on upgrade", len(fake.Builds()))
+	}
+	if _, ok := fake.Request("DELETE", "/images/dox-jq:latest"); !ok {
+		t.Error("the old image should have been removed before rebuilding")
+	}
+}
+
+func TestDockerExecuteCommandCopyWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0644)
+	os.WriteFile(filepath.Join(dir, "stale.txt"), []byte("old"), 0644)
+
+	oldDir, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(oldDir)
+
+	fake := newFakeDocker(t)
+	fake.AddImage("golang:1.21")
+	fake.Archive = buildTar(t, map[string]string{
+		"workspace/":        "",
+		"workspace/main.go": "package main // edited",
+	})
+
+	cfg := &config.CommandConfig{Image: "golang:1.21", Workspace: config.WorkspaceCopy}
+	if _, err := fake.runtime().ExecuteCommand(context.Background(), cfg, "go", []string{"fmt"}, false, nil, &bytes.Buffer{}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+
+	create := fake.LastCreate()
+	if len(create.HostConfig.Binds) != 0 {
+		t.Errorf("Binds = %v, want no workspace bind mount", create.HostConfig.Binds)
+	}
+	if create.HostConfig.AutoRemove {
+		t.Error("AutoRemove must be off so the workspace can be copied back")
+	}
+
+	c := fake.Containers()[0]
+	if uploaded := tarNames(t, c.Archive); !uploaded["workspace/main.go"] || !uploaded["workspace/stale.txt"] {
+		t.Errorf("uploaded archive = %v, want the workspace files", uploaded)
+	}
+	var removals int
+	for _, request := range fake.Requests() {
+		if request == "DELETE /containers/"+c.ID {
+			removals++
+		}
+	}
+	if removals != 1 {
+		t.Errorf("the container was removed %d times, want once after the workspace is synced back", removals)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, "main.go"))
+	if string(data) != "package main // edited" {
+		t.Errorf("main.go = %q, want the container's version", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "stale.txt")); !os.IsNotExist(err) {
+		t.Error("stale.txt was deleted in the container and should be removed locally")
+	}
+}
+
+func TestDockerCopyWorkspaceOwnership(t *testing.T) {
+	defer stubHostUser(t, 1000, 1000)()
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0644)
+
+	oldDir, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(oldDir)
+
+	// Files in /workspace belong to the user the command runs as, so it can
+	// change them.
+	tests := []struct {
+		user     string
+		uid, gid int
+	}{
+		{"", 1000, 1000},
+		{config.UserHost, 1000, 1000},
+		{config.UserRoot, 0, 0},
+		{"1001:1002", 1001, 1002},
+		{"1001", 1001, 1001},
+		{config.UserImage, 1000, 1000},
+		{"node", 1000, 1000},
+	}
+	for _, tt := range tests {
+		fake := newFakeDocker(t)
+		fake.AddImage("golang:1.21")
+		fake.Archive = buildTar(t, map[string]string{"workspace/": ""})
+
+		cfg := &config.CommandConfig{Image: "golang:1.21", Workspace: config.WorkspaceCopy, User: tt.user}
+		if _, err := fake.runtime().ExecuteCommand(context.Background(), cfg, "go", nil, false, nil, &bytes.Buffer{}, &bytes.Buffer{}); err != nil {
+			t.Fatalf("ExecuteCommand() error = %v", err)
+		}
+
+		tr := tar.NewReader(bytes.NewReader(fake.Containers()[0].Archive))
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Uid != tt.uid || header.Gid != tt.gid {
+				t.Errorf("user %q: %s is owned by %d:%d, want %d:%d", tt.user, header.Name, header.Uid, header.Gid, tt.uid, tt.gid)
+			}
+		}
+	}
+}
+
+func TestDockerExecuteCommandCanceled(t *testing.T) {
+	fake := newFakeDocker(t)
+	fake.AddImage("alpine")
+	fake.RunUntilKilled = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := fake.runtime().ExecuteCommand(ctx, &config.CommandConfig{Image: "alpine"}, "sleep", nil, false, nil, &bytes.Buffer{}, &bytes.Buffer{})
+		done <- err
+	}()
+
+	c := waitForContainer(t, fake)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("ExecuteCommand() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ExecuteCommand() did not return after the context was canceled")
+	}
+
+	// The container must not be left running.
+	if _, ok := fake.Request("DELETE", "/containers/"+c.ID); !ok {
+		t.Error("canceled container was not removed")
+	}
+}
+
+// waitForContainer waits until the fake daemon has started a container and returns it.
+func waitForContainer(t *testing.T, fake *fakeDocker) *fakeContainer {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if containers := fake.Containers(); len(containers) > 0 {
+			c := containers[len(containers)-1]
+			if _, ok := fake.Request("POST", "/containers/"+c.ID+"/start"); ok {
+				return c
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("container was not started")
+	return nil
+}
+
+func TestDockerPullImage(t *testing.T) {
+	fake := newFakeDocker(t)
+	rt := fake.runtime()
+
+	if err := rt.PullImage(context.Background(), "alpine:3.19"); err != nil {
+		t.Fatalf("PullImage() error = %v", err)
+	}
+	if !fake.HasImage("alpine:3.19") {
+		t.Error("image should exist after pulling")
+	}
+
+	fake.PullError = "pull access denied"
+	err := rt.PullImage(context.Background(), "private/image")
+	if err == nil || !strings.Contains(err.Error(), "pull access denied") {
+		t.Errorf("PullImage() error = %v, want the pull error", err)
+	}
+}
+
+func TestDockerBuildImage(t *testing.T) {
+	fake := newFakeDocker(t)
+	rt := fake.runtime()
+
+	if err := rt.BuildImage(context.Background(), "FROM alpine\n", "dox-test:latest"); err != nil {
+		t.Fatalf("BuildImage() error = %v", err)
+	}
+	if !fake.HasImage("dox-test:latest") {
+		t.Error("image should exist after building")
+	}
+
+	build, _ := fake.Request("POST", "/build")
+	if build.Query.Get("rm") != "1" {
+		t.Errorf("build rm = %s, want intermediate containers removed", build.Query.Get("rm"))
+	}
+
+	fake.BuildError = "The command '/bin/sh -c false' returned a non-zero code: 1"
+	err := rt.BuildImage(context.Background(), "FROM alpine\nRUN false\n", "dox-broken:latest")
+	if err == nil || !strings.Contains(err.Error(), "non-zero code") {
+		t.Errorf("BuildImage() error = %v, want the build error", err)
+	}
+	if fake.HasImage("dox-broken:latest") {
+		t.Error("a failed build should not produce an image")
+	}
+}
+
+func TestDockerRemoveUnusedContainers(t *testing.T) {
+	fake := newFakeDocker(t)
+	fake.Listed = []types.Container{
+		{ID: "aaaaaaaaaaaa1111", State: "exited"},
+		{ID: "bbbbbbbbbbbb2222", State: "running"},
+		{ID: "cccccccccccc3333", State: "exited"},
+	}
+
+	if err := fake.runtime().RemoveUnusedContainers(context.Background()); err != nil {
+		t.Fatalf("RemoveUnusedContainers() error = %v", err)
+	}
+
+	list, _ := fake.Request("GET", "/containers/json")
+	args, err := filters.FromJSON(list.Query.Get("filters"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !args.ExactMatch("status", "exited") {
+		t.Errorf("filters = %v, want status=exited", list.Query.Get("filters"))
+	}
+
+	expected := []string{
+		"GET /containers/json",
+		"DELETE /containers/aaaaaaaaaaaa1111",
+		"DELETE /containers/cccccccccccc3333",
+		// Idle warm containers are looked up last.
+		"GET /containers/json",
+	}
+	if requests := fake.Requests(); !reflect.DeepEqual(requests, expected) {
+		t.Errorf("requests = %v, want %v", requests, expected)
+	}
+}
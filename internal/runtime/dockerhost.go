@@ -0,0 +1,277 @@
+package runtime
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// dockerEndpoint describes how to reach a Docker daemon.
+type dockerEndpoint struct {
+	Host          string
+	CAFile        string
+	CertFile      string
+	KeyFile       string
+	SkipTLSVerify bool
+}
+
+// dockerContextMeta mirrors the metadata file the Docker CLI writes for each context.
+type dockerContextMeta struct {
+	Name      string `json:"Name"`
+	Endpoints map[string]struct {
+		Host          string `json:"Host"`
+		SkipTLSVerify bool   `json:"SkipTLSVerify"`
+	} `json:"Endpoints"`
+}
+
+// resolveDockerEndpoint determines which daemon to connect to.
+// An explicit host wins, then DOCKER_HOST, then the active Docker context.
+// A nil endpoint means the client defaults should be used.
+func resolveDockerEndpoint(host string) (*dockerEndpoint, error) {
+	if host != "" {
+		return &dockerEndpoint{Host: host}, nil
+	}
+
+	// DOCKER_HOST is handled by client.FromEnv, including its TLS settings,
+	// except for ssh hosts which need the ssh dialer.
+	if envHost := os.Getenv("DOCKER_HOST"); envHost != "" {
+		if strings.HasPrefix(envHost, "ssh://") {
+			return &dockerEndpoint{Host: envHost}, nil
+		}
+		return nil, nil
+	}
+
+	contextName, err := currentDockerContext()
+	if err != nil {
+		return nil, err
+	}
+	if contextName == "" || contextName == "default" {
+		return nil, nil
+	}
+
+	return loadDockerContext(contextName)
+}
+
+// dockerConfigDir returns the Docker CLI configuration directory.
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".docker")
+}
+
+// currentDockerContext returns the name of the active Docker context.
+func currentDockerContext() (string, error) {
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return name, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(dockerConfigDir(), "config.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read Docker config: %w", err)
+	}
+
+	var dockerConfig struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if err := json.Unmarshal(data, &dockerConfig); err != nil {
+		return "", fmt.Errorf("failed to parse Docker config: %w", err)
+	}
+
+	return dockerConfig.CurrentContext, nil
+}
+
+// loadDockerContext reads the endpoint of a named Docker context.
+func loadDockerContext(name string) (*dockerEndpoint, error) {
+	digest := sha256.Sum256([]byte(name))
+	contextID := hex.EncodeToString(digest[:])
+
+	metaPath := filepath.Join(dockerConfigDir(), "contexts", "meta", contextID, "meta.json")
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("Docker context '%s' not found", name)
+		}
+		return nil, fmt.Errorf("failed to read Docker context '%s': %w", name, err)
+	}
+
+	var meta dockerContextMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse Docker context '%s': %w", name, err)
+	}
+
+	dockerMeta, ok := meta.Endpoints["docker"]
+	if !ok || dockerMeta.Host == "" {
+		return nil, fmt.Errorf("Docker context '%s' has no docker endpoint", name)
+	}
+
+	endpoint := &dockerEndpoint{
+		Host:          dockerMeta.Host,
+		SkipTLSVerify: dockerMeta.SkipTLSVerify,
+	}
+
+	// Use the context's TLS material if the Docker CLI stored any.
+	tlsDir := filepath.Join(dockerConfigDir(), "contexts", "tls", contextID, "docker")
+	if _, err := os.Stat(filepath.Join(tlsDir, "cert.pem")); err == nil {
+		endpoint.CAFile = filepath.Join(tlsDir, "ca.pem")
+		endpoint.CertFile = filepath.Join(tlsDir, "cert.pem")
+		endpoint.KeyFile = filepath.Join(tlsDir, "key.pem")
+	}
+
+	return endpoint, nil
+}
+
+// clientOpts returns the Docker client options needed to reach the endpoint.
+func (e *dockerEndpoint) clientOpts() ([]client.Opt, error) {
+	hostURL, err := url.Parse(e.Host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Docker host %s: %w", e.Host, err)
+	}
+
+	if hostURL.Scheme == "ssh" {
+		dialer := newSSHDialer(hostURL)
+		// The host is only a placeholder since all connections go through ssh.
+		return []client.Opt{
+			client.WithHost("http://docker.example.com"),
+			client.WithDialContext(dialer),
+		}, nil
+	}
+
+	opts := []client.Opt{client.WithHost(e.Host)}
+	if e.CertFile != "" {
+		caFile := e.CAFile
+		if e.SkipTLSVerify {
+			caFile = ""
+		}
+		opts = append(opts, client.WithTLSClientConfig(caFile, e.CertFile, e.KeyFile))
+	}
+	return opts, nil
+}
+
+// newSSHDialer returns a dialer that tunnels the Docker API through ssh, like the Docker CLI does.
+func newSSHDialer(hostURL *url.URL) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	var sshArgs []string
+	if hostURL.User != nil {
+		sshArgs = append(sshArgs, "-l", hostURL.User.Username())
+	}
+	if hostURL.Port() != "" {
+		sshArgs = append(sshArgs, "-p", hostURL.Port())
+	}
+	sshArgs = append(sshArgs, "--", hostURL.Hostname(), "docker", "system", "dial-stdio")
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return newCommandConn("ssh", sshArgs...)
+	}
+}
+
+// commandConn is a net.Conn backed by the stdio of a child process.
+type commandConn struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	stdout    io.ReadCloser
+	closeOnce sync.Once
+}
+
+// newCommandConn starts the command and wraps its stdio in a net.Conn.
+func newCommandConn(name string, args ...string) (net.Conn, error) {
+	// The connection outlives the dial context, so the process must not be bound to it.
+	cmd := exec.Command(name, args...)
+	conn := &commandConn{cmd: cmd}
+	// Let ssh talk to the user directly, e.g. for host key confirmation.
+	cmd.Stderr = os.Stderr
+
+	var err error
+	if conn.stdin, err = cmd.StdinPipe(); err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	if conn.stdout, err = cmd.StdoutPipe(); err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", name, err)
+	}
+
+	return conn, nil
+}
+
+// Read reads from the process stdout.
+func (c *commandConn) Read(p []byte) (int, error) {
+	return c.stdout.Read(p)
+}
+
+// Write writes to the process stdin.
+func (c *commandConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+// CloseWrite closes the process stdin, which the hijacked attach stream relies on.
+func (c *commandConn) CloseWrite() error {
+	return c.stdin.Close()
+}
+
+// Close terminates the process.
+func (c *commandConn) Close() error {
+	c.closeOnce.Do(func() {
+		_ = c.stdin.Close()
+		if c.cmd.Process != nil {
+			_ = c.cmd.Process.Kill()
+		}
+		_ = c.cmd.Wait()
+	})
+	return nil
+}
+
+// LocalAddr returns a placeholder address.
+func (c *commandConn) LocalAddr() net.Addr {
+	return commandAddr{}
+}
+
+// RemoteAddr returns a placeholder address.
+func (c *commandConn) RemoteAddr() net.Addr {
+	return commandAddr{}
+}
+
+// SetDeadline is a no-op since pipes have no deadlines.
+func (c *commandConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+// SetReadDeadline is a no-op since pipes have no deadlines.
+func (c *commandConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline is a no-op since pipes have no deadlines.
+func (c *commandConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// commandAddr is the net.Addr of a commandConn.
+type commandAddr struct{}
+
+// Network returns the network name.
+func (commandAddr) Network() string {
+	return "command"
+}
+
+// String returns the address.
+func (commandAddr) String() string {
+	return "command"
+}
@@ -0,0 +1,103 @@
+package runtime
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeDockerContext creates a Docker CLI context in the given config directory.
+func writeDockerContext(t *testing.T, configDir, name, host string) {
+	t.Helper()
+	digest := sha256.Sum256([]byte(name))
+	metaDir := filepath.Join(configDir, "contexts", "meta", hex.EncodeToString(digest[:]))
+	if err := os.MkdirAll(metaDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	meta := `{"Name":"` + name + `","Endpoints":{"docker":{"Host":"` + host + `","SkipTLSVerify":false}}}`
+	if err := os.WriteFile(filepath.Join(metaDir, "meta.json"), []byte(meta), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveDockerEndpoint(t *testing.T) {
+	configDir := t.TempDir()
+	writeDockerContext(t, configDir, "remote", "ssh://builder@build.example.com")
+	writeDockerContext(t, configDir, "other", "tcp://10.0.0.5:2376")
+	os.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{"currentContext":"remote"}`), 0644)
+
+	t.Setenv("DOCKER_CONFIG", configDir)
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "")
+
+	tests := []struct {
+		name     string
+		host     string
+		env      map[string]string
+		expected string
+	}{
+		{
+			name:     "current context from config",
+			expected: "ssh://builder@build.example.com",
+		},
+		{
+			name:     "DOCKER_CONTEXT overrides config",
+			env:      map[string]string{"DOCKER_CONTEXT": "other"},
+			expected: "tcp://10.0.0.5:2376",
+		},
+		{
+			name:     "DOCKER_HOST overrides context",
+			env:      map[string]string{"DOCKER_HOST": "tcp://127.0.0.1:2375"},
+			expected: "",
+		},
+		{
+			name:     "ssh DOCKER_HOST needs the ssh dialer",
+			env:      map[string]string{"DOCKER_HOST": "ssh://me@elsewhere"},
+			expected: "ssh://me@elsewhere",
+		},
+		{
+			name:     "explicit host wins",
+			host:     "unix:///run/user/1000/docker.sock",
+			env:      map[string]string{"DOCKER_HOST": "tcp://127.0.0.1:2375"},
+			expected: "unix:///run/user/1000/docker.sock",
+		},
+		{
+			name:     "default context",
+			env:      map[string]string{"DOCKER_CONTEXT": "default"},
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			endpoint, err := resolveDockerEndpoint(tt.host)
+			if err != nil {
+				t.Fatalf("resolveDockerEndpoint() error = %v", err)
+			}
+
+			host := ""
+			if endpoint != nil {
+				host = endpoint.Host
+			}
+			if host != tt.expected {
+				t.Errorf("resolveDockerEndpoint() host = %q, want %q", host, tt.expected)
+			}
+		})
+	}
+}
+
+func TestResolveDockerEndpointMissingContext(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "nonexistent")
+
+	if _, err := resolveDockerEndpoint(""); err == nil {
+		t.Fatal("resolveDockerEndpoint() should fail for a missing context")
+	}
+}
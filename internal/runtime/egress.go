@@ -0,0 +1,148 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	"github.com/sirupsen/logrus"
+	"github.com/skorokithakis/dox/internal/config"
+	"github.com/skorokithakis/dox/internal/egress"
+)
+
+// egressNetwork is the internal network of containers with an egress allowlist.
+// It has no route out, so the only way out is dox's proxy on its gateway.
+const egressNetwork = "dox-egress"
+
+// noProxy are the addresses that containers with an egress allowlist reach
+// without the proxy.
+const noProxy = "localhost,127.0.0.1,::1"
+
+// egressNetworker is the subset of a runtime needed to put containers on the
+// egress network.
+type egressNetworker interface {
+	// egressGateway creates the egress network if it is missing, and returns
+	// the host's address on it.
+	egressGateway(ctx context.Context) (string, error)
+}
+
+// applyEgress puts a command with an egress allowlist on the egress network.
+func applyEgress(opts *ContainerOptions, egressConfig *config.EgressConfig) {
+	if egressConfig == nil {
+		return
+	}
+	opts.Network = egressNetwork
+	opts.EgressAllow = egressConfig.Allow
+}
+
+// startEgress starts the proxy that enforces the options' egress allowlist on
+// the egress network's gateway, and points the container at it. The returned
+// function stops the proxy once the container is gone.
+func startEgress(ctx context.Context, n egressNetworker, opts *ContainerOptions) (func(), error) {
+	if len(opts.EgressAllow) == 0 {
+		return func() {}, nil
+	}
+
+	gateway, err := n.egressGateway(ctx)
+	if err != nil {
+		return nil, &ContainerError{Err: err}
+	}
+	// The gateway is only an address of this host if the runtime runs here,
+	// rather than in a virtual machine or on a remote daemon. Containers can
+	// connect to any service of the host listening on it, not only the proxy.
+	listener, err := net.Listen("tcp", net.JoinHostPort(gateway, "0"))
+	if err != nil {
+		return nil, &ContainerError{Err: fmt.Errorf("failed to start the egress proxy on %s, which requires the container runtime to run on this host: %w", gateway, err)}
+	}
+
+	proxy := egress.NewProxy(opts.EgressAllow)
+	go func() {
+		if err := proxy.Serve(listener); err != nil {
+			logrus.Warnf("Egress proxy stopped: %v", err)
+		}
+	}()
+	logrus.Debugf("Egress proxy listening on %s", listener.Addr())
+
+	address := "http://" + listener.Addr().String()
+	opts.Env = append(opts.Env,
+		"HTTP_PROXY="+address, "HTTPS_PROXY="+address, "NO_PROXY="+noProxy,
+		"http_proxy="+address, "https_proxy="+address, "no_proxy="+noProxy,
+	)
+	return func() { proxy.Close() }, nil
+}
+
+// egressComment describes the egress proxy, which dox runs itself.
+func egressComment(opts ContainerOptions) string {
+	return fmt.Sprintf("# Dox's proxy, passed in HTTP_PROXY and HTTPS_PROXY, only lets the container connect to %s.", strings.Join(opts.EgressAllow, ", "))
+}
+
+// egressGateway creates the egress network if it is missing, and returns its gateway.
+func (r *DockerRuntime) egressGateway(ctx context.Context) (string, error) {
+	resource, err := r.client.NetworkInspect(ctx, egressNetwork, types.NetworkInspectOptions{})
+	if errdefs.IsNotFound(err) {
+		logrus.Infof("Creating network %s...", egressNetwork)
+		_, err = r.client.NetworkCreate(ctx, egressNetwork, types.NetworkCreate{Driver: "bridge", Internal: true})
+		// Another run may have created it in the meantime.
+		if err != nil && !errdefs.IsConflict(err) {
+			return "", fmt.Errorf("failed to create network %s: %w", egressNetwork, err)
+		}
+		resource, err = r.client.NetworkInspect(ctx, egressNetwork, types.NetworkInspectOptions{})
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect network %s: %w", egressNetwork, err)
+	}
+
+	var gateways []string
+	for _, ipam := range resource.IPAM.Config {
+		gateways = append(gateways, ipam.Gateway)
+	}
+	return egressNetworkGateway(resource.Internal, gateways)
+}
+
+// egressGateway creates the egress network if it is missing, and returns its gateway.
+func (r *PodmanRuntime) egressGateway(ctx context.Context) (string, error) {
+	if exec.CommandContext(ctx, r.binary, "network", "exists", egressNetwork).Run() != nil {
+		logrus.Infof("Creating network %s...", egressNetwork)
+		// Another run may have created it in the meantime, which inspecting it finds out.
+		if err := exec.CommandContext(ctx, r.binary, "network", "create", "--internal", egressNetwork).Run(); err != nil {
+			logrus.Debugf("Failed to create network %s: %v", egressNetwork, err)
+		}
+	}
+
+	var networks []struct {
+		Internal bool `json:"internal"`
+		Subnets  []struct {
+			Gateway string `json:"gateway"`
+		} `json:"subnets"`
+	}
+	if err := r.inspect(ctx, &networks, "network", "inspect", egressNetwork); err != nil {
+		return "", fmt.Errorf("failed to inspect network %s: %w", egressNetwork, err)
+	}
+	if len(networks) == 0 {
+		return "", fmt.Errorf("network %s was not created", egressNetwork)
+	}
+
+	var gateways []string
+	for _, subnet := range networks[0].Subnets {
+		gateways = append(gateways, subnet.Gateway)
+	}
+	return egressNetworkGateway(networks[0].Internal, gateways)
+}
+
+// egressNetworkGateway checks that the egress network can't be used to bypass
+// the proxy, and returns its first gateway.
+func egressNetworkGateway(internal bool, gateways []string) (string, error) {
+	if !internal {
+		return "", fmt.Errorf("network %s must be internal, so containers can't bypass the egress proxy; remove it to have dox recreate it", egressNetwork)
+	}
+	for _, gateway := range gateways {
+		if gateway != "" {
+			return gateway, nil
+		}
+	}
+	return "", fmt.Errorf("network %s has no gateway for the egress proxy", egressNetwork)
+}
@@ -0,0 +1,123 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/sirupsen/logrus"
+)
+
+// engineSocketPath is where the container runtime's socket is mounted in
+// containers with engine access. Docker's default lets tools like
+// testcontainers find it without configuration.
+const engineSocketPath = "/var/run/docker.sock"
+
+// engineSocketer is the subset of a runtime needed to give containers access
+// to it.
+type engineSocketer interface {
+	// engineSocket returns the path of the runtime's API socket on the host.
+	engineSocket(ctx context.Context) (string, error)
+}
+
+// applyDevices records the host devices of a command, in docker's
+// host:container:permissions format with the defaults filled in.
+func applyDevices(opts *ContainerOptions, devices []string) {
+	for _, device := range devices {
+		mapping := parseDevice(device)
+		opts.Devices = append(opts.Devices, mapping.PathOnHost+":"+mapping.PathInContainer+":"+mapping.CgroupPermissions)
+	}
+}
+
+// parseDevice parses a device like docker run's --device flag, which defaults
+// to the host path in the container with all permissions.
+func parseDevice(device string) container.DeviceMapping {
+	parts := strings.Split(device, ":")
+	mapping := container.DeviceMapping{PathOnHost: parts[0], PathInContainer: parts[0], CgroupPermissions: "rwm"}
+	switch {
+	case len(parts) == 2 && devicePermissions(parts[1]):
+		mapping.CgroupPermissions = parts[1]
+	case len(parts) >= 2:
+		mapping.PathInContainer = parts[1]
+	}
+	if len(parts) == 3 {
+		mapping.CgroupPermissions = parts[2]
+	}
+	return mapping
+}
+
+// devicePermissions reports whether part of a device is its permissions rather
+// than a path.
+func devicePermissions(part string) bool {
+	return part != "" && strings.Trim(part, "rwm") == ""
+}
+
+// mountEngineSocket gives a container with engine access the runtime's socket,
+// and the socket's group so the host user can use it. It warns, since
+// controlling the runtime is equivalent to root access on the host.
+func mountEngineSocket(ctx context.Context, e engineSocketer, opts *ContainerOptions) error {
+	if !opts.EngineSocket {
+		return nil
+	}
+
+	socket, err := e.engineSocket(ctx)
+	if err != nil {
+		return &ContainerError{Err: err}
+	}
+	info, err := os.Stat(socket)
+	if err != nil {
+		return &ContainerError{Err: fmt.Errorf("failed to find the container runtime's socket: %w", err)}
+	}
+
+	opts.Volumes = append(opts.Volumes, socket+":"+engineSocketPath)
+	opts.Env = append(opts.Env, "DOCKER_HOST=unix://"+engineSocketPath, "CONTAINER_HOST=unix://"+engineSocketPath)
+	if gid, ok := fileGroup(info); ok {
+		group := strconv.Itoa(gid)
+		if !slices.Contains(opts.GroupAdd, group) {
+			opts.GroupAdd = append(opts.GroupAdd, group)
+		}
+	}
+	logrus.Warnf("The container has access to the container runtime through %s, which is equivalent to root access on this host. Only run images you trust with engine_socket.", socket)
+	return nil
+}
+
+// engineComment describes the runtime's socket, which dox finds when the
+// command runs.
+func engineComment() string {
+	return fmt.Sprintf("# Dox mounts the container runtime's socket at %s and adds its group. This is equivalent to root access on the host.", engineSocketPath)
+}
+
+// engineSocket returns the path of the daemon's socket, which must be a local
+// unix socket.
+func (r *DockerRuntime) engineSocket(ctx context.Context) (string, error) {
+	host := r.client.DaemonHost()
+	socket, ok := strings.CutPrefix(host, "unix://")
+	if !ok {
+		return "", fmt.Errorf("engine_socket requires a Docker daemon on a local unix socket, but it is at %s", host)
+	}
+	return socket, nil
+}
+
+// engineSocket returns the path of Podman's API socket, which its systemd
+// socket unit provides.
+func (r *PodmanRuntime) engineSocket(ctx context.Context) (string, error) {
+	output, err := exec.CommandContext(ctx, r.binary, "info", "--format", "{{.Host.RemoteSocket.Path}}").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to find Podman's socket: %w", err)
+	}
+	// Older versions report the socket as a URL.
+	socket := strings.TrimSpace(string(output))
+	socket = strings.TrimPrefix(strings.TrimPrefix(socket, "unix://"), "unix:")
+	if socket == "" {
+		return "", fmt.Errorf("Podman has no API socket; enable it with systemctl --user enable --now podman.socket")
+	}
+	if _, err := os.Stat(socket); err != nil {
+		return "", fmt.Errorf("Podman's socket %s doesn't exist; enable it with systemctl --user enable --now podman.socket", socket)
+	}
+	return socket, nil
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package runtime
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileGroup returns the group that owns a file.
+func fileGroup(info os.FileInfo) (int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int(stat.Gid), true
+}
@@ -0,0 +1,8 @@
+package runtime
+
+import "os"
+
+// fileGroup returns the group that owns a file, which Windows doesn't have.
+func fileGroup(info os.FileInfo) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,81 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UnavailableError is returned when the container runtime can't be reached.
+type UnavailableError struct {
+	Err error
+}
+
+// Error implements the error interface.
+func (e *UnavailableError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// ImageError is returned when a command's image can't be pulled or built.
+type ImageError struct {
+	Image string
+	Err   error
+}
+
+// Error implements the error interface.
+func (e *ImageError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ImageError) Unwrap() error {
+	return e.Err
+}
+
+// ContainerError is returned when the container for a command can't be created,
+// started or set up.
+type ContainerError struct {
+	Err error
+}
+
+// Error implements the error interface.
+func (e *ContainerError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ContainerError) Unwrap() error {
+	return e.Err
+}
+
+// SandboxNotFoundError is returned when a sandbox doesn't exist. ID is empty
+// when no sandbox of a workspace was found.
+type SandboxNotFoundError struct {
+	ID        string
+	Workspace string
+}
+
+// Error implements the error interface.
+func (e *SandboxNotFoundError) Error() string {
+	if e.ID == "" {
+		return fmt.Sprintf("no sandbox found for %s", e.Workspace)
+	}
+	return fmt.Sprintf("sandbox '%s' not found", e.ID)
+}
+
+// SandboxConflictError is returned when a sandbox can't be applied because the
+// workspace has changed since the sandbox was created, in the same paths as the
+// sandbox.
+type SandboxConflictError struct {
+	ID    string
+	Paths []string
+}
+
+// Error implements the error interface.
+func (e *SandboxConflictError) Error() string {
+	return fmt.Sprintf("sandbox '%s' conflicts with changes made to the workspace since it was created: %s", e.ID, strings.Join(e.Paths, ", "))
+}
@@ -0,0 +1,950 @@
+package runtime
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// fakeDockerAPIVersion is the API version the fake daemon advertises.
+const fakeDockerAPIVersion = "1.43"
+
+// apiVersionPrefix matches the version prefix the client adds to request paths.
+var apiVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+// fakeRequest is a single API call received by the fake daemon.
+type fakeRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+}
+
+// String formats the request as "METHOD /path" for compact assertions.
+func (r fakeRequest) String() string {
+	return r.Method + " " + r.Path
+}
+
+// fakeCreateRequest is the decoded body of a container create call.
+type fakeCreateRequest struct {
+	Name string
+	container.Config
+	HostConfig       *container.HostConfig
+	NetworkingConfig *network.NetworkingConfig
+}
+
+// fakeBuildRequest is a recorded image build.
+type fakeBuildRequest struct {
+	Tags       []string
+	Dockerfile string
+}
+
+// fakeExec is an execution in a container of the fake daemon.
+type fakeExec struct {
+	ID          string
+	ContainerID string
+	Config      types.ExecConfig
+	Stdin       []byte
+	exitCode    int
+	done        bool
+}
+
+// fakeContainer is a container known to the fake daemon.
+type fakeContainer struct {
+	ID      string
+	Create  fakeCreateRequest
+	Stdin   []byte
+	Archive []byte
+	// ArchivePath is where Archive was extracted.
+	ArchivePath string
+	started     bool
+	conn        net.Conn
+	reader      *bufio.Reader
+	exitCode    int
+	oomKill     bool
+	exited      chan struct{}
+	removed     chan struct{}
+	killed      chan struct{}
+	once        sync.Once
+	killOnce    sync.Once
+}
+
+// fakeDocker is an in-process fake of the Docker Engine API served over a unix socket.
+// It records every request and answers with scripted responses.
+type fakeDocker struct {
+	t      testing.TB
+	Host   string
+	server *http.Server
+
+	mu         sync.Mutex
+	requests   []fakeRequest
+	containers map[string]*fakeContainer
+	order      []string
+	images     map[string]bool
+	builds     []fakeBuildRequest
+	pulls      []string
+	execs      []*fakeExec
+	networks   map[string]types.NetworkResource
+	nextID     int
+
+	// Scripted behaviour for containers, pulls and builds.
+	ExitCode   int
+	Stdout     string
+	Stderr     string
+	EchoStdin  bool
+	PullError  string
+	BuildError string
+	// RunUntilKilled keeps containers running after their output until they are
+	// killed, when they exit with 128 plus the signal number.
+	RunUntilKilled bool
+	// Archive is returned when the workspace is copied out of a container.
+	// Without one, the container's workspace is as it was copied in.
+	Archive []byte
+	// Listed is returned by the container list endpoint.
+	Listed []types.Container
+	// ImageEntrypoint and ImageCmd are the defaults of all images.
+	ImageEntrypoint []string
+	ImageCmd        []string
+	// ImageFiles are the files in /etc of all images, by path.
+	ImageFiles map[string]string
+	// OOMKill makes containers with a memory limit run out of memory.
+	OOMKill bool
+	// NoIdleCommand makes images lack the command warm containers idle with.
+	NoIdleCommand bool
+	// Fetch are URLs containers fetch through their proxy, reporting the
+	// responses on stdout.
+	Fetch []string
+}
+
+// newFakeDocker starts a fake daemon that is shut down when the test ends.
+func newFakeDocker(t testing.TB) *fakeDocker {
+	t.Helper()
+
+	// Unix socket paths are limited in length, so don't nest them under the test name.
+	dir, err := os.MkdirTemp("", "dox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeDocker{
+		t:          t,
+		Host:       "unix://" + socket,
+		containers: map[string]*fakeContainer{},
+		images:     map[string]bool{},
+		networks:   map[string]types.NetworkResource{},
+	}
+	f.server = &http.Server{Handler: http.HandlerFunc(f.serveHTTP)}
+	go f.server.Serve(listener)
+
+	t.Cleanup(func() {
+		f.server.Close()
+		os.RemoveAll(dir)
+	})
+	return f
+}
+
+// runtime returns a DockerRuntime connected to the fake daemon.
+func (f *fakeDocker) runtime() *DockerRuntime {
+	f.t.Helper()
+	rt, err := NewDockerRuntimeForHost(f.Host)
+	if err != nil {
+		f.t.Fatalf("NewDockerRuntimeForHost() error = %v", err)
+	}
+	return rt
+}
+
+// AddImage makes an image available locally.
+func (f *fakeDocker) AddImage(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.images[normalizeImage(name)] = true
+}
+
+// HasImage reports whether an image exists locally.
+func (f *fakeDocker) HasImage(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.images[normalizeImage(name)]
+}
+
+// Requests returns the recorded requests as "METHOD /path" strings, ignoring pings.
+func (f *fakeDocker) Requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var requests []string
+	for _, req := range f.requests {
+		if req.Path != "/_ping" {
+			requests = append(requests, req.String())
+		}
+	}
+	return requests
+}
+
+// Request returns the last recorded request with the given method and path.
+func (f *fakeDocker) Request(method, path string) (fakeRequest, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.requests) - 1; i >= 0; i-- {
+		if f.requests[i].Method == method && f.requests[i].Path == path {
+			return f.requests[i], true
+		}
+	}
+	return fakeRequest{}, false
+}
+
+// Containers returns every container created so far, in creation order.
+func (f *fakeDocker) Containers() []*fakeContainer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var containers []*fakeContainer
+	for _, id := range f.order {
+		containers = append(containers, f.containers[id])
+	}
+	return containers
+}
+
+// LastCreate returns the body of the most recent container create call.
+func (f *fakeDocker) LastCreate() fakeCreateRequest {
+	f.t.Helper()
+	containers := f.Containers()
+	if len(containers) == 0 {
+		f.t.Fatal("no container was created")
+	}
+	return containers[len(containers)-1].Create
+}
+
+// Builds returns the recorded image builds.
+func (f *fakeDocker) Builds() []fakeBuildRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeBuildRequest(nil), f.builds...)
+}
+
+// Pulls returns the recorded image pulls.
+func (f *fakeDocker) Pulls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.pulls...)
+}
+
+// Networks returns the networks that were created, by name.
+func (f *fakeDocker) Networks() map[string]types.NetworkResource {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	networks := make(map[string]types.NetworkResource)
+	for name, resource := range f.networks {
+		networks[name] = resource
+	}
+	return networks
+}
+
+// normalizeImage adds the implicit latest tag to an image reference.
+func normalizeImage(name string) string {
+	if strings.Contains(name, "@") {
+		return name
+	}
+	if i := strings.LastIndex(name, ":"); i < 0 || strings.Contains(name[i:], "/") {
+		return name + ":latest"
+	}
+	return name
+}
+
+// serveHTTP routes a request to the matching endpoint handler.
+func (f *fakeDocker) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	path := apiVersionPrefix.ReplaceAllString(req.URL.Path, "")
+
+	f.mu.Lock()
+	f.requests = append(f.requests, fakeRequest{Method: req.Method, Path: path, Query: req.URL.Query()})
+	f.mu.Unlock()
+
+	w.Header().Set("API-Version", fakeDockerAPIVersion)
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case path == "/_ping":
+		w.Write([]byte("OK"))
+	case path == "/containers/create" && req.Method == http.MethodPost:
+		f.createContainer(w, req)
+	case path == "/containers/json" && req.Method == http.MethodGet:
+		f.listContainers(w, req)
+	case parts[0] == "containers" && len(parts) == 2 && req.Method == http.MethodDelete:
+		f.removeContainer(w, parts[1])
+	case parts[0] == "containers" && len(parts) == 3:
+		f.containerAction(w, req, parts[1], parts[2])
+	case parts[0] == "exec" && len(parts) == 3:
+		f.execAction(w, req, parts[1], parts[2])
+	case path == "/images/create" && req.Method == http.MethodPost:
+		f.pullImage(w, req)
+	case path == "/build" && req.Method == http.MethodPost:
+		f.buildImage(w, req)
+	case parts[0] == "images" && strings.HasSuffix(path, "/json") && req.Method == http.MethodGet:
+		f.inspectImage(w, strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/json"))
+	case parts[0] == "images" && req.Method == http.MethodDelete:
+		f.removeImage(w, strings.TrimPrefix(path, "/images/"))
+	case path == "/networks/create" && req.Method == http.MethodPost:
+		f.createNetwork(w, req)
+	case parts[0] == "networks" && len(parts) == 2 && req.Method == http.MethodGet:
+		f.inspectNetwork(w, parts[1])
+	default:
+		writeError(w, http.StatusNotFound, "page not found")
+	}
+}
+
+// writeError writes an error in the format returned by the Docker daemon.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// writeJSON writes a JSON response body.
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// lookup returns a container by ID or name, or nil if it doesn't exist or was removed.
+func (f *fakeDocker) lookup(id string) *fakeContainer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.containers {
+		if c.ID != id && (c.Create.Name == "" || c.Create.Name != id) {
+			continue
+		}
+		select {
+		case <-c.removed:
+		default:
+			return c
+		}
+	}
+	return nil
+}
+
+// Execs returns the executions in the order they were created.
+func (f *fakeDocker) Execs() []*fakeExec {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*fakeExec(nil), f.execs...)
+}
+
+// isWarm reports whether a container is a warm container, which runs until removed.
+func (c *fakeContainer) isWarm() bool {
+	_, ok := c.Create.Labels[labelKeepAlive]
+	return ok
+}
+
+func (f *fakeDocker) createContainer(w http.ResponseWriter, req *http.Request) {
+	var body fakeCreateRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	body.Name = req.URL.Query().Get("name")
+
+	if body.Name != "" && f.lookup(body.Name) != nil {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Conflict. The container name \"/%s\" is already in use", body.Name))
+		return
+	}
+	if !f.HasImage(body.Image) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("No such image: %s", body.Image))
+		return
+	}
+
+	f.mu.Lock()
+	f.nextID++
+	id := fmt.Sprintf("%064x", f.nextID)
+	f.containers[id] = &fakeContainer{
+		ID:       id,
+		Create:   body,
+		exitCode: f.ExitCode,
+		exited:   make(chan struct{}),
+		removed:  make(chan struct{}),
+		killed:   make(chan struct{}),
+	}
+	if f.OOMKill && body.HostConfig != nil && body.HostConfig.Memory > 0 {
+		f.containers[id].exitCode, f.containers[id].oomKill = oomExitCode, true
+	}
+	f.order = append(f.order, id)
+	f.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, container.CreateResponse{ID: id})
+}
+
+func (f *fakeDocker) containerAction(w http.ResponseWriter, req *http.Request, id, action string) {
+	c := f.lookup(id)
+	if c == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("No such container: %s", id))
+		return
+	}
+
+	switch action {
+	case "attach":
+		f.attachContainer(w, c)
+	case "start":
+		f.startContainer(w, c)
+	case "wait":
+		f.waitContainer(w, req, c)
+	case "kill":
+		f.killContainer(w, req, c)
+	case "resize":
+		w.WriteHeader(http.StatusNoContent)
+	case "archive":
+		f.containerArchive(w, req, c)
+	case "json":
+		f.inspectContainer(w, c)
+	case "exec":
+		f.createExec(w, req, c)
+	default:
+		writeError(w, http.StatusNotFound, "page not found")
+	}
+}
+
+func (f *fakeDocker) attachContainer(w http.ResponseWriter, c *fakeContainer) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "cannot hijack connection")
+		return
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+
+	contentType := "application/vnd.docker.multiplexed-stream"
+	if c.Create.Tty {
+		contentType = "application/vnd.docker.raw-stream"
+	}
+	fmt.Fprintf(conn, "HTTP/1.1 101 UPGRADED\r\nContent-Type: %s\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n", contentType)
+
+	f.mu.Lock()
+	c.conn = conn
+	c.reader = buf.Reader
+	f.mu.Unlock()
+}
+
+func (f *fakeDocker) startContainer(w http.ResponseWriter, c *fakeContainer) {
+	f.mu.Lock()
+	missing := f.NoIdleCommand && len(c.Create.Entrypoint) > 0 && c.Create.Entrypoint[0] == idleCommand[0]
+	c.started = !missing
+	f.mu.Unlock()
+	if missing {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to create task for container: exec: %q: executable file not found in $PATH: unknown", idleCommand[0]))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	go f.runContainer(c)
+}
+
+// runContainer plays the scripted output, then exits the container.
+func (f *fakeDocker) runContainer(c *fakeContainer) {
+	f.mu.Lock()
+	conn, reader := c.conn, c.reader
+	stdoutText, stderrText, echo, hold, fetch := f.Stdout, f.Stderr, f.EchoStdin, f.RunUntilKilled, f.Fetch
+	f.mu.Unlock()
+	if len(fetch) > 0 {
+		stdoutText += fakeFetch(c.Create.Env, fetch)
+	}
+
+	if conn != nil {
+		var stdin []byte
+		if echo {
+			stdin, _ = io.ReadAll(reader)
+		}
+
+		var stdout, stderr io.Writer = conn, conn
+		if !c.Create.Tty {
+			stdout = stdcopy.NewStdWriter(conn, stdcopy.Stdout)
+			stderr = stdcopy.NewStdWriter(conn, stdcopy.Stderr)
+		}
+		if stdoutText != "" || len(stdin) > 0 {
+			stdout.Write(append([]byte(stdoutText), stdin...))
+		}
+		if stderrText != "" {
+			stderr.Write([]byte(stderrText))
+		}
+
+		f.mu.Lock()
+		c.Stdin = stdin
+		f.mu.Unlock()
+	}
+
+	f.mu.Lock()
+	idles := c.isWarm() && fakeIdles(c.Create.Entrypoint, c.Create.Cmd, f.ImageEntrypoint, f.ImageCmd)
+	f.mu.Unlock()
+	if idles {
+		<-c.removed
+	} else if hold {
+		<-c.killed
+	}
+	if conn != nil {
+		conn.Close()
+	}
+
+	close(c.exited)
+	if c.Create.HostConfig != nil && c.Create.HostConfig.AutoRemove {
+		c.once.Do(func() { close(c.removed) })
+	}
+}
+
+// killContainer stops a container that runs until killed. Signals sent to other
+// containers are accepted and ignored.
+func (f *fakeDocker) killContainer(w http.ResponseWriter, req *http.Request, c *fakeContainer) {
+	f.mu.Lock()
+	hold := f.RunUntilKilled
+	f.mu.Unlock()
+
+	if hold {
+		sig, err := parseFakeSignal(req.URL.Query().Get("signal"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		c.killOnce.Do(func() {
+			f.mu.Lock()
+			c.exitCode = 128 + sig
+			f.mu.Unlock()
+			close(c.killed)
+		})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseFakeSignal parses a signal number or one of the names the daemon accepts.
+func parseFakeSignal(value string) (int, error) {
+	names := map[string]int{"": 9, "KILL": 9, "SIGKILL": 9, "INT": 2, "SIGINT": 2, "TERM": 15, "SIGTERM": 15}
+	if sig, ok := names[value]; ok {
+		return sig, nil
+	}
+	sig, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid signal: %s", value)
+	}
+	return sig, nil
+}
+
+func (f *fakeDocker) waitContainer(w http.ResponseWriter, req *http.Request, c *fakeContainer) {
+	// Acknowledge the request straight away, like the daemon does, so the client can start
+	// the container while the wait is pending.
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	f.mu.Lock()
+	started := c.started
+	f.mu.Unlock()
+
+	switch container.WaitCondition(req.URL.Query().Get("condition")) {
+	case container.WaitConditionRemoved:
+		<-c.removed
+	case container.WaitConditionNextExit:
+		<-c.exited
+	default:
+		if started {
+			<-c.exited
+		}
+	}
+
+	json.NewEncoder(w).Encode(container.WaitResponse{StatusCode: int64(c.exitCode)})
+}
+
+func (f *fakeDocker) containerArchive(w http.ResponseWriter, req *http.Request, c *fakeContainer) {
+	switch req.Method {
+	case http.MethodPut:
+		// Like the daemon, only volumes can be copied into on a read-only root
+		// filesystem.
+		path := req.URL.Query().Get("path")
+		readOnly := c.Create.HostConfig != nil && c.Create.HostConfig.ReadonlyRootfs
+		if _, volume := c.Create.Volumes[path]; readOnly && !volume {
+			writeError(w, http.StatusInternalServerError, "container rootfs is marked read-only")
+			return
+		}
+		data, _ := io.ReadAll(req.Body)
+		f.mu.Lock()
+		c.Archive, c.ArchivePath = data, path
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		if path := req.URL.Query().Get("path"); strings.HasPrefix(path, "/etc/") {
+			f.imageFile(w, path)
+			return
+		}
+		f.mu.Lock()
+		archive := f.Archive
+		if archive == nil && c.Archive != nil {
+			archive = fakeArchiveOf(c.Archive, c.ArchivePath, req.URL.Query().Get("path"))
+		}
+		f.mu.Unlock()
+		stat, _ := json.Marshal(types.ContainerPathStat{Name: filepath.Base(req.URL.Query().Get("path")), Mode: os.ModeDir | 0755})
+		w.Header().Set("X-Docker-Container-Path-Stat", base64.StdEncoding.EncodeToString(stat))
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Write(archive)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// imageFile serves a file of the images from ImageFiles, archived like the
+// daemon does.
+func (f *fakeDocker) imageFile(w http.ResponseWriter, path string) {
+	f.mu.Lock()
+	content, ok := f.ImageFiles[path]
+	f.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Could not find the file %s in container", path))
+		return
+	}
+	stat, _ := json.Marshal(types.ContainerPathStat{Name: filepath.Base(path), Mode: 0644, Size: int64(len(content))})
+	w.Header().Set("X-Docker-Container-Path-Stat", base64.StdEncoding.EncodeToString(stat))
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Write(fakeFileArchive(filepath.Base(path), content))
+}
+
+// fakeArchiveOf returns the entries of an archive extracted at from that are
+// in dir, named like the daemon names them when dir is copied out.
+func fakeArchiveOf(data []byte, from, dir string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		name := path.Join(from, header.Name)
+		if name != dir && !strings.HasPrefix(name, dir+"/") {
+			continue
+		}
+		header.Name = path.Join(path.Base(dir), strings.TrimPrefix(name, dir))
+		if header.Typeflag == tar.TypeDir {
+			header.Name += "/"
+		}
+		tw.WriteHeader(header)
+		io.Copy(tw, tr)
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+// fakeFileArchive returns a tar archive of a single file.
+func fakeFileArchive(name, content string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+	tw.Write([]byte(content))
+	tw.Close()
+	return buf.Bytes()
+}
+
+func (f *fakeDocker) removeContainer(w http.ResponseWriter, id string) {
+	c := f.lookup(id)
+	if c == nil {
+		// Listed containers are not tracked, but removing them is still recorded.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	c.once.Do(func() { close(c.removed) })
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeDocker) listContainers(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	listed := f.Listed
+	f.mu.Unlock()
+
+	args, err := filters.FromJSON(req.URL.Query().Get("filters"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Tracked containers are listed after the scripted ones.
+	for _, c := range f.Containers() {
+		if f.lookup(c.ID) == nil {
+			continue
+		}
+		state := "running"
+		select {
+		case <-c.exited:
+			state = "exited"
+		default:
+		}
+		listed = append(listed, types.Container{ID: c.ID, Names: []string{"/" + c.Create.Name}, Labels: c.Create.Labels, State: state})
+	}
+
+	containers := []types.Container{}
+	for _, c := range listed {
+		if args.Contains("status") && !args.ExactMatch("status", c.State) {
+			continue
+		}
+		if matched := true; args.Contains("label") {
+			for _, label := range args.Get("label") {
+				key, value, hasValue := strings.Cut(label, "=")
+				if actual, ok := c.Labels[key]; !ok || (hasValue && actual != value) {
+					matched = false
+				}
+			}
+			if !matched {
+				continue
+			}
+		}
+		containers = append(containers, c)
+	}
+	writeJSON(w, http.StatusOK, containers)
+}
+
+func (f *fakeDocker) inspectContainer(w http.ResponseWriter, c *fakeContainer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	running := c.started
+	select {
+	case <-c.exited:
+		running = false
+	default:
+	}
+	config := c.Create.Config
+	writeJSON(w, http.StatusOK, types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{ID: c.ID, Name: "/" + c.Create.Name, State: &types.ContainerState{Running: running, OOMKilled: c.oomKill && !running}},
+		Config:            &config,
+	})
+}
+
+func (f *fakeDocker) createExec(w http.ResponseWriter, req *http.Request, c *fakeContainer) {
+	var config types.ExecConfig
+	if err := json.NewDecoder(req.Body).Decode(&config); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	select {
+	case <-c.exited:
+		writeError(w, http.StatusConflict, fmt.Sprintf("Container %s is not running", c.ID))
+		return
+	default:
+	}
+
+	f.mu.Lock()
+	f.nextID++
+	e := &fakeExec{ID: fmt.Sprintf("exec%060x", f.nextID), ContainerID: c.ID, Config: config, exitCode: f.ExitCode}
+	f.execs = append(f.execs, e)
+	f.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, types.IDResponse{ID: e.ID})
+}
+
+func (f *fakeDocker) execAction(w http.ResponseWriter, req *http.Request, id, action string) {
+	var e *fakeExec
+	for _, candidate := range f.Execs() {
+		if candidate.ID == id {
+			e = candidate
+		}
+	}
+	if e == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("No such exec instance: %s", id))
+		return
+	}
+
+	switch action {
+	case "start":
+		f.startExec(w, e)
+	case "json":
+		f.mu.Lock()
+		inspect := types.ContainerExecInspect{ExecID: e.ID, ContainerID: e.ContainerID, Running: !e.done, ExitCode: e.exitCode}
+		f.mu.Unlock()
+		writeJSON(w, http.StatusOK, inspect)
+	case "resize":
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeError(w, http.StatusNotFound, "page not found")
+	}
+}
+
+// startExec plays the scripted output over a hijacked connection, like a container.
+func (f *fakeDocker) startExec(w http.ResponseWriter, e *fakeExec) {
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	contentType := "application/vnd.docker.multiplexed-stream"
+	if e.Config.Tty {
+		contentType = "application/vnd.docker.raw-stream"
+	}
+	fmt.Fprintf(conn, "HTTP/1.1 101 UPGRADED\r\nContent-Type: %s\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n", contentType)
+
+	f.mu.Lock()
+	stdoutText, stderrText, echo := f.Stdout, f.Stderr, f.EchoStdin
+	f.mu.Unlock()
+
+	var stdin []byte
+	if echo && e.Config.AttachStdin {
+		stdin, _ = io.ReadAll(buf.Reader)
+	}
+	var stdout, stderr io.Writer = conn, conn
+	if !e.Config.Tty {
+		stdout = stdcopy.NewStdWriter(conn, stdcopy.Stdout)
+		stderr = stdcopy.NewStdWriter(conn, stdcopy.Stderr)
+	}
+	if stdoutText != "" || len(stdin) > 0 {
+		stdout.Write(append([]byte(stdoutText), stdin...))
+	}
+	if stderrText != "" {
+		stderr.Write([]byte(stderrText))
+	}
+
+	f.mu.Lock()
+	e.Stdin = stdin
+	e.done = true
+	f.mu.Unlock()
+}
+
+func (f *fakeDocker) pullImage(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	image := query.Get("fromImage")
+	if tag := query.Get("tag"); tag != "" {
+		image += ":" + tag
+	}
+
+	f.mu.Lock()
+	f.pulls = append(f.pulls, image)
+	pullError := f.PullError
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.Encode(map[string]string{"status": "Pulling from " + query.Get("fromImage"), "id": query.Get("tag")})
+	if pullError != "" {
+		encoder.Encode(map[string]string{"error": pullError})
+		return
+	}
+	encoder.Encode(map[string]string{"status": "Status: Downloaded newer image for " + image})
+	f.AddImage(image)
+}
+
+func (f *fakeDocker) buildImage(w http.ResponseWriter, req *http.Request) {
+	build := fakeBuildRequest{Tags: req.URL.Query()["t"]}
+
+	dockerfile := req.URL.Query().Get("dockerfile")
+	tr := tar.NewReader(req.Body)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		if header.Name == dockerfile {
+			data, _ := io.ReadAll(tr)
+			build.Dockerfile = string(data)
+		}
+	}
+
+	f.mu.Lock()
+	f.builds = append(f.builds, build)
+	buildError := f.BuildError
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.Encode(map[string]string{"stream": "Step 1/1 : FROM scratch\n"})
+	if buildError != "" {
+		encoder.Encode(map[string]interface{}{
+			"errorDetail": map[string]string{"message": buildError},
+			"error":       buildError,
+		})
+		return
+	}
+	encoder.Encode(map[string]interface{}{"aux": map[string]string{"ID": "sha256:0123456789ab"}})
+	for _, tag := range build.Tags {
+		f.AddImage(tag)
+	}
+}
+
+func (f *fakeDocker) inspectImage(w http.ResponseWriter, name string) {
+	if !f.HasImage(name) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("No such image: %s", name))
+		return
+	}
+	f.mu.Lock()
+	config := &container.Config{Entrypoint: f.ImageEntrypoint, Cmd: f.ImageCmd}
+	f.mu.Unlock()
+	writeJSON(w, http.StatusOK, types.ImageInspect{ID: "sha256:0123456789ab", RepoTags: []string{normalizeImage(name)}, Config: config})
+}
+
+func (f *fakeDocker) removeImage(w http.ResponseWriter, name string) {
+	f.mu.Lock()
+	exists := f.images[normalizeImage(name)]
+	delete(f.images, normalizeImage(name))
+	f.mu.Unlock()
+
+	if !exists {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("No such image: %s", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, []types.ImageDeleteResponseItem{{Untagged: name}})
+}
+
+// createNetwork creates a network whose gateway is the loopback address, so
+// proxies listening on it are reachable in tests.
+func (f *fakeDocker) createNetwork(w http.ResponseWriter, req *http.Request) {
+	var body types.NetworkCreateRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.networks[body.Name]; ok {
+		writeError(w, http.StatusConflict, fmt.Sprintf("network with name %s already exists", body.Name))
+		return
+	}
+	f.nextID++
+	resource := types.NetworkResource{
+		Name:     body.Name,
+		ID:       fmt.Sprintf("%064d", f.nextID),
+		Driver:   body.Driver,
+		Internal: body.Internal,
+		IPAM:     network.IPAM{Config: []network.IPAMConfig{{Subnet: "127.0.0.0/8", Gateway: "127.0.0.1"}}},
+	}
+	f.networks[body.Name] = resource
+	writeJSON(w, http.StatusCreated, types.NetworkCreateResponse{ID: resource.ID})
+}
+
+func (f *fakeDocker) inspectNetwork(w http.ResponseWriter, name string) {
+	f.mu.Lock()
+	resource, ok := f.networks[name]
+	f.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("network %s not found", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, resource)
+}
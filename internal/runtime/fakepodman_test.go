@@ -0,0 +1,630 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+// fakePodmanEnv points a re-executed test binary at the state of a fake podman.
+const fakePodmanEnv = "DOX_FAKE_PODMAN"
+
+// TestMain lets the test binary double as a fake podman executable.
+func TestMain(m *testing.M) {
+	if dir := os.Getenv(fakePodmanEnv); dir != "" {
+		os.Exit(fakePodmanMain(dir, os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// fakePodmanRun is a recorded podman run invocation.
+type fakePodmanRun struct {
+	ID        string
+	Options   ContainerOptions
+	Stdin     string
+	OOMKilled bool
+	// PID is the process of a run that holds until it is killed.
+	PID int
+}
+
+// fakePodmanWarm is a detached container started by the fake podman.
+type fakePodmanWarm struct {
+	ID      string
+	Name    string
+	Labels  map[string]string
+	Init    bool
+	Options ContainerOptions
+	// Exited is whether the container's process exited instead of idling.
+	Exited bool
+}
+
+// fakePodmanState is shared between the test and the fake podman processes it starts.
+type fakePodmanState struct {
+	Images []string
+	Calls  [][]string
+	Runs   []fakePodmanRun
+	Warm   []fakePodmanWarm
+	Execs  []fakePodmanRun
+	Builds []fakeBuildRequest
+	Pulls  []string
+	Listed []string
+
+	// Scripted behaviour for runs, pulls and builds.
+	ExitCode   int
+	Stdout     string
+	Stderr     string
+	EchoStdin  bool
+	PullError  string
+	BuildError string
+	// Signal makes podman run die from the signal, like when the container is killed.
+	Signal int
+	// ImageEntrypoint and ImageCmd are the defaults of all images.
+	ImageEntrypoint []string
+	ImageCmd        []string
+	// ImageFiles are the files in /etc of all images, by path.
+	ImageFiles map[string]string
+	// Created are the containers created, but never started, with podman create.
+	Created []string
+	// OOMKill makes containers with a memory limit run out of memory.
+	OOMKill bool
+	// NoIdleCommand makes images lack the command warm containers idle with.
+	NoIdleCommand bool
+	// Hold keeps podman run running after its output until the container is
+	// killed, when it exits with 128 plus the signal number.
+	Hold bool
+	// Removed are the containers removed with podman rm.
+	Removed []string
+	// Networks are the networks that were created, with whether they are internal.
+	Networks map[string]bool
+	// Fetch are URLs runs fetch through their proxy, reporting the responses
+	// on stdout.
+	Fetch []string
+	// EngineSocket is the API socket podman info reports.
+	EngineSocket string
+}
+
+// fakePodman is a fake podman binary backed by a state file.
+type fakePodman struct {
+	t   testing.TB
+	dir string
+}
+
+// newFakePodman sets up an empty fake podman for the test.
+func newFakePodman(t testing.TB) *fakePodman {
+	t.Helper()
+	f := &fakePodman{t: t, dir: t.TempDir()}
+	if err := saveFakePodmanState(f.dir, &fakePodmanState{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(fakePodmanEnv, f.dir)
+	return f
+}
+
+// runtime returns a PodmanRuntime that runs the fake podman.
+func (f *fakePodman) runtime() *PodmanRuntime {
+	f.t.Helper()
+	executable, err := os.Executable()
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return &PodmanRuntime{binary: executable}
+}
+
+// State returns the current state of the fake.
+func (f *fakePodman) State() *fakePodmanState {
+	f.t.Helper()
+	state, err := loadFakePodmanState(f.dir)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return state
+}
+
+// Update modifies the state of the fake.
+func (f *fakePodman) Update(update func(state *fakePodmanState)) {
+	f.t.Helper()
+	state := f.State()
+	update(state)
+	if err := saveFakePodmanState(f.dir, state); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// Calls returns the subcommands podman was invoked with, like "run" or "image exists".
+func (f *fakePodman) Calls() []string {
+	var calls []string
+	for _, args := range f.State().Calls {
+		if len(args) > 1 && (args[0] == "image" || args[0] == "container" || args[0] == "network") {
+			calls = append(calls, args[0]+" "+args[1])
+		} else if len(args) > 0 {
+			calls = append(calls, args[0])
+		}
+	}
+	return calls
+}
+
+func loadFakePodmanState(dir string) (*fakePodmanState, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "state.json"))
+	if err != nil {
+		return nil, err
+	}
+	state := &fakePodmanState{}
+	return state, json.Unmarshal(data, state)
+}
+
+func saveFakePodmanState(dir string, state *fakePodmanState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "state.json"), data, 0644)
+}
+
+// hasImage reports whether the fake has an image.
+func (s *fakePodmanState) hasImage(name string) bool {
+	for _, image := range s.Images {
+		if image == normalizeImage(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// warm returns the index of a detached container by name or ID, or -1.
+func (s *fakePodmanState) warm(name string) int {
+	for i, w := range s.Warm {
+		if w.Name == name || w.ID == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// fakePodmanMain implements the podman subcommands used by PodmanRuntime.
+func fakePodmanMain(dir string, args []string) int {
+	state, err := loadFakePodmanState(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fake podman: %v\n", err)
+		return 125
+	}
+	state.Calls = append(state.Calls, args)
+	defer saveFakePodmanState(dir, state)
+
+	if len(args) == 0 {
+		return 125
+	}
+
+	switch args[0] {
+	case "version":
+		fmt.Println("Version: 4.9.0")
+		return 0
+
+	case "image":
+		if len(args) == 3 && args[1] == "exists" && state.hasImage(args[2]) {
+			return 0
+		}
+		if len(args) == 3 && args[1] == "inspect" && state.hasImage(args[2]) {
+			image := map[string]interface{}{
+				"Id":     "sha256:0123456789ab",
+				"Config": map[string][]string{"Entrypoint": state.ImageEntrypoint, "Cmd": state.ImageCmd},
+			}
+			json.NewEncoder(os.Stdout).Encode([]interface{}{image})
+			return 0
+		}
+		return 1
+
+	case "container":
+		if len(args) == 3 && args[1] == "inspect" {
+			if i := state.warm(args[2]); i >= 0 {
+				container := map[string]interface{}{
+					"Id":     state.Warm[i].ID,
+					"State":  map[string]bool{"Running": !state.Warm[i].Exited},
+					"Config": map[string]interface{}{"Labels": state.Warm[i].Labels},
+				}
+				json.NewEncoder(os.Stdout).Encode([]interface{}{container})
+				return 0
+			}
+			for _, run := range state.Runs {
+				if run.ID == args[2] {
+					container := map[string]interface{}{
+						"Id":    run.ID,
+						"State": map[string]bool{"Running": false, "OOMKilled": run.OOMKilled},
+					}
+					json.NewEncoder(os.Stdout).Encode([]interface{}{container})
+					return 0
+				}
+			}
+			fmt.Fprintf(os.Stderr, "Error: no such container %s\n", args[2])
+		}
+		return 125
+
+	case "network":
+		return fakePodmanNetworkCommand(state, args[1:])
+
+	case "info":
+		if len(args) == 3 && args[1] == "--format" && args[2] == "{{.Host.RemoteSocket.Path}}" {
+			fmt.Println(state.EngineSocket)
+			return 0
+		}
+		return 125
+
+	case "pull":
+		state.Pulls = append(state.Pulls, normalizeImage(args[1]))
+		if state.PullError != "" {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", state.PullError)
+			return 125
+		}
+		state.Images = append(state.Images, normalizeImage(args[1]))
+		return 0
+
+	case "build":
+		build := fakeBuildRequest{}
+		for i := 1; i < len(args)-1; i++ {
+			switch args[i] {
+			case "-t":
+				build.Tags = append(build.Tags, args[i+1])
+			case "-f":
+				data, _ := os.ReadFile(args[i+1])
+				build.Dockerfile = string(data)
+			}
+		}
+		state.Builds = append(state.Builds, build)
+		if state.BuildError != "" {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", state.BuildError)
+			return 1
+		}
+		for _, tag := range build.Tags {
+			state.Images = append(state.Images, normalizeImage(tag))
+		}
+		return 0
+
+	case "rmi":
+		for i, image := range state.Images {
+			if image == normalizeImage(args[1]) {
+				state.Images = append(state.Images[:i], state.Images[i+1:]...)
+				return 0
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Error: %s: image not known\n", args[1])
+		return 1
+
+	case "ps":
+		for i := 1; i < len(args)-1; i++ {
+			if args[i] == "--filter" && args[i+1] == "label="+labelKeepAlive {
+				for _, w := range state.Warm {
+					fmt.Printf("%s %s\n", w.Name, w.Labels[labelKeepAlive])
+				}
+				return 0
+			}
+		}
+		fmt.Println(strings.Join(state.Listed, "\n"))
+		return 0
+
+	case "create":
+		if !state.hasImage(args[len(args)-1]) {
+			fmt.Fprintf(os.Stderr, "Error: %s: image not known\n", args[len(args)-1])
+			return 125
+		}
+		id := fmt.Sprintf("created%d", len(state.Created))
+		state.Created = append(state.Created, id)
+		fmt.Println(id)
+		return 0
+
+	case "cp":
+		container, path, _ := strings.Cut(args[1], ":")
+		if !slices.Contains(state.Created, container) || args[2] != "-" {
+			fmt.Fprintf(os.Stderr, "Error: no container with name or ID %q found\n", container)
+			return 125
+		}
+		content, ok := state.ImageFiles[path]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error: %q could not be found on container %s: no such file or directory\n", path, container)
+			return 125
+		}
+		os.Stdout.Write(fakeFileArchive(filepath.Base(path), content))
+		return 0
+
+	case "rm":
+		state.Removed = append(state.Removed, args[len(args)-1])
+		if i := state.warm(args[len(args)-1]); i >= 0 {
+			state.Warm = append(state.Warm[:i], state.Warm[i+1:]...)
+		}
+		return 0
+
+	case "kill":
+		// Killing a held run ends it with the signal, which defaults to SIGKILL.
+		sig := syscall.SIGKILL
+		if strings.HasPrefix(args[1], "--signal=") {
+			sig = fakePodmanSignals[strings.TrimPrefix(args[1], "--signal=")]
+		}
+		for _, run := range state.Runs {
+			if run.ID == args[len(args)-1] && run.PID != 0 {
+				if process, err := os.FindProcess(run.PID); err == nil {
+					process.Signal(sig)
+				}
+			}
+		}
+		return 0
+
+	case "run":
+		return fakePodmanRunCommand(dir, state, args[1:])
+
+	case "exec":
+		return fakePodmanExecCommand(state, args[1:])
+	}
+
+	fmt.Fprintf(os.Stderr, "fake podman: unsupported command %s\n", args[0])
+	return 125
+}
+
+// fakePodmanRunCommand parses podman run arguments and plays the scripted output.
+func fakePodmanRunCommand(dir string, state *fakePodmanState, args []string) int {
+	opts := ContainerOptions{}
+	cidFile := ""
+	warm := fakePodmanWarm{Labels: map[string]string{}}
+	detach := false
+	i := 0
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-d":
+			detach = true
+		case arg == "--init":
+			warm.Init = true
+		case strings.HasPrefix(arg, "--name="):
+			warm.Name = strings.TrimPrefix(arg, "--name=")
+		case strings.HasPrefix(arg, "--label="):
+			key, value, _ := strings.Cut(strings.TrimPrefix(arg, "--label="), "=")
+			warm.Labels[key] = value
+		case arg == "--rm":
+			opts.Remove = true
+		case arg == "-i":
+			opts.Interactive = true
+		case arg == "-t":
+			opts.TTY = true
+		case arg == "-v":
+			i++
+			opts.Volumes = append(opts.Volumes, args[i])
+		case arg == "-e":
+			i++
+			opts.Env = append(opts.Env, args[i])
+		case arg == "-p":
+			i++
+			opts.Ports = append(opts.Ports, args[i])
+		case arg == "-w":
+			i++
+			opts.WorkingDir = args[i]
+		case strings.HasPrefix(arg, "--entrypoint="):
+			opts.Entrypoint = strings.TrimPrefix(arg, "--entrypoint=")
+		case strings.HasPrefix(arg, "--user="):
+			opts.User = strings.TrimPrefix(arg, "--user=")
+		case strings.HasPrefix(arg, "--userns="):
+			opts.UserNS = strings.TrimPrefix(arg, "--userns=")
+		case strings.HasPrefix(arg, "--group-add="):
+			opts.GroupAdd = append(opts.GroupAdd, strings.TrimPrefix(arg, "--group-add="))
+		case strings.HasPrefix(arg, "--network="):
+			opts.Network = strings.TrimPrefix(arg, "--network=")
+		case strings.HasPrefix(arg, "--cpus="):
+			opts.CPUs, _ = strconv.ParseFloat(strings.TrimPrefix(arg, "--cpus="), 64)
+		case strings.HasPrefix(arg, "--memory="):
+			opts.Memory, _ = units.RAMInBytes(strings.TrimPrefix(arg, "--memory="))
+		case arg == "--memory-swap=-1":
+			opts.MemorySwap = -1
+		case strings.HasPrefix(arg, "--memory-swap="):
+			opts.MemorySwap, _ = units.RAMInBytes(strings.TrimPrefix(arg, "--memory-swap="))
+		case strings.HasPrefix(arg, "--pids-limit="):
+			opts.PidsLimit, _ = strconv.ParseInt(strings.TrimPrefix(arg, "--pids-limit="), 10, 64)
+		case strings.HasPrefix(arg, "--ulimit="):
+			opts.Ulimits = append(opts.Ulimits, strings.TrimPrefix(arg, "--ulimit="))
+		case strings.HasPrefix(arg, "--shm-size="):
+			opts.ShmSize, _ = units.RAMInBytes(strings.TrimPrefix(arg, "--shm-size="))
+		case strings.HasPrefix(arg, "--stop-signal="):
+			opts.StopSignal = strings.TrimPrefix(arg, "--stop-signal=")
+		case strings.HasPrefix(arg, "--stop-timeout="):
+			seconds, _ := strconv.Atoi(strings.TrimPrefix(arg, "--stop-timeout="))
+			opts.StopGracePeriod = time.Duration(seconds) * time.Second
+		case arg == "--read-only":
+			opts.ReadOnly = true
+		case strings.HasPrefix(arg, "--tmpfs="):
+			opts.Tmpfs = append(opts.Tmpfs, strings.TrimPrefix(arg, "--tmpfs="))
+		case strings.HasPrefix(arg, "--cap-drop="):
+			opts.CapDrop = append(opts.CapDrop, strings.TrimPrefix(arg, "--cap-drop="))
+		case strings.HasPrefix(arg, "--cap-add="):
+			opts.CapAdd = append(opts.CapAdd, strings.TrimPrefix(arg, "--cap-add="))
+		case strings.HasPrefix(arg, "--security-opt="):
+			opts.SecurityOpt = append(opts.SecurityOpt, strings.TrimPrefix(arg, "--security-opt="))
+		case strings.HasPrefix(arg, "--device="):
+			opts.Devices = append(opts.Devices, strings.TrimPrefix(arg, "--device="))
+		case strings.HasPrefix(arg, "--cidfile="):
+			cidFile = strings.TrimPrefix(arg, "--cidfile=")
+		case strings.HasPrefix(arg, "--env="):
+			// Terminal size hints aren't part of the command's environment.
+		default:
+			fmt.Fprintf(os.Stderr, "fake podman: unsupported flag %s\n", arg)
+			return 125
+		}
+	}
+	if i == len(args) {
+		fmt.Fprintln(os.Stderr, "Error: an image is required")
+		return 125
+	}
+	opts.Image = args[i]
+	if i+1 < len(args) {
+		opts.Command = args[i+1:]
+	}
+
+	// Never pull implicitly, so tests notice when the runtime doesn't pull first.
+	if !state.hasImage(opts.Image) {
+		fmt.Fprintf(os.Stderr, "Error: %s: image not known\n", opts.Image)
+		return 125
+	}
+
+	if detach {
+		if state.warm(warm.Name) >= 0 {
+			fmt.Fprintf(os.Stderr, "Error: the container name %q is already in use\n", warm.Name)
+			return 125
+		}
+		warm.ID = fmt.Sprintf("%064x", len(state.Calls)+1000)
+		warm.Options = opts
+		var entrypoint []string
+		if opts.Entrypoint != "" {
+			entrypoint = []string{opts.Entrypoint}
+		}
+		warm.Exited = !fakeIdles(entrypoint, opts.Command, state.ImageEntrypoint, state.ImageCmd)
+		state.Warm = append(state.Warm, warm)
+		// Containers that fail to start are still created.
+		if state.NoIdleCommand && opts.Entrypoint == idleCommand[0] {
+			state.Warm[len(state.Warm)-1].Exited = true
+			fmt.Fprintf(os.Stderr, "Error: crun: executable file `%s` not found in $PATH: No such file or directory: OCI runtime attempted to invoke a command that was not found\n", idleCommand[0])
+			return 127
+		}
+		fmt.Println(warm.ID)
+		return 0
+	}
+
+	run := fakePodmanRun{ID: fmt.Sprintf("%064d", len(state.Runs)+1), Options: opts}
+	if cidFile != "" {
+		_ = os.WriteFile(cidFile, []byte(run.ID), 0644)
+	}
+	exitCode := state.ExitCode
+	if state.OOMKill && opts.Memory > 0 {
+		run.OOMKilled, exitCode = true, oomExitCode
+	}
+	if opts.Interactive && state.EchoStdin {
+		stdin, _ := io.ReadAll(os.Stdin)
+		run.Stdin = string(stdin)
+	}
+	state.Runs = append(state.Runs, run)
+
+	fmt.Fprint(os.Stdout, state.Stdout+run.Stdin)
+	if len(state.Fetch) > 0 {
+		fmt.Fprint(os.Stdout, fakeFetch(opts.Env, state.Fetch))
+	}
+	fmt.Fprint(os.Stderr, state.Stderr)
+
+	if state.Hold {
+		// Save the state now, so podman kill can find the run, since the
+		// deferred save won't run.
+		held := make(chan os.Signal, 1)
+		signal.Notify(held, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
+		state.Runs[len(state.Runs)-1].PID = os.Getpid()
+		saveFakePodmanState(dir, state)
+		sig := (<-held).(syscall.Signal)
+		os.Exit(128 + int(sig))
+	}
+
+	if state.Signal != 0 {
+		// Save the state now, since the deferred save won't run.
+		saveFakePodmanState(dir, state)
+		process, _ := os.FindProcess(os.Getpid())
+		process.Signal(syscall.Signal(state.Signal))
+		select {}
+	}
+	return exitCode
+}
+
+// fakePodmanNetworkCommand implements podman network exists, create and
+// inspect. Networks have the loopback address as their gateway, so proxies
+// listening on it are reachable in tests.
+func fakePodmanNetworkCommand(state *fakePodmanState, args []string) int {
+	if len(args) < 2 {
+		return 125
+	}
+	name := args[len(args)-1]
+	internal, exists := state.Networks[name]
+	switch args[0] {
+	case "exists":
+		if exists {
+			return 0
+		}
+		return 1
+	case "create":
+		if exists {
+			fmt.Fprintf(os.Stderr, "Error: network name %s already used\n", name)
+			return 125
+		}
+		if state.Networks == nil {
+			state.Networks = map[string]bool{}
+		}
+		state.Networks[name] = len(args) == 3 && args[1] == "--internal"
+		fmt.Println(name)
+		return 0
+	case "inspect":
+		if !exists {
+			fmt.Fprintf(os.Stderr, "Error: network %s: unable to find network with name or ID %s: network not found\n", name, name)
+			return 125
+		}
+		network := map[string]interface{}{
+			"name":     name,
+			"internal": internal,
+			"subnets":  []map[string]string{{"subnet": "127.0.0.0/8", "gateway": "127.0.0.1"}},
+		}
+		json.NewEncoder(os.Stdout).Encode([]interface{}{network})
+		return 0
+	}
+	return 125
+}
+
+// fakePodmanSignals are the signals podman kill accepts by name in tests.
+var fakePodmanSignals = map[string]syscall.Signal{"SIGINT": syscall.SIGINT, "SIGTERM": syscall.SIGTERM, "SIGQUIT": syscall.SIGQUIT, "SIGHUP": syscall.SIGHUP, "SIGKILL": syscall.SIGKILL}
+
+// fakePodmanExecCommand parses podman exec arguments and plays the scripted output.
+func fakePodmanExecCommand(state *fakePodmanState, args []string) int {
+	opts := ContainerOptions{}
+	i := 0
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-i":
+			opts.Interactive = true
+		case arg == "-t":
+			opts.TTY = true
+		case arg == "-e":
+			i++
+			opts.Env = append(opts.Env, args[i])
+		case arg == "-w":
+			i++
+			opts.WorkingDir = args[i]
+		case strings.HasPrefix(arg, "--user="):
+			opts.User = strings.TrimPrefix(arg, "--user=")
+		default:
+			fmt.Fprintf(os.Stderr, "fake podman: unsupported flag %s\n", arg)
+			return 125
+		}
+	}
+	if i == len(args) || state.warm(args[i]) < 0 {
+		fmt.Fprintln(os.Stderr, "Error: no such container")
+		return 125
+	}
+	if state.Warm[state.warm(args[i])].Exited {
+		fmt.Fprintln(os.Stderr, "Error: can only create exec sessions on running containers: container state improper")
+		return 125
+	}
+	opts.Image = state.Warm[state.warm(args[i])].Options.Image
+	opts.Command = args[i+1:]
+
+	run := fakePodmanRun{Options: opts}
+	if opts.Interactive && state.EchoStdin {
+		stdin, _ := io.ReadAll(os.Stdin)
+		run.Stdin = string(stdin)
+	}
+	state.Execs = append(state.Execs, run)
+
+	fmt.Fprint(os.Stdout, state.Stdout+run.Stdin)
+	if len(state.Fetch) > 0 {
+		fmt.Fprint(os.Stdout, fakeFetch(opts.Env, state.Fetch))
+	}
+	fmt.Fprint(os.Stderr, state.Stderr)
+	return state.ExitCode
+}
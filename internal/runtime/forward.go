@@ -0,0 +1,188 @@
+package runtime
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/skorokithakis/dox/internal/config"
+	"github.com/skorokithakis/dox/internal/forward"
+)
+
+// forwardDir is where the sockets of forwarded host services are mounted in
+// the container.
+const forwardDir = "/run/dox"
+
+// The names of the sockets and GnuPG home directory in forwardDir.
+const (
+	sshAgentSocket       = "ssh-agent.sock"
+	gnupgHome            = "gnupg"
+	gitCredentialsSocket = "git-credentials.sock"
+)
+
+// gnupgFiles are the files of the host's GnuPG home that are copied for the
+// container, so gpg knows the public keys of the agent's secret keys.
+var gnupgFiles = []string{"pubring.kbx", "pubring.gpg", "trustdb.gpg"}
+
+// gpgDirs returns the host's GnuPG home directory and the gpg-agent's extra
+// socket, which is restricted for use by remote machines. It starts the agent
+// if it isn't running. It is a variable so tests can use a stand-in agent.
+var gpgDirs = func() (home, extraSocket string, err error) {
+	if err := exec.Command("gpgconf", "--launch", "gpg-agent").Run(); err != nil {
+		return "", "", fmt.Errorf("failed to start gpg-agent: %w", err)
+	}
+	output, err := exec.Command("gpgconf", "--list-dirs").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to find gpg-agent's socket: %w", err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		name, value, _ := strings.Cut(line, ":")
+		// Values are percent-escaped, like %3a for colons.
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		switch name {
+		case "homedir":
+			home = value
+		case "agent-extra-socket":
+			extraSocket = value
+		}
+	}
+	if home == "" || extraSocket == "" {
+		return "", "", fmt.Errorf("gpgconf didn't list gpg-agent's extra socket")
+	}
+	return home, extraSocket, nil
+}
+
+// applyForward points the container at the sockets of forwarded host services.
+func applyForward(opts *ContainerOptions, forwards []string) {
+	opts.Forward = forwards
+	for _, name := range forwards {
+		switch name {
+		case config.ForwardSSHAgent:
+			opts.Env = append(opts.Env, "SSH_AUTH_SOCK="+forwardDir+"/"+sshAgentSocket)
+		case config.ForwardGPGAgent:
+			opts.Env = append(opts.Env, "GNUPGHOME="+forwardDir+"/"+gnupgHome)
+		case config.ForwardGitCredentials:
+			// Git's own credential-cache client talks to dox's socket, so
+			// images need nothing but git.
+			opts.Env = append(opts.Env,
+				"GIT_CONFIG_COUNT=1",
+				"GIT_CONFIG_KEY_0=credential.helper",
+				"GIT_CONFIG_VALUE_0=cache --socket="+forwardDir+"/"+gitCredentialsSocket,
+			)
+		}
+	}
+}
+
+// startForward creates the sockets of the options' forwarded host services in
+// a private directory, and mounts it in the container. The sockets let any
+// container user connect, whichever user it maps to on the host. Services that
+// aren't available on the host are skipped with a warning. The returned
+// function removes the sockets once the container is gone.
+func startForward(opts *ContainerOptions) (func(), error) {
+	if len(opts.Forward) == 0 {
+		return func() {}, nil
+	}
+
+	// Only the host user can reach the private directory, while the container
+	// reaches the mounted one inside it through the mount.
+	private, err := os.MkdirTemp("", "dox-forward")
+	if err != nil {
+		return nil, &ContainerError{Err: fmt.Errorf("failed to create directory for forwarded sockets: %w", err)}
+	}
+	dir := filepath.Join(private, "run")
+	server := forward.NewServer()
+	stop := func() {
+		server.Close()
+		os.RemoveAll(private)
+	}
+	if err := mkdirMode(dir, 0755); err != nil {
+		stop()
+		return nil, &ContainerError{Err: fmt.Errorf("failed to create directory for forwarded sockets: %w", err)}
+	}
+
+	for _, name := range opts.Forward {
+		var err error
+		switch name {
+		case config.ForwardSSHAgent:
+			target := os.Getenv("SSH_AUTH_SOCK")
+			if target == "" {
+				logrus.Warnf("SSH_AUTH_SOCK isn't set, so there is no SSH agent to forward")
+				continue
+			}
+			err = server.Relay(filepath.Join(dir, sshAgentSocket), target)
+		case config.ForwardGPGAgent:
+			err = forwardGPGAgent(server, filepath.Join(dir, gnupgHome))
+		case config.ForwardGitCredentials:
+			if _, lookErr := exec.LookPath("git"); lookErr != nil {
+				logrus.Warnf("git isn't installed, so there are no git credentials to forward")
+				continue
+			}
+			err = server.Credentials(filepath.Join(dir, gitCredentialsSocket), forward.GitFill)
+		}
+		if err != nil {
+			stop()
+			return nil, &ContainerError{Err: fmt.Errorf("failed to forward %s: %w", name, err)}
+		}
+	}
+
+	opts.Volumes = append(opts.Volumes, dir+":"+forwardDir)
+	return stop, nil
+}
+
+// forwardGPGAgent sets up a GnuPG home directory with the host's public
+// keyring and a socket relayed to the host agent's extra socket, which can
+// sign and decrypt but not export or change secret keys.
+func forwardGPGAgent(server *forward.Server, dir string) error {
+	home, extraSocket, err := gpgDirs()
+	if err != nil {
+		logrus.Warnf("There is no gpg-agent to forward: %v", err)
+		return nil
+	}
+
+	// Any container user must be able to use the directory, and gpg would
+	// warn about it being writable by others.
+	if err := mkdirMode(dir, 0777); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "gpg.conf"), []byte("no-permission-warning\n"), 0644); err != nil {
+		return err
+	}
+	for _, name := range gnupgFiles {
+		data, err := os.ReadFile(filepath.Join(home, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return err
+		}
+	}
+	return server.Relay(filepath.Join(dir, "S.gpg-agent"), extraSocket)
+}
+
+// mkdirMode creates a directory with exactly the given permissions, regardless
+// of the umask.
+func mkdirMode(dir string, mode os.FileMode) error {
+	if err := os.Mkdir(dir, mode); err != nil {
+		return err
+	}
+	return os.Chmod(dir, mode)
+}
+
+// forwardComment describes the forwarded host services, whose sockets dox
+// creates itself.
+func forwardComment(opts ContainerOptions) string {
+	names := strings.Join(opts.Forward, ", ")
+	if last := len(opts.Forward) - 1; last > 0 {
+		names = strings.Join(opts.Forward[:last], ", ") + " and " + opts.Forward[last]
+	}
+	return fmt.Sprintf("# Dox forwards %s through sockets in a private directory, mounted at %s.", names, forwardDir)
+}
@@ -0,0 +1,143 @@
+package runtime
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+// startEchoAgent starts a stand-in agent on a unix socket that echoes what it
+// is sent, and returns the socket's path.
+func startEchoAgent(t *testing.T, dir, name string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return path
+}
+
+// mountSource returns the host directory mounted at a container path.
+func mountSource(t *testing.T, volumes []string, containerPath string) string {
+	t.Helper()
+	for _, volume := range volumes {
+		if source, ok := strings.CutSuffix(volume, ":"+containerPath); ok {
+			return source
+		}
+	}
+	t.Fatalf("volumes = %v, want one mounted at %s", volumes, containerPath)
+	return ""
+}
+
+// echoes reports whether the socket at path answers with what it is sent.
+func echoes(path string) bool {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	response := make([]byte, 4)
+	_, err = io.ReadFull(conn, response)
+	return err == nil && string(response) == "ping"
+}
+
+func TestStartForward(t *testing.T) {
+	// Unix socket paths are limited in length, so don't nest them under the test name.
+	agents, err := os.MkdirTemp("", "dox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(agents)
+	t.Setenv("SSH_AUTH_SOCK", startEchoAgent(t, agents, "ssh.sock"))
+
+	gnupg := filepath.Join(agents, "gnupg")
+	os.Mkdir(gnupg, 0700)
+	os.WriteFile(filepath.Join(gnupg, "pubring.kbx"), []byte("public keys"), 0600)
+	os.WriteFile(filepath.Join(gnupg, "secring.gpg"), []byte("secret keys"), 0600)
+	extraSocket := startEchoAgent(t, agents, "S.gpg-agent.extra")
+	original := gpgDirs
+	gpgDirs = func() (string, string, error) { return gnupg, extraSocket, nil }
+	defer func() { gpgDirs = original }()
+
+	opts := ContainerOptions{}
+	applyForward(&opts, []string{config.ForwardSSHAgent, config.ForwardGPGAgent})
+	for _, expected := range []string{"SSH_AUTH_SOCK=/run/dox/ssh-agent.sock", "GNUPGHOME=/run/dox/gnupg"} {
+		if !contains(opts.Env, expected) {
+			t.Errorf("Env = %v, want %s", opts.Env, expected)
+		}
+	}
+
+	stop, err := startForward(&opts)
+	if err != nil {
+		t.Fatalf("startForward() error = %v", err)
+	}
+	dir := mountSource(t, opts.Volumes, forwardDir)
+
+	if !echoes(filepath.Join(dir, sshAgentSocket)) {
+		t.Error("the SSH agent socket should be relayed to SSH_AUTH_SOCK")
+	}
+	if !echoes(filepath.Join(dir, gnupgHome, "S.gpg-agent")) {
+		t.Error("the GnuPG agent socket should be relayed to the extra socket")
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, gnupgHome, "pubring.kbx")); string(content) != "public keys" {
+		t.Errorf("pubring.kbx = %q, want the public keyring copied", content)
+	}
+	if _, err := os.Stat(filepath.Join(dir, gnupgHome, "secring.gpg")); !os.IsNotExist(err) {
+		t.Errorf("secret keys must not be copied, stat error = %v", err)
+	}
+	// Container users that aren't the host user can use the sockets too.
+	others := map[string]os.FileMode{
+		dir:                                0005,
+		filepath.Join(dir, sshAgentSocket): 0006,
+		filepath.Join(dir, gnupgHome, "S.gpg-agent"): 0006,
+	}
+	for path, bits := range others {
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm()&bits != bits {
+			t.Errorf("%s has mode %v, %v, want it usable by other users", path, info.Mode(), err)
+		}
+	}
+	if info, err := os.Stat(filepath.Dir(dir)); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("the private directory has mode %v, %v, want 0700", info.Mode(), err)
+	}
+
+	stop()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("the sockets should be removed, stat error = %v", err)
+	}
+}
+
+func TestStartForwardWithoutAgent(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	opts := ContainerOptions{}
+	applyForward(&opts, []string{config.ForwardSSHAgent})
+
+	stop, err := startForward(&opts)
+	if err != nil {
+		t.Fatalf("startForward() error = %v, want missing agents skipped", err)
+	}
+	defer stop()
+	dir := mountSource(t, opts.Volumes, forwardDir)
+	if _, err := os.Stat(filepath.Join(dir, sshAgentSocket)); !os.IsNotExist(err) {
+		t.Errorf("there should be no socket without an agent, stat error = %v", err)
+	}
+}
@@ -0,0 +1,441 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+// guiDir is the container's XDG_RUNTIME_DIR, where the display and audio
+// sockets and the Xauthority cookie file are mounted.
+const guiDir = "/run/dox-gui"
+
+// The names of the sockets and cookies in guiDir.
+const (
+	xauthorityFile = "Xauthority"
+	waylandSocket  = "wayland-0"
+	pulseSocket    = "pulse/native"
+	pulseCookie    = "pulse/cookie"
+	pipewireSocket = "pipewire-0"
+)
+
+// x11SocketDir is where X servers create their sockets, on the host and in
+// the container. It is a variable so tests can use a stand-in server.
+var x11SocketDir = "/tmp/.X11-unix"
+
+// xauthCommand generates the containers' X11 cookies. It is a variable so
+// tests can use a stand-in.
+var xauthCommand = "xauth"
+
+// untrustedCookieTimeout is how many seconds a container's X11 cookie stays
+// valid while no client uses it, after which the X server forgets it.
+const untrustedCookieTimeout = 3600
+
+// localDisplay matches the DISPLAY of X servers on this host, which are reached
+// through their socket, like ":0" or "unix:1.0".
+var localDisplay = regexp.MustCompile(`^(?:unix)?:(\d+)(\.\d+)?$`)
+
+// The Xauthority address families dox uses.
+const (
+	familyLocal = 256
+	familyWild  = 65535
+)
+
+// xauthEntry is an entry of an Xauthority file.
+type xauthEntry struct {
+	family  uint16
+	address string
+	number  string
+	name    string
+	data    string
+}
+
+// applyGUI records the display and audio the container may use.
+func applyGUI(opts *ContainerOptions, gui string, audio bool) {
+	opts.GUI = gui
+	opts.Audio = audio
+}
+
+// startGUI mounts the sockets of the options' display and audio servers in the
+// container, along with a cookie file that only authorizes it to use the
+// display, in a private directory. The container reaches the servers as the
+// host user, so it must run as the host user, or as root in rootless Podman.
+// The returned function removes the cookies once the container is gone.
+func startGUI(opts *ContainerOptions) (func(), error) {
+	if opts.GUI == "" && !opts.Audio {
+		return func() {}, nil
+	}
+
+	// Only the host user can reach the private directory, while the container
+	// reaches the mounted one inside it through the mount.
+	private, err := os.MkdirTemp("", "dox-gui")
+	if err != nil {
+		return nil, &ContainerError{Err: fmt.Errorf("failed to create directory for display sockets: %w", err)}
+	}
+	dir := filepath.Join(private, "run")
+	stop := func() { os.RemoveAll(private) }
+	if err := mkdirMode(dir, 0755); err != nil {
+		stop()
+		return nil, &ContainerError{Err: fmt.Errorf("failed to create directory for display sockets: %w", err)}
+	}
+
+	g := guiMounts{dir: dir, scratch: private, user: opts.User}
+	var displays int
+	if opts.GUI == config.GUIX11 || opts.GUI == config.GUIAuto {
+		found, err := g.x11()
+		if err != nil && opts.GUI == config.GUIX11 {
+			stop()
+			return nil, &ContainerError{Err: err}
+		}
+		if err != nil {
+			logrus.Debugf("Not forwarding X11: %v", err)
+		} else if found {
+			displays++
+		}
+	}
+	if opts.GUI == config.GUIWayland || opts.GUI == config.GUIAuto {
+		found, err := g.wayland()
+		if err != nil && opts.GUI == config.GUIWayland {
+			stop()
+			return nil, &ContainerError{Err: err}
+		}
+		if err != nil {
+			logrus.Debugf("Not forwarding Wayland: %v", err)
+		} else if found {
+			displays++
+		}
+	}
+	if opts.GUI == config.GUIAuto && displays == 0 {
+		logrus.Warnf("There is no X11 or Wayland display to forward, so GUI applications won't start")
+	}
+	if opts.Audio {
+		if err := g.audio(); err != nil {
+			stop()
+			return nil, &ContainerError{Err: err}
+		}
+	}
+	if g.err != nil {
+		stop()
+		return nil, &ContainerError{Err: fmt.Errorf("failed to forward the display: %w", g.err)}
+	}
+
+	// The mounted sockets are mounted inside the directory, which docker and
+	// podman do in order of depth.
+	opts.Volumes = append([]string{dir + ":" + guiDir}, opts.Volumes...)
+	opts.Volumes = append(opts.Volumes, g.volumes...)
+	opts.Env = append(opts.Env, "XDG_RUNTIME_DIR="+guiDir)
+	opts.Env = append(opts.Env, g.env...)
+	return stop, nil
+}
+
+// guiMounts collects the mounts and environment of the forwarded display and
+// audio servers.
+type guiMounts struct {
+	dir string
+	// scratch is a directory for files that aren't mounted.
+	scratch string
+	// user is the user the container runs as.
+	user    string
+	volumes []string
+	env     []string
+	// err is the first error creating the files in dir.
+	err error
+}
+
+// mountSocket mounts the host's socket at name in the directory, creating the
+// file it is mounted over, so the runtime doesn't have to.
+func (g *guiMounts) mountSocket(socket, name string) {
+	g.volumes = append(g.volumes, socket+":"+guiDir+"/"+name)
+	g.writeFile(name, nil, 0644)
+}
+
+// writeFile creates a file in the directory, along with its parent.
+func (g *guiMounts) writeFile(name string, data []byte, mode os.FileMode) {
+	path := filepath.Join(g.dir, name)
+	if g.err == nil {
+		g.err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if g.err == nil {
+		g.err = os.WriteFile(path, data, mode)
+	}
+}
+
+// giveToUser makes a file in the directory belong to the container's user, so
+// it can read the file without it being readable by anyone else. Files of the
+// host user are left alone when the container runs as the host user or as
+// root, who can read them.
+func (g *guiMounts) giveToUser(name string) {
+	uid, gid, ok := containerUser(g.user)
+	if !ok || g.err != nil {
+		return
+	}
+	if err := os.Lchown(filepath.Join(g.dir, name), uid, gid); err != nil {
+		g.err = fmt.Errorf("failed to give %s to the container's user %s; run the command as the host user instead: %w", name, g.user, err)
+	}
+}
+
+// containerUser returns the uid and gid of a numeric container user that
+// isn't the host user or root, with -1 for a gid that isn't set.
+func containerUser(user string) (uid, gid int, ok bool) {
+	name, group, hasGroup := strings.Cut(user, ":")
+	uid, err := strconv.Atoi(name)
+	if err != nil {
+		return 0, 0, false
+	}
+	if hostUID, _ := hostUser(); uid == 0 || uid == hostUID {
+		return 0, 0, false
+	}
+	gid = -1
+	if hasGroup {
+		if gid, err = strconv.Atoi(group); err != nil {
+			gid = -1
+		}
+	}
+	return uid, gid, true
+}
+
+// x11 mounts the socket of the host's X server and a cookie for its display.
+// The cookie is generated for the container as an untrusted client, which
+// can't see or control the windows of other clients, and the host user's own
+// cookie is only used if the X server can't generate one. It reports whether
+// the host has an X server.
+func (g *guiMounts) x11() (bool, error) {
+	display := os.Getenv("DISPLAY")
+	if display == "" {
+		return false, fmt.Errorf("gui is %s, but DISPLAY isn't set", config.GUIX11)
+	}
+	match := localDisplay.FindStringSubmatch(display)
+	if match == nil {
+		return false, fmt.Errorf("the X11 display %s isn't on this host, so it can't be forwarded", display)
+	}
+	number := match[1]
+	socket := filepath.Join(x11SocketDir, "X"+number)
+	if _, err := os.Stat(socket); err != nil {
+		return false, fmt.Errorf("failed to find the socket of the X11 display %s: %w", display, err)
+	}
+
+	cookie, err := untrustedCookie(display, number, g.scratch)
+	if err != nil {
+		hostCookie, hostErr := displayCookie(number)
+		if hostErr != nil {
+			return false, hostErr
+		}
+		if hostCookie != nil {
+			logrus.Warnf("Failed to generate an untrusted X11 cookie for the container (%v), so it gets your own, which lets it see and control every window on the display", err)
+		}
+		cookie = hostCookie
+	}
+
+	g.volumes = append(g.volumes, socket+":/tmp/.X11-unix/X"+number)
+	g.env = append(g.env,
+		"DISPLAY=:"+number+match[2],
+		// The container doesn't share the host's shared memory, so the MIT-SHM
+		// extension would fail.
+		"QT_X11_NO_MITSHM=1",
+	)
+	if cookie == nil {
+		logrus.Debugf("The X11 display %s has no cookie, so the container won't get one", display)
+		return true, nil
+	}
+	var file bytes.Buffer
+	writeXauthEntry(&file, *cookie)
+	g.writeFile(xauthorityFile, file.Bytes(), 0600)
+	g.giveToUser(xauthorityFile)
+	g.env = append(g.env, "XAUTHORITY="+guiDir+"/"+xauthorityFile)
+	return true, nil
+}
+
+// wayland mounts the socket of the host's Wayland compositor. It reports
+// whether the host has a compositor.
+func (g *guiMounts) wayland() (bool, error) {
+	display := os.Getenv("WAYLAND_DISPLAY")
+	if display == "" {
+		return false, fmt.Errorf("gui is %s, but WAYLAND_DISPLAY isn't set", config.GUIWayland)
+	}
+	socket := display
+	if !filepath.IsAbs(socket) {
+		socket = filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), display)
+	}
+	if _, err := os.Stat(socket); err != nil {
+		return false, fmt.Errorf("failed to find the socket of the Wayland display %s: %w", display, err)
+	}
+	g.mountSocket(socket, waylandSocket)
+	g.env = append(g.env, "WAYLAND_DISPLAY="+waylandSocket)
+	return true, nil
+}
+
+// audio mounts the sockets of the host's PulseAudio and PipeWire servers. It
+// only fails if neither is running.
+func (g *guiMounts) audio() error {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		return fmt.Errorf("audio needs XDG_RUNTIME_DIR to find the host's audio server, but it isn't set")
+	}
+	var found bool
+	// PipeWire's PulseAudio server uses the same socket, and most applications
+	// only speak PulseAudio.
+	if socket := filepath.Join(runtimeDir, pulseSocket); exists(socket) {
+		found = true
+		g.mountSocket(socket, pulseSocket)
+		g.env = append(g.env, "PULSE_SERVER=unix:"+guiDir+"/"+pulseSocket)
+		if home, err := os.UserHomeDir(); err == nil {
+			if cookie, err := os.ReadFile(filepath.Join(home, ".config", "pulse", "cookie")); err == nil {
+				g.writeFile(pulseCookie, cookie, 0644)
+				g.env = append(g.env, "PULSE_COOKIE="+guiDir+"/"+pulseCookie)
+			}
+		}
+	}
+	if socket := filepath.Join(runtimeDir, pipewireSocket); exists(socket) {
+		found = true
+		g.mountSocket(socket, pipewireSocket)
+	}
+	if !found {
+		return fmt.Errorf("there is no PulseAudio or PipeWire socket in %s to forward audio through", runtimeDir)
+	}
+	return nil
+}
+
+// exists reports whether there is a file at path.
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// untrustedCookie has the X server generate a cookie for an untrusted client of
+// a display with xauth, made valid for any host, since the container has its
+// own hostname. It needs xauth and the X server's SECURITY extension.
+func untrustedCookie(display, number, dir string) (*xauthEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	path := filepath.Join(dir, "generated")
+	defer os.Remove(path)
+	output, err := exec.CommandContext(ctx, xauthCommand, "-q", "-f", path, "generate", display, ".", "untrusted", "timeout", strconv.Itoa(untrustedCookieTimeout)).CombinedOutput()
+	if err != nil {
+		if message := strings.TrimSpace(string(output)); message != "" {
+			return nil, fmt.Errorf("%w: %s", err, message)
+		}
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readXauthEntries(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the generated cookie: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.number == number {
+			entry.family = familyWild
+			entry.address = ""
+			return &entry, nil
+		}
+	}
+	return nil, fmt.Errorf("xauth generated no cookie for display %s", display)
+}
+
+// displayCookie returns the host user's cookie for a local X11 display, made
+// valid for any host, since the container has its own hostname. It returns
+// nil if the display has no cookie.
+func displayCookie(number string) (*xauthEntry, error) {
+	path := os.Getenv("XAUTHORITY")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".Xauthority")
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read X11 cookies: %w", err)
+	}
+	entries, err := readXauthEntries(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read X11 cookies from %s: %w", path, err)
+	}
+
+	hostname, _ := os.Hostname()
+	for _, entry := range entries {
+		if entry.number != number {
+			continue
+		}
+		if entry.family == familyWild || (entry.family == familyLocal && entry.address == hostname) {
+			entry.family = familyWild
+			entry.address = ""
+			return &entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// readXauthEntries reads the entries of an Xauthority file, each of which is
+// its family followed by its length-prefixed fields, in big endian.
+func readXauthEntries(r io.Reader) ([]xauthEntry, error) {
+	var entries []xauthEntry
+	for {
+		var entry xauthEntry
+		if err := binary.Read(r, binary.BigEndian, &entry.family); err != nil {
+			if errors.Is(err, io.EOF) {
+				return entries, nil
+			}
+			return nil, err
+		}
+		for _, field := range []*string{&entry.address, &entry.number, &entry.name, &entry.data} {
+			var length uint16
+			if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+				return nil, fmt.Errorf("truncated entry: %w", err)
+			}
+			value := make([]byte, length)
+			if _, err := io.ReadFull(r, value); err != nil {
+				return nil, fmt.Errorf("truncated entry: %w", err)
+			}
+			*field = string(value)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// writeXauthEntry writes an entry in the format of Xauthority files.
+func writeXauthEntry(w *bytes.Buffer, entry xauthEntry) {
+	_ = binary.Write(w, binary.BigEndian, entry.family)
+	for _, field := range []string{entry.address, entry.number, entry.name, entry.data} {
+		_ = binary.Write(w, binary.BigEndian, uint16(len(field)))
+		w.WriteString(field)
+	}
+}
+
+// guiComment describes the forwarded display and audio servers, whose sockets
+// dox finds when the command runs.
+func guiComment(opts ContainerOptions) string {
+	var forwarded []string
+	switch opts.GUI {
+	case config.GUIX11:
+		forwarded = append(forwarded, "the X11 display with an untrusted cookie of its own")
+	case config.GUIWayland:
+		forwarded = append(forwarded, "the Wayland display")
+	case config.GUIAuto:
+		forwarded = append(forwarded, "the X11 display with an untrusted cookie of its own and the Wayland display, whichever exist")
+	}
+	if opts.Audio {
+		forwarded = append(forwarded, "the PulseAudio and PipeWire sockets")
+	}
+	return fmt.Sprintf("# Dox mounts %s in a private directory at %s.", strings.Join(forwarded, ", and "), guiDir)
+}
@@ -0,0 +1,219 @@
+package runtime
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+// listenUnix starts a stand-in server on a unix socket at path.
+func listenUnix(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+}
+
+// readCookies returns the entries of an Xauthority file.
+func readCookies(t *testing.T, path string) []xauthEntry {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readXauthEntries(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+// fakeDisplays starts stand-in X11, Wayland and PulseAudio servers, and points
+// the environment at them.
+func fakeDisplays(t *testing.T) string {
+	t.Helper()
+	// Unix socket paths are limited in length, so don't nest them under the test name.
+	dir, err := os.MkdirTemp("", "dox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	original := x11SocketDir
+	x11SocketDir = filepath.Join(dir, "x11")
+	t.Cleanup(func() { x11SocketDir = original })
+	listenUnix(t, filepath.Join(x11SocketDir, "X7"))
+	listenUnix(t, filepath.Join(dir, "wayland-1"))
+	listenUnix(t, filepath.Join(dir, "pulse", "native"))
+
+	hostname, _ := os.Hostname()
+	var xauthority bytes.Buffer
+	writeXauthEntry(&xauthority, xauthEntry{family: familyLocal, address: hostname, number: "0", name: "MIT-MAGIC-COOKIE-1", data: "other display"})
+	writeXauthEntry(&xauthority, xauthEntry{family: familyLocal, address: hostname, number: "7", name: "MIT-MAGIC-COOKIE-1", data: "secret"})
+	os.WriteFile(filepath.Join(dir, "Xauthority"), xauthority.Bytes(), 0600)
+
+	// The stand-in xauth records its arguments and generates an untrusted
+	// cookie for the display.
+	var generated bytes.Buffer
+	writeXauthEntry(&generated, xauthEntry{family: familyLocal, address: hostname, number: "7", name: "MIT-MAGIC-COOKIE-1", data: "untrusted"})
+	os.WriteFile(filepath.Join(dir, "generated"), generated.Bytes(), 0600)
+	xauth := filepath.Join(dir, "xauth")
+	os.WriteFile(xauth, []byte("#!/bin/sh\necho \"$@\" > \"$(dirname \"$0\")/xauth-args\"\ncp \"$(dirname \"$0\")/generated\" \"$3\"\n"), 0755)
+	originalXauth := xauthCommand
+	xauthCommand = xauth
+	t.Cleanup(func() { xauthCommand = originalXauth })
+
+	t.Setenv("DISPLAY", ":7.0")
+	t.Setenv("XAUTHORITY", filepath.Join(dir, "Xauthority"))
+	t.Setenv("WAYLAND_DISPLAY", "wayland-1")
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	t.Setenv("HOME", dir)
+	return dir
+}
+
+func TestStartGUI(t *testing.T) {
+	dir := fakeDisplays(t)
+	opts := ContainerOptions{}
+	applyGUI(&opts, config.GUIAuto, true)
+
+	stop, err := startGUI(&opts)
+	if err != nil {
+		t.Fatalf("startGUI() error = %v", err)
+	}
+	private := mountSource(t, opts.Volumes, guiDir)
+
+	for _, expected := range []string{
+		filepath.Join(x11SocketDir, "X7") + ":/tmp/.X11-unix/X7",
+		filepath.Join(dir, "wayland-1") + ":/run/dox-gui/wayland-0",
+		filepath.Join(dir, "pulse", "native") + ":/run/dox-gui/pulse/native",
+	} {
+		if !contains(opts.Volumes, expected) {
+			t.Errorf("Volumes = %v, want %s", opts.Volumes, expected)
+		}
+	}
+	for _, expected := range []string{
+		"DISPLAY=:7.0",
+		"XAUTHORITY=/run/dox-gui/Xauthority",
+		"WAYLAND_DISPLAY=wayland-0",
+		"XDG_RUNTIME_DIR=/run/dox-gui",
+		"PULSE_SERVER=unix:/run/dox-gui/pulse/native",
+	} {
+		if !contains(opts.Env, expected) {
+			t.Errorf("Env = %v, want %s", opts.Env, expected)
+		}
+	}
+	if contains(opts.Volumes, filepath.Join(dir, "pipewire-0")+":/run/dox-gui/pipewire-0") {
+		t.Error("PipeWire's socket should only be mounted if it exists")
+	}
+
+	// The container gets an untrusted cookie of its own, valid for its hostname
+	// and only readable by its user.
+	expected := xauthEntry{family: familyWild, number: "7", name: "MIT-MAGIC-COOKIE-1", data: "untrusted"}
+	if entries := readCookies(t, filepath.Join(private, xauthorityFile)); len(entries) != 1 || entries[0] != expected {
+		t.Errorf("cookies = %+v, want only %+v", entries, expected)
+	}
+	if info, err := os.Stat(filepath.Join(private, xauthorityFile)); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("the cookie has mode %v, %v, want 0600", info.Mode(), err)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "xauth-args"))
+	if !strings.HasSuffix(string(args), " generate :7.0 . untrusted timeout 3600\n") {
+		t.Errorf("xauth ran with %q, want an untrusted cookie generated", args)
+	}
+
+	stop()
+	if _, err := os.Stat(private); !os.IsNotExist(err) {
+		t.Errorf("the cookie should be removed, stat error = %v", err)
+	}
+}
+
+func TestStartGUIHostCookie(t *testing.T) {
+	dir := fakeDisplays(t)
+	xauthCommand = filepath.Join(dir, "missing")
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	opts := ContainerOptions{}
+	applyGUI(&opts, config.GUIX11, false)
+	stop, err := startGUI(&opts)
+	if err != nil {
+		t.Fatalf("startGUI() error = %v", err)
+	}
+	defer stop()
+
+	// Without xauth, the container gets the host user's cookie of its display,
+	// with a warning.
+	expected := xauthEntry{family: familyWild, number: "7", name: "MIT-MAGIC-COOKIE-1", data: "secret"}
+	if entries := readCookies(t, filepath.Join(mountSource(t, opts.Volumes, guiDir), xauthorityFile)); len(entries) != 1 || entries[0] != expected {
+		t.Errorf("cookies = %+v, want only %+v", entries, expected)
+	}
+	if entry := hook.LastEntry(); entry == nil || entry.Level != logrus.WarnLevel || !strings.Contains(entry.Message, "see and control every window") {
+		t.Errorf("last log entry = %+v, want a warning about the host's cookie", entry)
+	}
+}
+
+func TestContainerUser(t *testing.T) {
+	defer stubHostUser(t, 1000, 1000)()
+
+	tests := []struct {
+		user     string
+		uid, gid int
+		ok       bool
+	}{
+		{"", 0, 0, false},
+		{"1000:1000", 0, 0, false},
+		{"0:0", 0, 0, false},
+		{"node", 0, 0, false},
+		{"1001:1002", 1001, 1002, true},
+		{"1001", 1001, -1, true},
+	}
+	for _, tt := range tests {
+		if uid, gid, ok := containerUser(tt.user); uid != tt.uid || gid != tt.gid || ok != tt.ok {
+			t.Errorf("containerUser(%q) = %d, %d, %v, want %d, %d, %v", tt.user, uid, gid, ok, tt.uid, tt.gid, tt.ok)
+		}
+	}
+}
+
+func TestStartGUIMissingDisplay(t *testing.T) {
+	fakeDisplays(t)
+	t.Setenv("WAYLAND_DISPLAY", "")
+
+	opts := ContainerOptions{}
+	applyGUI(&opts, config.GUIWayland, false)
+	if _, err := startGUI(&opts); err == nil {
+		t.Error("startGUI() should fail without the requested display")
+	}
+
+	// Auto only forwards the displays the host has.
+	opts = ContainerOptions{}
+	applyGUI(&opts, config.GUIAuto, false)
+	stop, err := startGUI(&opts)
+	if err != nil {
+		t.Fatalf("startGUI() error = %v", err)
+	}
+	defer stop()
+	if !contains(opts.Env, "DISPLAY=:7.0") || contains(opts.Env, "WAYLAND_DISPLAY=wayland-0") {
+		t.Errorf("Env = %v, want only the X11 display", opts.Env)
+	}
+}
+
+func TestStartGUIRemoteDisplay(t *testing.T) {
+	fakeDisplays(t)
+	t.Setenv("DISPLAY", "localhost:10.0")
+	opts := ContainerOptions{}
+	applyGUI(&opts, config.GUIX11, false)
+	if _, err := startGUI(&opts); err == nil {
+		t.Error("startGUI() should fail for a display that isn't on this host")
+	}
+}
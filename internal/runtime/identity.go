@@ -0,0 +1,445 @@
+package runtime
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"path/filepath"
+	goruntime "runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/sirupsen/logrus"
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+// localeVariables are the host environment variables that make up the locale.
+var localeVariables = []string{"LANG", "LANGUAGE", "LC_ALL", "LC_CTYPE", "LC_NUMERIC", "LC_TIME", "LC_COLLATE", "LC_MONETARY", "LC_MESSAGES"}
+
+// The account files the host identity is added to.
+const (
+	passwdPath = "/etc/passwd"
+	groupPath  = "/etc/group"
+)
+
+// defaultPasswd and defaultGroup stand in for the account files of images that
+// don't have them, with the users most tools expect.
+const (
+	defaultPasswd = "root:x:0:0:root:/root:/bin/sh\nnobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin\n"
+	defaultGroup  = "root:x:0:\nnogroup:x:65534:\n"
+)
+
+// accountReader is the subset of a runtime needed to read the account files of
+// images.
+type accountReader interface {
+	imageManager
+	// imageID returns the ID of a local image.
+	imageID(ctx context.Context, image string) (string, error)
+	// readImageFiles returns the contents of the regular files at paths in an
+	// image, leaving out the ones it doesn't have.
+	readImageFiles(ctx context.Context, image string, paths []string) (map[string]string, error)
+}
+
+// hostIdentity is the host user as it appears in the container, backed by
+// passwd and group files and a home directory that dox keeps on the host.
+type hostIdentity struct {
+	uid, gid int
+	name     string
+	group    string
+	home     string
+	hostHome string
+	stateDir string
+	// image is the image whose account files the host user is added to.
+	image string
+	// supplementary are the host groups added to the container, by GID.
+	supplementary map[string]string
+}
+
+// identityFor returns the identity emulated for a command running in image, or
+// nil if emulation is off or the command doesn't run as an unprivileged host
+// user.
+func identityFor(cfg *config.CommandConfig, command, image string) *hostIdentity {
+	uid, gid := hostUser()
+	if !cfg.HostIdentity || !isHostUser(cfg) || uid <= 0 {
+		return nil
+	}
+
+	stateDir := identityDir()
+	identity := &hostIdentity{
+		uid:      uid,
+		gid:      gid,
+		name:     os.Getenv("USER"),
+		stateDir: stateDir,
+		hostHome: filepath.Join(stateDir, "home", command),
+		image:    image,
+	}
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		identity.name = u.Username
+	}
+	if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+		identity.group = g.Name
+	}
+	if identity.name == "" || identity.name == "root" {
+		identity.name = "dox"
+	}
+	if identity.group == "" || identity.group == "root" {
+		identity.group = identity.name
+	}
+	identity.home = path.Join("/home", identity.name)
+
+	// Name the supplementary groups like on the host, so tools that check for
+	// membership by name work.
+	identity.supplementary = make(map[string]string)
+	for _, gid := range resolveGroups(cfg.GroupAdd) {
+		if g, err := user.LookupGroupId(gid); err == nil {
+			identity.supplementary[gid] = g.Name
+		}
+	}
+	return identity
+}
+
+// identityDir returns the host directory that holds the emulated identities.
+func identityDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	return filepath.Join(cacheDir, "dox", "identity")
+}
+
+// passwd returns the container's /etc/passwd: the image's, with the host user
+// in place of any user with the same name or UID.
+func (i *hostIdentity) passwd(image string) string {
+	if image == "" {
+		image = defaultPasswd
+	}
+	uid := strconv.Itoa(i.uid)
+	entries := keepEntries(image, func(name, id string) bool {
+		return name != i.name && id != uid
+	})
+	return entries + fmt.Sprintf("%s:x:%d:%d:%s:%s:/bin/sh\n", i.name, i.uid, i.gid, i.name, i.home)
+}
+
+// groups returns the container's /etc/group: the image's, with the host user's
+// groups in place of any group with the same name or GID. Root's and nobody's
+// groups are left as the image has them.
+func (i *hostIdentity) groups(image string) string {
+	if image == "" {
+		image = defaultGroup
+	}
+	names := map[string]string{strconv.Itoa(i.gid): i.group}
+	for gid, name := range i.supplementary {
+		if gid != strconv.Itoa(i.gid) {
+			names[gid] = name
+		}
+	}
+	delete(names, "0")
+	delete(names, "65534")
+
+	gids := make([]string, 0, len(names))
+	taken := map[string]bool{}
+	for gid, name := range names {
+		gids = append(gids, gid)
+		taken[name] = true
+	}
+	sort.Strings(gids)
+
+	groups := keepEntries(image, func(name, gid string) bool {
+		_, replaced := names[gid]
+		return !replaced && !taken[name]
+	})
+	for _, gid := range gids {
+		groups += fmt.Sprintf("%s:x:%s:%s\n", names[gid], gid, i.name)
+	}
+	return groups
+}
+
+// keepEntries returns the lines of an account file whose entries keep accepts
+// by their name and ID, along with comments and malformed lines.
+func keepEntries(file string, keep func(name, id string) bool) string {
+	var kept strings.Builder
+	for _, line := range strings.SplitAfter(file, "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(strings.TrimSuffix(line, "\n"), ":")
+		if len(fields) >= 3 && !keep(fields[0], fields[2]) {
+			continue
+		}
+		kept.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			kept.WriteString("\n")
+		}
+	}
+	return kept.String()
+}
+
+// files returns where the passwd and group files are kept on the host. They are
+// shared by the commands that use the same image, as they only depend on it and
+// the host user.
+func (i *hostIdentity) files() (passwd, group string) {
+	digest := sha256.Sum256([]byte(i.image))
+	dir := filepath.Join(i.stateDir, strconv.Itoa(i.uid), hex.EncodeToString(digest[:8]))
+	return filepath.Join(dir, "passwd"), filepath.Join(dir, "group")
+}
+
+// volumes returns the mounts that make up the identity. Each command gets a
+// home directory of its own, which persists between runs.
+func (i *hostIdentity) volumes() []string {
+	passwd, group := i.files()
+	return []string{
+		passwd + ":" + passwdPath + ":ro",
+		group + ":" + groupPath + ":ro",
+		i.hostHome + ":" + i.home,
+	}
+}
+
+// env returns the environment variables of a login session.
+func (i *hostIdentity) env() []string {
+	return []string{"HOME=" + i.home, "USER=" + i.name, "LOGNAME=" + i.name}
+}
+
+// write creates the files and directories the identity's volumes refer to,
+// from the image's account files.
+func (i *hostIdentity) write(accounts map[string]string) error {
+	if err := os.MkdirAll(i.hostHome, 0700); err != nil {
+		return fmt.Errorf("failed to create home directory: %w", err)
+	}
+	passwd, group := i.files()
+	if err := writeFileAtomic(passwd, i.passwd(accounts[passwdPath])); err != nil {
+		return fmt.Errorf("failed to write passwd file: %w", err)
+	}
+	if err := writeFileAtomic(group, i.groups(accounts[groupPath])); err != nil {
+		return fmt.Errorf("failed to write group file: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces a file without exposing a partial one to containers
+// that are starting concurrently.
+func writeFileAtomic(name, content string) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// prepareIdentity creates the files of the identity emulated for a command, if
+// any, from the account files of its image. The image is provided if it is
+// missing, as its files are needed before the container is created.
+func prepareIdentity(ctx context.Context, r accountReader, cfg *config.CommandConfig, command, image string) error {
+	identity := identityFor(cfg, command, image)
+	if identity == nil {
+		return nil
+	}
+	if !r.imageExists(ctx, image) {
+		if err := provideImage(ctx, r, cfg, image); err != nil {
+			return err
+		}
+	}
+	accounts, err := imageAccounts(ctx, r, image)
+	if err != nil {
+		return &ContainerError{Err: err}
+	}
+	if err := identity.write(accounts); err != nil {
+		return &ContainerError{Err: err}
+	}
+	return nil
+}
+
+// imageAccounts returns the account files of an image. They are cached by image
+// ID, as reading them takes a container.
+func imageAccounts(ctx context.Context, r accountReader, image string) (map[string]string, error) {
+	id, err := r.imageID(ctx, image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image %s: %w", image, err)
+	}
+	cache := filepath.Join(identityDir(), "images", strings.TrimPrefix(id, "sha256:")+".json")
+	var accounts map[string]string
+	if data, err := os.ReadFile(cache); err == nil && json.Unmarshal(data, &accounts) == nil {
+		return accounts, nil
+	}
+
+	accounts, err = r.readImageFiles(ctx, image, []string{passwdPath, groupPath})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the users and groups of image %s: %w", image, err)
+	}
+	if _, ok := accounts[passwdPath]; !ok {
+		logrus.Debugf("Image %s has no %s, so the host user is added to the default users", image, passwdPath)
+	}
+	if data, err := json.Marshal(accounts); err == nil {
+		_ = writeFileAtomic(cache, string(data))
+	}
+	return accounts, nil
+}
+
+// readAccountFile returns the contents of the only file in an archive, as
+// copied out of a container, and whether it is a regular file.
+func readAccountFile(r io.Reader) (string, bool, error) {
+	tr := tar.NewReader(r)
+	header, err := tr.Next()
+	if err == io.EOF {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if header.Typeflag != tar.TypeReg {
+		return "", false, nil
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		return "", false, err
+	}
+	return string(data), true, nil
+}
+
+// imageID returns the ID of a local image.
+func (r *DockerRuntime) imageID(ctx context.Context, image string) (string, error) {
+	info, _, err := r.client.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return "", err
+	}
+	return info.ID, nil
+}
+
+// readImageFiles copies files out of a container that is created from the
+// image, but never started.
+func (r *DockerRuntime) readImageFiles(ctx context.Context, image string, paths []string) (map[string]string, error) {
+	// The command only has to be set for images without one.
+	created, err := r.client.ContainerCreate(ctx, &container.Config{Image: image, Entrypoint: []string{"true"}}, nil, nil, nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container: %w", err)
+	}
+	defer r.client.ContainerRemove(context.Background(), created.ID, types.ContainerRemoveOptions{Force: true})
+
+	files := map[string]string{}
+	for _, path := range paths {
+		archive, _, err := r.client.CopyFromContainer(ctx, created.ID, path)
+		if errdefs.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy %s: %w", path, err)
+		}
+		content, ok, err := readAccountFile(archive)
+		archive.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if ok {
+			files[path] = content
+		}
+	}
+	return files, nil
+}
+
+// imageID returns the ID of a local image.
+func (r *PodmanRuntime) imageID(ctx context.Context, image string) (string, error) {
+	var images []struct {
+		ID string `json:"Id"`
+	}
+	if err := r.inspect(ctx, &images, "image", "inspect", image); err != nil {
+		return "", err
+	}
+	if len(images) == 0 {
+		return "", fmt.Errorf("no such image: %s", image)
+	}
+	return images[0].ID, nil
+}
+
+// readImageFiles copies files out of a container that is created from the
+// image, but never started.
+func (r *PodmanRuntime) readImageFiles(ctx context.Context, image string, paths []string) (map[string]string, error) {
+	// The command only has to be set for images without one.
+	output, err := exec.CommandContext(ctx, r.binary, "create", "--entrypoint=true", image).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container: %w", commandError(err))
+	}
+	id := strings.TrimSpace(string(output))
+	defer exec.Command(r.binary, "rm", "-f", id).Run()
+
+	files := map[string]string{}
+	for _, path := range paths {
+		archive, err := exec.CommandContext(ctx, r.binary, "cp", id+":"+path, "-").Output()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && strings.Contains(string(exitErr.Stderr), "no such file or directory") {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy %s: %w", path, commandError(err))
+		}
+		content, ok, err := readAccountFile(bytes.NewReader(archive))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if ok {
+			files[path] = content
+		}
+	}
+	return files, nil
+}
+
+// hostTimezone returns the host's timezone name and the zoneinfo file to mount
+// at /etc/localtime, either of which may be empty. The file is only mounted on
+// Linux, as other hosts don't share /etc with the container runtime's VM.
+func hostTimezone() (name, localtime string) {
+	// A TZ starting with a colon names a file, which means nothing in the container.
+	if name = os.Getenv("TZ"); strings.HasPrefix(name, ":") {
+		name = ""
+	}
+	target, err := filepath.EvalSymlinks("/etc/localtime")
+	if err != nil {
+		return name, ""
+	}
+	if name == "" {
+		if _, zone, ok := strings.Cut(filepath.ToSlash(target), "/zoneinfo/"); ok {
+			name = zone
+		}
+	}
+	if goruntime.GOOS == "linux" {
+		localtime = target
+	}
+	return name, localtime
+}
+
+// hostLocale returns the host's locale environment variables that are set.
+func hostLocale() []string {
+	var env []string
+	for _, name := range localeVariables {
+		if value := os.Getenv(name); value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
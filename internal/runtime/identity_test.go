@@ -0,0 +1,113 @@
+package runtime
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+// stubHostUser makes dox believe it runs as another host user, and returns a
+// function that restores the real one.
+func stubHostUser(t *testing.T, uid, gid int) func() {
+	t.Helper()
+	original := hostUser
+	hostUser = func() (int, int) {
+		return uid, gid
+	}
+	return func() {
+		hostUser = original
+	}
+}
+
+// contains reports whether a slice has an entry.
+func contains(entries []string, entry string) bool {
+	for _, e := range entries {
+		if e == entry {
+			return true
+		}
+	}
+	return false
+}
+
+func TestIdentityFor(t *testing.T) {
+	defer stubHostUser(t, 4242, 4343)()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("USER", "")
+
+	if identityFor(&config.CommandConfig{}, "tool", "alpine") != nil {
+		t.Error("identity emulation should be off by default")
+	}
+	if identityFor(&config.CommandConfig{HostIdentity: true, User: "0:0"}, "tool", "alpine") != nil {
+		t.Error("an explicit user shouldn't be given the host identity")
+	}
+
+	identity := identityFor(&config.CommandConfig{HostIdentity: true}, "tool", "alpine")
+	if identity == nil {
+		t.Fatal("identityFor() = nil, want the host identity")
+	}
+	// The uid doesn't exist on the host, so the fallback name is used.
+	if identity.name != "dox" || identity.home != "/home/dox" {
+		t.Errorf("name = %s and home = %s, want dox and /home/dox", identity.name, identity.home)
+	}
+	// Images without account files get the users most tools expect.
+	if !strings.Contains(identity.passwd(""), "dox:x:4242:4343:dox:/home/dox:/bin/sh\n") {
+		t.Errorf("passwd = %q, want an entry for the host user", identity.passwd(""))
+	}
+	if !strings.HasPrefix(identity.passwd(""), "root:x:0:0:") {
+		t.Errorf("passwd = %q, want root to be kept", identity.passwd(""))
+	}
+	if !strings.Contains(identity.groups(""), "dox:x:4343:dox\n") {
+		t.Errorf("group = %q, want an entry for the host group", identity.groups(""))
+	}
+
+	if err := identity.write(nil); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	passwd, group := identity.files()
+	for name, expected := range map[string]string{passwd: identity.passwd(""), group: identity.groups("")} {
+		if content, err := os.ReadFile(name); err != nil || string(content) != expected {
+			t.Errorf("%s = %q (%v), want %q", name, content, err, expected)
+		}
+	}
+}
+
+func TestIdentityMergesImageAccounts(t *testing.T) {
+	identity := &hostIdentity{uid: 1000, gid: 1000, name: "alice", group: "alice", home: "/home/alice", supplementary: map[string]string{"0": "root", "998": "docker"}}
+
+	passwd := identity.passwd("# Users\nroot:x:0:0:root:/root:/bin/bash\nnode:x:1000:1000::/home/node:/bin/sh\nalice:x:1001:1001::/home/alice:/bin/sh\nwww-data:x:33:33::/var/www:/usr/sbin/nologin")
+	expected := "# Users\nroot:x:0:0:root:/root:/bin/bash\nwww-data:x:33:33::/var/www:/usr/sbin/nologin\nalice:x:1000:1000:alice:/home/alice:/bin/sh\n"
+	if passwd != expected {
+		t.Errorf("passwd =\n%s\nwant\n%s", passwd, expected)
+	}
+
+	groups := identity.groups("root:x:0:\ndocker:x:999:\nnode:x:1000:\nusers:x:100:\n")
+	expected = "root:x:0:\nusers:x:100:\nalice:x:1000:alice\ndocker:x:998:alice\n"
+	if groups != expected {
+		t.Errorf("group =\n%s\nwant\n%s", groups, expected)
+	}
+}
+
+func TestIdentityOptions(t *testing.T) {
+	defer stubHostUser(t, 4242, 4343)()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("LC_ALL", "C.UTF-8")
+	t.Setenv("LANG", "")
+
+	cfg := &config.CommandConfig{Image: "alpine", HostIdentity: true, HostLocale: true, Environment: []string{"HOME=/custom"}}
+	opts := newContainerOptions(cfg, "tool", "alpine", nil, false)
+	if opts.User != "4242:4343" {
+		t.Errorf("User = %s, want 4242:4343", opts.User)
+	}
+	if !contains(opts.Env, "LC_ALL=C.UTF-8") || contains(opts.Env, "LANG=") {
+		t.Errorf("env = %v, want the locale variables that are set", opts.Env)
+	}
+	// Configured variables come later, so they override the identity's.
+	if opts.Env[len(opts.Env)-1] != "HOME=/custom" {
+		t.Errorf("env = %v, want the configured HOME last", opts.Env)
+	}
+	if len(opts.Volumes) != 4 || !strings.HasSuffix(opts.Volumes[1], ":/etc/passwd:ro") {
+		t.Errorf("volumes = %v, want the workspace and the identity", opts.Volumes)
+	}
+}
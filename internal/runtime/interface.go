@@ -3,6 +3,7 @@ package runtime
 import (
 	"context"
 	"io"
+	"time"
 
 	"github.com/skorokithakis/dox/internal/config"
 )
@@ -10,7 +11,8 @@ import (
 // Runtime defines the interface for container runtimes.
 type Runtime interface {
 	// ExecuteCommand runs a command in a container.
-	ExecuteCommand(ctx context.Context, config *config.CommandConfig, command string, args []string, upgrade bool, stdin io.Reader, stdout, stderr io.Writer) (int, error)
+	// The result carries exit code 1 when an error is returned.
+	ExecuteCommand(ctx context.Context, config *config.CommandConfig, command string, args []string, upgrade bool, stdin io.Reader, stdout, stderr io.Writer) (ExecResult, error)
 	
 	// PullImage pulls a container image.
 	PullImage(ctx context.Context, image string) error
@@ -21,7 +23,7 @@ type Runtime interface {
 	// ListImages lists all images.
 	ListImages(ctx context.Context) ([]string, error)
 	
-	// RemoveUnusedContainers removes stopped containers.
+	// RemoveUnusedContainers removes stopped containers and idle warm containers.
 	RemoveUnusedContainers(ctx context.Context) error
 	
 	// RemoveImage removes a specific image.
@@ -31,16 +33,90 @@ type Runtime interface {
 	IsAvailable(ctx context.Context) error
 }
 
+// Explainer is a Runtime that can explain commands. It is separate from Runtime
+// so runtimes implemented outside dox don't have to.
+type Explainer interface {
+	// Explain works out what ExecuteCommand would do with the same arguments,
+	// without creating containers or touching images.
+	Explain(ctx context.Context, config *config.CommandConfig, command string, args []string, upgrade bool, stdin io.Reader, stdout io.Writer) (*Plan, error)
+}
+
+// ExecResult describes how a containerized command finished.
+type ExecResult struct {
+	ExitCode    int
+	ContainerID string
+	// OOMKilled reports whether the container was killed for running out of
+	// memory. It is only detected for containers with a memory limit.
+	OOMKilled bool
+	// TimedOut reports whether the container was stopped for running longer
+	// than its timeout.
+	TimedOut bool
+	// Phases are the steps of a successful run with how long they took, in order.
+	// The last one includes the command itself.
+	Phases []Phase
+}
+
+// Phase is a timed step of running a command.
+type Phase struct {
+	Name     string
+	Duration time.Duration
+}
+
 // ContainerOptions represents options for container execution.
 type ContainerOptions struct {
 	Image       string
 	Command     []string
+	Entrypoint  string
 	Env         []string
 	Volumes     []string
 	WorkingDir  string
 	User        string
+	UserNS      string
+	GroupAdd    []string
 	Interactive bool
 	TTY         bool
 	Remove      bool
 	Network     string
+	Ports       []string
+	// HostPaths are the volumes among Volumes that make host paths given as
+	// arguments available.
+	HostPaths []string
+	// Resource limits. Zero values leave the runtime's defaults, and sizes are
+	// in bytes, where -1 is unlimited swap.
+	CPUs       float64
+	Memory     int64
+	MemorySwap int64
+	PidsLimit  int64
+	Ulimits    []string
+	ShmSize    int64
+	// Timeout is how long the command may run, or zero for no limit. When it
+	// ends, or dox is terminated, the container is sent StopSignal and killed
+	// if it is still running after StopGracePeriod. Empty and zero values are
+	// dox's defaults.
+	Timeout         time.Duration
+	StopSignal      string
+	StopGracePeriod time.Duration
+	// Security hardening. Tmpfs mounts are path[:options], and SecurityOpt
+	// holds no-new-privileges and the seccomp and AppArmor profiles, like
+	// docker run's --security-opt.
+	ReadOnly    bool
+	Tmpfs       []string
+	CapDrop     []string
+	CapAdd      []string
+	SecurityOpt []string
+	// EgressAllow are the hosts the container may connect to through dox's
+	// proxy, on the egress network. Empty allows any egress.
+	EgressAllow []string
+	// Forward are the host services whose sockets dox forwards into the
+	// container while it runs.
+	Forward []string
+	// GUI is the display whose socket dox mounts in the container while it
+	// runs, and Audio whether it mounts the audio servers' sockets too.
+	GUI   string
+	Audio bool
+	// Devices are the host devices in the container, as
+	// host:container:permissions.
+	Devices []string
+	// EngineSocket mounts the container runtime's socket in the container.
+	EngineSocket bool
 }
\ No newline at end of file
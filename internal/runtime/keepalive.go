@@ -0,0 +1,258 @@
+package runtime
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+// Labels of warm containers. The hash identifies the settings a warm container
+// was started with, so it is replaced when they change.
+const (
+	labelCommand   = "dox.command"
+	labelKeepAlive = "dox.keep-alive"
+	labelHash      = "dox.hash"
+)
+
+// idleCommand keeps a warm container running until it is removed. It replaces
+// the image's entrypoint, which is often the tool itself, and runs under the
+// runtime's init process, so the container stops promptly.
+var idleCommand = []string{"tail", "-f", "/dev/null"}
+
+// errNotIdling is returned when a warm container doesn't keep running, like in
+// images without the idle command. Commands are then run in a container of
+// their own.
+var errNotIdling = fmt.Errorf("the warm container doesn't keep running, which needs %s in the image", idleCommand[0])
+
+// invalidNameCharacters matches characters that aren't allowed in container names.
+var invalidNameCharacters = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// withoutKeepAlive returns a command's configuration for a run in a container
+// of its own, when its warm container can't be used.
+func withoutKeepAlive(cfg *config.CommandConfig) *config.CommandConfig {
+	cold := *cfg
+	cold.KeepAlive = ""
+	return &cold
+}
+
+// keepAlive returns how long a command's warm container is kept after its last
+// run, or zero if the command doesn't use one.
+func keepAlive(cfg *config.CommandConfig) time.Duration {
+	if cfg.KeepAlive == "" {
+		return 0
+	}
+	duration, err := time.ParseDuration(cfg.KeepAlive)
+	if err != nil {
+		return 0
+	}
+	return duration
+}
+
+// warmContainer describes the long-lived container that a command's runs are
+// executed in.
+type warmContainer struct {
+	name   string
+	labels map[string]string
+	// options are the settings the container is started with. Per-run settings,
+	// like the environment and the user, are given to each execution instead.
+	options ContainerOptions
+	// entrypoint and cmd are the image's defaults, which executions don't apply
+	// by themselves.
+	entrypoint []string
+	cmd        []string
+}
+
+// newWarmContainer describes the warm container of a command in the current
+// workspace, for a run with the given options. The image ID makes sure the
+// container is replaced when the image changes.
+func newWarmContainer(cfg *config.CommandConfig, command string, opts ContainerOptions, imageID string, entrypoint, cmd []string) *warmContainer {
+	cwd, _ := os.Getwd()
+	workspace := resolveWorkspace(cfg.Workspace, cwd)
+	workspaceHash := sha256.Sum256([]byte(workspace.hostRoot))
+	name := fmt.Sprintf("dox-%s-%s", invalidNameCharacters.ReplaceAllString(command, "-"), hex.EncodeToString(workspaceHash[:])[:12])
+
+	options := ContainerOptions{
+		Image:      opts.Image,
+		Entrypoint: idleCommand[0],
+		Command:    idleCommand[1:],
+		Volumes:    opts.Volumes,
+		UserNS:     opts.UserNS,
+		GroupAdd:   opts.GroupAdd,
+		Network:    opts.Network,
+		Ports:      opts.Ports,
+		// Resource limits apply to the whole container.
+		CPUs:       opts.CPUs,
+		Memory:     opts.Memory,
+		MemorySwap: opts.MemorySwap,
+		PidsLimit:  opts.PidsLimit,
+		Ulimits:    opts.Ulimits,
+		ShmSize:    opts.ShmSize,
+		Devices:    opts.Devices,
+		// So does the security hardening.
+		ReadOnly:    opts.ReadOnly,
+		Tmpfs:       opts.Tmpfs,
+		CapDrop:     opts.CapDrop,
+		CapAdd:      opts.CapAdd,
+		SecurityOpt: opts.SecurityOpt,
+	}
+	settings, _ := json.Marshal(struct {
+		ImageID string
+		Options ContainerOptions
+	}{imageID, options})
+	settingsHash := sha256.Sum256(settings)
+
+	return &warmContainer{
+		name: name,
+		labels: map[string]string{
+			labelCommand:   command,
+			labelKeepAlive: cfg.KeepAlive,
+			labelHash:      hex.EncodeToString(settingsHash[:]),
+		},
+		options:    options,
+		entrypoint: entrypoint,
+		cmd:        cmd,
+	}
+}
+
+// matches reports whether a running container with the given labels can be reused.
+func (w *warmContainer) matches(labels map[string]string) bool {
+	return labels[labelHash] == w.labels[labelHash]
+}
+
+// execCommand returns the command line an execution runs, which applies the
+// image's entrypoint and default command like starting a container does.
+func (w *warmContainer) execCommand(opts ContainerOptions) []string {
+	entrypoint, cmd := w.entrypoint, w.cmd
+	if opts.Entrypoint != "" {
+		// Overriding the entrypoint discards the image's default command.
+		entrypoint, cmd = []string{opts.Entrypoint}, nil
+	}
+	if len(opts.Command) > 0 {
+		cmd = opts.Command
+	}
+	return append(append([]string{}, entrypoint...), cmd...)
+}
+
+// labelArgs returns the labels as sorted --label flags.
+func (w *warmContainer) labelArgs() []string {
+	keys := []string{labelCommand, labelHash, labelKeepAlive}
+	args := make([]string, 0, len(keys))
+	for _, key := range keys {
+		args = append(args, fmt.Sprintf("--label=%s=%s", key, w.labels[key]))
+	}
+	return args
+}
+
+// warmRunArgs translates a warm container into detached run arguments, which
+// are compatible with docker run.
+func warmRunArgs(w *warmContainer) []string {
+	args := runArgs(w.options)
+	flags := append([]string{"-d", "--name=" + w.name, "--init"}, w.labelArgs()...)
+	return append(append([]string{args[0]}, flags...), args[1:]...)
+}
+
+// execArgs translates the per-run options into exec arguments for a warm
+// container, which are compatible with docker exec.
+func execArgs(opts ContainerOptions, name string, command []string) []string {
+	args := []string{"exec"}
+	if opts.Interactive {
+		args = append(args, "-i")
+	}
+	if opts.TTY {
+		args = append(args, "-t")
+	}
+	if opts.User != "" {
+		args = append(args, fmt.Sprintf("--user=%s", opts.User))
+	}
+	if opts.WorkingDir != "" {
+		args = append(args, "-w", opts.WorkingDir)
+	}
+	for _, env := range opts.Env {
+		args = append(args, "-e", env)
+	}
+	args = append(args, name)
+	return append(args, command...)
+}
+
+// warmStateDir returns the host directory that records when warm containers
+// were last used.
+func warmStateDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	return filepath.Join(cacheDir, "dox", "warm")
+}
+
+// touchWarm records that a warm container was just used.
+func touchWarm(name string) {
+	path := filepath.Join(warmStateDir(), name)
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logrus.Debugf("Failed to record use of %s: %v", name, err)
+		return
+	}
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		logrus.Debugf("Failed to record use of %s: %v", name, err)
+	}
+}
+
+// isIdle reports whether a warm container hasn't been used for longer than its
+// keep-alive duration. Containers without a record of their use are idle.
+func isIdle(name, keepAlive string) bool {
+	duration, err := time.ParseDuration(keepAlive)
+	if err != nil {
+		return true
+	}
+	info, err := os.Stat(filepath.Join(warmStateDir(), name))
+	if err != nil {
+		return true
+	}
+	return time.Since(info.ModTime()) > duration
+}
+
+// warmManager is the subset of a runtime needed to reap warm containers.
+type warmManager interface {
+	// listWarm returns the names of warm containers with their keep-alive durations.
+	listWarm(ctx context.Context) (map[string]string, error)
+	removeWarm(ctx context.Context, name string) error
+}
+
+// reapIdle removes the warm containers that have been idle for longer than
+// their keep-alive duration, except the one given.
+func reapIdle(ctx context.Context, m warmManager, except string) {
+	containers, err := m.listWarm(ctx)
+	if err != nil {
+		logrus.Debugf("Failed to list warm containers: %v", err)
+		return
+	}
+	for name, duration := range containers {
+		if name == except || !isIdle(name, duration) {
+			continue
+		}
+		logrus.Debugf("Removing idle warm container %s", name)
+		if err := m.removeWarm(ctx, name); err != nil {
+			logrus.Warnf("Failed to remove idle container %s: %v", name, err)
+			continue
+		}
+		_ = os.Remove(filepath.Join(warmStateDir(), name))
+	}
+}
+
+// containerName strips the leading slash the Docker API puts in front of names.
+func containerName(name string) string {
+	return strings.TrimPrefix(name, "/")
+}
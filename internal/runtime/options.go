@@ -0,0 +1,305 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/skorokithakis/dox/internal/config"
+	"golang.org/x/term"
+)
+
+// imageManager is the subset of a runtime needed to prepare a command's image.
+type imageManager interface {
+	imageExists(ctx context.Context, image string) bool
+	BuildImage(ctx context.Context, dockerfileContent string, tag string) error
+	PullImage(ctx context.Context, image string) error
+	RemoveImage(ctx context.Context, image string) error
+}
+
+// hostUser returns the uid and gid of the host user. It is a variable so tests
+// can run as an unprivileged user.
+var hostUser = func() (uid, gid int) {
+	return os.Getuid(), os.Getgid()
+}
+
+// InlineImageName returns the tag of the image built from a command's inline Dockerfile.
+func InlineImageName(command string) string {
+	return fmt.Sprintf("dox-%s:latest", command)
+}
+
+// hasInlineDockerfile reports whether the command builds its own image.
+func hasInlineDockerfile(cfg *config.CommandConfig) bool {
+	return cfg.Build != nil && cfg.Build.DockerfileInline != ""
+}
+
+// imageFor returns the name of the image a command runs in.
+func imageFor(cfg *config.CommandConfig, command string) string {
+	if hasInlineDockerfile(cfg) {
+		return InlineImageName(command)
+	}
+	return cfg.Image
+}
+
+// provideImage pulls or builds a command's image that isn't available locally.
+// Runs don't look images up in advance, but call this when creating the
+// container finds the image missing, which saves a round trip on every run.
+func provideImage(ctx context.Context, m imageManager, cfg *config.CommandConfig, image string) error {
+	if hasInlineDockerfile(cfg) {
+		logrus.Infof("Building image %s from inline Dockerfile...", image)
+		if err := m.BuildImage(ctx, cfg.Build.DockerfileInline, image); err != nil {
+			return &ImageError{Image: image, Err: err}
+		}
+		return nil
+	}
+	logrus.Infof("Pulling image %s...", image)
+	if err := m.PullImage(ctx, image); err != nil {
+		return &ImageError{Image: image, Err: fmt.Errorf("failed to pull image: %w", err)}
+	}
+	return nil
+}
+
+// resolveImage returns the name of a command's image. The image is only pulled
+// or built here when upgrading, as runs find out whether it is missing later on.
+func resolveImage(ctx context.Context, m imageManager, cfg *config.CommandConfig, command string, upgrade bool) (string, error) {
+	if upgrade {
+		return prepareImage(ctx, m, cfg, command, upgrade)
+	}
+	return imageFor(cfg, command), nil
+}
+
+// prepareImage makes sure the command's image is ready and returns its name.
+// Inline Dockerfiles are built once and rebuilt on upgrade, while upgrading a
+// regular image pulls its latest version.
+func prepareImage(ctx context.Context, m imageManager, cfg *config.CommandConfig, command string, upgrade bool) (string, error) {
+	if !hasInlineDockerfile(cfg) {
+		if upgrade {
+			logrus.Infof("Pulling latest version of image %s...", cfg.Image)
+			if err := m.PullImage(ctx, cfg.Image); err != nil {
+				logrus.Warnf("Failed to pull latest image: %v. Using existing image if available.", err)
+			}
+		}
+		return cfg.Image, nil
+	}
+
+	imageName := InlineImageName(command)
+	imageExists := m.imageExists(ctx, imageName)
+
+	if upgrade && imageExists {
+		// Remove the existing image to force rebuild.
+		logrus.Infof("Removing existing image %s for rebuild...", imageName)
+		if err := m.RemoveImage(ctx, imageName); err != nil {
+			logrus.Warnf("Failed to remove existing image: %v", err)
+		}
+		imageExists = false
+	}
+
+	if !imageExists {
+		logrus.Infof("Building image %s from inline Dockerfile...", imageName)
+		if err := m.BuildImage(ctx, cfg.Build.DockerfileInline, imageName); err != nil {
+			return "", &ImageError{Image: imageName, Err: err}
+		}
+	}
+
+	return imageName, nil
+}
+
+// newContainerOptions resolves a command configuration into the container settings
+// shared by all runtimes, so they behave identically.
+func newContainerOptions(cfg *config.CommandConfig, command string, image string, args []string, tty bool) ContainerOptions {
+	opts := ContainerOptions{
+		Image:       image,
+		Entrypoint:  cfg.Entrypoint,
+		UserNS:      cfg.UserNS,
+		GroupAdd:    resolveGroups(cfg.GroupAdd),
+		Interactive: true,
+		TTY:         tty,
+		Network:     cfg.Network,
+	}
+
+	// Run as the host user by default, so files in the workspace keep their owner.
+	// The image's user is kept by leaving the user unset.
+	switch cfg.User {
+	case "", config.UserHost:
+		uid, gid := hostUser()
+		opts.User = fmt.Sprintf("%d:%d", uid, gid)
+	case config.UserRoot:
+		opts.User = "0:0"
+	case config.UserImage:
+	default:
+		opts.User = cfg.User
+	}
+
+	// Make the host user known in the container, and give it a writable home.
+	// The configured environment and volumes come later, so they take precedence.
+	identity := identityFor(cfg, command, image)
+	if identity != nil {
+		opts.Env = append(opts.Env, identity.env()...)
+	}
+	if cfg.HostTimezone {
+		name, localtime := hostTimezone()
+		if name != "" {
+			opts.Env = append(opts.Env, "TZ="+name)
+		}
+		if localtime != "" {
+			opts.Volumes = append(opts.Volumes, localtime+":/etc/localtime:ro")
+		}
+	}
+	if cfg.HostLocale {
+		opts.Env = append(opts.Env, hostLocale()...)
+	}
+
+	// Pass through the environment variables that are set on the host. Entries
+	// with an explicit value are set as is.
+	for _, envVar := range cfg.Environment {
+		if strings.Contains(envVar, "=") {
+			opts.Env = append(opts.Env, envVar)
+		} else if value := os.Getenv(envVar); value != "" {
+			opts.Env = append(opts.Env, fmt.Sprintf("%s=%s", envVar, value))
+		}
+	}
+
+	// Mount the workspace unless it is copied in. Copied workspaces are
+	// synced back after exit, so the container must outlive its process.
+	// Sandboxed runs mount their copy of the workspace in its place, while
+	// paths are still worked out from the real one.
+	cwd, _ := os.Getwd()
+	workspace := resolveWorkspace(cfg.Workspace, cwd)
+	if cfg.Workspace == config.WorkspaceCopy {
+		opts.Remove = false
+	} else {
+		source := workspace.hostRoot
+		if cfg.Sandbox != "" {
+			source = cfg.Sandbox
+		}
+		opts.Volumes = append(opts.Volumes, fmt.Sprintf("%s:%s", source, workspace.containerRoot))
+		opts.Remove = true
+	}
+	if identity != nil {
+		opts.Volumes = append(opts.Volumes, identity.volumes()...)
+	}
+	opts.Volumes = append(opts.Volumes, cfg.Volumes...)
+
+	// An explicit working directory always wins, and "image" keeps the image's
+	// WORKDIR. Otherwise, the cwd and copy modes work in the workspace, except
+	// with an inline Dockerfile, whose WORKDIR instruction takes precedence. The
+	// mirror and git-root modes are about where the command runs, so they always
+	// set the working directory.
+	switch {
+	case cfg.WorkingDir == config.WorkingDirImage:
+	case cfg.WorkingDir != "":
+		opts.WorkingDir = cfg.WorkingDir
+	case cfg.Workspace == config.WorkspaceMirror || cfg.Workspace == config.WorkspaceGitRoot:
+		opts.WorkingDir = workspace.workingDir
+	case !hasInlineDockerfile(cfg):
+		opts.WorkingDir = workspace.workingDir
+	}
+
+	applyResources(&opts, cfg.Resources)
+	applyStop(&opts, cfg)
+	applySecurity(&opts, cfg.Security)
+	// A read-only root filesystem refuses the copied workspace, so it gets an
+	// anonymous volume to be copied into instead.
+	if cfg.Workspace == config.WorkspaceCopy && opts.ReadOnly {
+		opts.Volumes = append(opts.Volumes, workspace.containerRoot)
+	}
+	applyEgress(&opts, cfg.Egress)
+	applyForward(&opts, cfg.Forward)
+	applyGUI(&opts, cfg.GUI, cfg.Audio)
+	applyDevices(&opts, cfg.Devices)
+	opts.EngineSocket = cfg.EngineSocket
+
+	// Ports are meaningless on the host network.
+	if opts.Network != "host" {
+		opts.Ports = cfg.Ports
+	}
+
+	// Make host paths given as arguments available in the container.
+	if cfg.PathArgs == config.PathArgsAuto {
+		translator := newPathTranslator(cwd, workspace, opts.WorkingDir, cfg.PathArgsAllow, cfg.PathArgsDeny, cfg.PathArgsWrite)
+		args = translator.translateArgs(args)
+		opts.Volumes = append(opts.Volumes, translator.volumes...)
+		opts.HostPaths = translator.volumes
+	}
+
+	// Only pass args if provided, let container use its default ENTRYPOINT/CMD.
+	if cfg.Command != "" {
+		opts.Command = append([]string{cfg.Command}, args...)
+	} else if len(args) > 0 {
+		opts.Command = args
+	}
+
+	return opts
+}
+
+// isHostUser reports whether a command runs as the host user.
+func isHostUser(cfg *config.CommandConfig) bool {
+	return cfg.User == "" || cfg.User == config.UserHost
+}
+
+// resolveGroups turns the names of supplementary groups into the host's GIDs,
+// since the container's groups don't match the host's. Names that aren't host
+// groups, like podman's keep-groups, are passed through.
+func resolveGroups(groups []string) []string {
+	var resolved []string
+	for _, group := range groups {
+		if _, err := strconv.Atoi(group); err != nil {
+			if g, err := user.LookupGroup(group); err == nil {
+				group = g.Gid
+			}
+		}
+		resolved = append(resolved, group)
+	}
+	return resolved
+}
+
+// workspaceOwner returns the owner of files copied into the container, which is
+// the container's user if it is numeric and the host user otherwise.
+func workspaceOwner(containerUser string) (uid, gid int) {
+	uid, gid = hostUser()
+	name, group, hasGroup := strings.Cut(containerUser, ":")
+	parsedUID, err := strconv.Atoi(name)
+	if err != nil {
+		return uid, gid
+	}
+	// A uid without a group gets the user's primary group, which is unknown, so
+	// use the matching gid like most images do.
+	parsedGID := parsedUID
+	if hasGroup {
+		if parsedGID, err = strconv.Atoi(group); err != nil {
+			return uid, gid
+		}
+	}
+	return parsedUID, parsedGID
+}
+
+// unsupported returns an error if the runtime with the given CLI binary can't
+// run a command as configured.
+func unsupported(binary string, cfg *config.CommandConfig) error {
+	if binary == "podman" && cfg.Workspace == config.WorkspaceCopy {
+		return &ContainerError{Err: fmt.Errorf("workspace mode '%s' is not supported by the podman runtime", cfg.Workspace)}
+	}
+	if binary == "docker" && cfg.UserNS != "" && cfg.UserNS != "host" {
+		return &ContainerError{Err: fmt.Errorf("userns mode '%s' is not supported by the docker runtime", cfg.UserNS)}
+	}
+	return nil
+}
+
+// isTerminal checks if both stdin and stdout are terminals.
+func isTerminal(stdin io.Reader, stdout io.Writer) bool {
+	stdinFile, ok := stdin.(*os.File)
+	if !ok {
+		return false
+	}
+	stdoutFile, ok := stdout.(*os.File)
+	if !ok {
+		return false
+	}
+	// Both stdin and stdout should be terminals for interactive mode.
+	return term.IsTerminal(int(stdinFile.Fd())) && term.IsTerminal(int(stdoutFile.Fd()))
+}
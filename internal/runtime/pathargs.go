@@ -0,0 +1,164 @@
+package runtime
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// hostPathsDir is the directory in the container where host paths given as
+// arguments are mounted, at their host path.
+const hostPathsDir = "/host"
+
+// pathTranslator rewrites arguments that are existing host paths into container
+// paths, and collects the volumes that make them available.
+type pathTranslator struct {
+	// cwd is the host directory relative arguments are resolved against.
+	cwd string
+	// home is the host user's home directory, which is never mounted whole.
+	home      string
+	workspace workspaceLayout
+	// workingDir is the container's working directory.
+	workingDir string
+	allow      map[string]bool
+	deny       map[string]bool
+	write      bool
+
+	volumes []string
+	mounted map[string]bool
+}
+
+// newPathTranslator creates a translator for a command's arguments.
+func newPathTranslator(cwd string, workspace workspaceLayout, workingDir string, allow, deny []string, write bool) *pathTranslator {
+	home, _ := os.UserHomeDir()
+	t := &pathTranslator{
+		cwd:        cwd,
+		home:       home,
+		workspace:  workspace,
+		workingDir: workingDir,
+		allow:      make(map[string]bool),
+		deny:       make(map[string]bool),
+		write:      write,
+		mounted:    make(map[string]bool),
+	}
+	for _, flag := range allow {
+		t.allow[flag] = true
+	}
+	for _, flag := range deny {
+		t.deny[flag] = true
+	}
+	return t
+}
+
+// translateArgs returns the arguments with host paths rewritten. Standalone
+// arguments are translated unless they follow a denied flag, while the values of
+// --flag=value arguments are only translated for allowed flags. Everything after
+// "--" is a standalone argument.
+func (t *pathTranslator) translateArgs(args []string) []string {
+	translated := make([]string, len(args))
+	endOfFlags := false
+	for i, arg := range args {
+		translated[i] = arg
+		switch {
+		case endOfFlags:
+			translated[i] = t.translate(arg)
+		case arg == "--":
+			endOfFlags = true
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			if flag, value, ok := strings.Cut(arg, "="); ok && t.allow[flag] && !t.deny[flag] {
+				translated[i] = flag + "=" + t.translate(value)
+			}
+		case i > 0 && t.deny[args[i-1]]:
+			// The value of a denied flag.
+		default:
+			translated[i] = t.translate(arg)
+		}
+	}
+	return translated
+}
+
+// translate rewrites a single argument if it is an existing host path.
+func (t *pathTranslator) translate(arg string) string {
+	if arg == "" || arg == "-" {
+		return arg
+	}
+
+	hostPath := filepath.Clean(arg)
+	if !filepath.IsAbs(hostPath) {
+		hostPath = filepath.Join(t.cwd, hostPath)
+	}
+	if _, err := os.Stat(hostPath); err != nil {
+		return arg
+	}
+
+	// Paths in the workspace are already available. Relative ones still work
+	// if the command runs in the container's counterpart of the cwd.
+	if rel, ok := relativePath(t.workspace.hostRoot, hostPath); ok {
+		if !filepath.IsAbs(arg) && t.workingDir == t.workspace.workingDir {
+			return arg
+		}
+		return path.Join(t.workspace.containerRoot, filepath.ToSlash(rel))
+	}
+
+	if t.tooBroad(hostPath) {
+		logrus.Warnf("Not mounting %s for argument %q: it would expose the root, home or working directory", hostPath, arg)
+		return arg
+	}
+
+	// Mount other paths read-only, unless writes are allowed.
+	mode := ":ro"
+	if t.write {
+		mode = ""
+	}
+	if !t.mounted[hostPath] {
+		t.mounted[hostPath] = true
+		t.volumes = append(t.volumes, hostPath+":"+hostContainerPath(hostPath)+mode)
+	}
+	return hostContainerPath(hostPath)
+}
+
+// tooBroad reports whether a host path is a filesystem root, or the home or
+// working directory or one of their parents, which are never mounted. Symlinks
+// are resolved, because the mount would follow them.
+func (t *pathTranslator) tooBroad(hostPath string) bool {
+	paths := []string{hostPath}
+	if resolved, err := filepath.EvalSymlinks(hostPath); err == nil && resolved != hostPath {
+		paths = append(paths, resolved)
+	}
+	for _, p := range paths {
+		if filepath.Dir(p) == p {
+			return true
+		}
+		for _, protected := range []string{t.home, t.cwd} {
+			if _, ok := relativePath(p, protected); ok && protected != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// relativePath returns target relative to base if it is inside it.
+func relativePath(base, target string) (string, bool) {
+	rel, err := filepath.Rel(base, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// hostContainerPath returns where a host path is mounted in the container.
+func hostContainerPath(hostPath string) string {
+	return containerPathUnder(hostPathsDir, hostPath)
+}
+
+// containerPathUnder returns the container path for a host path under dir.
+// Windows drives become a directory, like /host/C/Users.
+func containerPathUnder(dir, hostPath string) string {
+	volume := filepath.VolumeName(hostPath)
+	rest := filepath.ToSlash(strings.TrimPrefix(hostPath, volume))
+	return path.Join(dir, strings.TrimSuffix(volume, ":"), rest)
+}
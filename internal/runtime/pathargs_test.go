@@ -0,0 +1,139 @@
+package runtime
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+// pathArgsFixture creates a project directory and files outside of it.
+func pathArgsFixture(t *testing.T) (root, cwd string) {
+	t.Helper()
+	root = t.TempDir()
+	cwd = filepath.Join(root, "project")
+	for _, dir := range []string{filepath.Join(cwd, "src"), filepath.Join(root, "data")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{filepath.Join(cwd, "src", "main.py"), filepath.Join(root, "script.py"), filepath.Join(root, "data", "input.json")} {
+		if err := os.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, cwd
+}
+
+func TestTranslatePathArgs(t *testing.T) {
+	root, cwd := pathArgsFixture(t)
+	script := filepath.Join(root, "script.py")
+	input := filepath.Join(root, "data", "input.json")
+
+	translator := newPathTranslator(cwd, resolveWorkspace(config.WorkspaceCwd, cwd), "/workspace", []string{"--config"}, []string{"-m"}, false)
+	args := translator.translateArgs([]string{
+		"src/main.py",
+		filepath.Join(cwd, "src", "main.py"),
+		script,
+		"../data",
+		"--config=" + input,
+		"--output=" + input,
+		"-m", script,
+		"missing.txt",
+		"-",
+		"--", "-m", script,
+	})
+
+	expected := []string{
+		"src/main.py",
+		"/workspace/src/main.py",
+		"/host" + script,
+		"/host" + filepath.Join(root, "data"),
+		"--config=/host" + input,
+		"--output=" + input,
+		"-m", script,
+		"missing.txt",
+		"-",
+		"--", "-m", "/host" + script,
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("translateArgs() = %v, want %v", args, expected)
+	}
+
+	volumes := []string{
+		script + ":/host" + script + ":ro",
+		filepath.Join(root, "data") + ":/host" + filepath.Join(root, "data") + ":ro",
+		input + ":/host" + input + ":ro",
+	}
+	if !reflect.DeepEqual(translator.volumes, volumes) {
+		t.Errorf("volumes = %v, want %v", translator.volumes, volumes)
+	}
+}
+
+func TestTranslatePathArgsWritable(t *testing.T) {
+	root, cwd := pathArgsFixture(t)
+	input := filepath.Join(root, "data", "input.json")
+
+	// With the image's working directory, relative workspace paths are made absolute.
+	translator := newPathTranslator(cwd, resolveWorkspace(config.WorkspaceCwd, cwd), "", nil, nil, true)
+	args := translator.translateArgs([]string{"src/main.py", input, filepath.Join(root, "data")})
+
+	expected := []string{"/workspace/src/main.py", "/host" + input, "/host" + filepath.Join(root, "data")}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("translateArgs() = %v, want %v", args, expected)
+	}
+	// Files are mounted read-write themselves, not their parent directory.
+	volumes := []string{
+		input + ":/host" + input,
+		filepath.Join(root, "data") + ":/host" + filepath.Join(root, "data"),
+	}
+	if !reflect.DeepEqual(translator.volumes, volumes) {
+		t.Errorf("volumes = %v, want %v", translator.volumes, volumes)
+	}
+}
+
+func TestTranslatePathArgsGitRoot(t *testing.T) {
+	root, cwd := pathArgsFixture(t)
+	if err := os.Mkdir(filepath.Join(root, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	workspace := resolveWorkspace(config.WorkspaceGitRoot, cwd)
+	translator := newPathTranslator(cwd, workspace, workspace.workingDir, nil, nil, false)
+	args := translator.translateArgs([]string{"src/main.py", "../script.py", filepath.Join(root, "data")})
+
+	// The whole repository is in the workspace, so nothing needs mounting, and
+	// relative paths work from the subdirectory.
+	expected := []string{"src/main.py", "../script.py", "/workspace/data"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("translateArgs() = %v, want %v", args, expected)
+	}
+	if len(translator.volumes) != 0 {
+		t.Errorf("volumes = %v, want none", translator.volumes)
+	}
+}
+
+func TestTranslatePathArgsTooBroad(t *testing.T) {
+	root, cwd := pathArgsFixture(t)
+	home := filepath.Join(root, "data")
+	t.Setenv("HOME", home)
+	input := filepath.Join(home, "input.json")
+	if err := os.Symlink("/", filepath.Join(root, "root")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The root, home directory, their parents and the working directory's are
+	// passed through, while files in the home directory are mounted alone.
+	translator := newPathTranslator(cwd, resolveWorkspace(config.WorkspaceCwd, cwd), "/workspace", nil, nil, true)
+	args := translator.translateArgs([]string{"/", home, root, "..", filepath.Join(root, "root"), input})
+
+	expected := []string{"/", home, root, "..", filepath.Join(root, "root"), "/host" + input}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("translateArgs() = %v, want %v", args, expected)
+	}
+	if volumes := []string{input + ":/host" + input}; !reflect.DeepEqual(translator.volumes, volumes) {
+		t.Errorf("volumes = %v, want %v", translator.volumes, volumes)
+	}
+}
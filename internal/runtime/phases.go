@@ -0,0 +1,37 @@
+package runtime
+
+import "time"
+
+// Names of the phases runs are split into.
+const (
+	// PhaseImage resolves the image, which is only pulled or built here when upgrading.
+	PhaseImage = "image"
+	// PhaseCreate creates the container, pulling or building a missing image first.
+	PhaseCreate = "create"
+	// PhaseStart attaches to the container and starts it.
+	PhaseStart = "start"
+	// PhaseContainer finds or starts a warm container.
+	PhaseContainer = "container"
+	// PhaseRun lasts until the container exits. Podman creates and starts the
+	// container in this phase too.
+	PhaseRun = "run"
+	// PhaseExec lasts until an execution in a warm container exits.
+	PhaseExec = "exec"
+)
+
+// phaseTimer splits the time a run takes into phases.
+type phaseTimer struct {
+	last   time.Time
+	phases []Phase
+}
+
+func newPhaseTimer() *phaseTimer {
+	return &phaseTimer{last: time.Now()}
+}
+
+// mark ends the current phase, which started when the previous one ended.
+func (p *phaseTimer) mark(name string) {
+	now := time.Now()
+	p.phases = append(p.phases, Phase{Name: name, Duration: now.Sub(p.last)})
+	p.last = now
+}
@@ -0,0 +1,105 @@
+package runtime
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+func TestExecuteCommandPhases(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	tests := []struct {
+		keepAlive string
+		phases    map[string][]string
+	}{
+		{"", map[string][]string{
+			"docker": {PhaseImage, PhaseCreate, PhaseStart, PhaseRun},
+			"podman": {PhaseImage, PhaseRun},
+		}},
+		{"10m", map[string][]string{
+			"docker": {PhaseImage, PhaseContainer, PhaseExec},
+			"podman": {PhaseImage, PhaseContainer, PhaseExec},
+		}},
+	}
+	for _, tt := range tests {
+		for _, backend := range conformanceBackends {
+			h := backend.setup(t)
+			h.AddImage("alpine")
+			result, err := h.Runtime().ExecuteCommand(context.Background(), &config.CommandConfig{Image: "alpine", KeepAlive: tt.keepAlive}, "alpine", nil, false, strings.NewReader(""), io.Discard, io.Discard)
+			if err != nil {
+				t.Fatalf("%s: ExecuteCommand() error = %v", backend.name, err)
+			}
+			var names []string
+			for _, phase := range result.Phases {
+				names = append(names, phase.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.phases[backend.name], ",") {
+				t.Errorf("%s with keep_alive %q: phases = %v, want %v", backend.name, tt.keepAlive, names, tt.phases[backend.name])
+			}
+		}
+	}
+}
+
+func TestExecuteCommandDefersImageLookup(t *testing.T) {
+	fake := newFakeDocker(t)
+	fake.AddImage("alpine")
+	rt := fake.runtime()
+	if _, err := rt.ExecuteCommand(context.Background(), &config.CommandConfig{Image: "alpine"}, "alpine", nil, false, strings.NewReader(""), io.Discard, io.Discard); err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+	for _, request := range fake.Requests() {
+		if strings.HasPrefix(request, "GET /images/") || request == "GET /_ping" || request == "HEAD /_ping" {
+			t.Errorf("request %s, want runs not to check the image or the daemon up front", request)
+		}
+	}
+
+	// A missing inline image is built once creating the container finds it missing.
+	cfg := &config.CommandConfig{Build: &config.BuildConfig{DockerfileInline: "FROM alpine"}}
+	if _, err := rt.ExecuteCommand(context.Background(), cfg, "tool", nil, false, strings.NewReader(""), io.Discard, io.Discard); err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+	if builds := fake.Builds(); len(builds) != 1 {
+		t.Errorf("builds = %v, want the missing inline image built", builds)
+	}
+}
+
+// BenchmarkExecuteCommand tracks the overhead of each phase of a run against the
+// fake backends, which respond instantly. The phases are reported as metrics.
+func BenchmarkExecuteCommand(b *testing.B) {
+	for _, backend := range conformanceBackends {
+		for _, keepAlive := range []string{"", "10m"} {
+			name := backend.name
+			if keepAlive != "" {
+				name += "/keep_alive"
+			}
+			setup := backend.setup
+			b.Run(name, func(b *testing.B) {
+				b.Setenv("XDG_CACHE_HOME", b.TempDir())
+				h := setup(b)
+				h.AddImage("alpine")
+				rt := h.Runtime()
+				cfg := &config.CommandConfig{Image: "alpine", KeepAlive: keepAlive}
+
+				totals := make(map[string]time.Duration)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					result, err := rt.ExecuteCommand(context.Background(), cfg, "alpine", nil, false, strings.NewReader(""), io.Discard, io.Discard)
+					if err != nil {
+						b.Fatal(err)
+					}
+					for _, phase := range result.Phases {
+						totals[phase.Name] += phase.Duration
+					}
+				}
+				for phase, total := range totals {
+					b.ReportMetric(float64(total.Nanoseconds())/float64(b.N), phase+"-ns/op")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package runtime
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/skorokithakis/dox/internal/config"
+	"github.com/skorokithakis/dox/internal/utils"
+)
+
+// maskedValue replaces secret values in explained command lines.
+const maskedValue = "****"
+
+// secretName matches the names of environment variables whose values are masked.
+var secretName = regexp.MustCompile(`(?i)(TOKEN|SECRET|PASSW|PASSPHRASE|KEY|CREDENTIAL|AUTH|COOKIE|SESSION|PRIVATE)`)
+
+// urlUserinfo matches the user information of URLs, like user:password@ in
+// postgres://user:password@db/app, with the scheme in its first group.
+var urlUserinfo = regexp.MustCompile(`(?i)([a-z][a-z0-9+.-]*://)([^/?#@\s]+)@`)
+
+// safeShellWord matches words that don't need quoting in a shell.
+var safeShellWord = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// Plan describes what ExecuteCommand would do for a command, so it can be shown
+// instead of run.
+type Plan struct {
+	// Binary is the CLI of the runtime, docker or podman.
+	Binary string
+	// Host is the Docker daemon the command runs on, if not the default.
+	Host string
+	// RemoveImage is the image removed before it is rebuilt, if any.
+	RemoveImage string
+	// BuildTag and Dockerfile describe the image built from an inline Dockerfile, if any.
+	BuildTag   string
+	Dockerfile string
+	// Pull is the image pulled before running, if any.
+	Pull string
+	// Options are the settings of the container.
+	Options ContainerOptions
+	// CopyWorkspace reports whether the workspace is copied instead of mounted.
+	CopyWorkspace bool
+	// WarmContainer is the name of the long-lived container the command is
+	// executed in, if it has a keep-alive duration.
+	WarmContainer string
+
+	warm *warmContainer
+}
+
+// NewPlan works out what a runtime with the given CLI binary would do to run a
+// command. The exists function reports whether an image is available locally.
+func NewPlan(binary string, exists func(image string) bool, cfg *config.CommandConfig, command string, args []string, upgrade bool, tty bool) (*Plan, error) {
+	if err := unsupported(binary, cfg); err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{Binary: binary, CopyWorkspace: cfg.Workspace == config.WorkspaceCopy}
+	if binary == "docker" {
+		plan.Host = cfg.DockerHost
+	}
+
+	image := imageFor(cfg, command)
+	if hasInlineDockerfile(cfg) {
+		imageExists := exists(image)
+		if upgrade && imageExists {
+			plan.RemoveImage = image
+		}
+		if upgrade || !imageExists {
+			plan.BuildTag = image
+			plan.Dockerfile = cfg.Build.DockerfileInline
+		}
+	} else if upgrade || !exists(image) {
+		plan.Pull = image
+	}
+
+	plan.Options = newContainerOptions(cfg, command, image, args, tty)
+	// Like when running it, path arguments that need mounts are run in a
+	// container of their own.
+	if keepAlive(cfg) > 0 && len(plan.Options.HostPaths) == 0 {
+		// The image isn't inspected, so its ID and entrypoint are unknown.
+		plan.warm = newWarmContainer(cfg, command, plan.Options, "", nil, nil)
+		plan.WarmContainer = plan.warm.name
+	}
+	return plan, nil
+}
+
+// String renders the plan as shell commands for the runtime's CLI, with the
+// values of secret-looking environment variables masked.
+func (p *Plan) String() string {
+	var lines []string
+
+	if p.RemoveImage != "" {
+		lines = append(lines, p.commandLine("rmi", p.RemoveImage))
+	}
+	if p.BuildTag != "" {
+		lines = append(lines, p.commandLine("build", "-t", p.BuildTag, "-")+" <<'EOF'")
+		lines = append(lines, strings.TrimRight(p.Dockerfile, "\n"), "EOF")
+	}
+	if p.Pull != "" {
+		lines = append(lines, p.commandLine("pull", p.Pull))
+	}
+	if p.Options.Timeout > 0 {
+		lines = append(lines, p.timeoutComment())
+	}
+	if len(p.Options.EgressAllow) > 0 {
+		lines = append(lines,
+			"# Created unless it exists, as a network without outside access.",
+			p.commandLine("network", "create", "--internal", egressNetwork),
+			egressComment(p.Options),
+		)
+	}
+	if len(p.Options.Forward) > 0 {
+		lines = append(lines, forwardComment(p.Options))
+	}
+	if p.Options.GUI != "" || p.Options.Audio {
+		lines = append(lines, guiComment(p.Options))
+	}
+	if p.Options.EngineSocket {
+		lines = append(lines, engineComment())
+	}
+
+	if p.warm != nil {
+		// The settings hash depends on the image's ID, so it isn't shown.
+		var run []string
+		for _, arg := range warmRunArgs(p.warm) {
+			if !strings.HasPrefix(arg, "--label="+labelHash+"=") {
+				run = append(run, arg)
+			}
+		}
+		exec := execArgs(p.Options, p.warm.name, p.warm.execCommand(p.Options))
+		maskEnvArgs(exec)
+		lines = append(lines,
+			"# Started unless a warm container with the same settings is running.",
+			p.commandLine(run...),
+			"# The image's entrypoint and default command apply to the executed command.",
+			p.commandLine(exec...),
+		)
+		return strings.Join(lines, "\n") + "\n"
+	}
+
+	args := runArgs(p.Options)
+	maskEnvArgs(args)
+
+	if !p.CopyWorkspace {
+		lines = append(lines, p.commandLine(args...))
+		return strings.Join(lines, "\n") + "\n"
+	}
+
+	// There is no single command for copied workspaces, so spell out the steps.
+	args[0] = "create"
+	start := []string{"start", "-a"}
+	if p.Options.Interactive {
+		start = append(start, "-i")
+	}
+	lines = append(lines,
+		"# The workspace is copied in and synced back, except for ignored paths.",
+		"id=$("+p.commandLine(args...)+")",
+		p.commandLine("cp", "./.")+` "$id":`+workspaceDir,
+		p.commandLine(start...)+` "$id"`,
+		p.commandLine("cp")+` "$id":`+workspaceDir+"/. .",
+		p.commandLine("rm", "-fv")+` "$id"`,
+	)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// timeoutComment describes how dox stops the command when it times out, which
+// the runtime's CLI has no flag for.
+func (p *Plan) timeoutComment() string {
+	signal, grace := p.Options.StopSignal, p.Options.StopGracePeriod
+	if signal == "" {
+		signal = utils.DefaultStopSignal
+	}
+	if grace == 0 {
+		grace = utils.DefaultGracePeriod
+	}
+	return fmt.Sprintf("# Sent %s after %s, and killed if it is still running %s later.", signal, p.Options.Timeout, grace)
+}
+
+// commandLine returns a shell command line for the runtime's CLI.
+func (p *Plan) commandLine(args ...string) string {
+	words := []string{p.Binary}
+	if p.Host != "" {
+		words = append(words, "--host", shellQuote(maskURLs(p.Host)))
+	}
+	for _, arg := range args {
+		words = append(words, shellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// maskEnvArgs masks the secret values of -e arguments in place.
+func maskEnvArgs(args []string) {
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "-e" {
+			args[i+1] = maskEnv(args[i+1])
+		}
+	}
+}
+
+// maskEnv masks the value of an environment variable entry if its name looks
+// secret, and the credentials of URLs in it otherwise.
+func maskEnv(entry string) string {
+	name, value, ok := strings.Cut(entry, "=")
+	if !ok || value == "" {
+		return entry
+	}
+	if secretName.MatchString(name) {
+		return name + "=" + maskedValue
+	}
+	return name + "=" + maskURLs(value)
+}
+
+// maskURLs masks the passwords of URLs in text, or their user names if they
+// have no password, since those are often tokens.
+func maskURLs(text string) string {
+	return urlUserinfo.ReplaceAllStringFunc(text, func(match string) string {
+		groups := urlUserinfo.FindStringSubmatch(match)
+		if user, _, ok := strings.Cut(groups[2], ":"); ok {
+			return groups[1] + user + ":" + maskedValue + "@"
+		}
+		return groups[1] + maskedValue + "@"
+	})
+}
+
+// shellQuote quotes a word for a POSIX shell if needed.
+func shellQuote(word string) string {
+	if safeShellWord.MatchString(word) {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
@@ -2,11 +2,15 @@ package runtime
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 	"github.com/skorokithakis/dox/internal/config"
@@ -14,144 +18,388 @@ import (
 )
 
 // PodmanRuntime implements the Runtime interface for Podman.
-type PodmanRuntime struct{}
+type PodmanRuntime struct {
+	binary string
+}
 
 // NewPodmanRuntime creates a new Podman runtime.
 func NewPodmanRuntime() (*PodmanRuntime, error) {
-	return &PodmanRuntime{}, nil
+	return &PodmanRuntime{
+		binary: "podman",
+	}, nil
 }
 
 // IsAvailable checks if Podman is available.
 func (r *PodmanRuntime) IsAvailable(ctx context.Context) error {
-	cmd := exec.CommandContext(ctx, "podman", "version")
+	cmd := exec.CommandContext(ctx, r.binary, "version")
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("Podman not available. Is Podman installed?")
+		return &UnavailableError{Err: fmt.Errorf("Podman not available. Is Podman installed?")}
 	}
 	return nil
 }
 
 // ExecuteCommand runs a command in a Podman container.
-func (r *PodmanRuntime) ExecuteCommand(ctx context.Context, cfg *config.CommandConfig, command string, args []string, upgrade bool, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
-	// Build image if inline Dockerfile is provided.
-	if cfg.Build != nil && cfg.Build.DockerfileInline != "" {
-		imageName := fmt.Sprintf("dox-%s:latest", command)
-		
-		if upgrade {
-			// Remove existing image to force rebuild.
-			logrus.Infof("Removing existing image %s for rebuild...", imageName)
-			removeCmd := exec.CommandContext(ctx, "podman", "rmi", imageName)
-			if err := removeCmd.Run(); err != nil {
-				// Image might not exist, which is fine.
-				logrus.Debugf("Image removal failed (might not exist): %v", err)
-			}
-		}
-		
-		if err := r.BuildImage(ctx, cfg.Build.DockerfileInline, imageName); err != nil {
-			return 1, err
-		}
-		cfg.Image = imageName
-	} else if upgrade {
-		// Force pull if upgrade flag is set and it's not a locally built image.
-		logrus.Infof("Pulling latest version of image %s...", cfg.Image)
-		if err := r.PullImage(ctx, cfg.Image); err != nil {
-			logrus.Warnf("Failed to pull latest image: %v. Using existing image if available.", err)
-		}
-	}
-
-	// Prepare Podman run arguments.
-	podmanArgs := []string{"run", "--rm", "-it"}
+func (r *PodmanRuntime) ExecuteCommand(ctx context.Context, cfg *config.CommandConfig, command string, args []string, upgrade bool, stdin io.Reader, stdout, stderr io.Writer) (ExecResult, error) {
+	result := ExecResult{ExitCode: 1}
 
-	// Set terminal size if TTY is enabled
-	width, height := utils.GetTerminalSize()
-	podmanArgs = append(podmanArgs, fmt.Sprintf("--env=COLUMNS=%d", width))
-	podmanArgs = append(podmanArgs, fmt.Sprintf("--env=LINES=%d", height))
+	if err := unsupported("podman", cfg); err != nil {
+		return result, err
+	}
+	if keepAlive(cfg) > 0 {
+		return r.executeWarm(ctx, cfg, command, args, upgrade, stdin, stdout, stderr)
+	}
 
-	// Network mode - only set if specified.
-	if cfg.Network != "" {
-		podmanArgs = append(podmanArgs, fmt.Sprintf("--network=%s", cfg.Network))
+	timer := newPhaseTimer()
+	image, err := resolveImage(ctx, r, cfg, command, upgrade)
+	if err != nil {
+		return result, err
 	}
 
-	// Port mappings - only add if specified and not using host network.
-	if len(cfg.Ports) > 0 && cfg.Network != "host" {
-		for _, port := range cfg.Ports {
-			podmanArgs = append(podmanArgs, "-p", port)
+	// Provide missing images up front, like Docker does when the container
+	// can't be created, so failing to pull them is reported as such.
+	if !r.imageExists(ctx, image) {
+		if err := provideImage(ctx, r, cfg, image); err != nil {
+			return result, err
 		}
 	}
+	timer.mark(PhaseImage)
 
-	// User mapping.
-	uid := os.Getuid()
-	gid := os.Getgid()
-	podmanArgs = append(podmanArgs, fmt.Sprintf("--user=%d:%d", uid, gid))
-
-	// Only set working directory if no inline Dockerfile is provided.
-	// When using inline Dockerfile, let the WORKDIR instruction in the Dockerfile take precedence.
-	if cfg.Build == nil || cfg.Build.DockerfileInline == "" {
-		podmanArgs = append(podmanArgs, "-w", "/workspace")
+	opts := newContainerOptions(cfg, command, image, args, isTerminal(stdin, stdout))
+	if err := prepareIdentity(ctx, r, cfg, command, image); err != nil {
+		return result, err
 	}
-
-	// Volume mounts - always mount current directory.
-	cwd, _ := os.Getwd()
-	podmanArgs = append(podmanArgs, "-v", fmt.Sprintf("%s:/workspace", cwd))
-	for _, volume := range cfg.Volumes {
-		podmanArgs = append(podmanArgs, "-v", volume)
+	if _, err := seccompProfile(opts); err != nil {
+		return result, err
 	}
-
-	// Environment variables.
-	for _, envVar := range cfg.Environment {
-		if value := os.Getenv(envVar); value != "" {
-			podmanArgs = append(podmanArgs, "-e", fmt.Sprintf("%s=%s", envVar, value))
-		}
+	if err := mountEngineSocket(ctx, r, &opts); err != nil {
+		return result, err
 	}
+	stopEgress, err := startEgress(ctx, r, &opts)
+	if err != nil {
+		return result, err
+	}
+	defer stopEgress()
+	stopForward, err := startForward(&opts)
+	if err != nil {
+		return result, err
+	}
+	defer stopForward()
+	stopGUI, err := startGUI(&opts)
+	if err != nil {
+		return result, err
+	}
+	defer stopGUI()
 
-	// Image.
-	podmanArgs = append(podmanArgs, cfg.Image)
-
-	// Command and arguments.
-	if cfg.Command != "" {
-		podmanArgs = append(podmanArgs, cfg.Command)
-		podmanArgs = append(podmanArgs, args...)
-	} else if len(args) > 0 {
-		// Only pass args if provided, let container use its default ENTRYPOINT/CMD.
-		podmanArgs = append(podmanArgs, args...)
+	// Have podman write the container ID to a file, so it can be reported and
+	// cleaned up.
+	cidDir, err := os.MkdirTemp("", "dox-cid")
+	if err != nil {
+		return result, &ContainerError{Err: fmt.Errorf("failed to create container ID directory: %w", err)}
+	}
+	defer os.RemoveAll(cidDir)
+	cidFile := filepath.Join(cidDir, "cid")
+
+	// Remove containers with a memory limit after inspecting them, instead of
+	// having podman remove them on exit.
+	keep := keepForInspection(opts)
+	if keep {
+		opts.Remove = false
 	}
 
-	// Check if we should setup terminal raw mode.
-	isInteractive := false
-	for _, arg := range podmanArgs {
-		if arg == "-it" {
-			isInteractive = true
-			break
+	podmanArgs := runArgs(opts)
+	podmanArgs = append([]string{podmanArgs[0], "--cidfile=" + cidFile}, podmanArgs[1:]...)
+	result, err = r.run(ctx, opts, podmanArgs, cidFile, stdin, stdout, stderr)
+	if keep && result.ContainerID != "" {
+		if err == nil && result.ExitCode == oomExitCode {
+			result.OOMKilled = r.oomKilled(result.ContainerID)
 		}
+		_ = exec.Command(r.binary, "rm", "-f", result.ContainerID).Run()
 	}
+	if err == nil {
+		timer.mark(PhaseRun)
+		result.Phases = timer.phases
+	}
+	return result, err
+}
+
+// run runs podman in the foreground and reports how the container, which was
+// started with opts, finished. The container's ID is read from cidFile.
+func (r *PodmanRuntime) run(ctx context.Context, opts ContainerOptions, podmanArgs []string, cidFile string, stdin io.Reader, stdout, stderr io.Writer) (ExecResult, error) {
+	result := ExecResult{ExitCode: 1}
 
 	// Setup terminal raw mode for interactive containers.
-	if isInteractive {
+	if opts.TTY {
 		oldTermState, _ := utils.SetupTerminal()
 		defer utils.RestoreTerminal(oldTermState)
 	}
 
 	// Execute Podman.
-	cmd := exec.CommandContext(ctx, "podman", podmanArgs...)
+	cmd := exec.CommandContext(ctx, r.binary, podmanArgs...)
 	cmd.Stdin = stdin
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 
-	// Run the command.
-	err := cmd.Run()
+	// Run the command, relaying the signals that podman doesn't receive itself.
+	if err := cmd.Start(); err != nil {
+		return result, &ContainerError{Err: fmt.Errorf("failed to run podman: %w", err)}
+	}
+	forwarder := newSignalForwarder(&podmanSignalTarget{binary: r.binary, cidFile: cidFile}, nil, opts)
+	forwarder.Start()
+	err := cmd.Wait()
+	forwarder.Stop()
+	result.TimedOut = forwarder.TimedOut()
+
+	if cid, readErr := os.ReadFile(cidFile); readErr == nil {
+		result.ContainerID = strings.TrimSpace(string(cid))
+	}
+
+	// Killing the podman client doesn't stop the container, so remove it when
+	// the caller gives up on it.
+	if ctx.Err() != nil {
+		if result.ContainerID != "" {
+			_ = exec.Command(r.binary, "rm", "-f", result.ContainerID).Run()
+		}
+		return result, ctx.Err()
+	}
+
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
-			return exitErr.ExitCode(), nil
+			result.ExitCode = exitErr.ExitCode()
+			// Report podman dying from a signal like a container would.
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				result.ExitCode = utils.SignalExitCode(status.Signal())
+			}
+			return result, nil
+		}
+		return result, &ContainerError{Err: fmt.Errorf("failed to run podman: %w", err)}
+	}
+
+	result.ExitCode = 0
+	return result, nil
+}
+
+// executeWarm runs a command in its warm container, which is started if it
+// isn't running or was started with different settings.
+func (r *PodmanRuntime) executeWarm(ctx context.Context, cfg *config.CommandConfig, command string, args []string, upgrade bool, stdin io.Reader, stdout, stderr io.Writer) (ExecResult, error) {
+	result := ExecResult{ExitCode: 1}
+
+	timer := newPhaseTimer()
+	image, err := resolveImage(ctx, r, cfg, command, upgrade)
+	if err != nil {
+		return result, err
+	}
+	var images []struct {
+		ID     string `json:"Id"`
+		Config struct {
+			Entrypoint []string
+			Cmd        []string
+		}
+	}
+	if err := r.inspect(ctx, &images, "image", "inspect", image); err != nil || len(images) == 0 {
+		if err := provideImage(ctx, r, cfg, image); err != nil {
+			return result, err
+		}
+		if err := r.inspect(ctx, &images, "image", "inspect", image); err != nil || len(images) == 0 {
+			return result, &ImageError{Image: image, Err: fmt.Errorf("failed to inspect image: %w", err)}
+		}
+	}
+
+	opts := newContainerOptions(cfg, command, image, args, isTerminal(stdin, stdout))
+	if err := prepareIdentity(ctx, r, cfg, command, image); err != nil {
+		return result, err
+	}
+	if _, err := seccompProfile(opts); err != nil {
+		return result, err
+	}
+	if err := mountEngineSocket(ctx, r, &opts); err != nil {
+		return result, err
+	}
+	// Warm containers can't gain mounts, so path arguments that need them are
+	// run in a container of their own.
+	if len(opts.HostPaths) > 0 {
+		return r.ExecuteCommand(ctx, withoutKeepAlive(cfg), command, args, false, stdin, stdout, stderr)
+	}
+	warm := newWarmContainer(cfg, command, opts, images[0].ID, images[0].Config.Entrypoint, images[0].Config.Cmd)
+
+	timer.mark(PhaseImage)
+
+	containerID, err := r.startWarm(ctx, warm, upgrade)
+	if errors.Is(err, errNotIdling) {
+		logrus.Warnf("Running %s without a warm container: %v", command, err)
+		return r.ExecuteCommand(ctx, withoutKeepAlive(cfg), command, args, false, stdin, stdout, stderr)
+	}
+	if err != nil {
+		return result, err
+	}
+	timer.mark(PhaseContainer)
+	touchWarm(warm.name)
+	defer func() {
+		touchWarm(warm.name)
+		reapIdle(context.Background(), r, warm.name)
+	}()
+
+	// Record the warm container's ID, so it is reported and removed if the
+	// run is abandoned.
+	cidDir, err := os.MkdirTemp("", "dox-cid")
+	if err != nil {
+		return result, &ContainerError{Err: fmt.Errorf("failed to create container ID directory: %w", err)}
+	}
+	defer os.RemoveAll(cidDir)
+	cidFile := filepath.Join(cidDir, "cid")
+	if err := os.WriteFile(cidFile, []byte(containerID), 0644); err != nil {
+		return result, &ContainerError{Err: fmt.Errorf("failed to write container ID: %w", err)}
+	}
+
+	result, err = r.run(ctx, opts, execArgs(opts, warm.name, warm.execCommand(opts)), cidFile, stdin, stdout, stderr)
+	if err == nil && result.ExitCode == oomExitCode && opts.Memory > 0 {
+		result.OOMKilled = r.oomKilled(containerID)
+	}
+	if err == nil {
+		timer.mark(PhaseExec)
+		result.Phases = timer.phases
+	}
+	return result, err
+}
+
+// startWarm returns the ID of a running warm container, replacing one that was
+// started with different settings or when upgrading.
+func (r *PodmanRuntime) startWarm(ctx context.Context, warm *warmContainer, upgrade bool) (string, error) {
+	var existing []struct {
+		ID    string `json:"Id"`
+		State struct {
+			Running bool
+		}
+		Config struct {
+			Labels map[string]string
+		}
+	}
+	if err := r.inspect(ctx, &existing, "container", "inspect", warm.name); err == nil && len(existing) > 0 {
+		if !upgrade && existing[0].State.Running && warm.matches(existing[0].Config.Labels) {
+			return existing[0].ID, nil
+		}
+		logrus.Infof("Replacing warm container %s...", warm.name)
+		if err := r.removeWarm(ctx, warm.name); err != nil {
+			return "", &ContainerError{Err: fmt.Errorf("failed to remove warm container: %w", err)}
+		}
+	}
+
+	// A container that fails to start is still created, so it is removed.
+	output, err := exec.CommandContext(ctx, r.binary, warmRunArgs(warm)...).Output()
+	if err != nil {
+		_ = r.removeWarm(ctx, warm.name)
+		return "", fmt.Errorf("%w: %w", errNotIdling, commandError(err))
+	}
+	var started []struct {
+		State struct {
+			Running bool
+		}
+	}
+	if err := r.inspect(ctx, &started, "container", "inspect", warm.name); err == nil && (len(started) == 0 || !started[0].State.Running) {
+		_ = r.removeWarm(ctx, warm.name)
+		return "", errNotIdling
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// inspect decodes the JSON output of a podman inspect command.
+func (r *PodmanRuntime) inspect(ctx context.Context, value interface{}, args ...string) error {
+	output, err := exec.CommandContext(ctx, r.binary, args...).Output()
+	if err != nil {
+		return commandError(err)
+	}
+	return json.Unmarshal(output, value)
+}
+
+// oomKilled reports whether the kernel killed a container for running out of memory.
+func (r *PodmanRuntime) oomKilled(containerID string) bool {
+	var containers []struct {
+		State struct {
+			OOMKilled bool
 		}
-		return 1, fmt.Errorf("failed to run podman: %w", err)
 	}
+	if err := r.inspect(context.Background(), &containers, "container", "inspect", containerID); err != nil || len(containers) == 0 {
+		logrus.Debugf("Failed to inspect container %s: %v", containerID, err)
+		return false
+	}
+	return containers[0].State.OOMKilled
+}
+
+// listWarm returns the names of warm containers with their keep-alive durations.
+func (r *PodmanRuntime) listWarm(ctx context.Context) (map[string]string, error) {
+	format := fmt.Sprintf(`{{.Names}} {{index .Labels "%s"}}`, labelKeepAlive)
+	output, err := exec.CommandContext(ctx, r.binary, "ps", "-a", "--filter", "label="+labelKeepAlive, "--format", format).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", commandError(err))
+	}
+	warm := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if name, duration, ok := strings.Cut(line, " "); ok {
+			warm[name] = duration
+		}
+	}
+	return warm, nil
+}
+
+// removeWarm removes a warm container.
+func (r *PodmanRuntime) removeWarm(ctx context.Context, name string) error {
+	if err := exec.CommandContext(ctx, r.binary, "rm", "-f", name).Run(); err != nil {
+		return commandError(err)
+	}
+	return nil
+}
+
+// commandError adds podman's error message to a failed command's error.
+func commandError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
+
+// podmanSignalTarget relays signals to a container run by a podman process.
+type podmanSignalTarget struct {
+	binary  string
+	cidFile string
+}
 
-	return 0, nil
+// Signal is a no-op. Podman runs in dox's process group, so it receives signals
+// from the terminal, like Ctrl+C and window resizes, directly and proxies them to
+// the container itself. Signals that terminate dox stop the container instead.
+func (t *podmanSignalTarget) Signal(sig os.Signal) error {
+	return nil
+}
+
+// Resize is a no-op, since podman resizes the container's TTY along with its own.
+func (t *podmanSignalTarget) Resize(width, height int) error {
+	return nil
+}
+
+// Stop sends the stop signal to the container's main process. It is sent with
+// podman kill, since podman only proxies the signals it receives itself.
+func (t *podmanSignalTarget) Stop(signal string) error {
+	return t.kill("--signal=" + signal)
+}
+
+// Kill kills the container.
+func (t *podmanSignalTarget) Kill() error {
+	return t.kill()
+}
+
+// kill runs podman kill on the container with the given flags.
+func (t *podmanSignalTarget) kill(flags ...string) error {
+	cid, err := os.ReadFile(t.cidFile)
+	if err != nil {
+		return fmt.Errorf("failed to read container ID: %w", err)
+	}
+	args := append(append([]string{"kill"}, flags...), strings.TrimSpace(string(cid)))
+	return exec.Command(t.binary, args...).Run()
 }
 
 // PullImage pulls a Podman image.
 func (r *PodmanRuntime) PullImage(ctx context.Context, image string) error {
-	cmd := exec.CommandContext(ctx, "podman", "pull", image)
-	cmd.Stdout = os.Stdout
+	cmd := exec.CommandContext(ctx, r.binary, "pull", image)
+	// Send progress to stderr, so piped output stays clean.
+	cmd.Stdout = os.Stderr
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to pull image %s: %w", image, err)
@@ -161,23 +409,23 @@ func (r *PodmanRuntime) PullImage(ctx context.Context, image string) error {
 
 // BuildImage builds a Podman image from inline Dockerfile.
 func (r *PodmanRuntime) BuildImage(ctx context.Context, dockerfileContent string, tag string) error {
-	// Create a temporary Dockerfile.
-	tmpfile, err := os.CreateTemp("", "Dockerfile")
+	// Use an empty build context, like the Docker runtime does, rather than the
+	// current directory.
+	contextDir, err := os.MkdirTemp("", "dox-build")
 	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+		return fmt.Errorf("failed to create build context: %w", err)
 	}
-	defer os.Remove(tmpfile.Name())
+	defer os.RemoveAll(contextDir)
 
-	if _, err := tmpfile.Write([]byte(dockerfileContent)); err != nil {
+	dockerfilePath := filepath.Join(contextDir, "Dockerfile")
+	if err := os.WriteFile(dockerfilePath, []byte(dockerfileContent), 0644); err != nil {
 		return fmt.Errorf("failed to write Dockerfile: %w", err)
 	}
-	if err := tmpfile.Close(); err != nil {
-		return fmt.Errorf("failed to close temp file: %w", err)
-	}
 
 	// Build the image.
-	cmd := exec.CommandContext(ctx, "podman", "build", "-t", tag, "-f", tmpfile.Name(), ".")
-	cmd.Stdout = os.Stdout
+	cmd := exec.CommandContext(ctx, r.binary, "build", "-t", tag, "-f", dockerfilePath, contextDir)
+	// Send progress to stderr, so piped output stays clean.
+	cmd.Stdout = os.Stderr
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to build image: %w", err)
@@ -186,9 +434,92 @@ func (r *PodmanRuntime) BuildImage(ctx context.Context, dockerfileContent string
 	return nil
 }
 
+// imageExists checks whether an image is available locally.
+func (r *PodmanRuntime) imageExists(ctx context.Context, image string) bool {
+	cmd := exec.CommandContext(ctx, r.binary, "image", "exists", image)
+	return cmd.Run() == nil
+}
+
+// Explain works out what ExecuteCommand would do, without doing it.
+func (r *PodmanRuntime) Explain(ctx context.Context, cfg *config.CommandConfig, command string, args []string, upgrade bool, stdin io.Reader, stdout io.Writer) (*Plan, error) {
+	exists := func(image string) bool {
+		return r.imageExists(ctx, image)
+	}
+	return NewPlan("podman", exists, cfg, command, args, upgrade, isTerminal(stdin, stdout))
+}
+
+// runArgs translates container options into podman run arguments. They are
+// compatible with docker run, which explained commands rely on.
+func runArgs(opts ContainerOptions) []string {
+	podmanArgs := []string{"run"}
+	if opts.Remove {
+		podmanArgs = append(podmanArgs, "--rm")
+	}
+
+	// Keep stdin open so input can be piped in, but only allocate a TTY for terminals.
+	if opts.Interactive {
+		podmanArgs = append(podmanArgs, "-i")
+	}
+	// Podman shares dox's terminal and resizes the container's TTY along with it. Don't
+	// set COLUMNS and LINES, since they would override the live size in curses programs.
+	if opts.TTY {
+		podmanArgs = append(podmanArgs, "-t")
+	}
+
+	// Network mode - only set if specified.
+	if opts.Network != "" {
+		podmanArgs = append(podmanArgs, fmt.Sprintf("--network=%s", opts.Network))
+	}
+
+	// Port mappings.
+	for _, port := range opts.Ports {
+		podmanArgs = append(podmanArgs, "-p", port)
+	}
+
+	// User mapping.
+	if opts.User != "" {
+		podmanArgs = append(podmanArgs, fmt.Sprintf("--user=%s", opts.User))
+	}
+
+	if opts.UserNS != "" {
+		podmanArgs = append(podmanArgs, fmt.Sprintf("--userns=%s", opts.UserNS))
+	}
+	for _, group := range opts.GroupAdd {
+		podmanArgs = append(podmanArgs, fmt.Sprintf("--group-add=%s", group))
+	}
+
+	podmanArgs = append(podmanArgs, resourceArgs(opts)...)
+	podmanArgs = append(podmanArgs, stopArgs(opts)...)
+	podmanArgs = append(podmanArgs, securityArgs(opts)...)
+
+	if opts.WorkingDir != "" {
+		podmanArgs = append(podmanArgs, "-w", opts.WorkingDir)
+	}
+
+	if opts.Entrypoint != "" {
+		podmanArgs = append(podmanArgs, fmt.Sprintf("--entrypoint=%s", opts.Entrypoint))
+	}
+
+	// Volume mounts.
+	for _, volume := range opts.Volumes {
+		podmanArgs = append(podmanArgs, "-v", volume)
+	}
+
+	// Environment variables.
+	for _, env := range opts.Env {
+		podmanArgs = append(podmanArgs, "-e", env)
+	}
+
+	// Image, then the command and arguments.
+	podmanArgs = append(podmanArgs, opts.Image)
+	podmanArgs = append(podmanArgs, opts.Command...)
+
+	return podmanArgs
+}
+
 // ListImages lists Podman images.
 func (r *PodmanRuntime) ListImages(ctx context.Context) ([]string, error) {
-	cmd := exec.CommandContext(ctx, "podman", "images", "--format", "{{.Repository}}:{{.Tag}}")
+	cmd := exec.CommandContext(ctx, r.binary, "images", "--format", "{{.Repository}}:{{.Tag}}")
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list images: %w", err)
@@ -205,10 +536,12 @@ func (r *PodmanRuntime) ListImages(ctx context.Context) ([]string, error) {
 	return images, nil
 }
 
-// RemoveUnusedContainers removes stopped containers.
+// RemoveUnusedContainers removes stopped containers and idle warm containers.
 func (r *PodmanRuntime) RemoveUnusedContainers(ctx context.Context) error {
+	defer reapIdle(ctx, r, "")
+
 	// List exited containers.
-	cmd := exec.CommandContext(ctx, "podman", "ps", "-aq", "--filter", "status=exited")
+	cmd := exec.CommandContext(ctx, r.binary, "ps", "-aq", "--filter", "status=exited")
 	output, err := cmd.Output()
 	if err != nil {
 		return fmt.Errorf("failed to list containers: %w", err)
@@ -221,7 +554,7 @@ func (r *PodmanRuntime) RemoveUnusedContainers(ctx context.Context) error {
 
 	// Remove each container.
 	for _, id := range containerIDs {
-		cmd := exec.CommandContext(ctx, "podman", "rm", id)
+		cmd := exec.CommandContext(ctx, r.binary, "rm", id)
 		if err := cmd.Run(); err != nil {
 			logrus.Warnf("Failed to remove container %s: %v", id, err)
 		}
@@ -232,7 +565,7 @@ func (r *PodmanRuntime) RemoveUnusedContainers(ctx context.Context) error {
 
 // RemoveImage removes a specific Podman image.
 func (r *PodmanRuntime) RemoveImage(ctx context.Context, image string) error {
-	cmd := exec.CommandContext(ctx, "podman", "rmi", image)
+	cmd := exec.CommandContext(ctx, r.binary, "rmi", image)
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to remove image %s: %w", image, err)
 	}
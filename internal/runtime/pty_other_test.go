@@ -0,0 +1,19 @@
+//go:build !linux
+
+package runtime
+
+import (
+	"os"
+	"testing"
+)
+
+// openPTY opens a pseudo-terminal pair that is closed when the test ends.
+func openPTY(t *testing.T) (*os.File, *os.File) {
+	t.Skip("pseudo-terminal tests are only supported on Linux")
+	return nil, nil
+}
+
+// setPTYSize sets the window size of a pseudo-terminal.
+func setPTYSize(t *testing.T, tty *os.File, width, height int) {
+	t.Skip("pseudo-terminal tests are only supported on Linux")
+}
@@ -0,0 +1,124 @@
+package runtime
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+// oomExitCode is the exit code of a container killed by the kernel, which is
+// what running out of memory looks like without inspecting the container.
+const oomExitCode = 137
+
+// applyResources sets a command's resource limits on container options. The
+// configuration loader rejects invalid limits, so they are skipped here.
+func applyResources(opts *ContainerOptions, resources *config.ResourcesConfig) {
+	if resources == nil {
+		return
+	}
+	if cpus, err := strconv.ParseFloat(resources.CPUs, 64); err == nil {
+		opts.CPUs = cpus
+	}
+	if memory, err := units.RAMInBytes(resources.Memory); err == nil {
+		opts.Memory = memory
+	}
+	if resources.MemorySwap == "-1" {
+		opts.MemorySwap = -1
+	} else if swap, err := units.RAMInBytes(resources.MemorySwap); err == nil {
+		opts.MemorySwap = swap
+	}
+	opts.PidsLimit = resources.PidsLimit
+	if shmSize, err := units.RAMInBytes(resources.ShmSize); err == nil {
+		opts.ShmSize = shmSize
+	}
+
+	// Sort the limits, so containers are started the same way every time.
+	names := make([]string, 0, len(resources.Ulimits))
+	for name := range resources.Ulimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ulimit, err := units.ParseUlimit(name + "=" + resources.Ulimits[name]); err == nil {
+			opts.Ulimits = append(opts.Ulimits, ulimit.String())
+		}
+	}
+}
+
+// resourceArgs translates resource limits into run arguments, which are
+// compatible with docker run.
+func resourceArgs(opts ContainerOptions) []string {
+	var args []string
+	if opts.CPUs > 0 {
+		args = append(args, "--cpus="+strconv.FormatFloat(opts.CPUs, 'f', -1, 64))
+	}
+	if opts.Memory > 0 {
+		args = append(args, "--memory="+formatBytes(opts.Memory))
+	}
+	if opts.MemorySwap != 0 {
+		args = append(args, "--memory-swap="+formatBytes(opts.MemorySwap))
+	}
+	if opts.PidsLimit != 0 {
+		args = append(args, fmt.Sprintf("--pids-limit=%d", opts.PidsLimit))
+	}
+	for _, ulimit := range opts.Ulimits {
+		args = append(args, "--ulimit="+ulimit)
+	}
+	if opts.ShmSize > 0 {
+		args = append(args, "--shm-size="+formatBytes(opts.ShmSize))
+	}
+	for _, device := range opts.Devices {
+		args = append(args, "--device="+device)
+	}
+	return args
+}
+
+// dockerResources translates resource limits into the Docker API's. The shared
+// memory size is set on the host config separately.
+func dockerResources(opts ContainerOptions) container.Resources {
+	resources := container.Resources{
+		NanoCPUs:   int64(opts.CPUs * 1e9),
+		Memory:     opts.Memory,
+		MemorySwap: opts.MemorySwap,
+	}
+	if opts.PidsLimit != 0 {
+		pidsLimit := opts.PidsLimit
+		resources.PidsLimit = &pidsLimit
+	}
+	for _, ulimit := range opts.Ulimits {
+		if parsed, err := units.ParseUlimit(ulimit); err == nil {
+			resources.Ulimits = append(resources.Ulimits, parsed)
+		}
+	}
+	for _, device := range opts.Devices {
+		resources.Devices = append(resources.Devices, parseDevice(device))
+	}
+	return resources
+}
+
+// formatBytes formats a size in the largest unit that represents it exactly,
+// like 512m or 4g.
+func formatBytes(bytes int64) string {
+	if bytes > 0 {
+		for _, unit := range []struct {
+			suffix string
+			size   int64
+		}{{"g", units.GiB}, {"m", units.MiB}, {"k", units.KiB}} {
+			if bytes%unit.size == 0 {
+				return fmt.Sprintf("%d%s", bytes/unit.size, unit.suffix)
+			}
+		}
+	}
+	return strconv.FormatInt(bytes, 10)
+}
+
+// keepForInspection reports whether a container that is removed on exit must
+// be removed by dox instead, so it can be inspected first. Only containers with
+// a memory limit are, to tell whether they ran out of memory.
+func keepForInspection(opts ContainerOptions) bool {
+	return opts.Remove && opts.Memory > 0
+}
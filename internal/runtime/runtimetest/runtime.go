@@ -0,0 +1,223 @@
+// Package runtimetest provides an in-memory container runtime for tests.
+package runtimetest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/skorokithakis/dox/internal/config"
+	"github.com/skorokithakis/dox/internal/runtime"
+)
+
+// Execution is a recorded ExecuteCommand call.
+type Execution struct {
+	Config  *config.CommandConfig
+	Command string
+	Args    []string
+	Upgrade bool
+	Stdin   string
+}
+
+// Runtime is an in-memory runtime.Runtime that records what it was asked to do
+// and plays back scripted results. The zero value is not usable; call New.
+type Runtime struct {
+	mu sync.Mutex
+
+	images map[string]bool
+
+	// Scripted behaviour. Errors are returned by the matching methods when set.
+	ExitCode         int
+	OOMKilled        bool
+	TimedOut         bool
+	Stdout           string
+	Stderr           string
+	ExecuteError     error
+	PullError        error
+	BuildError       error
+	UnavailableError error
+	CleanError       error
+
+	// OnExecute, when set, is called with the configuration of every run that
+	// creates a container, like the command doing its work, e.g. to change
+	// files in its sandbox.
+	OnExecute func(cfg *config.CommandConfig)
+
+	// Block, when set, makes ExecuteCommand wait until it is closed or the
+	// context is cancelled, like a long-running container.
+	Block chan struct{}
+
+	// Recorded calls.
+	Executions []Execution
+	Explained  []string
+	Pulls      []string
+	Builds     []string
+	Removed    []string
+	Cleanups   int
+}
+
+// Make sure Runtime implements runtime.Runtime and runtime.Explainer.
+var (
+	_ runtime.Runtime   = (*Runtime)(nil)
+	_ runtime.Explainer = (*Runtime)(nil)
+)
+
+// New creates an empty fake runtime with the given images available.
+func New(images ...string) *Runtime {
+	r := &Runtime{images: map[string]bool{}}
+	for _, image := range images {
+		r.images[image] = true
+	}
+	return r
+}
+
+// AddImage makes an image available.
+func (r *Runtime) AddImage(image string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.images[image] = true
+}
+
+// HasImage reports whether an image is available.
+func (r *Runtime) HasImage(image string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.images[image]
+}
+
+// LastExecution returns the most recent ExecuteCommand call.
+func (r *Runtime) LastExecution() (Execution, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.Executions) == 0 {
+		return Execution{}, false
+	}
+	return r.Executions[len(r.Executions)-1], true
+}
+
+// ExecuteCommand records the call, reads stdin and writes the scripted output.
+// Containers are given sequential IDs like "fake-1", and the whole call is
+// reported as the run phase.
+func (r *Runtime) ExecuteCommand(ctx context.Context, cfg *config.CommandConfig, command string, args []string, upgrade bool, stdin io.Reader, stdout, stderr io.Writer) (runtime.ExecResult, error) {
+	started := time.Now()
+	var input []byte
+	if stdin != nil {
+		input, _ = io.ReadAll(stdin)
+	}
+
+	r.mu.Lock()
+	// Like a real runtime, an unavailable one can't create containers.
+	if r.UnavailableError != nil {
+		r.mu.Unlock()
+		return runtime.ExecResult{ExitCode: 1}, &runtime.ContainerError{Err: r.UnavailableError}
+	}
+	r.Executions = append(r.Executions, Execution{
+		Config:  cfg,
+		Command: command,
+		Args:    args,
+		Upgrade: upgrade,
+		Stdin:   string(input),
+	})
+	result := runtime.ExecResult{ExitCode: 1, ContainerID: fmt.Sprintf("fake-%d", len(r.Executions))}
+	exitCode, oomKilled, timedOut, executeErr, block := r.ExitCode, r.OOMKilled, r.TimedOut, r.ExecuteError, r.Block
+	output, errOutput, onExecute := r.Stdout, r.Stderr, r.OnExecute
+	r.mu.Unlock()
+
+	if executeErr != nil {
+		return result, executeErr
+	}
+	if onExecute != nil {
+		onExecute(cfg)
+	}
+	fmt.Fprint(stdout, output)
+	fmt.Fprint(stderr, errOutput)
+
+	if block != nil {
+		select {
+		case <-block:
+		case <-ctx.Done():
+			return result, ctx.Err()
+		}
+	}
+
+	result.ExitCode = exitCode
+	result.OOMKilled = oomKilled
+	result.TimedOut = timedOut
+	result.Phases = []runtime.Phase{{Name: runtime.PhaseRun, Duration: time.Since(started)}}
+	return result, nil
+}
+
+// Explain returns a plan for the docker CLI based on the available images.
+func (r *Runtime) Explain(ctx context.Context, cfg *config.CommandConfig, command string, args []string, upgrade bool, stdin io.Reader, stdout io.Writer) (*runtime.Plan, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Explained = append(r.Explained, command)
+	exists := func(image string) bool {
+		return r.images[image]
+	}
+	return runtime.NewPlan("docker", exists, cfg, command, args, upgrade, false)
+}
+
+// PullImage records the pull and makes the image available.
+func (r *Runtime) PullImage(ctx context.Context, image string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Pulls = append(r.Pulls, image)
+	if r.PullError != nil {
+		return r.PullError
+	}
+	r.images[image] = true
+	return nil
+}
+
+// BuildImage records the build and makes the tagged image available.
+func (r *Runtime) BuildImage(ctx context.Context, dockerfileContent string, tag string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Builds = append(r.Builds, tag)
+	if r.BuildError != nil {
+		return r.BuildError
+	}
+	r.images[tag] = true
+	return nil
+}
+
+// ListImages returns the available images in sorted order.
+func (r *Runtime) ListImages(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var images []string
+	for image := range r.images {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// RemoveUnusedContainers records the cleanup.
+func (r *Runtime) RemoveUnusedContainers(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Cleanups++
+	return r.CleanError
+}
+
+// RemoveImage removes an image, failing like Docker does if it doesn't exist.
+func (r *Runtime) RemoveImage(ctx context.Context, image string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.images[image] {
+		return fmt.Errorf("No such image: %s", image)
+	}
+	delete(r.images, image)
+	r.Removed = append(r.Removed, image)
+	return nil
+}
+
+// IsAvailable returns the scripted availability error.
+func (r *Runtime) IsAvailable(ctx context.Context) error {
+	return r.UnavailableError
+}
@@ -0,0 +1,622 @@
+package runtime
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+// Kinds of changes made in a sandbox.
+const (
+	SandboxCreated  = "created"
+	SandboxModified = "modified"
+	SandboxDeleted  = "deleted"
+)
+
+// sandboxPrefix is the directory in a sandbox that holds the copy of the
+// workspace.
+const sandboxPrefix = "workspace"
+
+// Sandbox is a copy of a command's workspace that a sandboxed run works on
+// instead of the workspace, so its changes can be reviewed before they are
+// applied.
+type Sandbox struct {
+	ID      string
+	Command string
+	// Source is the workspace the sandbox was copied from.
+	Source  string
+	Created time.Time
+	// Workspace is the copy that is mounted in the container.
+	Workspace string
+
+	// ignore are the patterns that were left out of the copy, which applying
+	// the sandbox leaves alone.
+	ignore []string
+	// snapshot is the state of the copy when the sandbox was created.
+	snapshot workspaceSnapshot
+}
+
+// SandboxChange is a file or directory that was created, modified or deleted in
+// a sandbox. Paths are slash-separated and relative to the workspace, and
+// directories end in a slash.
+type SandboxChange struct {
+	Path string
+	Kind string
+}
+
+// sandboxMetadata is how a sandbox is stored next to its copy.
+type sandboxMetadata struct {
+	ID       string            `json:"id"`
+	Command  string            `json:"command"`
+	Source   string            `json:"source"`
+	Created  time.Time         `json:"created"`
+	Ignore   []string          `json:"ignore,omitempty"`
+	Snapshot workspaceSnapshot `json:"snapshot"`
+}
+
+// sandboxesDir returns the host directory that holds the sandboxes.
+func sandboxesDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	return filepath.Join(cacheDir, "dox", "sandboxes")
+}
+
+// SandboxWorkspace returns where the copy of the sandbox with the given ID is.
+func SandboxWorkspace(id string) string {
+	return filepath.Join(sandboxesDir(), id, sandboxPrefix)
+}
+
+// NewSandbox copies the workspace a command would run in to a new sandbox.
+// Files matching the command's workspace_ignore patterns aren't copied.
+func NewSandbox(cfg *config.CommandConfig, command string) (*Sandbox, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	}
+	source := resolveWorkspace(cfg.Workspace, cwd).hostRoot
+
+	id, err := newSandboxID()
+	if err != nil {
+		return nil, err
+	}
+	sandbox := &Sandbox{
+		ID:        id,
+		Command:   command,
+		Source:    source,
+		Created:   time.Now(),
+		Workspace: SandboxWorkspace(id),
+		ignore:    cfg.WorkspaceIgnore,
+	}
+
+	if err := os.MkdirAll(sandbox.Workspace, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create sandbox: %w", err)
+	}
+	snapshot, err := copyWorkspace(source, sandbox.Workspace, sandbox.ignore)
+	if err != nil {
+		_ = sandbox.Discard()
+		return nil, fmt.Errorf("failed to copy workspace to sandbox: %w", err)
+	}
+	sandbox.snapshot = snapshot
+
+	if err := sandbox.save(); err != nil {
+		_ = sandbox.Discard()
+		return nil, err
+	}
+	return sandbox, nil
+}
+
+// newSandboxID returns a unique ID that sorts by creation time.
+func newSandboxID() (string, error) {
+	suffix := make([]byte, 2)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate sandbox ID: %w", err)
+	}
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix), nil
+}
+
+// save writes the sandbox's metadata.
+func (s *Sandbox) save() error {
+	metadata := sandboxMetadata{
+		ID:       s.ID,
+		Command:  s.Command,
+		Source:   s.Source,
+		Created:  s.Created,
+		Ignore:   s.ignore,
+		Snapshot: s.snapshot,
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to encode sandbox: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(sandboxesDir(), s.ID, "sandbox.json"), data, 0644); err != nil {
+		return fmt.Errorf("failed to save sandbox: %w", err)
+	}
+	return nil
+}
+
+// OpenSandbox returns the sandbox with the given ID.
+func OpenSandbox(id string) (*Sandbox, error) {
+	// IDs are directory names, so they must not lead anywhere else.
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return nil, &SandboxNotFoundError{ID: id}
+	}
+
+	data, err := os.ReadFile(filepath.Join(sandboxesDir(), id, "sandbox.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, &SandboxNotFoundError{ID: id}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sandbox: %w", err)
+	}
+
+	var metadata sandboxMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode sandbox %s: %w", id, err)
+	}
+	return &Sandbox{
+		ID:        id,
+		Command:   metadata.Command,
+		Source:    metadata.Source,
+		Created:   metadata.Created,
+		Workspace: SandboxWorkspace(id),
+		ignore:    metadata.Ignore,
+		snapshot:  metadata.Snapshot,
+	}, nil
+}
+
+// ListSandboxes returns the sandboxes from oldest to newest.
+func ListSandboxes() ([]*Sandbox, error) {
+	entries, err := os.ReadDir(sandboxesDir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sandboxes: %w", err)
+	}
+
+	var sandboxes []*Sandbox
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		sandbox, err := OpenSandbox(entry.Name())
+		var notFoundErr *SandboxNotFoundError
+		if errors.As(err, &notFoundErr) {
+			// A sandbox that is still being created, or a leftover of one that
+			// failed to be.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sandboxes = append(sandboxes, sandbox)
+	}
+	sort.SliceStable(sandboxes, func(i, j int) bool {
+		return sandboxes[i].Created.Before(sandboxes[j].Created)
+	})
+	return sandboxes, nil
+}
+
+// LatestSandbox returns the newest sandbox of the workspace dir is in.
+func LatestSandbox(dir string) (*Sandbox, error) {
+	sandboxes, err := ListSandboxes()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(sandboxes) - 1; i >= 0; i-- {
+		if _, ok := relativePath(sandboxes[i].Source, dir); ok {
+			return sandboxes[i], nil
+		}
+	}
+	return nil, &SandboxNotFoundError{Workspace: dir}
+}
+
+// Changes returns what was created, modified and deleted in the sandbox, sorted
+// by path.
+func (s *Sandbox) Changes() ([]SandboxChange, error) {
+	changes, _, err := s.changes()
+	return changes, err
+}
+
+// changes returns the sandbox's changes along with the current state of its copy.
+func (s *Sandbox) changes() ([]SandboxChange, workspaceSnapshot, error) {
+	current, err := snapshotWorkspace(s.Workspace, s.ignore)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read sandbox: %w", err)
+	}
+
+	var changes []SandboxChange
+	for rel, entry := range current {
+		previous, existed := s.snapshot[rel]
+		switch {
+		case !existed:
+			changes = append(changes, SandboxChange{Path: changePath(rel, entry), Kind: SandboxCreated})
+		case previous.Dir && entry.Dir:
+			// The contents of directories are changes of their own.
+		case previous != entry:
+			changes = append(changes, SandboxChange{Path: changePath(rel, entry), Kind: SandboxModified})
+		}
+	}
+	for rel, previous := range s.snapshot {
+		if _, exists := current[rel]; !exists {
+			changes = append(changes, SandboxChange{Path: changePath(rel, previous), Kind: SandboxDeleted})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, current, nil
+}
+
+// changePath returns the path a change is reported with.
+func changePath(rel string, entry workspaceEntry) string {
+	if entry.Dir {
+		return rel + "/"
+	}
+	return rel
+}
+
+// Apply copies the sandbox's changes to the workspace it was created from, and
+// removes the sandbox. If the workspace has changed since then in any of the
+// same paths, nothing is applied and a *SandboxConflictError is returned, unless
+// force is set. Other changes to the workspace are kept either way.
+func (s *Sandbox) Apply(force bool) ([]SandboxChange, error) {
+	changes, current, err := s.changes()
+	if err != nil {
+		return nil, err
+	}
+
+	if !force {
+		source, err := snapshotWorkspace(s.Source, s.ignore)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read workspace: %w", err)
+		}
+		var conflicts []string
+		for _, change := range changes {
+			rel := strings.TrimSuffix(change.Path, "/")
+			entry, exists := source[rel]
+			previous, existed := s.snapshot[rel]
+			changed, hasChanged := current[rel]
+			// Workspaces that already match the sandbox don't conflict.
+			if exists == existed && entry == previous || exists == hasChanged && entry == changed {
+				continue
+			}
+			conflicts = append(conflicts, change.Path)
+		}
+		// Deleting a directory would also delete what was added to it since.
+		for rel := range source {
+			if _, existed := s.snapshot[rel]; !existed && deletedParent(rel, changes) {
+				conflicts = append(conflicts, rel)
+			}
+		}
+		if len(conflicts) > 0 {
+			sort.Strings(conflicts)
+			return nil, &SandboxConflictError{ID: s.ID, Paths: conflicts}
+		}
+	}
+
+	if err := s.applyChanges(changes, current); err != nil {
+		return nil, fmt.Errorf("failed to apply sandbox: %w", err)
+	}
+
+	if err := s.Discard(); err != nil {
+		return changes, err
+	}
+	return changes, nil
+}
+
+// deletedParent reports whether a directory that contains rel was deleted.
+func deletedParent(rel string, changes []SandboxChange) bool {
+	for _, change := range changes {
+		if change.Kind == SandboxDeleted && strings.HasSuffix(change.Path, "/") && strings.HasPrefix(rel, change.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Discard removes the sandbox without applying its changes.
+func (s *Sandbox) Discard() error {
+	dir := filepath.Join(sandboxesDir(), s.ID)
+	// Files the command created without write permission on their directory
+	// would stop them from being removed.
+	_ = filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.IsDir() {
+			_ = os.Chmod(filePath, 0755)
+		}
+		return nil
+	})
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove sandbox: %w", err)
+	}
+	return nil
+}
+
+// snapshotWorkspace records the state of the files in dir that aren't ignored,
+// like archiveWorkspace does for the files it copies.
+func snapshotWorkspace(dir string, ignore []string) (workspaceSnapshot, error) {
+	snapshot := workspaceSnapshot{}
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath == dir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if isIgnored(rel, ignore) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.IsDir():
+			snapshot[rel] = workspaceEntry{Dir: true, Mode: info.Mode().Perm()}
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(filePath)
+			if err != nil {
+				return err
+			}
+			snapshot[rel] = workspaceEntry{Target: target}
+		case info.Mode().IsRegular():
+			hash, err := hashFile(filePath)
+			if err != nil {
+				return err
+			}
+			snapshot[rel] = workspaceEntry{Mode: info.Mode().Perm(), Hash: hash}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// applyChanges makes the sandbox's changes in the workspace it was created
+// from, copying what was created and modified from current, the state of the
+// copy.
+func (s *Sandbox) applyChanges(changes []SandboxChange, current workspaceSnapshot) error {
+	root, err := filepath.EvalSymlinks(s.Source)
+	if err != nil {
+		return fmt.Errorf("failed to resolve workspace directory: %w", err)
+	}
+
+	// Deletions go first, so paths that were replaced by something of another
+	// type are free to be created again.
+	for i := len(changes) - 1; i >= 0; i-- {
+		if changes[i].Kind != SandboxDeleted {
+			continue
+		}
+		rel := strings.TrimSuffix(changes[i].Path, "/")
+		target := filepath.Join(root, filepath.FromSlash(rel))
+		if !withinDir(root, target) {
+			continue
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", rel, err)
+		}
+	}
+
+	// Changes are sorted by path, so directories are created before what they
+	// contain.
+	for _, change := range changes {
+		if change.Kind == SandboxDeleted {
+			continue
+		}
+		rel := strings.TrimSuffix(change.Path, "/")
+		entry := current[rel]
+		target := filepath.Join(root, filepath.FromSlash(rel))
+		if !withinDir(root, target) {
+			return fmt.Errorf("refusing to write %s: path escapes the workspace", rel)
+		}
+		if info, err := os.Lstat(target); err == nil && info.IsDir() != entry.Dir {
+			_ = os.RemoveAll(target)
+		}
+
+		switch {
+		case entry.Dir:
+			if err := os.MkdirAll(target, entry.Mode); err != nil {
+				return fmt.Errorf("failed to create %s: %w", rel, err)
+			}
+		case entry.Target != "":
+			_ = os.Remove(target)
+			if err := os.Symlink(entry.Target, target); err != nil {
+				return fmt.Errorf("failed to create symlink %s: %w", rel, err)
+			}
+		default:
+			if _, err := copyFile(filepath.Join(s.Workspace, filepath.FromSlash(rel)), target, entry.Mode); err != nil {
+				return fmt.Errorf("failed to write %s: %w", rel, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Diff writes a unified diff of a created or modified text file, from the
+// workspace's current version to the sandbox's. It reports false without
+// writing anything for deletions, directories, symlinks and binary files,
+// which can only be listed.
+func (s *Sandbox) Diff(w io.Writer, change SandboxChange) (bool, error) {
+	if change.Kind == SandboxDeleted || strings.HasSuffix(change.Path, "/") {
+		return false, nil
+	}
+	after, ok, err := readText(filepath.Join(s.Workspace, filepath.FromSlash(change.Path)))
+	if err != nil || !ok {
+		return false, err
+	}
+	before, from := "", "/dev/null"
+	if change.Kind == SandboxModified {
+		before, ok, err = readText(filepath.Join(s.Source, filepath.FromSlash(change.Path)))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			// The workspace has since deleted it, so the whole file is new.
+		case err != nil || !ok:
+			return false, err
+		default:
+			from = "a/" + change.Path
+		}
+	}
+	return true, writeUnifiedDiff(w, from, "b/"+change.Path, before, after)
+}
+
+// readText returns the contents of a regular file, and whether it is text. Like
+// git, files with a NUL byte near the start are binary.
+func readText(path string) (string, bool, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", false, err
+	}
+	if !info.Mode().IsRegular() {
+		return "", false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, err
+	}
+	if bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 {
+		return "", false, nil
+	}
+	return string(data), true, nil
+}
+
+// copyWorkspace copies the files in dir that aren't ignored to target, and
+// returns their snapshot, like archiveWorkspace does for containers.
+func copyWorkspace(dir, target string, ignore []string) (workspaceSnapshot, error) {
+	snapshot := workspaceSnapshot{}
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath == dir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if isIgnored(rel, ignore) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		copied := filepath.Join(target, filepath.FromSlash(rel))
+
+		switch {
+		case info.IsDir():
+			// The copy's directories stay writable by the host user, so their
+			// contents can be copied and the sandbox removed.
+			if err := os.Mkdir(copied, info.Mode().Perm()|0700); err != nil {
+				return err
+			}
+			snapshot[rel] = workspaceEntry{Dir: true, Mode: info.Mode().Perm()}
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(filePath)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, copied); err != nil {
+				return err
+			}
+			snapshot[rel] = workspaceEntry{Target: link}
+		case info.Mode().IsRegular():
+			hash, err := copyFile(filePath, copied, info.Mode().Perm())
+			if err != nil {
+				return fmt.Errorf("failed to copy %s: %w", rel, err)
+			}
+			snapshot[rel] = workspaceEntry{Mode: info.Mode().Perm(), Hash: hash}
+		}
+
+		// Sockets, devices and pipes can't be meaningfully copied.
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// copyFile copies a file to target with the given mode, and returns the hash of
+// its contents. It is written to a temporary file next to target first, so
+// target is replaced in one step.
+func copyFile(source, target string, mode fs.FileMode) (string, error) {
+	in, err := os.Open(source)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(target), ".dox-sync-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(out.Name())
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hash), in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(out.Name(), mode); err != nil {
+		return "", err
+	}
+	if err := os.Rename(out.Name(), target); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashFile returns the hex-encoded SHA-256 digest of a file's contents.
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
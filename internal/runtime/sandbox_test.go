@@ -0,0 +1,268 @@
+package runtime
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+// newTestSandbox creates a sandbox of a workspace with a few files, and returns
+// it along with the workspace.
+func newTestSandbox(t *testing.T) (*Sandbox, string) {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.MkdirAll(filepath.Join(dir, "build"), 0755)
+	os.WriteFile(filepath.Join(dir, "main.py"), []byte("print('hi')"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "util.py"), []byte("x = 1"), 0644)
+	os.WriteFile(filepath.Join(dir, "build", "out.o"), []byte("object"), 0644)
+
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	sandbox, err := NewSandbox(&config.CommandConfig{WorkspaceIgnore: []string{"build"}}, "python")
+	if err != nil {
+		t.Fatalf("NewSandbox() error = %v", err)
+	}
+	return sandbox, dir
+}
+
+// readFile returns the contents of a file, or "" if it can't be read.
+func readFile(path string) string {
+	data, _ := os.ReadFile(path)
+	return string(data)
+}
+
+func TestNewSandbox(t *testing.T) {
+	sandbox, dir := newTestSandbox(t)
+
+	if sandbox.Source != dir || sandbox.Command != "python" {
+		t.Errorf("sandbox of %s for %s, want %s for python", sandbox.Source, sandbox.Command, dir)
+	}
+	if content := readFile(filepath.Join(sandbox.Workspace, "sub", "util.py")); content != "x = 1" {
+		t.Errorf("sub/util.py = %q in the sandbox, want it copied", content)
+	}
+	if _, err := os.Stat(filepath.Join(sandbox.Workspace, "build")); !os.IsNotExist(err) {
+		t.Errorf("ignored build should not be copied, stat error = %v", err)
+	}
+
+	changes, err := sandbox.Changes()
+	if err != nil || len(changes) != 0 {
+		t.Errorf("Changes() = %v, %v, want no changes", changes, err)
+	}
+
+	opened, err := OpenSandbox(sandbox.ID)
+	if err != nil {
+		t.Fatalf("OpenSandbox() error = %v", err)
+	}
+	if !opened.Created.Equal(sandbox.Created) {
+		t.Errorf("Created = %s, want %s", opened.Created, sandbox.Created)
+	}
+	opened.Created = sandbox.Created
+	if !reflect.DeepEqual(opened, sandbox) {
+		t.Errorf("OpenSandbox() = %+v, want %+v", opened, sandbox)
+	}
+	latest, err := LatestSandbox(filepath.Join(dir, "sub"))
+	if err != nil || latest.ID != sandbox.ID {
+		t.Errorf("LatestSandbox() = %v, %v, want the sandbox", latest, err)
+	}
+
+	var notFoundErr *SandboxNotFoundError
+	if _, err := OpenSandbox("../" + sandbox.ID); !errors.As(err, &notFoundErr) {
+		t.Errorf("OpenSandbox() of a path error = %v, want a *SandboxNotFoundError", err)
+	}
+	if _, err := LatestSandbox(t.TempDir()); !errors.As(err, &notFoundErr) {
+		t.Errorf("LatestSandbox() of another workspace error = %v, want a *SandboxNotFoundError", err)
+	}
+}
+
+func TestSandboxApply(t *testing.T) {
+	sandbox, dir := newTestSandbox(t)
+
+	// The command modified main.py, deleted sub, created a file and touched an
+	// ignored path.
+	os.WriteFile(filepath.Join(sandbox.Workspace, "main.py"), []byte("print('changed')"), 0644)
+	os.RemoveAll(filepath.Join(sandbox.Workspace, "sub"))
+	os.WriteFile(filepath.Join(sandbox.Workspace, "new.txt"), []byte("created"), 0644)
+	os.MkdirAll(filepath.Join(sandbox.Workspace, "build"), 0755)
+	os.WriteFile(filepath.Join(sandbox.Workspace, "build", "out.o"), []byte("rebuilt"), 0644)
+	// Meanwhile, an unrelated file was created in the workspace.
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("mine"), 0644)
+
+	expected := []SandboxChange{
+		{Path: "main.py", Kind: SandboxModified},
+		{Path: "new.txt", Kind: SandboxCreated},
+		{Path: "sub/", Kind: SandboxDeleted},
+		{Path: "sub/util.py", Kind: SandboxDeleted},
+	}
+	changes, err := sandbox.Changes()
+	if err != nil {
+		t.Fatalf("Changes() error = %v", err)
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Changes() = %v, want %v", changes, expected)
+	}
+
+	applied, err := sandbox.Apply(false)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if !reflect.DeepEqual(applied, expected) {
+		t.Errorf("Apply() = %v, want %v", applied, expected)
+	}
+
+	files := map[string]string{
+		"main.py":     "print('changed')",
+		"new.txt":     "created",
+		"notes.txt":   "mine",
+		"build/out.o": "object",
+		"sub/util.py": "",
+	}
+	for name, content := range files {
+		if actual := readFile(filepath.Join(dir, name)); actual != content {
+			t.Errorf("%s = %q, want %q", name, actual, content)
+		}
+	}
+	if _, err := OpenSandbox(sandbox.ID); err == nil {
+		t.Error("an applied sandbox should be removed")
+	}
+}
+
+func TestSandboxApplyConflicts(t *testing.T) {
+	sandbox, dir := newTestSandbox(t)
+
+	os.WriteFile(filepath.Join(sandbox.Workspace, "main.py"), []byte("print('sandbox')"), 0644)
+	os.RemoveAll(filepath.Join(sandbox.Workspace, "sub"))
+	os.WriteFile(filepath.Join(dir, "main.py"), []byte("print('workspace')"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "added.py"), []byte("y = 2"), 0644)
+
+	_, err := sandbox.Apply(false)
+	var conflictErr *SandboxConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("Apply() error = %v, want a *SandboxConflictError", err)
+	}
+	if expected := []string{"main.py", "sub/added.py"}; !reflect.DeepEqual(conflictErr.Paths, expected) {
+		t.Errorf("conflicts = %v, want %v", conflictErr.Paths, expected)
+	}
+	if content := readFile(filepath.Join(dir, "main.py")); content != "print('workspace')" {
+		t.Errorf("main.py = %q, want nothing applied", content)
+	}
+
+	if _, err := sandbox.Apply(true); err != nil {
+		t.Fatalf("Apply(force) error = %v", err)
+	}
+	if content := readFile(filepath.Join(dir, "main.py")); content != "print('sandbox')" {
+		t.Errorf("main.py = %q, want the sandbox's version", content)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub")); !os.IsNotExist(err) {
+		t.Errorf("sub should have been removed, stat error = %v", err)
+	}
+}
+
+func TestSandboxApplyTypeChanges(t *testing.T) {
+	sandbox, dir := newTestSandbox(t)
+
+	// The command replaced the sub directory with a file, and main.py with a
+	// directory.
+	os.RemoveAll(filepath.Join(sandbox.Workspace, "sub"))
+	os.WriteFile(filepath.Join(sandbox.Workspace, "sub"), []byte("flat"), 0600)
+	os.Remove(filepath.Join(sandbox.Workspace, "main.py"))
+	os.MkdirAll(filepath.Join(sandbox.Workspace, "main.py"), 0755)
+	os.WriteFile(filepath.Join(sandbox.Workspace, "main.py", "__init__.py"), []byte("pass"), 0644)
+
+	if _, err := sandbox.Apply(false); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if content := readFile(filepath.Join(dir, "sub")); content != "flat" {
+		t.Errorf("sub = %q, want the sandbox's file", content)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "sub")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("sub has mode %v, %v, want 0600", info.Mode(), err)
+	}
+	if content := readFile(filepath.Join(dir, "main.py", "__init__.py")); content != "pass" {
+		t.Errorf("main.py/__init__.py = %q, want the sandbox's file", content)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 3 {
+		t.Errorf("workspace has %d entries, want build, main.py and sub without temporary files", len(entries))
+	}
+}
+
+func TestSandboxDiff(t *testing.T) {
+	sandbox, _ := newTestSandbox(t)
+	os.WriteFile(filepath.Join(sandbox.Workspace, "main.py"), []byte("print('hi')\nprint('bye')\n"), 0644)
+	os.WriteFile(filepath.Join(sandbox.Workspace, "image.png"), []byte("\x89PNG\x00\x01"), 0644)
+	os.Remove(filepath.Join(sandbox.Workspace, "sub", "util.py"))
+
+	changes, err := sandbox.Changes()
+	if err != nil {
+		t.Fatalf("Changes() error = %v", err)
+	}
+	var diffs strings.Builder
+	var listed []string
+	for _, change := range changes {
+		shown, err := sandbox.Diff(&diffs, change)
+		if err != nil {
+			t.Fatalf("Diff(%s) error = %v", change.Path, err)
+		}
+		if !shown {
+			listed = append(listed, change.Path)
+		}
+	}
+
+	expected := "--- a/main.py\n+++ b/main.py\n@@ -1 +1,2 @@\n-print('hi')\n\\ No newline at end of file\n+print('hi')\n+print('bye')\n"
+	if diffs.String() != expected {
+		t.Errorf("Diff() wrote\n%s\nwant\n%s", diffs.String(), expected)
+	}
+	if expected := []string{"image.png", "sub/util.py"}; !reflect.DeepEqual(listed, expected) {
+		t.Errorf("Diff() left %v to be listed, want %v", listed, expected)
+	}
+}
+
+func TestSandboxDiscard(t *testing.T) {
+	sandbox, dir := newTestSandbox(t)
+	os.WriteFile(filepath.Join(sandbox.Workspace, "main.py"), []byte("print('changed')"), 0644)
+	// Directories the command made read-only don't stop the sandbox from being removed.
+	os.Chmod(filepath.Join(sandbox.Workspace, "sub"), 0555)
+
+	if err := sandbox.Discard(); err != nil {
+		t.Fatalf("Discard() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(sandboxesDir(), sandbox.ID)); !os.IsNotExist(err) {
+		t.Errorf("sandbox should have been removed, stat error = %v", err)
+	}
+	if content := readFile(filepath.Join(dir, "main.py")); content != "print('hi')" {
+		t.Errorf("main.py = %q, want it untouched", content)
+	}
+	sandboxes, err := ListSandboxes()
+	if err != nil || len(sandboxes) != 0 {
+		t.Errorf("ListSandboxes() = %v, %v, want none", sandboxes, err)
+	}
+}
+
+func TestContainerOptionsSandbox(t *testing.T) {
+	cwd, _ := os.Getwd()
+	cfg := &config.CommandConfig{Image: "alpine", Sandbox: "/cache/dox/sandboxes/1/workspace", PathArgs: config.PathArgsAuto}
+	opts := newContainerOptions(cfg, "tool", "alpine", []string{filepath.Join(cwd, "sandbox.go")}, false)
+
+	if volume := opts.Volumes[0]; volume != "/cache/dox/sandboxes/1/workspace:/workspace" {
+		t.Errorf("workspace volume = %s, want the sandbox mounted at /workspace", volume)
+	}
+	// Paths in the workspace are found in the sandbox.
+	for _, volume := range opts.Volumes {
+		if strings.HasPrefix(volume, cwd) {
+			t.Errorf("volumes = %v, the workspace must not be mounted", opts.Volumes)
+		}
+	}
+	if !reflect.DeepEqual(opts.Command, []string{"/workspace/sandbox.go"}) {
+		t.Errorf("Command = %v, want the path in the sandbox", opts.Command)
+	}
+}
@@ -0,0 +1,147 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+// readOnlyTmpfs are the in-memory scratch directories of containers with a
+// read-only root filesystem. Podman mounts them by default, so they are mounted
+// explicitly to make Docker behave the same.
+var readOnlyTmpfs = []string{"/tmp", "/var/tmp", "/run"}
+
+// applySecurity sets a command's security hardening on container options,
+// starting from its preset.
+func applySecurity(opts *ContainerOptions, security *config.SecurityConfig) {
+	if security == nil {
+		return
+	}
+
+	readOnly, noNewPrivileges := false, false
+	var capDrop []string
+	if security.Preset == config.SecurityPresetStrict {
+		readOnly, noNewPrivileges = true, true
+		capDrop = []string{"ALL"}
+	}
+	if security.ReadOnlyRootfs != nil {
+		readOnly = *security.ReadOnlyRootfs
+	}
+	if security.NoNewPrivileges != nil {
+		noNewPrivileges = *security.NoNewPrivileges
+	}
+
+	opts.ReadOnly = readOnly
+	if readOnly {
+		for _, path := range readOnlyTmpfs {
+			if !hasTmpfs(security.Tmpfs, path) {
+				opts.Tmpfs = append(opts.Tmpfs, path)
+			}
+		}
+	}
+	opts.Tmpfs = append(opts.Tmpfs, security.Tmpfs...)
+
+	for _, capability := range append(capDrop, security.CapDrop...) {
+		opts.CapDrop = append(opts.CapDrop, strings.ToUpper(capability))
+	}
+	for _, capability := range security.CapAdd {
+		opts.CapAdd = append(opts.CapAdd, strings.ToUpper(capability))
+	}
+
+	if noNewPrivileges {
+		opts.SecurityOpt = append(opts.SecurityOpt, "no-new-privileges")
+	}
+	if security.Seccomp != "" {
+		opts.SecurityOpt = append(opts.SecurityOpt, "seccomp="+security.Seccomp)
+	}
+	if security.AppArmor != "" {
+		opts.SecurityOpt = append(opts.SecurityOpt, "apparmor="+security.AppArmor)
+	}
+}
+
+// hasTmpfs reports whether a tmpfs is mounted at path.
+func hasTmpfs(tmpfs []string, path string) bool {
+	for _, mount := range tmpfs {
+		if target, _, _ := strings.Cut(mount, ":"); target == path {
+			return true
+		}
+	}
+	return false
+}
+
+// securityArgs translates security settings into run arguments, which are
+// compatible with docker run.
+func securityArgs(opts ContainerOptions) []string {
+	var args []string
+	if opts.ReadOnly {
+		args = append(args, "--read-only")
+	}
+	for _, tmpfs := range opts.Tmpfs {
+		args = append(args, "--tmpfs="+tmpfs)
+	}
+	for _, capability := range opts.CapDrop {
+		args = append(args, "--cap-drop="+capability)
+	}
+	for _, capability := range opts.CapAdd {
+		args = append(args, "--cap-add="+capability)
+	}
+	for _, opt := range opts.SecurityOpt {
+		args = append(args, "--security-opt="+opt)
+	}
+	return args
+}
+
+// applyDockerSecurity sets security settings on a Docker host config. Like the
+// docker CLI, it sends the contents of the seccomp profile, since the daemon
+// may not be able to read the file.
+func applyDockerSecurity(hostConfig *container.HostConfig, opts ContainerOptions) error {
+	hostConfig.ReadonlyRootfs = opts.ReadOnly
+	hostConfig.CapDrop = opts.CapDrop
+	hostConfig.CapAdd = opts.CapAdd
+	if len(opts.Tmpfs) > 0 {
+		hostConfig.Tmpfs = make(map[string]string)
+		for _, tmpfs := range opts.Tmpfs {
+			path, options, _ := strings.Cut(tmpfs, ":")
+			hostConfig.Tmpfs[path] = options
+		}
+	}
+
+	profile, err := seccompProfile(opts)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts.SecurityOpt {
+		if strings.HasPrefix(opt, "seccomp=") && profile != "" {
+			opt = "seccomp=" + profile
+		}
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, opt)
+	}
+	return nil
+}
+
+// seccompProfile reads the seccomp profile of a container, if it has one that
+// isn't unconfined, so both runtimes fail the same way when it is missing or
+// invalid.
+func seccompProfile(opts ContainerOptions) (string, error) {
+	for _, opt := range opts.SecurityOpt {
+		path, ok := strings.CutPrefix(opt, "seccomp=")
+		if !ok || path == config.SecurityUnconfined {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", &ContainerError{Err: fmt.Errorf("failed to read seccomp profile: %w", err)}
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, content); err != nil {
+			return "", &ContainerError{Err: fmt.Errorf("invalid seccomp profile %s: %w", path, err)}
+		}
+		return compact.String(), nil
+	}
+	return "", nil
+}
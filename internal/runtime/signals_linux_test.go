@@ -0,0 +1,110 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+func TestDockerExecuteCommandForwardsResize(t *testing.T) {
+	_, tty := openPTY(t)
+	setPTYSize(t, tty, 100, 30)
+
+	fake := newFakeDocker(t)
+	fake.AddImage("alpine")
+	fake.RunUntilKilled = true
+	rt := fake.runtime()
+
+	type outcome struct {
+		result ExecResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := rt.ExecuteCommand(context.Background(), &config.CommandConfig{Image: "alpine"}, "vim", nil, false, tty, tty, tty)
+		done <- outcome{result, err}
+	}()
+
+	c := waitForContainer(t, fake)
+	if size := c.Create.HostConfig.ConsoleSize; size != [2]uint{30, 100} {
+		t.Errorf("ConsoleSize = %v, want [30 100]", size)
+	}
+
+	// Resize the terminal until the change reaches the container. Signals sent
+	// before the forwarder is listening are ignored.
+	setPTYSize(t, tty, 120, 40)
+	resizePath := "/containers/" + c.ID + "/resize"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err := syscall.Kill(syscall.Getpid(), syscall.SIGWINCH); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := fake.Request("POST", resizePath); ok || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resize, ok := fake.Request("POST", resizePath)
+	if !ok {
+		t.Fatal("terminal resize was not forwarded")
+	}
+	if width, height := resize.Query.Get("w"), resize.Query.Get("h"); width != "120" || height != "40" {
+		t.Errorf("resize = %sx%s, want 120x40", width, height)
+	}
+
+	// Stop the container through the signal target, as a forwarded SIGTERM would.
+	target := &dockerSignalTarget{client: rt.client, containerID: c.ID}
+	if err := target.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("Signal() error = %v", err)
+	}
+
+	select {
+	case outcome := <-done:
+		if outcome.err != nil {
+			t.Fatalf("ExecuteCommand() error = %v", outcome.err)
+		}
+		if outcome.result.ExitCode != 143 {
+			t.Errorf("exitCode = %d, want 143", outcome.result.ExitCode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ExecuteCommand() did not return after the container was signalled")
+	}
+
+	kill, _ := fake.Request("POST", "/containers/"+c.ID+"/kill")
+	if signal := kill.Query.Get("signal"); signal != "15" {
+		t.Errorf("kill signal = %q, want 15", signal)
+	}
+}
+
+func TestDockerSignalTargetKill(t *testing.T) {
+	fake := newFakeDocker(t)
+	fake.AddImage("alpine")
+	fake.RunUntilKilled = true
+	rt := fake.runtime()
+
+	done := make(chan ExecResult, 1)
+	go func() {
+		result, _ := rt.ExecuteCommand(context.Background(), &config.CommandConfig{Image: "alpine"}, "sleep", nil, false, nil, &bytes.Buffer{}, &bytes.Buffer{})
+		done <- result
+	}()
+
+	c := waitForContainer(t, fake)
+	target := &dockerSignalTarget{client: rt.client, containerID: c.ID}
+	if err := target.Kill(); err != nil {
+		t.Fatalf("Kill() error = %v", err)
+	}
+
+	select {
+	case result := <-done:
+		if result.ExitCode != 137 {
+			t.Errorf("exitCode = %d, want 137", result.ExitCode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ExecuteCommand() did not return after the container was killed")
+	}
+}
@@ -0,0 +1,60 @@
+package runtime
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/skorokithakis/dox/internal/config"
+	"github.com/skorokithakis/dox/internal/utils"
+)
+
+// applyStop sets how a command's container is stopped on container options. The
+// configuration loader rejects invalid settings, so they are skipped here.
+func applyStop(opts *ContainerOptions, cfg *config.CommandConfig) {
+	if timeout, err := time.ParseDuration(cfg.Timeout); err == nil {
+		opts.Timeout = timeout
+	}
+	if cfg.StopSignal != "" {
+		if name, ok := config.SignalName(cfg.StopSignal); ok {
+			opts.StopSignal = name
+		}
+	}
+	if grace, err := time.ParseDuration(cfg.StopGracePeriod); err == nil {
+		opts.StopGracePeriod = grace
+	}
+}
+
+// stopArgs translates the stop settings into run arguments, which are compatible
+// with docker run. They apply when the container is stopped from outside dox.
+func stopArgs(opts ContainerOptions) []string {
+	var args []string
+	if opts.StopSignal != "" {
+		args = append(args, "--stop-signal="+opts.StopSignal)
+	}
+	if opts.StopGracePeriod > 0 {
+		args = append(args, fmt.Sprintf("--stop-timeout=%d", stopTimeout(opts)))
+	}
+	return args
+}
+
+// stopTimeout returns the grace period in whole seconds, which is what the
+// runtimes accept, rounded up so the command gets at least as long.
+func stopTimeout(opts ContainerOptions) int {
+	return int(math.Ceil(opts.StopGracePeriod.Seconds()))
+}
+
+// newSignalForwarder creates a forwarder that stops the container the way its
+// options ask for.
+func newSignalForwarder(target utils.SignalTarget, terminal *os.File, opts ContainerOptions) *utils.SignalForwarder {
+	forwarder := utils.NewSignalForwarder(target, terminal)
+	forwarder.Timeout = opts.Timeout
+	if opts.StopSignal != "" {
+		forwarder.StopSignal = opts.StopSignal
+	}
+	if opts.StopGracePeriod > 0 {
+		forwarder.GracePeriod = opts.StopGracePeriod
+	}
+	return forwarder
+}
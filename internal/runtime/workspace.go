@@ -0,0 +1,370 @@
+package runtime
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+// workspaceDir is the directory in the container where the workspace is made available.
+const workspaceDir = "/workspace"
+
+// workspaceLayout describes where the workspace is in the container.
+type workspaceLayout struct {
+	// hostRoot is mounted at containerRoot.
+	hostRoot      string
+	containerRoot string
+	// workingDir is the container's counterpart of the current directory.
+	workingDir string
+}
+
+// resolveWorkspace works out the workspace layout of a workspace mode for the
+// current directory. Outside of a git repository, git-root behaves like cwd.
+func resolveWorkspace(mode, cwd string) workspaceLayout {
+	switch mode {
+	case config.WorkspaceMirror:
+		mirror := containerPathUnder("/", cwd)
+		return workspaceLayout{hostRoot: cwd, containerRoot: mirror, workingDir: mirror}
+
+	case config.WorkspaceGitRoot:
+		root, ok := findGitRoot(cwd)
+		if !ok {
+			logrus.Warnf("%s is not in a git repository, mounting it as the workspace", cwd)
+			break
+		}
+		rel, _ := filepath.Rel(root, cwd)
+		return workspaceLayout{hostRoot: root, containerRoot: workspaceDir, workingDir: path.Join(workspaceDir, filepath.ToSlash(rel))}
+	}
+
+	return workspaceLayout{hostRoot: cwd, containerRoot: workspaceDir, workingDir: workspaceDir}
+}
+
+// findGitRoot returns the root of the git repository dir is in. Worktrees and
+// submodules, whose .git is a file, count as repositories.
+func findGitRoot(dir string) (string, bool) {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// workspaceSnapshot records the state of every file copied into a container,
+// keyed by slash-separated path relative to the workspace root.
+type workspaceSnapshot map[string]workspaceEntry
+
+// workspaceEntry describes a single file or directory in a workspace snapshot.
+type workspaceEntry struct {
+	Dir    bool
+	Mode   fs.FileMode
+	Hash   string
+	Target string
+}
+
+// streamWorkspace archives dir in the background, so the archive is streamed
+// to its reader rather than held in memory. The returned function closes the
+// reader, waits for the archive to finish and returns its snapshot. The
+// archive doesn't fail because its reader stopped early, since the reader
+// reports why it did.
+func streamWorkspace(dir, prefix string, ignore []string, uid, gid int) (io.Reader, func() (workspaceSnapshot, error)) {
+	reader, writer := io.Pipe()
+	type archived struct {
+		snapshot workspaceSnapshot
+		err      error
+	}
+	done := make(chan archived, 1)
+	go func() {
+		snapshot, err := archiveWorkspace(writer, dir, prefix, ignore, uid, gid)
+		writer.CloseWithError(err)
+		if errors.Is(err, io.ErrClosedPipe) {
+			err = nil
+		}
+		done <- archived{snapshot, err}
+	}()
+	return reader, func() (workspaceSnapshot, error) {
+		// Closing the reader stops the archive if it wasn't read to the end.
+		reader.Close()
+		result := <-done
+		return result.snapshot, result.err
+	}
+}
+
+// archiveWorkspace writes a tar archive of dir whose entries live under prefix.
+// Files matching the ignore patterns are skipped, and all entries are owned by uid:gid
+// so the container user can modify them.
+func archiveWorkspace(w io.Writer, dir, prefix string, ignore []string, uid, gid int) (workspaceSnapshot, error) {
+	tw := tar.NewWriter(w)
+	snapshot := workspaceSnapshot{}
+
+	root := &tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     prefix + "/",
+		Mode:     0755,
+		Uid:      uid,
+		Gid:      gid,
+	}
+	if err := tw.WriteHeader(root); err != nil {
+		return nil, fmt.Errorf("failed to write tar header: %w", err)
+	}
+
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath == dir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if isIgnored(rel, ignore) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		header := &tar.Header{
+			Name:    path.Join(prefix, rel),
+			Mode:    int64(info.Mode().Perm()),
+			ModTime: info.ModTime(),
+			Uid:     uid,
+			Gid:     gid,
+		}
+
+		switch {
+		case info.IsDir():
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+			snapshot[rel] = workspaceEntry{Dir: true, Mode: info.Mode().Perm()}
+			return tw.WriteHeader(header)
+
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(filePath)
+			if err != nil {
+				return err
+			}
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = target
+			snapshot[rel] = workspaceEntry{Target: target}
+			return tw.WriteHeader(header)
+
+		case info.Mode().IsRegular():
+			file, err := os.Open(filePath)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			header.Typeflag = tar.TypeReg
+			header.Size = info.Size()
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			hash := sha256.New()
+			if _, err := io.CopyN(io.MultiWriter(tw, hash), file, info.Size()); err != nil {
+				return fmt.Errorf("failed to read %s: %w", rel, err)
+			}
+			snapshot[rel] = workspaceEntry{Mode: info.Mode().Perm(), Hash: hex.EncodeToString(hash.Sum(nil))}
+			return nil
+		}
+
+		// Sockets, devices and pipes can't be meaningfully copied.
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to archive workspace: %w", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close tar writer: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// syncWorkspace applies the changes made inside the container back to dir.
+// The reader is the archive of the container workspace, with entries under prefix.
+// Only files that differ from the snapshot are written, and files that were copied
+// in but no longer exist in the container are removed.
+func syncWorkspace(dir, prefix string, r io.Reader, snapshot workspaceSnapshot, ignore []string) error {
+	tr := tar.NewReader(r)
+	seen := map[string]bool{}
+
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve workspace directory: %w", err)
+	}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read workspace archive: %w", err)
+		}
+
+		name := path.Clean(header.Name)
+		if !strings.HasPrefix(name, prefix+"/") {
+			continue
+		}
+		rel := strings.TrimPrefix(name, prefix+"/")
+		if isIgnored(rel, ignore) {
+			continue
+		}
+		seen[rel] = true
+
+		target := filepath.Join(root, filepath.FromSlash(rel))
+		if !withinDir(root, target) {
+			return fmt.Errorf("refusing to write %s: path escapes the workspace", rel)
+		}
+		previous, existed := snapshot[rel]
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if existed && previous.Dir {
+				continue
+			}
+			if err := os.MkdirAll(target, fs.FileMode(header.Mode).Perm()); err != nil {
+				return fmt.Errorf("failed to create %s: %w", rel, err)
+			}
+
+		case tar.TypeSymlink:
+			if existed && previous.Target == header.Linkname {
+				continue
+			}
+			_ = os.RemoveAll(target)
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return fmt.Errorf("failed to create symlink %s: %w", rel, err)
+			}
+
+		case tar.TypeReg:
+			if err := syncFile(tr, target, fs.FileMode(header.Mode).Perm(), previous, existed); err != nil {
+				return fmt.Errorf("failed to write %s: %w", rel, err)
+			}
+		}
+	}
+
+	// Remove whatever was deleted inside the container.
+	for rel := range snapshot {
+		if seen[rel] {
+			continue
+		}
+		target := filepath.Join(root, filepath.FromSlash(rel))
+		if !withinDir(root, target) {
+			continue
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", rel, err)
+		}
+	}
+
+	return nil
+}
+
+// syncFile streams a file from the archive to target. It is written to a
+// temporary file next to target while it is hashed, and only replaces target
+// if it differs from the snapshot.
+func syncFile(r io.Reader, target string, mode fs.FileMode, previous workspaceEntry, existed bool) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(target), ".dox-sync-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if existed && previous.Hash == hex.EncodeToString(hash.Sum(nil)) {
+		if previous.Mode != mode {
+			return os.Chmod(target, mode)
+		}
+		return nil
+	}
+	if existed && previous.Dir {
+		_ = os.RemoveAll(target)
+	}
+	if err := os.Chmod(file.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), target)
+}
+
+// isIgnored reports whether a slash-separated relative path, or any of its parent
+// directories, matches one of the ignore patterns. Patterns are matched against both
+// the full relative path and the base name, so ".git" and "build/*.o" both work.
+func isIgnored(rel string, patterns []string) bool {
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], "/")
+		for _, pattern := range patterns {
+			pattern = strings.TrimSuffix(pattern, "/")
+			if matched, _ := path.Match(pattern, prefix); matched {
+				return true
+			}
+			if matched, _ := path.Match(pattern, parts[i]); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withinDir reports whether target stays inside root once symlinks in its
+// existing parent directories are resolved.
+func withinDir(root, target string) bool {
+	parent := filepath.Dir(target)
+	for {
+		if _, err := os.Lstat(parent); err == nil {
+			break
+		}
+		parent = filepath.Dir(parent)
+	}
+
+	resolved, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, resolved)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// hashBytes returns the hex-encoded SHA-256 digest of data.
+func hashBytes(data []byte) string {
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
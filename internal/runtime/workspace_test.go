@@ -0,0 +1,253 @@
+package runtime
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/skorokithakis/dox/internal/config"
+)
+
+// buildTar creates a tar archive from a map of names to contents.
+// Names ending in a slash are directories.
+func buildTar(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for name, content := range files {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(name, "/") {
+			header = &tar.Header{Name: name, Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+// tarNames lists the entry names of a tar archive.
+func tarNames(t *testing.T, data []byte) map[string]bool {
+	t.Helper()
+	names := map[string]bool{}
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names[header.Name] = true
+	}
+	return names
+}
+
+func TestWorkspaceCopyRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.MkdirAll(filepath.Join(dir, ".git"), 0755)
+	os.WriteFile(filepath.Join(dir, "main.py"), []byte("print('hi')"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "util.py"), []byte("x = 1"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "util.pyc"), []byte("bytecode"), 0644)
+	os.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("ref: refs/heads/main"), 0644)
+
+	fake := newFakeDocker(t)
+	fake.AddImage("python:3.11")
+	rt := fake.runtime()
+	created, err := rt.client.ContainerCreate(context.Background(), &container.Config{Image: "python:3.11"}, nil, nil, nil, "")
+	if err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+
+	ignore := []string{".git", "*.pyc"}
+	snapshot, err := rt.copyWorkspaceIn(context.Background(), created.ID, dir, ignore, 1000, 1000, false)
+	if err != nil {
+		t.Fatalf("copyWorkspaceIn() error = %v", err)
+	}
+
+	uploaded := tarNames(t, fake.Containers()[0].Archive)
+	for _, name := range []string{"workspace/", "workspace/main.py", "workspace/sub/", "workspace/sub/util.py"} {
+		if !uploaded[name] {
+			t.Errorf("uploaded archive is missing %s", name)
+		}
+	}
+	for _, name := range []string{"workspace/.git/", "workspace/.git/HEAD", "workspace/sub/util.pyc"} {
+		if uploaded[name] {
+			t.Errorf("uploaded archive should not contain ignored %s", name)
+		}
+	}
+
+	// The container modified main.py, deleted sub/util.py, created a new file and
+	// touched an ignored path.
+	fake.Archive = buildTar(t, map[string]string{
+		"workspace/":          "",
+		"workspace/main.py":   "print('changed')",
+		"workspace/sub/":      "",
+		"workspace/new.txt":   "created",
+		"workspace/.git/":     "",
+		"workspace/.git/HEAD": "tampered",
+	})
+
+	if err := rt.copyWorkspaceOut(created.ID, dir, snapshot, ignore); err != nil {
+		t.Fatalf("copyWorkspaceOut() error = %v", err)
+	}
+
+	expected := map[string]string{
+		"main.py":      "print('changed')",
+		"new.txt":      "created",
+		".git/HEAD":    "ref: refs/heads/main",
+		"sub/util.pyc": "bytecode",
+	}
+	for name, content := range expected {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("failed to read %s: %v", name, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", name, data, content)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "sub", "util.py")); !os.IsNotExist(err) {
+		t.Errorf("sub/util.py should have been removed, stat error = %v", err)
+	}
+}
+
+func TestWorkspaceStreaming(t *testing.T) {
+	source := t.TempDir()
+	large := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	os.WriteFile(filepath.Join(source, "large.bin"), large, 0644)
+	os.WriteFile(filepath.Join(source, "same.txt"), []byte("unchanged"), 0600)
+
+	// Stream the workspace to a copy, and then the copy back over the source.
+	copied := t.TempDir()
+	archive, wait := streamWorkspace(source, "workspace", nil, 1000, 1000)
+	syncErr := syncWorkspace(copied, "workspace", archive, workspaceSnapshot{}, nil)
+	snapshot, err := wait()
+	if err != nil || syncErr != nil {
+		t.Fatalf("streaming the workspace failed: %v, %v", err, syncErr)
+	}
+	if data, _ := os.ReadFile(filepath.Join(copied, "large.bin")); !bytes.Equal(data, large) {
+		t.Errorf("large.bin has %d bytes, want the original %d", len(data), len(large))
+	}
+	if info, err := os.Stat(filepath.Join(copied, "same.txt")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("same.txt has mode %v, %v, want 0600", info.Mode(), err)
+	}
+
+	before, _ := os.Stat(filepath.Join(source, "same.txt"))
+	os.WriteFile(filepath.Join(copied, "large.bin"), []byte("small"), 0644)
+	archive, wait = streamWorkspace(copied, "workspace", nil, 1000, 1000)
+	syncErr = syncWorkspace(source, "workspace", archive, snapshot, nil)
+	if _, err := wait(); err != nil || syncErr != nil {
+		t.Fatalf("streaming the copy back failed: %v, %v", err, syncErr)
+	}
+	if data, _ := os.ReadFile(filepath.Join(source, "large.bin")); string(data) != "small" {
+		t.Errorf("large.bin = %q, want the copy's change", data)
+	}
+	// Unchanged files aren't rewritten, and no temporary files are left behind.
+	if after, err := os.Stat(filepath.Join(source, "same.txt")); err != nil || !os.SameFile(before, after) {
+		t.Errorf("same.txt was replaced although it didn't change, stat error = %v", err)
+	}
+	if entries, _ := os.ReadDir(source); len(entries) != 2 {
+		t.Errorf("source has %d entries, want only large.bin and same.txt", len(entries))
+	}
+}
+
+func TestStreamWorkspaceStoppedReader(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "large.bin"), bytes.Repeat([]byte("x"), 1<<20), 0644)
+
+	// A reader that stops early doesn't leave the archive blocked, and reports
+	// the failure itself.
+	archive, wait := streamWorkspace(dir, "workspace", nil, 1000, 1000)
+	io.CopyN(io.Discard, archive, 1024)
+	if _, err := wait(); err != nil {
+		t.Errorf("wait() error = %v, want the reader's failure left to it", err)
+	}
+}
+
+func TestSyncWorkspaceRejectsEscapes(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	tw.WriteHeader(&tar.Header{Name: "workspace/link", Typeflag: tar.TypeSymlink, Linkname: outside})
+	tw.WriteHeader(&tar.Header{Name: "workspace/link/evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 4})
+	tw.Write([]byte("evil"))
+	tw.Close()
+
+	if err := syncWorkspace(dir, "workspace", buf, workspaceSnapshot{}, nil); err == nil {
+		t.Fatal("syncWorkspace() should refuse to write through a symlink")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "evil")); !os.IsNotExist(err) {
+		t.Errorf("file was written outside the workspace")
+	}
+}
+
+func TestIsIgnored(t *testing.T) {
+	patterns := []string{".git", "node_modules/", "*.pyc", "build/*.o"}
+
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{".git", true},
+		{".git/objects/ab", true},
+		{"vendor/node_modules/pkg/index.js", true},
+		{"pkg/module.pyc", true},
+		{"build/main.o", true},
+		{"src/build/main.o", false},
+		{"src/main.py", false},
+		{".gitignore", false},
+	}
+
+	for _, tt := range tests {
+		if result := isIgnored(tt.path, patterns); result != tt.expected {
+			t.Errorf("isIgnored(%s) = %v, want %v", tt.path, result, tt.expected)
+		}
+	}
+}
+
+func TestResolveWorkspace(t *testing.T) {
+	repo := t.TempDir()
+	cwd := filepath.Join(repo, "services", "api")
+	if err := os.MkdirAll(cwd, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Worktrees have a .git file rather than a directory.
+	if err := os.WriteFile(filepath.Join(repo, ".git"), []byte("gitdir: /elsewhere\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+
+	tests := []struct {
+		mode     string
+		cwd      string
+		expected workspaceLayout
+	}{
+		{config.WorkspaceCwd, cwd, workspaceLayout{hostRoot: cwd, containerRoot: "/workspace", workingDir: "/workspace"}},
+		{config.WorkspaceCopy, cwd, workspaceLayout{hostRoot: cwd, containerRoot: "/workspace", workingDir: "/workspace"}},
+		{config.WorkspaceMirror, cwd, workspaceLayout{hostRoot: cwd, containerRoot: cwd, workingDir: cwd}},
+		{config.WorkspaceGitRoot, cwd, workspaceLayout{hostRoot: repo, containerRoot: "/workspace", workingDir: "/workspace/services/api"}},
+		{config.WorkspaceGitRoot, repo, workspaceLayout{hostRoot: repo, containerRoot: "/workspace", workingDir: "/workspace"}},
+		{config.WorkspaceGitRoot, outside, workspaceLayout{hostRoot: outside, containerRoot: "/workspace", workingDir: "/workspace"}},
+	}
+
+	for _, tt := range tests {
+		if actual := resolveWorkspace(tt.mode, tt.cwd); actual != tt.expected {
+			t.Errorf("resolveWorkspace(%s, %s) = %+v, want %+v", tt.mode, tt.cwd, actual, tt.expected)
+		}
+	}
+}
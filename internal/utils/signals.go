@@ -1,34 +1,222 @@
 package utils
 
 import (
-	"context"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
-	"github.com/docker/docker/client"
 	"github.com/sirupsen/logrus"
 )
 
-// SetupSignalHandler sets up signal forwarding to a container.
-func SetupSignalHandler(ctx context.Context, dockerClient *client.Client, containerID string) {
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
-
-	go func() {
-		for sig := range sigChan {
-			// Forward the signal to the container.
-			if err := dockerClient.ContainerKill(ctx, containerID, sig.String()); err != nil {
-				// Container might have already exited.
-				if !client.IsErrNotFound(err) {
-					logrus.Debugf("Failed to forward signal %s: %v", sig, err)
+// DefaultGracePeriod is how long a container gets to exit after a second
+// interrupt, or after being asked to stop, before it is killed.
+const DefaultGracePeriod = 10 * time.Second
+
+// DefaultStopSignal is the signal that asks a container to stop.
+const DefaultStopSignal = "SIGTERM"
+
+// forwardedSignals are the signals relayed to the container.
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP}
+
+// SignalTarget is a running container that signals and terminal resizes are relayed to.
+type SignalTarget interface {
+	// Signal delivers a signal to the container's main process.
+	Signal(sig os.Signal) error
+	// Resize changes the size of the container's TTY.
+	Resize(width, height int) error
+	// Stop sends the signal with the given name, like SIGTERM, to the
+	// container's main process to ask it to stop.
+	Stop(signal string) error
+	// Kill stops the container immediately.
+	Kill() error
+}
+
+// SignalForwarder relays the signals received by dox to a container while it runs.
+// The first interrupt is forwarded like any other signal. A second one is forwarded
+// too, and the container is killed if it hasn't exited after the grace period. A
+// third interrupt kills it straight away.
+//
+// When dox is terminated or the timeout ends, the container is stopped instead:
+// it is sent the stop signal, and killed if it hasn't exited after the grace
+// period, so it doesn't outlive dox.
+type SignalForwarder struct {
+	// GracePeriod is how long to wait after the second interrupt, or after
+	// the stop signal, before killing the container. It must be set before Start.
+	GracePeriod time.Duration
+	// StopSignal is the name of the signal that asks the container to stop. It
+	// must be set before Start.
+	StopSignal string
+	// Timeout is how long the container may run before it is stopped, or zero
+	// for no limit. It must be set before Start.
+	Timeout time.Duration
+
+	target   SignalTarget
+	terminal *os.File
+	size     func() (width, height int)
+
+	signals    chan os.Signal
+	done       chan struct{}
+	finished   chan struct{}
+	stopOnce   sync.Once
+	killed     atomic.Bool
+	timedOut   atomic.Bool
+	interrupts int
+	stopping   bool
+}
+
+// NewSignalForwarder creates a forwarder for a container. When terminal is not nil,
+// its size is applied to the container's TTY whenever the window is resized.
+func NewSignalForwarder(target SignalTarget, terminal *os.File) *SignalForwarder {
+	f := &SignalForwarder{
+		GracePeriod: DefaultGracePeriod,
+		StopSignal:  DefaultStopSignal,
+		target:      target,
+		terminal:    terminal,
+		signals:     make(chan os.Signal, 8),
+		done:        make(chan struct{}),
+		finished:    make(chan struct{}),
+	}
+	if terminal != nil {
+		f.size = func() (int, int) {
+			return GetTerminalSize(terminal)
+		}
+	}
+	return f
+}
+
+// Start begins relaying signals.
+func (f *SignalForwarder) Start() {
+	signals := forwardedSignals
+	if f.terminal != nil && resizeSignal != nil {
+		signals = append(signals[:len(signals):len(signals)], resizeSignal)
+	}
+	signal.Notify(f.signals, signals...)
+	go f.run()
+}
+
+// Stop stops relaying signals. Once it returns, the target is no longer used.
+// It is safe to call more than once.
+func (f *SignalForwarder) Stop() {
+	f.stopOnce.Do(func() {
+		signal.Stop(f.signals)
+		close(f.done)
+	})
+	<-f.finished
+}
+
+// Killed reports whether the container was killed after repeated interrupts,
+// or because it didn't stop in time.
+func (f *SignalForwarder) Killed() bool {
+	return f.killed.Load()
+}
+
+// TimedOut reports whether the container was stopped because it ran for longer
+// than the timeout.
+func (f *SignalForwarder) TimedOut() bool {
+	return f.timedOut.Load()
+}
+
+// run relays signals until the forwarder is stopped.
+func (f *SignalForwarder) run() {
+	defer close(f.finished)
+
+	var grace, timeout <-chan time.Time
+	if f.Timeout > 0 {
+		timer := time.NewTimer(f.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		select {
+		case <-f.done:
+			return
+
+		case <-grace:
+			grace = nil
+			f.kill()
+
+		case <-timeout:
+			timeout = nil
+			f.timedOut.Store(true)
+			logrus.Warnf("Timed out after %s, stopping the container.", f.Timeout)
+			if grace == nil {
+				grace = f.stop()
+			}
+
+		case sig := <-f.signals:
+			if sig == resizeSignal {
+				f.resize()
+				continue
+			}
+
+			// Dox is being terminated, e.g. by a service manager or because its
+			// terminal was closed, so the container must not outlive it.
+			if sig == syscall.SIGTERM || sig == syscall.SIGHUP {
+				if grace == nil {
+					grace = f.stop()
+				}
+				continue
+			}
+
+			if sig == syscall.SIGINT {
+				f.interrupts++
+				if f.interrupts > 2 {
+					f.kill()
+					continue
 				}
+				if f.interrupts == 2 {
+					logrus.Warnf("Interrupted again, killing the container in %s. Press Ctrl+C to kill it now.", f.GracePeriod)
+					grace = time.After(f.GracePeriod)
+				}
+			}
+
+			if err := f.target.Signal(sig); err != nil {
+				// The container might have already exited.
+				logrus.Debugf("Failed to forward signal %s: %v", sig, err)
 			}
 		}
-	}()
+	}
+}
+
+// stop asks the container to stop once, and returns the grace period after
+// which it is killed. Once stopping, it returns nil.
+func (f *SignalForwarder) stop() <-chan time.Time {
+	if f.stopping {
+		return nil
+	}
+	f.stopping = true
+	if err := f.target.Stop(f.StopSignal); err != nil {
+		// The container might have already exited.
+		logrus.Debugf("Failed to send stop signal %s: %v", f.StopSignal, err)
+	}
+	return time.After(f.GracePeriod)
 }
 
-// CleanupSignalHandler stops signal handling.
-func CleanupSignalHandler() {
-	signal.Stop(make(chan os.Signal, 1))
-}
\ No newline at end of file
+// resize applies the terminal size to the container.
+func (f *SignalForwarder) resize() {
+	if f.size == nil {
+		return
+	}
+	width, height := f.size()
+	if err := f.target.Resize(width, height); err != nil {
+		logrus.Debugf("Failed to resize container TTY: %v", err)
+	}
+}
+
+// kill kills the container once.
+func (f *SignalForwarder) kill() {
+	if f.killed.Swap(true) {
+		return
+	}
+	if err := f.target.Kill(); err != nil {
+		logrus.Debugf("Failed to kill container: %v", err)
+	}
+}
+
+// SignalExitCode returns the conventional exit code of a process killed by a signal.
+func SignalExitCode(sig syscall.Signal) int {
+	return 128 + int(sig)
+}
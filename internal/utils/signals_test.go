@@ -0,0 +1,261 @@
+package utils
+
+import (
+	"os"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeTarget records what a SignalForwarder relays to it.
+type fakeTarget struct {
+	mu      sync.Mutex
+	signals []os.Signal
+	resizes [][2]int
+	stops   []string
+	kills   int
+	killed  chan struct{}
+}
+
+func newFakeTarget() *fakeTarget {
+	return &fakeTarget{killed: make(chan struct{}, 10)}
+}
+
+func (t *fakeTarget) Signal(sig os.Signal) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.signals = append(t.signals, sig)
+	return nil
+}
+
+func (t *fakeTarget) Resize(width, height int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.resizes = append(t.resizes, [2]int{width, height})
+	return nil
+}
+
+func (t *fakeTarget) Stop(signal string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stops = append(t.stops, signal)
+	return nil
+}
+
+func (t *fakeTarget) Stops() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.stops...)
+}
+
+func (t *fakeTarget) Kill() error {
+	t.mu.Lock()
+	t.kills++
+	t.mu.Unlock()
+	t.killed <- struct{}{}
+	return nil
+}
+
+func (t *fakeTarget) Signals() []os.Signal {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]os.Signal(nil), t.signals...)
+}
+
+func (t *fakeTarget) Kills() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.kills
+}
+
+// deliver hands signals to a forwarder as if they had been received, and waits
+// until they were handled.
+func deliver(f *SignalForwarder, signals ...os.Signal) {
+	for _, sig := range signals {
+		f.signals <- sig
+	}
+	// Wait for the channel to drain, then give the last signal time to be handled.
+	for len(f.signals) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestSignalForwarderForwardsSignals(t *testing.T) {
+	target := newFakeTarget()
+	f := NewSignalForwarder(target, nil)
+	f.Start()
+	defer f.Stop()
+
+	deliver(f, syscall.SIGQUIT, syscall.SIGINT)
+
+	expected := []os.Signal{syscall.SIGQUIT, syscall.SIGINT}
+	if signals := target.Signals(); !reflect.DeepEqual(signals, expected) {
+		t.Errorf("signals = %v, want %v", signals, expected)
+	}
+	if target.Kills() != 0 || f.Killed() {
+		t.Error("a single interrupt must not kill the container")
+	}
+}
+
+func TestSignalForwarderSecondInterruptKillsAfterGracePeriod(t *testing.T) {
+	target := newFakeTarget()
+	f := NewSignalForwarder(target, nil)
+	f.GracePeriod = 50 * time.Millisecond
+	f.Start()
+	defer f.Stop()
+
+	start := time.Now()
+	deliver(f, syscall.SIGINT, syscall.SIGINT)
+	if signals := target.Signals(); len(signals) != 2 {
+		t.Errorf("signals = %v, want both interrupts forwarded", signals)
+	}
+
+	select {
+	case <-target.killed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("container was not killed after the grace period")
+	}
+	if elapsed := time.Since(start); elapsed < f.GracePeriod {
+		t.Errorf("container was killed after %s, before the grace period", elapsed)
+	}
+	if !f.Killed() {
+		t.Error("Killed() should report the escalation")
+	}
+}
+
+func TestSignalForwarderThirdInterruptKillsImmediately(t *testing.T) {
+	target := newFakeTarget()
+	f := NewSignalForwarder(target, nil)
+	f.GracePeriod = time.Hour
+	f.Start()
+	defer f.Stop()
+
+	deliver(f, syscall.SIGINT, syscall.SIGINT, syscall.SIGINT, syscall.SIGINT)
+
+	if kills := target.Kills(); kills != 1 {
+		t.Errorf("kills = %d, want 1", kills)
+	}
+	if signals := target.Signals(); len(signals) != 2 {
+		t.Errorf("signals = %v, want only the first two interrupts forwarded", signals)
+	}
+}
+
+func TestSignalForwarderTerminationStopsContainer(t *testing.T) {
+	for _, sig := range []os.Signal{syscall.SIGTERM, syscall.SIGHUP} {
+		target := newFakeTarget()
+		f := NewSignalForwarder(target, nil)
+		f.StopSignal = "SIGINT"
+		f.GracePeriod = 50 * time.Millisecond
+		f.Start()
+
+		start := time.Now()
+		deliver(f, sig, sig)
+		if stops := target.Stops(); !reflect.DeepEqual(stops, []string{"SIGINT"}) {
+			t.Errorf("%s: stops = %v, want the stop signal sent once", sig, stops)
+		}
+		if signals := target.Signals(); len(signals) != 0 {
+			t.Errorf("%s: signals = %v, want nothing forwarded", sig, signals)
+		}
+
+		select {
+		case <-target.killed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: container was not killed after the grace period", sig)
+		}
+		if elapsed := time.Since(start); elapsed < f.GracePeriod {
+			t.Errorf("%s: container was killed after %s, before the grace period", sig, elapsed)
+		}
+		if f.TimedOut() {
+			t.Errorf("%s: TimedOut() = true, want false", sig)
+		}
+		f.Stop()
+	}
+}
+
+func TestSignalForwarderTimeout(t *testing.T) {
+	target := newFakeTarget()
+	f := NewSignalForwarder(target, nil)
+	f.Timeout = 20 * time.Millisecond
+	f.GracePeriod = 20 * time.Millisecond
+	f.Start()
+	defer f.Stop()
+
+	select {
+	case <-target.killed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("container was not killed after the timeout and grace period")
+	}
+	if stops := target.Stops(); !reflect.DeepEqual(stops, []string{DefaultStopSignal}) {
+		t.Errorf("stops = %v, want the default stop signal", stops)
+	}
+	if !f.TimedOut() || !f.Killed() {
+		t.Errorf("TimedOut() = %v, Killed() = %v, want both", f.TimedOut(), f.Killed())
+	}
+}
+
+func TestSignalForwarderResizes(t *testing.T) {
+	if resizeSignal == nil {
+		t.Skip("terminal resizes aren't signalled on this platform")
+	}
+
+	target := newFakeTarget()
+	f := NewSignalForwarder(target, os.Stdout)
+	width := 80
+	f.size = func() (int, int) {
+		width++
+		return width, 24
+	}
+	f.Start()
+	defer f.Stop()
+
+	deliver(f, resizeSignal, resizeSignal)
+
+	expected := [][2]int{{81, 24}, {82, 24}}
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	if !reflect.DeepEqual(target.resizes, expected) {
+		t.Errorf("resizes = %v, want %v", target.resizes, expected)
+	}
+	if len(target.signals) != 0 {
+		t.Errorf("resize signals must not be forwarded, got %v", target.signals)
+	}
+}
+
+func TestSignalForwarderStop(t *testing.T) {
+	target := newFakeTarget()
+	f := NewSignalForwarder(target, nil)
+	f.GracePeriod = 20 * time.Millisecond
+	f.Start()
+
+	// Arm the grace period, then stop before it ends.
+	deliver(f, syscall.SIGINT, syscall.SIGINT)
+	f.Stop()
+	f.Stop()
+
+	select {
+	case <-f.finished:
+	default:
+		t.Fatal("Stop() returned before the forwarding goroutine exited")
+	}
+
+	f.signals <- syscall.SIGTERM
+	time.Sleep(2 * f.GracePeriod)
+	if signals := target.Signals(); len(signals) != 2 {
+		t.Errorf("signals = %v, want nothing forwarded after Stop()", signals)
+	}
+	if target.Kills() != 0 {
+		t.Error("the container must not be killed after Stop()")
+	}
+}
+
+func TestSignalExitCode(t *testing.T) {
+	if code := SignalExitCode(syscall.SIGKILL); code != 137 {
+		t.Errorf("SignalExitCode(SIGKILL) = %d, want 137", code)
+	}
+	if code := SignalExitCode(syscall.SIGTERM); code != 143 {
+		t.Errorf("SignalExitCode(SIGTERM) = %d, want 143", code)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// resizeSignal is delivered when the terminal window changes size.
+var resizeSignal os.Signal = syscall.SIGWINCH
@@ -0,0 +1,46 @@
+//go:build !windows
+
+package utils
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSignalForwarderReceivesProcessSignals(t *testing.T) {
+	target := newFakeTarget()
+	f := NewSignalForwarder(target, os.Stdout)
+	f.size = func() (int, int) {
+		return 120, 40
+	}
+	f.Start()
+
+	resizes := func() int {
+		target.mu.Lock()
+		defer target.mu.Unlock()
+		return len(target.resizes)
+	}
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGWINCH); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for resizes() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if resizes() != 1 {
+		t.Fatalf("resizes = %d, want 1", resizes())
+	}
+
+	// Once stopped, the process no longer delivers signals to the forwarder.
+	f.Stop()
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGWINCH); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if len(f.signals) != 0 || resizes() != 1 {
+		t.Error("signals were still delivered after Stop()")
+	}
+}
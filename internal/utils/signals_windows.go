@@ -0,0 +1,6 @@
+package utils
+
+import "os"
+
+// resizeSignal is nil because Windows has no signal for terminal resizes.
+var resizeSignal os.Signal
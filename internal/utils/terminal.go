@@ -6,10 +6,10 @@ import (
 	"golang.org/x/term"
 )
 
-// GetTerminalSize returns the current terminal width and height.
-// Returns 0, 0 if the terminal size cannot be determined.
-func GetTerminalSize() (width, height int) {
-	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+// GetTerminalSize returns the width and height of the terminal file is connected to.
+// Returns 80, 24 if the terminal size cannot be determined.
+func GetTerminalSize(file *os.File) (width, height int) {
+	width, height, err := term.GetSize(int(file.Fd()))
 	if err != nil {
 		// Default to standard terminal size if we can't get the actual size
 		return 80, 24
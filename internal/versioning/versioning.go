@@ -21,6 +21,9 @@ type CommandVersion struct {
 type VersionStore struct {
 	configHome string
 	versions   map[string]CommandVersion
+	// loaded reports whether the versions file was read. It is read on first
+	// use, so runs that don't need it skip reading it.
+	loaded bool
 }
 
 // NewVersionStore creates a new version store.
@@ -31,15 +34,24 @@ func NewVersionStore() *VersionStore {
 		configHome = filepath.Join(home, ".config")
 	}
 	
-	store := &VersionStore{
+	return NewVersionStoreWithConfigHome(configHome)
+}
+
+// NewVersionStoreWithConfigHome creates a version store that keeps its state in
+// configHome/dox instead of the XDG config directory.
+func NewVersionStoreWithConfigHome(configHome string) *VersionStore {
+	return &VersionStore{
 		configHome: configHome,
 		versions:   make(map[string]CommandVersion),
 	}
-	
-	// Load existing versions.
-	_ = store.load()
-	
-	return store
+}
+
+// ensureLoaded loads existing versions the first time they are needed.
+func (v *VersionStore) ensureLoaded() {
+	if !v.loaded {
+		_ = v.load()
+		v.loaded = true
+	}
 }
 
 // CalculateFileHash computes SHA-256 hash of a file.
@@ -72,6 +84,7 @@ func (v *VersionStore) HasCommandChanged(command string) (bool, error) {
 		return false, fmt.Errorf("failed to calculate hash for %s: %w", command, err)
 	}
 	
+	v.ensureLoaded()
 	storedVersion, exists := v.versions[command]
 	if !exists {
 		// No stored version means this is the first time running the command.
@@ -90,6 +103,7 @@ func (v *VersionStore) UpdateCommandVersion(command string) error {
 		return fmt.Errorf("failed to calculate hash for %s: %w", command, err)
 	}
 	
+	v.ensureLoaded()
 	v.versions[command] = CommandVersion{
 		Hash:        hash,
 		LastUpdated: time.Now(),
@@ -147,6 +161,7 @@ func (v *VersionStore) save() error {
 
 // RemoveCommandVersion removes the stored version for a command.
 func (v *VersionStore) RemoveCommandVersion(command string) error {
+	v.ensureLoaded()
 	delete(v.versions, command)
 	return v.save()
 }
\ No newline at end of file
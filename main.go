@@ -1,9 +1,11 @@
 package main
 
 import (
+	"os"
+
 	"github.com/skorokithakis/dox/internal/cli"
 )
 
 func main() {
-	cli.Execute()
+	os.Exit(cli.Execute())
 }
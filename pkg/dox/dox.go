@@ -0,0 +1,489 @@
+// Package dox runs dox commands from Go programs.
+//
+// A Client reads the same configuration as the dox binary, so a program can run
+// "whatever dox's terraform command is" without knowing how it is configured:
+//
+//	client := dox.New()
+//	command, err := client.Resolve("terraform")
+//	if err != nil {
+//		return err
+//	}
+//	result, err := command.Run(ctx, dox.RunOptions{
+//		Args:   []string{"plan"},
+//		Stdout: os.Stdout,
+//		Stderr: os.Stderr,
+//	})
+package dox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/skorokithakis/dox/internal/config"
+	"github.com/skorokithakis/dox/internal/runtime"
+	"github.com/skorokithakis/dox/internal/versioning"
+)
+
+// GlobalConfig is the dox configuration shared by all commands.
+type GlobalConfig = config.GlobalConfig
+
+// CommandConfig is the configuration of a single command.
+type CommandConfig = config.CommandConfig
+
+// BuildConfig describes how a command's image is built.
+type BuildConfig = config.BuildConfig
+
+// Runtime is a container runtime that commands are run with.
+type Runtime = runtime.Runtime
+
+// Explainer is a Runtime that can explain commands, which Command.Explain
+// requires. The Docker and Podman runtimes are both Explainers.
+type Explainer = runtime.Explainer
+
+// ExecResult describes how a command run by a Runtime finished.
+type ExecResult = runtime.ExecResult
+
+// Plan describes how a command would be run. Its String method renders it as
+// docker or podman command lines.
+type Plan = runtime.Plan
+
+// ContainerOptions are the settings of a command's container in a Plan.
+type ContainerOptions = runtime.ContainerOptions
+
+// Phase is a timed step of running a command.
+type Phase = runtime.Phase
+
+// PhasePrepare is the first phase of a run, in which dox checks the command's
+// version and connects to the runtime. The runtime's own phases follow it.
+const PhasePrepare = "prepare"
+
+// NotFoundError is returned by Client.Resolve for a command that isn't configured.
+type NotFoundError = config.NotFoundError
+
+// InvalidConfigError is returned when a configuration file can't be read or is invalid.
+type InvalidConfigError = config.InvalidError
+
+// UnavailableError is returned when the container runtime can't be reached.
+type UnavailableError = runtime.UnavailableError
+
+// ImageError is returned when a command's image can't be pulled or built.
+type ImageError = runtime.ImageError
+
+// ContainerError is returned when a command's container can't be created or started.
+type ContainerError = runtime.ContainerError
+
+// Sandbox is a copy of a command's workspace that a sandboxed run works on, so
+// its changes can be reviewed and then applied or discarded.
+type Sandbox = runtime.Sandbox
+
+// SandboxChange is a file or directory that was created, modified or deleted in
+// a sandbox.
+type SandboxChange = runtime.SandboxChange
+
+// SandboxNotFoundError is returned when a sandbox doesn't exist.
+type SandboxNotFoundError = runtime.SandboxNotFoundError
+
+// SandboxConflictError is returned when a sandbox can't be applied because the
+// workspace has changed in the same paths since the sandbox was created.
+type SandboxConflictError = runtime.SandboxConflictError
+
+// ConfigLoader loads the global and per-command configuration.
+type ConfigLoader interface {
+	LoadGlobalConfig() (*GlobalConfig, error)
+	LoadCommandConfig(command string) (*CommandConfig, error)
+	ListCommands() ([]string, error)
+}
+
+// RuntimeFactory creates the container runtime selected in the global configuration.
+// The Docker host is only used by the Docker runtime; empty means the default daemon.
+type RuntimeFactory func(globalConfig *GlobalConfig, dockerHost string) (Runtime, error)
+
+// VersionStore keeps track of command configuration changes, so images built
+// from an inline Dockerfile are rebuilt when their configuration changes.
+type VersionStore interface {
+	HasCommandChanged(command string) (bool, error)
+	UpdateCommandVersion(command string) error
+}
+
+// Client loads dox configuration and runs commands.
+type Client struct {
+	loader     ConfigLoader
+	newRuntime RuntimeFactory
+	versions   VersionStore
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithConfigHome reads configuration from configHome/dox instead of the XDG
+// config directory. Options applied after it override its loader and version store.
+func WithConfigHome(configHome string) Option {
+	return func(c *Client) {
+		c.loader = config.NewLoaderWithConfigHome(configHome)
+		c.versions = versioning.NewVersionStoreWithConfigHome(configHome)
+	}
+}
+
+// WithLoader sets the configuration loader.
+func WithLoader(loader ConfigLoader) Option {
+	return func(c *Client) {
+		c.loader = loader
+	}
+}
+
+// WithRuntimeFactory sets how container runtimes are created.
+func WithRuntimeFactory(factory RuntimeFactory) Option {
+	return func(c *Client) {
+		c.newRuntime = factory
+	}
+}
+
+// WithVersionStore sets where command versions are tracked.
+func WithVersionStore(versions VersionStore) Option {
+	return func(c *Client) {
+		c.versions = versions
+	}
+}
+
+// New creates a client that uses the dox configuration of the current user,
+// unless options say otherwise.
+func New(opts ...Option) *Client {
+	c := &Client{newRuntime: NewRuntime}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.loader == nil {
+		c.loader = config.NewLoader()
+	}
+	if c.versions == nil {
+		c.versions = versioning.NewVersionStore()
+	}
+	return c
+}
+
+// NewRuntime creates the container runtime selected in the global configuration.
+// It is the default RuntimeFactory.
+func NewRuntime(globalConfig *GlobalConfig, dockerHost string) (Runtime, error) {
+	switch globalConfig.Runtime {
+	case "podman":
+		return runtime.NewPodmanRuntime()
+	default:
+		return runtime.NewDockerRuntimeForHost(dockerHost)
+	}
+}
+
+// GlobalConfig loads the global configuration.
+func (c *Client) GlobalConfig() (*GlobalConfig, error) {
+	globalConfig, err := c.loader.LoadGlobalConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load global config: %w", err)
+	}
+	return globalConfig, nil
+}
+
+// Commands returns the names of the configured commands in sorted order.
+func (c *Client) Commands() ([]string, error) {
+	commands, err := c.loader.ListCommands()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commands: %w", err)
+	}
+	sort.Strings(commands)
+	return commands, nil
+}
+
+// Runtime connects to the configured container runtime and checks that it is
+// available. The Docker host may be empty to use the default daemon. Failures to
+// connect are returned as an *UnavailableError.
+func (c *Client) Runtime(ctx context.Context, dockerHost string) (Runtime, error) {
+	rt, err := c.runtime(dockerHost)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rt.IsAvailable(ctx); err != nil {
+		return nil, unavailable(err)
+	}
+	return rt, nil
+}
+
+// runtime connects to the configured container runtime without checking that
+// it is available.
+func (c *Client) runtime(dockerHost string) (Runtime, error) {
+	globalConfig, err := c.GlobalConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	rt, err := c.newRuntime(globalConfig, dockerHost)
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	return rt, nil
+}
+
+// unavailable wraps an error in an *UnavailableError unless it already is one.
+func unavailable(err error) error {
+	var unavailableErr *UnavailableError
+	if errors.As(err, &unavailableErr) {
+		return err
+	}
+	return &UnavailableError{Err: err}
+}
+
+// Resolve loads a command's configuration.
+func (c *Client) Resolve(name string) (*Command, error) {
+	commandConfig, err := c.loader.LoadCommandConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Command{Name: name, Config: commandConfig, client: c}, nil
+}
+
+// Command is a resolved dox command.
+type Command struct {
+	Name string
+	// Config may be modified before the command is run, which only affects
+	// this Command.
+	Config *CommandConfig
+
+	client *Client
+}
+
+// RunOptions are the per-run settings of a command.
+type RunOptions struct {
+	// Args are appended to the command configured for the container.
+	Args []string
+	// Stdin, Stdout and Stderr are connected to the container. A TTY is only
+	// allocated when stdin and stdout are terminals. Nil streams are empty or discarded.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Env sets environment variables in the container, overriding those passed
+	// through from the host.
+	Env map[string]string
+	// Upgrade pulls or rebuilds the image before running.
+	Upgrade bool
+	// Sandbox runs the command on a copy of its workspace, which is returned
+	// in Result.Sandbox. Sandboxed runs don't use warm containers, and the copy
+	// is mounted instead of copying the workspace into the container.
+	Sandbox bool
+}
+
+// Result describes a finished run.
+type Result struct {
+	// ExitCode is the exit code of the containerized command, or 1 if it
+	// couldn't be run.
+	ExitCode int
+	// ContainerID is empty if no container was created.
+	ContainerID string
+	StartedAt   time.Time
+	FinishedAt  time.Time
+	// Canceled reports whether the run was stopped because its context ended.
+	Canceled bool
+	// OOMKilled reports whether the command was killed for exceeding its
+	// memory limit. Only commands with a memory limit are checked.
+	OOMKilled bool
+	// TimedOut reports whether the command was stopped for running longer than
+	// its timeout.
+	TimedOut bool
+	// Sandbox is the copy of the workspace a sandboxed run worked on. It is
+	// kept until it is applied or discarded, even if the run fails.
+	Sandbox *Sandbox
+	// Phases are the steps of a successful run with how long they took, in
+	// order. The last one includes the command itself.
+	Phases []Phase
+}
+
+// Duration returns how long the run took, including preparing the image.
+func (r *Result) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// Run runs the command in a container and waits for it to finish. A non-zero
+// exit code of the command is not an error. When ctx ends, the container is
+// removed and the result is returned with the context's error.
+func (cmd *Command) Run(ctx context.Context, opts RunOptions) (*Result, error) {
+	result := &Result{ExitCode: 1, StartedAt: time.Now()}
+	defer func() {
+		result.FinishedAt = time.Now()
+	}()
+
+	commandConfig := cmd.config(opts.Env)
+	upgrade, commandChanged := cmd.upgrade(commandConfig, opts.Upgrade)
+	if upgrade && !opts.Upgrade {
+		logrus.Infof("Command configuration has changed, rebuilding container...")
+	}
+
+	if opts.Sandbox {
+		sandbox, err := runtime.NewSandbox(commandConfig, cmd.Name)
+		if err != nil {
+			return result, err
+		}
+		result.Sandbox = sandbox
+		commandConfig = sandboxed(commandConfig, sandbox.Workspace)
+	}
+
+	// The runtime's availability is only checked once running the command
+	// fails, which saves a round trip on every run.
+	rt, err := cmd.client.runtime(commandConfig.DockerHost)
+	if err != nil {
+		return result, err
+	}
+	prepare := Phase{Name: PhasePrepare, Duration: time.Since(result.StartedAt)}
+
+	stdin, stdout, stderr := opts.Stdin, opts.Stdout, opts.Stderr
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+
+	execResult, err := rt.ExecuteCommand(ctx, commandConfig, cmd.Name, opts.Args, upgrade, stdin, stdout, stderr)
+	result.ExitCode = execResult.ExitCode
+	result.ContainerID = execResult.ContainerID
+	result.OOMKilled = execResult.OOMKilled
+	result.TimedOut = execResult.TimedOut
+	if err != nil {
+		if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			result.Canceled = true
+			return result, err
+		}
+		if availableErr := rt.IsAvailable(ctx); availableErr != nil {
+			return result, unavailable(availableErr)
+		}
+		return result, fmt.Errorf("command execution failed: %w", err)
+	}
+	result.Phases = append([]Phase{prepare}, execResult.Phases...)
+
+	// Update the command version after successful execution.
+	// Only update if the command ran successfully and we detected a change or this is the first run.
+	if result.ExitCode == 0 && (commandChanged || upgrade) && hasInlineDockerfile(commandConfig) {
+		if err := cmd.client.versions.UpdateCommandVersion(cmd.Name); err != nil {
+			logrus.Warnf("Failed to update command version: %v", err)
+			// Not a fatal error, continue.
+		}
+	}
+
+	return result, nil
+}
+
+// Explain works out how Run would run the command with the same options, without
+// creating containers, touching images or recording the command's version. The
+// runtime doesn't have to be reachable, but images are assumed to be missing if it isn't.
+// It fails for runtimes that aren't Explainers.
+func (cmd *Command) Explain(ctx context.Context, opts RunOptions) (*Plan, error) {
+	commandConfig := cmd.config(opts.Env)
+	upgrade, _ := cmd.upgrade(commandConfig, opts.Upgrade)
+	if opts.Sandbox {
+		commandConfig = sandboxed(commandConfig, runtime.SandboxWorkspace("<id>"))
+	}
+
+	globalConfig, err := cmd.client.GlobalConfig()
+	if err != nil {
+		return nil, err
+	}
+	rt, err := cmd.client.newRuntime(globalConfig, commandConfig.DockerHost)
+	if err != nil {
+		return nil, unavailable(err)
+	}
+
+	explainer, ok := rt.(Explainer)
+	if !ok {
+		return nil, fmt.Errorf("runtime %T can't explain commands", rt)
+	}
+	return explainer.Explain(ctx, commandConfig, cmd.Name, opts.Args, upgrade, opts.Stdin, opts.Stdout)
+}
+
+// upgrade reports whether the image should be upgraded, which is forced for
+// inline Dockerfiles when the command's configuration file has changed, and
+// whether it has changed.
+func (cmd *Command) upgrade(commandConfig *CommandConfig, requested bool) (upgrade bool, changed bool) {
+	// Other images don't depend on the configuration file, so it isn't hashed for them.
+	if !hasInlineDockerfile(commandConfig) {
+		return requested, false
+	}
+
+	// Check if the command YAML has changed.
+	changed, err := cmd.client.versions.HasCommandChanged(cmd.Name)
+	if err != nil {
+		logrus.Warnf("Failed to check command version: %v", err)
+		// Continue without version checking on error.
+		changed = false
+	}
+
+	// Force rebuild if the YAML file has changed.
+	if changed {
+		return true, changed
+	}
+	return requested, changed
+}
+
+// hasInlineDockerfile reports whether the command builds its own image.
+func hasInlineDockerfile(commandConfig *CommandConfig) bool {
+	return commandConfig.Build != nil && commandConfig.Build.DockerfileInline != ""
+}
+
+// sandboxed returns the command configuration for a run in a sandbox whose copy
+// of the workspace is in dir. The copy is mounted, since copying it again would
+// be pointless, and warm containers would keep it mounted after the run.
+func sandboxed(commandConfig *CommandConfig, dir string) *CommandConfig {
+	sandboxConfig := *commandConfig
+	sandboxConfig.Sandbox = dir
+	sandboxConfig.KeepAlive = ""
+	if sandboxConfig.Workspace == config.WorkspaceCopy {
+		sandboxConfig.Workspace = config.WorkspaceCwd
+	}
+	return &sandboxConfig
+}
+
+// OpenSandbox returns the sandbox with the given ID, or an *SandboxNotFoundError.
+func OpenSandbox(id string) (*Sandbox, error) {
+	return runtime.OpenSandbox(id)
+}
+
+// LatestSandbox returns the newest sandbox of the workspace dir is in, or an
+// *SandboxNotFoundError.
+func LatestSandbox(dir string) (*Sandbox, error) {
+	return runtime.LatestSandbox(dir)
+}
+
+// Sandboxes returns all sandboxes from oldest to newest.
+func Sandboxes() ([]*Sandbox, error) {
+	return runtime.ListSandboxes()
+}
+
+// config returns the command configuration with the environment overrides applied.
+func (cmd *Command) config(env map[string]string) *CommandConfig {
+	if len(env) == 0 {
+		return cmd.Config
+	}
+
+	commandConfig := *cmd.Config
+	commandConfig.Environment = nil
+
+	// Overridden variables aren't passed through from the host.
+	for _, entry := range cmd.Config.Environment {
+		name, _, _ := strings.Cut(entry, "=")
+		if _, ok := env[name]; !ok {
+			commandConfig.Environment = append(commandConfig.Environment, entry)
+		}
+	}
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		commandConfig.Environment = append(commandConfig.Environment, fmt.Sprintf("%s=%s", name, env[name]))
+	}
+
+	return &commandConfig
+}
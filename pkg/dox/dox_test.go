@@ -0,0 +1,237 @@
+package dox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skorokithakis/dox/internal/runtime/runtimetest"
+)
+
+// newTestClient creates a client with its own config directory and a fake runtime.
+func newTestClient(t testing.TB, commands map[string]string) (*Client, *runtimetest.Runtime) {
+	t.Helper()
+	configHome := t.TempDir()
+	commandsDir := filepath.Join(configHome, "dox", "commands")
+	if err := os.MkdirAll(commandsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, yaml := range commands {
+		if err := os.WriteFile(filepath.Join(commandsDir, name+".yaml"), []byte(yaml), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rt := runtimetest.New()
+	client := New(
+		WithConfigHome(configHome),
+		WithRuntimeFactory(func(globalConfig *GlobalConfig, dockerHost string) (Runtime, error) {
+			return rt, nil
+		}),
+	)
+	return client, rt
+}
+
+func TestClientCommands(t *testing.T) {
+	client, _ := newTestClient(t, map[string]string{
+		"terraform": "image: hashicorp/terraform\n",
+		"node":      "image: node:20\n",
+	})
+
+	commands, err := client.Commands()
+	if err != nil {
+		t.Fatalf("Commands() error = %v", err)
+	}
+	if !reflect.DeepEqual(commands, []string{"node", "terraform"}) {
+		t.Errorf("Commands() = %v, want [node terraform]", commands)
+	}
+}
+
+func TestClientResolve(t *testing.T) {
+	client, _ := newTestClient(t, map[string]string{"terraform": "image: hashicorp/terraform\n"})
+
+	command, err := client.Resolve("terraform")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if command.Name != "terraform" || command.Config.Image != "hashicorp/terraform" {
+		t.Errorf("Resolve() = %s with image %s, want terraform with image hashicorp/terraform", command.Name, command.Config.Image)
+	}
+
+	var notFoundErr *NotFoundError
+	if _, err := client.Resolve("missing"); !errors.As(err, &notFoundErr) || notFoundErr.Command != "missing" {
+		t.Errorf("Resolve() error = %v, want a *NotFoundError for a command that isn't configured", err)
+	}
+}
+
+func TestCommandRun(t *testing.T) {
+	client, rt := newTestClient(t, map[string]string{"terraform": "image: hashicorp/terraform\n"})
+	rt.ExitCode = 2
+	rt.Stdout = "plan output\n"
+
+	command, err := client.Resolve("terraform")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	before := time.Now()
+	result, err := command.Run(context.Background(), RunOptions{
+		Args:   []string{"plan"},
+		Stdin:  strings.NewReader("yes\n"),
+		Stdout: &stdout,
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if result.ExitCode != 2 {
+		t.Errorf("ExitCode = %d, want 2", result.ExitCode)
+	}
+	if result.ContainerID != "fake-1" {
+		t.Errorf("ContainerID = %q, want fake-1", result.ContainerID)
+	}
+	if result.StartedAt.Before(before) || result.FinishedAt.Before(result.StartedAt) {
+		t.Errorf("timing = %v to %v, want it to cover the run", result.StartedAt, result.FinishedAt)
+	}
+	if result.Canceled {
+		t.Error("Canceled should be false for a finished run")
+	}
+	if stdout.String() != "plan output\n" {
+		t.Errorf("stdout = %q, want the command's output", stdout.String())
+	}
+
+	execution, _ := rt.LastExecution()
+	if !reflect.DeepEqual(execution.Args, []string{"plan"}) || execution.Stdin != "yes\n" {
+		t.Errorf("executed with args %v and stdin %q, want [plan] and the input", execution.Args, execution.Stdin)
+	}
+}
+
+func TestCommandRunEnvOverrides(t *testing.T) {
+	client, rt := newTestClient(t, map[string]string{
+		"terraform": "image: hashicorp/terraform\nenvironment:\n  - TF_LOG\n  - AWS_PROFILE\n  - TF_IN_AUTOMATION=1\n",
+	})
+
+	command, err := client.Resolve("terraform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = command.Run(context.Background(), RunOptions{
+		Env: map[string]string{"TF_LOG": "debug", "TF_IN_AUTOMATION": "0", "TF_WORKSPACE": "staging"},
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	execution, _ := rt.LastExecution()
+	expected := []string{"AWS_PROFILE", "TF_IN_AUTOMATION=0", "TF_LOG=debug", "TF_WORKSPACE=staging"}
+	if !reflect.DeepEqual(execution.Config.Environment, expected) {
+		t.Errorf("Environment = %v, want %v", execution.Config.Environment, expected)
+	}
+	if len(command.Config.Environment) != 3 {
+		t.Errorf("overrides changed the resolved configuration: %v", command.Config.Environment)
+	}
+}
+
+func TestCommandRunCanceled(t *testing.T) {
+	client, rt := newTestClient(t, map[string]string{"server": "image: nginx\n"})
+	rt.Block = make(chan struct{})
+
+	command, err := client.Resolve("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	result, err := command.Run(ctx, RunOptions{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run() error = %v, want the context's error", err)
+	}
+	if !result.Canceled {
+		t.Error("Canceled should be set when the context ends")
+	}
+	if result.ContainerID == "" {
+		t.Error("ContainerID should be reported for a canceled run")
+	}
+}
+
+func TestCommandRunErrors(t *testing.T) {
+	client, rt := newTestClient(t, map[string]string{"tool": "image: alpine\n"})
+	command, err := client.Resolve("tool")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rt.UnavailableError = errors.New("docker is not running")
+	if _, err := command.Run(context.Background(), RunOptions{}); err == nil || err.Error() != "docker is not running" {
+		t.Errorf("Run() error = %v, want the availability error", err)
+	}
+	var unavailableErr *UnavailableError
+	if _, err := command.Run(context.Background(), RunOptions{}); !errors.As(err, &unavailableErr) {
+		t.Errorf("Run() error = %v, want an *UnavailableError", err)
+	}
+	if len(rt.Executions) != 0 {
+		t.Error("nothing should run when the runtime is unavailable")
+	}
+
+	rt.UnavailableError = nil
+	rt.ExecuteError = errors.New("failed to pull image")
+	result, err := command.Run(context.Background(), RunOptions{})
+	if err == nil || !strings.Contains(err.Error(), "command execution failed: failed to pull image") {
+		t.Errorf("Run() error = %v, want the execution error", err)
+	}
+	if result.ExitCode != 1 || result.Canceled {
+		t.Errorf("result = %+v, want exit code 1 without cancellation", result)
+	}
+}
+
+func TestCommandRunPhases(t *testing.T) {
+	client, _ := newTestClient(t, map[string]string{"tool": "image: alpine\n"})
+	command, err := client.Resolve("tool")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := command.Run(context.Background(), RunOptions{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	var names []string
+	for _, phase := range result.Phases {
+		names = append(names, phase.Name)
+	}
+	if !reflect.DeepEqual(names, []string{PhasePrepare, "run"}) {
+		t.Errorf("phases = %v, want dox's preparation followed by the runtime's phases", names)
+	}
+}
+
+// BenchmarkCommandRun tracks the overhead dox adds to a run before the runtime
+// takes over, from resolving the command to its result.
+func BenchmarkCommandRun(b *testing.B) {
+	for _, yaml := range []string{"image: alpine\n", "build:\n  dockerfile_inline: FROM alpine\n"} {
+		name := "image"
+		if strings.HasPrefix(yaml, "build") {
+			name = "inline"
+		}
+		b.Run(name, func(b *testing.B) {
+			client, _ := newTestClient(b, map[string]string{"tool": yaml})
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				command, err := client.Resolve("tool")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := command.Run(context.Background(), RunOptions{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package dox_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skorokithakis/dox/pkg/dox"
+)
+
+// externalRuntime is a runtime implemented outside dox, with only the names
+// pkg/dox exports.
+type externalRuntime struct{}
+
+func (externalRuntime) ExecuteCommand(ctx context.Context, cfg *dox.CommandConfig, command string, args []string, upgrade bool, stdin io.Reader, stdout, stderr io.Writer) (dox.ExecResult, error) {
+	return dox.ExecResult{ExitCode: 3, ContainerID: "external"}, nil
+}
+
+func (externalRuntime) PullImage(ctx context.Context, image string) error { return nil }
+
+func (externalRuntime) BuildImage(ctx context.Context, dockerfileContent string, tag string) error {
+	return nil
+}
+
+func (externalRuntime) ListImages(ctx context.Context) ([]string, error) { return nil, nil }
+
+func (externalRuntime) RemoveUnusedContainers(ctx context.Context) error { return nil }
+
+func (externalRuntime) RemoveImage(ctx context.Context, image string) error { return nil }
+
+func (externalRuntime) IsAvailable(ctx context.Context) error { return nil }
+
+func TestExternalRuntime(t *testing.T) {
+	configHome := t.TempDir()
+	commandsDir := filepath.Join(configHome, "dox", "commands")
+	if err := os.MkdirAll(commandsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(commandsDir, "terraform.yaml"), []byte("image: hashicorp/terraform\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	client := dox.New(
+		dox.WithConfigHome(configHome),
+		dox.WithRuntimeFactory(func(globalConfig *dox.GlobalConfig, dockerHost string) (dox.Runtime, error) {
+			return externalRuntime{}, nil
+		}),
+	)
+
+	command, err := client.Resolve("terraform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := command.Run(context.Background(), dox.RunOptions{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.ExitCode != 3 || result.ContainerID != "external" {
+		t.Errorf("Run() = exit code %d in %q, want 3 in external", result.ExitCode, result.ContainerID)
+	}
+
+	// Explaining is optional for runtimes.
+	if _, err := command.Explain(context.Background(), dox.RunOptions{}); err == nil {
+		t.Error("Explain() should fail for a runtime that isn't an Explainer")
+	}
+}